@@ -0,0 +1,216 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/cart"
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "cart-service/models"
+)
+
+// fakeCatalog is a product-service knowing product 1 without variants and
+// product 2 with variants 3 and 4
+type fakeCatalog struct {
+    productpb.ProductServiceClient
+}
+
+func (fakeCatalog) GetProduct(ctx context.Context, req *productpb.GetProductRequest, opts ...grpc.CallOption) (*productpb.ProductResponse, error) {
+    switch req.Id {
+    case 1:
+        return &productpb.ProductResponse{Product: &productpb.Product{Id: 1}}, nil
+    case 2:
+        return &productpb.ProductResponse{Product: &productpb.Product{Id: 2, Variants: []*productpb.Variant{{Id: 3}, {Id: 4}}}}, nil
+    }
+    return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.Id)
+}
+
+func TestValidateProduct(t *testing.T) {
+    tests := []struct {
+        name      string
+        productID int64
+        variantID int64
+        code      codes.Code
+    }{
+        {name: "product without variants", productID: 1},
+        {name: "variant", productID: 2, variantID: 4},
+        {name: "unknown product", productID: 9, code: codes.NotFound},
+        {name: "unknown variant", productID: 2, variantID: 7, code: codes.NotFound},
+        {name: "variant missing", productID: 2, code: codes.InvalidArgument},
+    }
+    s := &server{ProductServiceClient: fakeCatalog{}}
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := s.validateProduct(context.Background(), tt.productID, tt.variantID); status.Code(err) != tt.code {
+                t.Errorf("validateProduct error = %v, want %v", err, tt.code)
+            }
+        })
+    }
+}
+
+func TestToProtoCart(t *testing.T) {
+    userID := uint(5)
+    items := []models.CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, VariantID: 3, Quantity: 1}}
+    tests := []struct {
+        name  string
+        cart  models.Cart
+        user  int64
+        token string
+    }{
+        {name: "anonymous", cart: models.Cart{Token: "abc", Items: items}, token: "abc"},
+        {name: "authenticated", cart: models.Cart{UserID: &userID, Token: "abc", Items: items}, user: 5},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := toProtoCart(&tt.cart)
+            if got.UserId != tt.user || got.CartToken != tt.token {
+                t.Errorf("cart of user %d with token %q, want user %d with token %q", got.UserId, got.CartToken, tt.user, tt.token)
+            }
+            if len(got.Items) != 2 || got.Items[1].VariantId != 3 || got.TotalQuantity != 3 {
+                t.Errorf("cart items = %v totalling %d, want both items totalling 3", got.Items, got.TotalQuantity)
+            }
+        })
+    }
+}
+
+func TestCartItems(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db, ProductServiceClient: fakeCatalog{}}
+    ctx := context.Background()
+
+    res, err := s.GetCart(ctx, &pb.GetCartRequest{Key: &pb.CartKey{}})
+    if err != nil {
+        t.Fatalf("GetCart error = %v", err)
+    }
+    key := &pb.CartKey{CartToken: res.Cart.CartToken}
+    if key.CartToken == "" {
+        t.Fatal("anonymous cart has no token")
+    }
+
+    steps := []struct {
+        name     string
+        call     func() (*pb.CartResponse, error)
+        code     codes.Code
+        quantity []int32 // Quantity of each item in the cart afterwards
+    }{
+        {
+            name:     "add",
+            call:     func() (*pb.CartResponse, error) { return s.AddItem(ctx, &pb.AddItemRequest{Key: key, ProductId: 1, Quantity: 2}) },
+            quantity: []int32{2},
+        },
+        {
+            name:     "add the same product",
+            call:     func() (*pb.CartResponse, error) { return s.AddItem(ctx, &pb.AddItemRequest{Key: key, ProductId: 1, Quantity: 1}) },
+            quantity: []int32{3},
+        },
+        {
+            name:     "add a variant",
+            call:     func() (*pb.CartResponse, error) { return s.AddItem(ctx, &pb.AddItemRequest{Key: key, ProductId: 2, VariantId: 3, Quantity: 1}) },
+            quantity: []int32{3, 1},
+        },
+        {
+            name: "add without a quantity",
+            call: func() (*pb.CartResponse, error) { return s.AddItem(ctx, &pb.AddItemRequest{Key: key, ProductId: 1}) },
+            code: codes.InvalidArgument,
+        },
+        {
+            name: "add an unknown product",
+            call: func() (*pb.CartResponse, error) { return s.AddItem(ctx, &pb.AddItemRequest{Key: key, ProductId: 9, Quantity: 1}) },
+            code: codes.NotFound,
+        },
+        {
+            name:     "update",
+            call:     func() (*pb.CartResponse, error) { return s.UpdateItem(ctx, &pb.UpdateItemRequest{Key: key, ProductId: 1, Quantity: 5}) },
+            quantity: []int32{5, 1},
+        },
+        {
+            name: "update an item not in the cart",
+            call: func() (*pb.CartResponse, error) { return s.UpdateItem(ctx, &pb.UpdateItemRequest{Key: key, ProductId: 2, VariantId: 4, Quantity: 1}) },
+            code: codes.NotFound,
+        },
+        {
+            name:     "remove",
+            call:     func() (*pb.CartResponse, error) { return s.RemoveItem(ctx, &pb.RemoveItemRequest{Key: key, ProductId: 1}) },
+            quantity: []int32{1},
+        },
+        {
+            name:     "clear",
+            call:     func() (*pb.CartResponse, error) { return s.ClearCart(ctx, &pb.ClearCartRequest{Key: key}) },
+            quantity: []int32{},
+        },
+        {
+            name: "unknown token",
+            call: func() (*pb.CartResponse, error) { return s.ClearCart(ctx, &pb.ClearCartRequest{Key: &pb.CartKey{CartToken: "unknown"}}) },
+            code: codes.NotFound,
+        },
+    }
+    for _, step := range steps {
+        res, err := step.call()
+        if status.Code(err) != step.code {
+            t.Fatalf("%s: error = %v, want %v", step.name, err, step.code)
+        }
+        if err != nil {
+            continue
+        }
+        if len(res.Cart.Items) != len(step.quantity) {
+            t.Fatalf("%s: cart items = %v, want quantities %v", step.name, res.Cart.Items, step.quantity)
+        }
+        for i, item := range res.Cart.Items {
+            if item.Quantity != step.quantity[i] {
+                t.Errorf("%s: item %d quantity = %d, want %d", step.name, i, item.Quantity, step.quantity[i])
+            }
+        }
+    }
+}
+
+func TestMergeCarts(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db, ProductServiceClient: fakeCatalog{}}
+    ctx := context.Background()
+    anonymousCart := func() string {
+        res, err := s.AddItem(ctx, &pb.AddItemRequest{Key: &pb.CartKey{}, ProductId: 1, Quantity: 1})
+        if err != nil {
+            t.Fatalf("AddItem error = %v", err)
+        }
+        return res.Cart.CartToken
+    }
+
+    // Without a cart of their own, the user takes the anonymous cart over
+    token := anonymousCart()
+    res, err := s.MergeCarts(ctx, &pb.MergeCartsRequest{UserId: 7, CartToken: token})
+    if err != nil {
+        t.Fatalf("MergeCarts error = %v", err)
+    }
+    if res.Cart.UserId != 7 || res.Cart.TotalQuantity != 1 {
+        t.Errorf("merged cart = %v, want user 7's cart with the anonymous item", res.Cart)
+    }
+    owned := res.Cart.Id
+
+    // The token no longer reaches the cart once it belongs to a user
+    if _, err := s.ClearCart(ctx, &pb.ClearCartRequest{Key: &pb.CartKey{CartToken: token}}); status.Code(err) != codes.NotFound {
+        t.Errorf("ClearCart by the token of a user's cart error = %v, want %v", err, codes.NotFound)
+    }
+
+    // With a cart of their own, the anonymous items are added to it
+    token = anonymousCart()
+    res, err = s.MergeCarts(ctx, &pb.MergeCartsRequest{UserId: 7, CartToken: token})
+    if err != nil {
+        t.Fatalf("MergeCarts error = %v", err)
+    }
+    if res.Cart.Id != owned || res.Cart.TotalQuantity != 2 {
+        t.Errorf("merged cart %d holds %d items, want cart %d holding 2", res.Cart.Id, res.Cart.TotalQuantity, owned)
+    }
+
+    // Merging the same token again changes nothing
+    res, err = s.MergeCarts(ctx, &pb.MergeCartsRequest{UserId: 7, CartToken: token})
+    if err != nil || res.Cart.Id != owned || res.Cart.TotalQuantity != 2 {
+        t.Errorf("repeated MergeCarts = %v, %v, want cart %d still holding 2", res.GetCart(), err, owned)
+    }
+
+    if _, err := s.MergeCarts(ctx, &pb.MergeCartsRequest{CartToken: token}); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("MergeCarts without a user error = %v, want %v", err, codes.InvalidArgument)
+    }
+}
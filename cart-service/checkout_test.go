@@ -0,0 +1,193 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    pb "github.com/atullal/ecommerce-backend-protobuf/cart"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "gorm.io/gorm"
+    "cart-service/models"
+)
+
+func TestCheckoutKey(t *testing.T) {
+    tests := []struct {
+        cart models.Cart
+        want string
+    }{
+        {cart: models.Cart{Model: gorm.Model{ID: 7}}, want: "cart-7-checkout"},
+        {cart: models.Cart{Model: gorm.Model{ID: 7}, Attempts: 2}, want: "cart-7-checkout-2"},
+    }
+    for _, tt := range tests {
+        if got := checkoutKey(&tt.cart); got != tt.want {
+            t.Errorf("checkoutKey = %q, want %q", got, tt.want)
+        }
+    }
+}
+
+func TestIsDefiniteFailure(t *testing.T) {
+    tests := []struct {
+        err  error
+        want bool
+    }{
+        {err: status.Errorf(codes.FailedPrecondition, "Insufficient stock"), want: true},
+        {err: status.Errorf(codes.InvalidArgument, "Invalid item"), want: true},
+        {err: status.Errorf(codes.Aborted, "Order request failed"), want: true},
+        {err: status.Errorf(codes.Unavailable, "connection refused"), want: false},
+        {err: status.Errorf(codes.DeadlineExceeded, "timeout"), want: false},
+        {err: status.Errorf(codes.Internal, "Error creating order"), want: false},
+        {err: errors.New("transport closed"), want: false},
+    }
+    for _, tt := range tests {
+        if got := isDefiniteFailure(tt.err); got != tt.want {
+            t.Errorf("isDefiniteFailure(%v) = %v, want %v", tt.err, got, tt.want)
+        }
+    }
+}
+
+func TestMergeCart(t *testing.T) {
+    db := testDB(t)
+    from := models.Cart{Token: "from", Status: models.CartActive, CouponCode: "SAVE10", Items: []models.CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, VariantID: 3, Quantity: 1}}}
+    into := models.Cart{Token: "into", Status: models.CartActive, Items: []models.CartItem{{ProductID: 1, Quantity: 1}}}
+    db.Create(&from)
+    db.Create(&into)
+
+    if err := mergeCart(db, &from, &into); err != nil {
+        t.Fatalf("mergeCart error = %v", err)
+    }
+    s := &server{db: db}
+    merged, err := s.reloadCart(db, into.ID)
+    if err != nil {
+        t.Fatalf("reloadCart error = %v", err)
+    }
+    if len(merged.Items) != 2 || merged.Items[0].Quantity != 3 || merged.Items[1].VariantID != 3 || merged.CouponCode != "SAVE10" {
+        t.Errorf("merged cart = %+v, want 3 of product 1, the variant and the coupon", merged)
+    }
+    db.First(&from, from.ID)
+    if from.Status != models.CartMerged {
+        t.Errorf("merged cart status = %s, want %s", from.Status, models.CartMerged)
+    }
+}
+
+// fakeOrders is an order-service failing CreateOrder with err, or creating
+// order 100 plus the number of keys seen
+type fakeOrders struct {
+    orderpb.OrderServiceClient
+    err  error
+    keys []string
+}
+
+func (f *fakeOrders) CreateOrder(ctx context.Context, req *orderpb.CreateOrderRequest, opts ...grpc.CallOption) (*orderpb.OrderResponse, error) {
+    f.keys = append(f.keys, req.IdempotencyKey)
+    if f.err != nil {
+        return nil, f.err
+    }
+    return &orderpb.OrderResponse{Order: &orderpb.Order{Id: int64(100 + len(f.keys)), CustomerId: req.CustomerId}}, nil
+}
+
+// createTestCart stores an active cart with one item for the user
+func createTestCart(t *testing.T, db *gorm.DB, userID uint) models.Cart {
+    t.Helper()
+    cart := models.Cart{UserID: &userID, Token: t.Name(), Status: models.CartActive, Items: []models.CartItem{{ProductID: 1, Quantity: 2}}}
+    if err := db.Create(&cart).Error; err != nil {
+        t.Fatalf("failed to create cart: %v", err)
+    }
+    return cart
+}
+
+func TestCheckout(t *testing.T) {
+    db := testDB(t)
+    tests := []struct {
+        name   string
+        err    error
+        status models.CartStatus
+        order  uint
+    }{
+        {name: "order created", status: models.CartCheckedOut, order: 101},
+        {name: "out of stock", err: status.Errorf(codes.FailedPrecondition, "Insufficient stock"), status: models.CartActive},
+        {name: "timeout", err: status.Errorf(codes.DeadlineExceeded, "context deadline exceeded"), status: models.CartCheckingOut},
+        {name: "unavailable", err: status.Errorf(codes.Unavailable, "connection refused"), status: models.CartCheckingOut},
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            orders := &fakeOrders{err: tt.err}
+            s := &server{db: db, OrderServiceClient: orders}
+            cart := createTestCart(t, db, uint(i+1))
+
+            res, err := s.Checkout(context.Background(), &pb.CheckoutRequest{UserId: int64(i + 1)})
+            if status.Code(err) != status.Code(tt.err) {
+                t.Fatalf("Checkout error = %v, want %v", err, tt.err)
+            }
+            if err == nil && res.OrderId != int64(tt.order) {
+                t.Errorf("order = %d, want %d", res.OrderId, tt.order)
+            }
+            db.First(&cart, cart.ID)
+            if cart.Status != tt.status || cart.OrderID != tt.order {
+                t.Errorf("cart is %s for order %d, want %s for order %d", cart.Status, cart.OrderID, tt.status, tt.order)
+            }
+            if len(orders.keys) != 1 || orders.keys[0] != checkoutKey(&models.Cart{Model: gorm.Model{ID: cart.ID}}) {
+                t.Errorf("keys = %v, want the cart's first checkout key", orders.keys)
+            }
+        })
+    }
+}
+
+func TestCheckoutRetries(t *testing.T) {
+    db := testDB(t)
+    orders := &fakeOrders{err: status.Errorf(codes.Unavailable, "connection refused")}
+    s := &server{db: db, OrderServiceClient: orders}
+    cart := createTestCart(t, db, 42)
+    checkout := func() (*pb.CheckoutResponse, error) {
+        return s.Checkout(context.Background(), &pb.CheckoutRequest{UserId: 42})
+    }
+
+    // The outcome is unknown, so the retry finishes the same checkout
+    checkout()
+    orders.err = status.Errorf(codes.FailedPrecondition, "Insufficient stock")
+    checkout()
+    if orders.keys[0] != orders.keys[1] {
+        t.Errorf("keys = %v, want the retry sent under the first key", orders.keys)
+    }
+
+    // That one failed for sure, so the next checkout is a new one
+    orders.err = nil
+    res, err := checkout()
+    if err != nil {
+        t.Fatalf("Checkout error = %v", err)
+    }
+    if orders.keys[2] == orders.keys[0] {
+        t.Errorf("keys = %v, want a new key after a failed checkout", orders.keys)
+    }
+    db.First(&cart, cart.ID)
+    if cart.Status != models.CartCheckedOut || cart.OrderID != uint(res.OrderId) {
+        t.Errorf("cart is %s for order %d, want CHECKED_OUT for order %d", cart.Status, cart.OrderID, res.OrderId)
+    }
+}
+
+func TestCheckoutMergesIntoNewCart(t *testing.T) {
+    db := testDB(t)
+    orders := &fakeOrders{err: status.Errorf(codes.DeadlineExceeded, "context deadline exceeded")}
+    s := &server{db: db, OrderServiceClient: orders}
+    stuck := createTestCart(t, db, 43)
+    s.Checkout(context.Background(), &pb.CheckoutRequest{UserId: 43})
+
+    // The user filled a new cart meanwhile; the retry learns the first checkout failed
+    userID := uint(43)
+    fresh := models.Cart{UserID: &userID, Token: "fresh", Status: models.CartActive, Items: []models.CartItem{{ProductID: 9, Quantity: 1}}}
+    db.Create(&fresh)
+    orders.err = status.Errorf(codes.Aborted, "Order request failed")
+    s.Checkout(context.Background(), &pb.CheckoutRequest{UserId: 43})
+
+    db.First(&stuck, stuck.ID)
+    if stuck.Status != models.CartMerged {
+        t.Errorf("stuck cart status = %s, want %s", stuck.Status, models.CartMerged)
+    }
+    merged, _ := s.reloadCart(db, fresh.ID)
+    if len(merged.Items) != 2 {
+        t.Errorf("new cart items = %+v, want the stuck cart's item merged in", merged.Items)
+    }
+}
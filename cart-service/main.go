@@ -0,0 +1,580 @@
+package main
+
+import (
+    "os"
+    "context"
+    "log"
+    "net"
+    "errors"
+    "crypto/rand"
+    "encoding/hex"
+    "strings"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/cart"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "cart-service/models"
+    "fmt"
+    "time"
+    "math"
+)
+
+type server struct {
+    pb.CartServiceServer
+    db *gorm.DB
+    ProductServiceClient productpb.ProductServiceClient
+    OrderServiceClient orderpb.OrderServiceClient
+}
+
+func connectWithBackoff(dsn string) (*gorm.DB, error) {
+    var db *gorm.DB
+    var err error
+
+    maxAttempts := 5
+    for i := 0; i < maxAttempts; i++ {
+        db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+        if err == nil {
+            sqlDB, err := db.DB()
+            if err == nil {
+                err = sqlDB.Ping() // Try to ping the database
+                if err == nil {
+                    return db, nil // Success
+                }
+            }
+        }
+
+        // Exponential backoff logic
+        backoff := math.Pow(2, float64(i))
+        time.Sleep(time.Duration(backoff) * time.Second)
+    }
+
+    return nil, err // Return the last error
+}
+
+func initDB() *gorm.DB {
+    dsn := os.Getenv("DSN")
+    db, err := connectWithBackoff(dsn)
+    if err != nil {
+        log.Fatalf("failed to connect database: %v", err)
+    }
+
+    // Migrate the schema
+    if err := db.AutoMigrate(&models.Cart{}, &models.CartItem{}); err != nil {
+        log.Fatalf("failed to migrate database: %v", err)
+    }
+    fmt.Println("Database connection successful")
+    return db
+}
+
+// serviceToken is the SERVICE_TOKEN calls between services are proven with
+var serviceToken string
+
+func (s *server) connectToProductService() {
+    // Set up a connection to the gRPC server.
+    productServiceConnection, err := grpc.Dial(serviceAddress("PRODUCT_SERVICE_ADDR", "0.0.0.0:50052"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(serviceToken)))
+    if err != nil {
+        log.Fatalf("Failed to connect to gRPC server: %v", err)
+    }
+    fmt.Println("Connected to gRPC server")
+
+    s.ProductServiceClient = productpb.NewProductServiceClient(productServiceConnection)
+}
+
+func (s *server) connectToOrderService() {
+    // Set up a connection to the gRPC server.
+    orderServiceConnection, err := grpc.Dial(serviceAddress("ORDER_SERVICE_ADDR", "0.0.0.0:50053"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(serviceToken)))
+    if err != nil {
+        log.Fatalf("Failed to connect to gRPC server: %v", err)
+    }
+    fmt.Println("Connected to gRPC server")
+
+    s.OrderServiceClient = orderpb.NewOrderServiceClient(orderServiceConnection)
+}
+
+// newCartToken generates a random token for an anonymous cart
+func newCartToken() (string, error) {
+    b := make([]byte, 24)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+// loadCart finds the active cart for a key. Authenticated keys resolve by user,
+// anonymous keys by token. When create is set, a missing cart is created instead
+// of returning NotFound; an anonymous cart then gets a fresh token.
+func (s *server) loadCart(tx *gorm.DB, key *pb.CartKey, create bool) (*models.Cart, error) {
+    var cart models.Cart
+    query := tx.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+        Where("status = ?", models.CartActive)
+
+    var err error
+    switch {
+    case key.GetUserId() != 0:
+        err = query.Where("user_id = ?", key.GetUserId()).First(&cart).Error
+    case key.GetCartToken() != "":
+        // A token only grants access to carts that do not belong to a user
+        err = query.Where("token = ? AND user_id IS NULL", key.GetCartToken()).First(&cart).Error
+    default:
+        err = gorm.ErrRecordNotFound
+    }
+    if err == nil {
+        return &cart, nil
+    }
+    if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, status.Errorf(codes.Internal, "Error retrieving cart: %v", err)
+    }
+    if !create {
+        return nil, status.Errorf(codes.NotFound, "Cart not found")
+    }
+
+    // Create a new cart for the key
+    token, err := newCartToken()
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error creating cart token: %v", err)
+    }
+    cart = models.Cart{Token: token, Status: models.CartActive}
+    if key.GetUserId() != 0 {
+        userID := uint(key.GetUserId())
+        cart.UserID = &userID
+    }
+    if err := tx.Create(&cart).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error creating cart: %v", err)
+    }
+    return &cart, nil
+}
+
+// findItem returns the cart item for a product variant, or nil if it is not in the cart
+func findItem(cart *models.Cart, productID, variantID uint) *models.CartItem {
+    for i := range cart.Items {
+        if cart.Items[i].ProductID == productID && cart.Items[i].VariantID == variantID {
+            return &cart.Items[i]
+        }
+    }
+    return nil
+}
+
+// reloadCart fetches a cart with its items after it was modified
+func (s *server) reloadCart(tx *gorm.DB, id uint) (*models.Cart, error) {
+    var cart models.Cart
+    if err := tx.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&cart, id).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving cart: %v", err)
+    }
+    return &cart, nil
+}
+
+// validateProduct checks that a product exists before it is put in a cart.
+// Products with variants are sold per variant, so one of them must be named.
+func (s *server) validateProduct(ctx context.Context, productID, variantID int64) error {
+    resp, err := s.ProductServiceClient.GetProduct(ctx, &productpb.GetProductRequest{Id: productID})
+    if err != nil {
+        if status.Code(err) == codes.NotFound {
+            return status.Errorf(codes.NotFound, "Product with ID '%d' not found", productID)
+        }
+        return status.Errorf(codes.Internal, "Error retrieving product: %v", err)
+    }
+
+    if variantID == 0 {
+        if len(resp.Product.Variants) > 0 {
+            return status.Errorf(codes.InvalidArgument, "Product with ID '%d' has variants; a variant is required", productID)
+        }
+        return nil
+    }
+    for _, variant := range resp.Product.Variants {
+        if variant.Id == variantID {
+            return nil
+        }
+    }
+    return status.Errorf(codes.NotFound, "Variant with ID '%d' not found", variantID)
+}
+
+func toProtoCart(cart *models.Cart) *pb.Cart {
+    pbCart := &pb.Cart{
+        Id:         int64(cart.ID),
+        CartToken:  cart.Token,
+        Items:      make([]*pb.CartItem, 0, len(cart.Items)),
+        CouponCode: cart.CouponCode,
+    }
+    if cart.UserID != nil {
+        pbCart.UserId = int64(*cart.UserID)
+        // Authenticated carts are found by user, so the token is not handed out
+        pbCart.CartToken = ""
+    }
+    for _, item := range cart.Items {
+        pbCart.Items = append(pbCart.Items, &pb.CartItem{
+            ProductId: int64(item.ProductID),
+            VariantId: int64(item.VariantID),
+            Quantity:  int32(item.Quantity),
+        })
+        pbCart.TotalQuantity += int32(item.Quantity)
+    }
+    return pbCart
+}
+
+func (s *server) GetCart(ctx context.Context, req *pb.GetCartRequest) (*pb.CartResponse, error) {
+    cart, err := s.loadCart(s.db, req.Key, true)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.CartResponse{Cart: toProtoCart(cart)}, nil
+}
+
+func (s *server) AddItem(ctx context.Context, req *pb.AddItemRequest) (*pb.CartResponse, error) {
+    if req.Quantity <= 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "Quantity must be positive")
+    }
+    if err := s.validateProduct(ctx, req.ProductId, req.VariantId); err != nil {
+        return nil, err
+    }
+
+    var cart *models.Cart
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        if cart, err = s.loadCart(tx, req.Key, true); err != nil {
+            return err
+        }
+
+        // Increase the quantity if the product is already in the cart
+        if item := findItem(cart, uint(req.ProductId), uint(req.VariantId)); item != nil {
+            if err := tx.Model(item).Update("quantity", gorm.Expr("quantity + ?", req.Quantity)).Error; err != nil {
+                return status.Errorf(codes.Internal, "Error updating cart item: %v", err)
+            }
+        } else {
+            item := models.CartItem{CartID: cart.ID, ProductID: uint(req.ProductId), VariantID: uint(req.VariantId), Quantity: int(req.Quantity)}
+            if err := tx.Create(&item).Error; err != nil {
+                return status.Errorf(codes.Internal, "Error adding cart item: %v", err)
+            }
+        }
+
+        cart, err = s.reloadCart(tx, cart.ID)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.CartResponse{Cart: toProtoCart(cart)}, nil
+}
+
+func (s *server) UpdateItem(ctx context.Context, req *pb.UpdateItemRequest) (*pb.CartResponse, error) {
+    if req.Quantity < 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "Quantity must not be negative")
+    }
+
+    var cart *models.Cart
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        if cart, err = s.loadCart(tx, req.Key, false); err != nil {
+            return err
+        }
+
+        item := findItem(cart, uint(req.ProductId), uint(req.VariantId))
+        if item == nil {
+            return status.Errorf(codes.NotFound, "Product with ID '%d' is not in the cart", req.ProductId)
+        }
+        // A zero quantity removes the item
+        if req.Quantity == 0 {
+            err = tx.Delete(item).Error
+        } else {
+            err = tx.Model(item).Update("quantity", req.Quantity).Error
+        }
+        if err != nil {
+            return status.Errorf(codes.Internal, "Error updating cart item: %v", err)
+        }
+
+        cart, err = s.reloadCart(tx, cart.ID)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.CartResponse{Cart: toProtoCart(cart)}, nil
+}
+
+func (s *server) RemoveItem(ctx context.Context, req *pb.RemoveItemRequest) (*pb.CartResponse, error) {
+    return s.UpdateItem(ctx, &pb.UpdateItemRequest{Key: req.Key, ProductId: req.ProductId, VariantId: req.VariantId, Quantity: 0})
+}
+
+func (s *server) ClearCart(ctx context.Context, req *pb.ClearCartRequest) (*pb.CartResponse, error) {
+    var cart *models.Cart
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        if cart, err = s.loadCart(tx, req.Key, false); err != nil {
+            return err
+        }
+        if err := tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error clearing cart: %v", err)
+        }
+        cart.Items = nil
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.CartResponse{Cart: toProtoCart(cart)}, nil
+}
+
+func (s *server) MergeCarts(ctx context.Context, req *pb.MergeCartsRequest) (*pb.CartResponse, error) {
+    if req.UserId == 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "User ID is required")
+    }
+    userKey := &pb.CartKey{UserId: req.UserId}
+
+    var cart *models.Cart
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        anonymous, err := s.loadCart(tx, &pb.CartKey{CartToken: req.CartToken}, false)
+        if status.Code(err) == codes.NotFound {
+            // Nothing to merge, e.g. the token was already merged
+            cart, err = s.loadCart(tx, userKey, true)
+            return err
+        }
+        if err != nil {
+            return err
+        }
+
+        userCart, err := s.loadCart(tx, userKey, false)
+        if status.Code(err) == codes.NotFound {
+            // The user has no cart yet, so the anonymous cart simply becomes theirs
+            userID := uint(req.UserId)
+            if err := tx.Model(anonymous).Update("user_id", userID).Error; err != nil {
+                return status.Errorf(codes.Internal, "Error assigning cart: %v", err)
+            }
+            cart, err = s.reloadCart(tx, anonymous.ID)
+            return err
+        }
+        if err != nil {
+            return err
+        }
+
+        if err := mergeCart(tx, anonymous, userCart); err != nil {
+            return err
+        }
+        cart, err = s.reloadCart(tx, userCart.ID)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.CartResponse{Cart: toProtoCart(cart)}, nil
+}
+
+// mergeCart moves every item of a cart into another, adding up quantities,
+// and marks the emptied cart MERGED
+func mergeCart(tx *gorm.DB, from, into *models.Cart) error {
+    for _, item := range from.Items {
+        var err error
+        if existing := findItem(into, item.ProductID, item.VariantID); existing != nil {
+            err = tx.Model(existing).Update("quantity", gorm.Expr("quantity + ?", item.Quantity)).Error
+        } else {
+            err = tx.Create(&models.CartItem{CartID: into.ID, ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity}).Error
+        }
+        if err != nil {
+            return status.Errorf(codes.Internal, "Error merging cart item: %v", err)
+        }
+    }
+    // A coupon applied to the merged cart carries over unless the other has one
+    if into.CouponCode == "" && from.CouponCode != "" {
+        if err := tx.Model(into).Update("coupon_code", from.CouponCode).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error merging cart: %v", err)
+        }
+    }
+    if err := tx.Model(from).Update("status", models.CartMerged).Error; err != nil {
+        return status.Errorf(codes.Internal, "Error merging cart: %v", err)
+    }
+    return nil
+}
+
+func (s *server) ApplyCoupon(ctx context.Context, req *pb.ApplyCouponRequest) (*pb.CartResponse, error) {
+    // The code is checked against the cart's items when previewed and at checkout
+    code := strings.ToUpper(strings.TrimSpace(req.CouponCode))
+
+    var cart *models.Cart
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        if cart, err = s.loadCart(tx, req.Key, false); err != nil {
+            return err
+        }
+        if err := tx.Model(cart).Update("coupon_code", code).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error applying coupon: %v", err)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.CartResponse{Cart: toProtoCart(cart)}, nil
+}
+
+// Checkout turns the user's cart into an order. The order is created with an
+// idempotency key derived from the cart, and a checkout whose answer was lost
+// is finished by the next one with the same key, so a cart is never ordered
+// twice. The cart goes back to the user only when no order was created.
+func (s *server) Checkout(ctx context.Context, req *pb.CheckoutRequest) (*pb.CheckoutResponse, error) {
+    if req.UserId == 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "User ID is required")
+    }
+
+    cart, err := s.unfinishedCheckout(req.UserId)
+    if err != nil {
+        return nil, err
+    }
+    if cart == nil {
+        cart, err = s.loadCart(s.db, &pb.CartKey{UserId: req.UserId}, false)
+        if err != nil {
+            return nil, err
+        }
+        if len(cart.Items) == 0 {
+            return nil, status.Errorf(codes.FailedPrecondition, "Cart is empty")
+        }
+
+        // Claim the cart so a concurrent checkout cannot order the same items twice
+        result := s.db.Model(&models.Cart{}).
+            Where("id = ? AND status = ?", cart.ID, models.CartActive).
+            Update("status", models.CartCheckingOut)
+        if result.Error != nil {
+            return nil, status.Errorf(codes.Internal, "Error checking out cart: %v", result.Error)
+        }
+        if result.RowsAffected == 0 {
+            return nil, status.Errorf(codes.Aborted, "Cart is already being checked out")
+        }
+    }
+
+    // Create the order through the order service
+    orderReq := &orderpb.CreateOrderRequest{CustomerId: req.UserId, IdempotencyKey: checkoutKey(cart)}
+    for _, item := range cart.Items {
+        orderReq.Items = append(orderReq.Items, &orderpb.OrderItem{
+            ProductId: int64(item.ProductID),
+            VariantId: int64(item.VariantID),
+            Quantity:  int32(item.Quantity),
+        })
+    }
+    orderReq.Currency = req.Currency
+    orderReq.CouponCode = cart.CouponCode
+    orderReq.ShippingMethodId = req.ShippingMethodId
+    orderReq.ShippingAddressId = req.ShippingAddressId
+    orderReq.BillingAddressId = req.BillingAddressId
+    if req.Country != "" {
+        orderReq.Destination = &orderpb.Destination{Country: req.Country, Region: req.Region, PostalCode: req.PostalCode}
+    }
+    orderResp, err := s.OrderServiceClient.CreateOrder(forwardCaller(ctx), orderReq)
+    if err != nil {
+        if isDefiniteFailure(err) {
+            // No order was created; give the cart back to the user so they can retry
+            if revertErr := s.reactivateCart(cart); revertErr != nil {
+                log.Printf("cart %d: failed to reactivate after checkout error: %v", cart.ID, revertErr)
+            }
+        }
+        // Otherwise the order may exist; the cart stays checking out until a
+        // retry learns the outcome
+        return nil, err
+    }
+
+    err = s.db.Model(cart).Updates(map[string]interface{}{"status": models.CartCheckedOut, "order_id": orderResp.Order.GetId()}).Error
+    if err != nil {
+        log.Printf("cart %d: failed to record order %d: %v", cart.ID, orderResp.Order.GetId(), err)
+    }
+    return &pb.CheckoutResponse{OrderId: orderResp.Order.GetId()}, nil
+}
+
+// unfinishedCheckout returns the user's cart whose checkout never learned if
+// the order was created, or nil when there is none
+func (s *server) unfinishedCheckout(userID int64) (*models.Cart, error) {
+    var cart models.Cart
+    err := s.db.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+        Where("user_id = ? AND status = ?", userID, models.CartCheckingOut).
+        Order("id").First(&cart).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving cart: %v", err)
+    }
+    return &cart, nil
+}
+
+// reactivateCart gives a cart whose checkout failed back to its user, to be
+// checked out again under a new key. If the user started another cart
+// meanwhile, the items are merged into that one.
+func (s *server) reactivateCart(cart *models.Cart) error {
+    return s.db.Transaction(func(tx *gorm.DB) error {
+        userCart, err := s.loadCart(tx, &pb.CartKey{UserId: int64(*cart.UserID)}, false)
+        if status.Code(err) == codes.NotFound {
+            return tx.Model(cart).Updates(map[string]interface{}{"status": models.CartActive, "attempts": gorm.Expr("attempts + 1")}).Error
+        }
+        if err != nil {
+            return err
+        }
+        return mergeCart(tx, cart, userCart)
+    })
+}
+
+// checkoutKey is the idempotency key of a cart's checkout. Retries of a
+// checkout share it; a checkout after a failed one gets a new one.
+func checkoutKey(cart *models.Cart) string {
+    if cart.Attempts == 0 {
+        return fmt.Sprintf("cart-%d-checkout", cart.ID)
+    }
+    return fmt.Sprintf("cart-%d-checkout-%d", cart.ID, cart.Attempts)
+}
+
+// isDefiniteFailure reports whether a failed remote call is known to have had no effect.
+// Transport errors and timeouts leave the outcome unknown.
+func isDefiniteFailure(err error) bool {
+    switch status.Code(err) {
+    case codes.InvalidArgument, codes.NotFound, codes.Aborted, codes.FailedPrecondition, codes.AlreadyExists:
+        return true
+    }
+    return false
+}
+
+// callerMetadataKeys are the metadata rest-service uses to forward the
+// authenticated user. Order creation prices the cart for the user's customer group.
+var callerMetadataKeys = []string{"x-user-id", "x-user-role", "x-customer-group"}
+
+// forwardCaller passes the user a request is made for on to the services it calls
+func forwardCaller(ctx context.Context) context.Context {
+    md, _ := metadata.FromIncomingContext(ctx)
+    for _, key := range callerMetadataKeys {
+        for _, value := range md.Get(key) {
+            ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+        }
+    }
+    return ctx
+}
+
+// serviceAddress returns the address of another service from the environment
+// variable env, e.g. PRODUCT_SERVICE_ADDR=product-service:50052, or fallback
+func serviceAddress(env, fallback string) string {
+    if address := os.Getenv(env); address != "" {
+        return address
+    }
+    return fallback
+}
+
+func main() {
+    db := initDB()
+    lis, err := net.Listen("tcp", ":50054")
+    if err != nil {
+        log.Fatalf("failed to listen: %v", err)
+    }
+    // Only other services holding the shared token may call cart-service
+    if serviceToken, err = serviceauth.TokenFromEnv(); err != nil {
+        log.Fatalf("failed to configure service authentication: %v", err)
+    }
+    s := grpc.NewServer(grpc.UnaryInterceptor(serviceauth.UnaryServerInterceptor(serviceToken)))
+    serv := &server{db: db}
+    serv.connectToProductService()
+    serv.connectToOrderService()
+    pb.RegisterCartServiceServer(s, serv)
+    log.Printf("server listening at %v", lis.Addr())
+    if err := s.Serve(lis); err != nil {
+        log.Fatalf("failed to serve: %v", err)
+    }
+}
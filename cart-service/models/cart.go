@@ -0,0 +1,38 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// Cart is a server-side shopping cart. Anonymous carts are found by Token,
+// authenticated carts by UserID; a user has at most one active cart.
+type Cart struct {
+    gorm.Model
+    UserID     *uint      `gorm:"index:idx_carts_active_user,unique,where:status = 'ACTIVE'"` // Nil for anonymous carts
+    Token      string     `gorm:"uniqueIndex"`
+    Status     CartStatus `gorm:"index"`
+    Items      []CartItem // Association with CartItem
+    CouponCode string     `gorm:"not null;default:''"` // Redeemed when the cart is checked out
+    OrderID    uint       `gorm:"not null;default:0"`  // Order the cart was checked out as
+    Attempts   int        `gorm:"not null;default:0"`  // Checkouts that failed, so each new one is sent with a fresh key
+}
+
+// CartItem represents a product, or one of its variants, and quantity in a cart
+type CartItem struct {
+    gorm.Model
+    CartID    uint `gorm:"index"` // Foreign key for the Cart
+    ProductID uint
+    VariantID uint `gorm:"not null;default:0"` // 0 for products without variants
+    Quantity  int
+}
+
+// CartStatus represents the status of a cart
+type CartStatus string
+
+// Enum values for CartStatus
+const (
+    CartActive      CartStatus = "ACTIVE"
+    CartMerged      CartStatus = "MERGED"       // Items were moved into a user's cart
+    CartCheckingOut CartStatus = "CHECKING_OUT" // Sent to order-service, which hasn't answered yet
+    CartCheckedOut  CartStatus = "CHECKED_OUT"  // Turned into an order
+)
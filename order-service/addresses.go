@@ -0,0 +1,131 @@
+package main
+
+import (
+    "context"
+    "strings"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    userpb "github.com/atullal/ecommerce-backend-protobuf/user"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+// orderAddresses picks the addresses an order ships and bills to from the
+// customer's address book and snapshots them. Without a shipping address or a
+// destination the customer's default shipping address is used; without a
+// billing address their default billing address, or else the shipping address.
+func (s *server) orderAddresses(ctx context.Context, customerID, shippingID, billingID int64, destination *pb.Destination) (shipTo, billTo models.OrderAddress, err error) {
+    hasDestination := destination.GetCountry() != ""
+    if shippingID != 0 && hasDestination {
+        return shipTo, billTo, status.Errorf(codes.InvalidArgument, "Send either a destination or a shippingAddressId")
+    }
+    if customerID == 0 {
+        if shippingID != 0 || billingID != 0 {
+            return shipTo, billTo, status.Errorf(codes.InvalidArgument, "Anonymous orders can't use saved addresses")
+        }
+        return shipTo, billTo, nil
+    }
+
+    res, err := s.UserServiceClient.ListAddresses(ctx, &userpb.ListAddressesRequest{UserId: customerID})
+    if err != nil {
+        if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+            return shipTo, billTo, err
+        }
+        return shipTo, billTo, status.Errorf(codes.Internal, "Error retrieving addresses: %v", err)
+    }
+    return chooseAddresses(res.Addresses, shippingID, billingID, hasDestination)
+}
+
+// chooseAddresses picks an order's addresses out of the customer's address book
+func chooseAddresses(addresses []*userpb.Address, shippingID, billingID int64, hasDestination bool) (shipTo, billTo models.OrderAddress, err error) {
+    find := func(id int64, isDefault func(*userpb.Address) bool) (*userpb.Address, error) {
+        for _, address := range addresses {
+            if (id != 0 && address.Id == id) || (id == 0 && isDefault(address)) {
+                return address, nil
+            }
+        }
+        if id != 0 {
+            return nil, status.Errorf(codes.NotFound, "Address with ID '%d' not found", id)
+        }
+        return nil, nil
+    }
+
+    if shippingID != 0 || !hasDestination {
+        address, err := find(shippingID, (*userpb.Address).GetDefaultShipping)
+        if err != nil {
+            return shipTo, billTo, err
+        }
+        shipTo = newOrderAddress(address)
+    }
+    address, err := find(billingID, (*userpb.Address).GetDefaultBilling)
+    if err != nil {
+        return shipTo, billTo, err
+    }
+    billTo = newOrderAddress(address)
+    if address == nil {
+        billTo = shipTo
+    }
+    return shipTo, billTo, nil
+}
+
+// newOrderAddress snapshots an address book entry; nil gives no address
+func newOrderAddress(address *userpb.Address) models.OrderAddress {
+    if address == nil {
+        return models.OrderAddress{}
+    }
+    return models.OrderAddress{
+        AddressID:  uint(address.Id),
+        Name:       address.Name,
+        Company:    address.Company,
+        Line1:      address.Line1,
+        Line2:      address.Line2,
+        City:       address.City,
+        Region:     address.Region,
+        PostalCode: address.PostalCode,
+        Country:    address.Country,
+        Phone:      address.Phone,
+    }
+}
+
+// addressDestination is where an order ships to when it ships to an address
+// from the address book
+func addressDestination(address models.OrderAddress, destination *pb.Destination) *pb.Destination {
+    if address.AddressID == 0 {
+        return destination
+    }
+    return &pb.Destination{Country: address.Country, Region: address.Region, PostalCode: address.PostalCode}
+}
+
+// formatAddress puts an address on one line, e.g. for labels and emails
+func formatAddress(address models.OrderAddress) string {
+    var parts []string
+    for _, part := range []string{
+        address.Name, address.Company, address.Line1, address.Line2, address.City,
+        strings.TrimSpace(address.Region + " " + address.PostalCode), address.Country,
+    } {
+        if part != "" {
+            parts = append(parts, part)
+        }
+    }
+    return strings.Join(parts, ", ")
+}
+
+// toProtoOrderAddress converts an address snapshot to protobuf
+func toProtoOrderAddress(address models.OrderAddress) *pb.OrderAddress {
+    if address.AddressID == 0 {
+        return nil
+    }
+    return &pb.OrderAddress{
+        AddressId:  int64(address.AddressID),
+        Name:       address.Name,
+        Company:    address.Company,
+        Line1:      address.Line1,
+        Line2:      address.Line2,
+        City:       address.City,
+        Region:     address.Region,
+        PostalCode: address.PostalCode,
+        Country:    address.Country,
+        Phone:      address.Phone,
+    }
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "testing"
+
+    userpb "github.com/atullal/ecommerce-backend-protobuf/user"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+func TestChooseAddresses(t *testing.T) {
+    addresses := []*userpb.Address{
+        {Id: 1, Name: "Home", Line1: "1 Main St", City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US", DefaultShipping: true},
+        {Id: 2, Name: "Office", Line1: "2 Market St", City: "Chicago", Region: "IL", PostalCode: "60601", Country: "US", DefaultBilling: true},
+        {Id: 3, Name: "Cabin", Line1: "3 Lake Rd", City: "Banff", Region: "AB", PostalCode: "T1L 1A1", Country: "CA"},
+    }
+    tests := []struct {
+        name               string
+        addresses          []*userpb.Address
+        shippingID         int64
+        billingID          int64
+        hasDestination     bool
+        wantShip, wantBill uint
+        code               codes.Code
+    }{
+        {name: "defaults", addresses: addresses, wantShip: 1, wantBill: 2},
+        {name: "chosen addresses", addresses: addresses, shippingID: 3, billingID: 1, wantShip: 3, wantBill: 1},
+        {name: "destination instead of an address", addresses: addresses, hasDestination: true, wantShip: 0, wantBill: 2},
+        {name: "bill to the shipping address", addresses: addresses[:1], wantShip: 1, wantBill: 1},
+        {name: "empty address book", wantShip: 0, wantBill: 0},
+        {name: "someone else's address", addresses: addresses, shippingID: 9, code: codes.NotFound},
+        {name: "someone else's billing address", addresses: addresses, billingID: 9, code: codes.NotFound},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            shipTo, billTo, err := chooseAddresses(tt.addresses, tt.shippingID, tt.billingID, tt.hasDestination)
+            if status.Code(err) != tt.code {
+                t.Fatalf("chooseAddresses error = %v, want %v", err, tt.code)
+            }
+            if err == nil && (shipTo.AddressID != tt.wantShip || billTo.AddressID != tt.wantBill) {
+                t.Errorf("chooseAddresses = %d, %d, want %d, %d", shipTo.AddressID, billTo.AddressID, tt.wantShip, tt.wantBill)
+            }
+        })
+    }
+}
+
+func TestFormatAddress(t *testing.T) {
+    address := models.OrderAddress{AddressID: 1, Name: "Ada Lovelace", Line1: "1 Main St", City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US"}
+    if got, want := formatAddress(address), "Ada Lovelace, 1 Main St, Springfield, IL 62701, US"; got != want {
+        t.Errorf("formatAddress = %q, want %q", got, want)
+    }
+    if got := formatAddress(models.OrderAddress{}); got != "" {
+        t.Errorf("formatAddress of no address = %q, want empty", got)
+    }
+}
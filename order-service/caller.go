@@ -0,0 +1,109 @@
+package main
+
+import (
+    "context"
+    "strconv"
+
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+// Metadata keys rest-service uses to forward the authenticated user
+const (
+    userIDMetadataKey        = "x-user-id"
+    userRoleMetadataKey      = "x-user-role"
+    customerGroupMetadataKey = "x-customer-group"
+)
+
+// roleAdmin is the user role allowed to manage every order
+const roleAdmin = 3
+
+// serviceToken is the SERVICE_TOKEN other services prove their calls with.
+// Forwarded users are only trusted on calls that carry it.
+var serviceToken string
+
+// caller is the user on whose behalf a request is made
+type caller struct {
+    UserID uint
+    Role   int
+    System bool // A service acting on its own behalf rather than for a user
+}
+
+// callerFromContext reads the forwarded user from the incoming gRPC metadata.
+// ok is false when the request carries no user, or doesn't come from a service.
+func callerFromContext(ctx context.Context) (c caller, ok bool) {
+    if !serviceauth.Verified(ctx, serviceToken) {
+        return caller{}, false
+    }
+    md, found := metadata.FromIncomingContext(ctx)
+    if !found {
+        return caller{}, false
+    }
+    ids := md.Get(userIDMetadataKey)
+    if len(ids) == 0 {
+        return caller{}, false
+    }
+    userID, err := strconv.ParseUint(ids[0], 10, 64)
+    if err != nil {
+        return caller{}, false
+    }
+    c.UserID = uint(userID)
+    if roles := md.Get(userRoleMetadataKey); len(roles) > 0 {
+        c.Role, _ = strconv.Atoi(roles[0])
+    }
+    return c, true
+}
+
+// customerGroupFromContext reads the forwarded customer group of the user, whose
+// price lists apply to the request. It is empty for customers outside any group.
+func customerGroupFromContext(ctx context.Context) string {
+    if !serviceauth.Verified(ctx, serviceToken) {
+        return ""
+    }
+    md, _ := metadata.FromIncomingContext(ctx)
+    if groups := md.Get(customerGroupMetadataKey); len(groups) > 0 {
+        return groups[0]
+    }
+    return ""
+}
+
+// IsAdmin reports whether the caller has the admin role
+func (c caller) IsAdmin() bool {
+    return c.Role == roleAdmin
+}
+
+// actorFromContext returns the forwarded user, or an admin-equivalent system
+// caller for services calling on their own behalf. A call with neither is
+// denied rather than trusted.
+func actorFromContext(ctx context.Context) (caller, error) {
+    if c, ok := callerFromContext(ctx); ok {
+        return c, nil
+    }
+    if serviceauth.IsSystem(ctx, serviceToken) {
+        return caller{Role: roleAdmin, System: true}, nil
+    }
+    return caller{}, status.Errorf(codes.Unauthenticated, "Request has no user")
+}
+
+// asSystem marks a call to another service as made by order-service itself
+func asSystem(ctx context.Context) context.Context {
+    return serviceauth.AsSystem(ctx, "order-service")
+}
+
+// CanAccess reports whether the caller may see the order: customers only
+// their own orders, admins every order
+func (c caller) CanAccess(order models.Order) bool {
+    return c.IsAdmin() || order.CustomerID == c.UserID
+}
+
+// authorizeOrder checks the caller may see the order. A denied order is
+// reported as not found so order IDs cannot be enumerated.
+func authorizeOrder(actor caller, order models.Order) error {
+    if !actor.CanAccess(order) {
+        return status.Errorf(codes.NotFound, "Order with ID '%d' not found", order.ID)
+    }
+    return nil
+}
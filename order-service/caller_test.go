@@ -0,0 +1,95 @@
+package main
+
+import (
+    "context"
+    "strconv"
+    "testing"
+
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+func TestActorFromContext(t *testing.T) {
+    serviceToken = "secret"
+    defer func() { serviceToken = "" }()
+
+    tests := []struct {
+        name string
+        md   metadata.MD
+        want caller
+        code codes.Code
+    }{
+        {
+            name: "forwarded user",
+            md:   metadata.Pairs(serviceauth.TokenMetadataKey, "secret", userIDMetadataKey, "10", userRoleMetadataKey, "1"),
+            want: caller{UserID: 10, Role: 1},
+        },
+        {
+            name: "service on its own behalf",
+            md:   metadata.Pairs(serviceauth.TokenMetadataKey, "secret", serviceauth.SystemMetadataKey, "payment-service"),
+            want: caller{Role: roleAdmin, System: true},
+        },
+        {name: "no user", md: metadata.Pairs(serviceauth.TokenMetadataKey, "secret"), code: codes.Unauthenticated},
+        {name: "no metadata", md: metadata.MD{}, code: codes.Unauthenticated},
+        {
+            name: "user without the service token",
+            md:   metadata.Pairs(userIDMetadataKey, "10", userRoleMetadataKey, "3"),
+            code: codes.Unauthenticated,
+        },
+        {
+            name: "system without the service token",
+            md:   metadata.Pairs(serviceauth.SystemMetadataKey, "payment-service"),
+            code: codes.Unauthenticated,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := actorFromContext(metadata.NewIncomingContext(context.Background(), tt.md))
+            if status.Code(err) != tt.code || got != tt.want {
+                t.Errorf("actorFromContext = %+v, %v, want %+v, %v", got, err, tt.want, tt.code)
+            }
+        })
+    }
+}
+
+func TestAuthorizeOrder(t *testing.T) {
+    order := models.Order{CustomerID: 10}
+    order.ID = 1
+
+    tests := []struct {
+        name  string
+        actor caller
+        want  codes.Code
+    }{
+        {"owner", caller{UserID: 10, Role: 1}, codes.OK},
+        {"other customer", caller{UserID: 20, Role: 1}, codes.NotFound},
+        {"admin", caller{UserID: 30, Role: roleAdmin}, codes.OK},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := status.Code(authorizeOrder(tt.actor, order)); got != tt.want {
+                t.Errorf("authorizeOrder = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+// customerContext is an incoming call from rest-service for a customer
+func customerContext(t *testing.T, userID uint) context.Context {
+    serviceToken = "secret"
+    t.Cleanup(func() { serviceToken = "" })
+    md := metadata.Pairs(serviceauth.TokenMetadataKey, "secret", userIDMetadataKey, strconv.Itoa(int(userID)), userRoleMetadataKey, "1")
+    return metadata.NewIncomingContext(context.Background(), md)
+}
+
+// adminContext is an incoming call from rest-service for an admin
+func adminContext(t *testing.T) context.Context {
+    serviceToken = "secret"
+    t.Cleanup(func() { serviceToken = "" })
+    md := metadata.Pairs(serviceauth.TokenMetadataKey, "secret", userIDMetadataKey, "1", userRoleMetadataKey, "3")
+    return metadata.NewIncomingContext(context.Background(), md)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    paymentpb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "order-service/models"
+)
+
+// canCancel reports whether the caller may cancel an order in its current status.
+// Customers may cancel orders that are PENDING or CONFIRMED; admins anything not yet shipped.
+func canCancel(actor caller, orderStatus models.OrderStatus) bool {
+    if actor.IsAdmin() {
+        return orderStatus != models.StatusShipped && orderStatus != models.StatusDelivered
+    }
+    return orderStatus == models.StatusPending || orderStatus == models.StatusConfirmed
+}
+
+func (s *server) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.OrderResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    order, err := s.cancelOrder(ctx, uint(req.OrderId), actor, req.Reason)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.OrderResponse{Order: toProtoOrder(order)}, nil
+}
+
+// cancelOrder moves the order to CANCELLED and then gives its stock back.
+// Cancelling an already cancelled order only retries a restock that has not
+// gone through, so repeated requests are safe.
+func (s *server) cancelOrder(ctx context.Context, orderID uint, actor caller, reason string) (models.Order, error) {
+    var order models.Order
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items.TaxLines").First(&order, orderID).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Order with ID '%d' not found", orderID)
+            }
+            return status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+        }
+        if err := authorizeOrder(actor, order); err != nil {
+            return err
+        }
+        if order.Status == models.StatusCancelled {
+            return nil
+        }
+        if !canCancel(actor, order.Status) {
+            return status.Errorf(codes.FailedPrecondition, "Order with ID '%d' cannot be cancelled while %s", orderID, order.Status)
+        }
+        // Items already on their way come back through a return instead
+        var shipments int64
+        if err := tx.Model(&models.Shipment{}).Where("order_id = ?", order.ID).Count(&shipments).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving shipments: %v", err)
+        }
+        if shipments > 0 {
+            return status.Errorf(codes.FailedPrecondition, "Order with ID '%d' has shipped items and cannot be cancelled", orderID)
+        }
+
+        // The coupon's use is given back along with the stock
+        if err := releasePromotion(tx, order); err != nil {
+            return status.Errorf(codes.Internal, "Error releasing coupon: %v", err)
+        }
+        order.Restock = models.RestockPending
+        order.Refund = models.RefundPending
+        return transitionOrder(tx, &order, models.StatusCancelled, actor, reason)
+    })
+    if err != nil {
+        return models.Order{}, err
+    }
+
+    // The cancellation is committed; a failed restock or refund is retried by the recovery worker
+    if order.Restock == models.RestockPending {
+        if err := s.restockOrder(ctx, &order); err != nil {
+            log.Printf("order %d: restock failed, will retry: %v", order.ID, err)
+        }
+    }
+    if order.Refund == models.RefundPending {
+        if err := s.refundOrder(ctx, &order); err != nil {
+            log.Printf("order %d: refund failed, will retry: %v", order.ID, err)
+        }
+    }
+    return order, nil
+}
+
+// restockKey is the idempotency key for returning an order's stock, so the
+// product service applies the restock at most once however often it is sent
+func restockKey(orderID uint) string {
+    return fmt.Sprintf("order-%d-cancel", orderID)
+}
+
+// restockOrder gives the stock of a cancelled order back. Stock the order
+// only held is released; stock already taken off hand is put back.
+func (s *server) restockOrder(ctx context.Context, order *models.Order) error {
+    committed, err := s.releaseOrderStock(ctx, order.ID)
+    if err != nil {
+        return err
+    }
+    if committed {
+        req := &productpb.UpdateMultipleInventoriesRequest{IdempotencyKey: restockKey(order.ID)}
+        for _, item := range order.Items {
+            req.InventoryUpdates = append(req.InventoryUpdates, &productpb.UpdateInventoryRequest{
+                ProductId:      int64(item.ProductID),
+                VariantId:      int64(item.VariantID),
+                QuantityChange: int32(item.Quantity),
+                WarehouseId:    int64(item.WarehouseID), // Back to the warehouse it was taken from
+                Reason:         "CANCEL",
+                ReferenceId:    fmt.Sprintf("order-%d", order.ID),
+            })
+        }
+        if _, err := s.ProductServiceClient.UpdateMultipleInventories(ctx, req); err != nil {
+            return err
+        }
+    }
+
+    order.Restock = models.RestockDone
+    return s.db.Model(order).Update("restock", models.RestockDone).Error
+}
+
+// releaseOrderStock releases the reservation holding a cancelled order's
+// stock unless the order's saga committed it, and reports whether the stock
+// was committed and so has to be restocked instead
+func (s *server) releaseOrderStock(ctx context.Context, orderID uint) (bool, error) {
+    var saga models.Saga
+    err := s.db.Preload("Steps").Where("type = ? AND order_id = ?", models.SagaTypeCreateOrder, orderID).First(&saga).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        // Orders placed before sagas took their stock off hand straight away
+        return true, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    if step := sagaStep(&saga, stepCommitInventory); step != nil && step.Status == models.StepDone {
+        return true, nil
+    }
+
+    req := &productpb.ReleaseReservationRequest{ReservationKey: saga.ReservationKey}
+    _, err = s.ProductServiceClient.ReleaseReservation(ctx, req)
+    switch status.Code(err) {
+    case codes.OK, codes.NotFound:
+        return false, nil
+    case codes.FailedPrecondition:
+        // The saga committed the hold while the order was being cancelled
+        return true, nil
+    }
+    return false, err
+}
+
+// restockCancelledOrders retries the restock of cancelled orders whose stock was not returned
+func (s *server) restockCancelledOrders() {
+    var orders []models.Order
+    if err := s.db.Preload("Items").Where("restock = ?", models.RestockPending).Find(&orders).Error; err != nil {
+        log.Printf("restock recovery: failed to load orders: %v", err)
+        return
+    }
+
+    for i := range orders {
+        ctx, cancel := context.WithTimeout(context.Background(), sagaCompensationTimeout)
+        if err := s.restockOrder(ctx, &orders[i]); err != nil {
+            log.Printf("order %d: restock failed, will retry: %v", orders[i].ID, err)
+        }
+        cancel()
+    }
+}
+
+// refundKey is the idempotency key for refunding a cancelled order, so the
+// payment is refunded at most once however often it is sent
+func refundKey(orderID uint) string {
+    return fmt.Sprintf("order-%d-cancel", orderID)
+}
+
+// refundOrder gives back the payment of a cancelled order: an authorized
+// hold, or one still waiting on 3-D Secure, is voided and a captured payment
+// refunded in full. A payment voided or refunded meanwhile, e.g. by
+// payment-service when the order couldn't be confirmed, is left as it is. The
+// refund stays PENDING while a payment is being authorized or has moved on
+// since it was listed, and is looked at again by the recovery worker.
+func (s *server) refundOrder(ctx context.Context, order *models.Order) error {
+    // The refund is made by order-service itself, whoever cancelled
+    ctx = asSystem(ctx)
+    payments, err := s.PaymentServiceClient.ListPayments(ctx, &paymentpb.ListPaymentsRequest{OrderId: int64(order.ID)})
+    if err != nil {
+        return err
+    }
+    settled := true
+    for _, payment := range payments.GetPayments() {
+        switch payment.Status {
+        case paymentpb.PaymentStatus_AUTHORIZED, paymentpb.PaymentStatus_REQUIRES_ACTION:
+            _, err = s.PaymentServiceClient.VoidPayment(ctx, &paymentpb.VoidPaymentRequest{Id: payment.Id})
+        case paymentpb.PaymentStatus_CAPTURED, paymentpb.PaymentStatus_PARTIALLY_REFUNDED:
+            _, err = s.PaymentServiceClient.RefundPayment(ctx, &paymentpb.RefundPaymentRequest{
+                Id:             payment.Id,
+                Reason:         fmt.Sprintf("Order %d cancelled", order.ID),
+                IdempotencyKey: refundKey(order.ID),
+            })
+        case paymentpb.PaymentStatus_PROCESSING:
+            settled = false
+            continue
+        default:
+            continue
+        }
+        if status.Code(err) == codes.FailedPrecondition && payment.Status == paymentpb.PaymentStatus_REQUIRES_ACTION {
+            // The challenge was completed meanwhile, so the payment may now be authorized or captured
+            settled = false
+            continue
+        }
+        if err != nil && status.Code(err) != codes.FailedPrecondition {
+            return err
+        }
+    }
+    if !settled {
+        return nil
+    }
+
+    order.Refund = models.RefundDone
+    return s.db.Model(order).Update("refund", models.RefundDone).Error
+}
+
+// refundCancelledOrders retries the refund of cancelled orders whose payment was not given back
+func (s *server) refundCancelledOrders() {
+    var orders []models.Order
+    if err := s.db.Where("refund = ?", models.RefundPending).Find(&orders).Error; err != nil {
+        log.Printf("refund recovery: failed to load orders: %v", err)
+        return
+    }
+
+    for i := range orders {
+        ctx, cancel := context.WithTimeout(context.Background(), sagaCompensationTimeout)
+        if err := s.refundOrder(ctx, &orders[i]); err != nil {
+            log.Printf("order %d: refund failed, will retry: %v", orders[i].ID, err)
+        }
+        cancel()
+    }
+}
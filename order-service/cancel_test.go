@@ -0,0 +1,236 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    paymentpb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+// fakePayments is a payment-service holding one payment per order, in memory
+type fakePayments struct {
+    paymentpb.PaymentServiceClient
+    payments map[int64]*paymentpb.Payment // By order
+    err      error                        // Returned by every call, e.g. when unreachable
+    lostErr  error                        // Returned after a refund went through, as if its answer was lost
+    refunds  []*paymentpb.RefundPaymentRequest
+}
+
+func newFakePayments() *fakePayments {
+    return &fakePayments{payments: map[int64]*paymentpb.Payment{}}
+}
+
+func (f *fakePayments) ListPayments(ctx context.Context, req *paymentpb.ListPaymentsRequest, opts ...grpc.CallOption) (*paymentpb.ListPaymentsResponse, error) {
+    if f.err != nil {
+        return nil, f.err
+    }
+    res := &paymentpb.ListPaymentsResponse{}
+    if payment, ok := f.payments[req.OrderId]; ok {
+        res.Payments = append(res.Payments, payment)
+    }
+    return res, nil
+}
+
+// payment returns the payment with the given ID
+func (f *fakePayments) payment(id int64) *paymentpb.Payment {
+    for _, payment := range f.payments {
+        if payment.Id == id {
+            return payment
+        }
+    }
+    return nil
+}
+
+func (f *fakePayments) VoidPayment(ctx context.Context, req *paymentpb.VoidPaymentRequest, opts ...grpc.CallOption) (*paymentpb.PaymentResponse, error) {
+    payment := f.payment(req.Id)
+    if payment.Status != paymentpb.PaymentStatus_AUTHORIZED && payment.Status != paymentpb.PaymentStatus_REQUIRES_ACTION {
+        return nil, status.Errorf(codes.FailedPrecondition, "Payment with ID '%d' is %s and can't be voided", req.Id, payment.Status)
+    }
+    payment.Status = paymentpb.PaymentStatus_VOIDED
+    return &paymentpb.PaymentResponse{Payment: payment}, nil
+}
+
+func (f *fakePayments) RefundPayment(ctx context.Context, req *paymentpb.RefundPaymentRequest, opts ...grpc.CallOption) (*paymentpb.PaymentResponse, error) {
+    f.refunds = append(f.refunds, req)
+    payment := f.payment(req.Id)
+    payment.Status = paymentpb.PaymentStatus_REFUNDED
+    if f.lostErr != nil {
+        return nil, f.lostErr
+    }
+    return &paymentpb.PaymentResponse{Payment: payment}, nil
+}
+
+func TestCancelOrderGivesStockBack(t *testing.T) {
+    db := testDB(t)
+    customer := caller{UserID: 3}
+    tests := []struct {
+        name        string
+        commit      bool // The saga committed the hold before the cancellation
+        committedBy bool // The saga committed the hold while the order was being cancelled
+        released    bool
+        restocked   bool
+    }{
+        {name: "before commit", released: true},
+        {name: "after commit", commit: true, restocked: true},
+        {name: "committed meanwhile", committedBy: true, restocked: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            inventory := newFakeInventory()
+            s := &server{db: db, ProductServiceClient: inventory, PaymentServiceClient: newFakePayments()}
+            saga, order := startTestSaga(t, s)
+            if tt.commit {
+                if err := s.completeSaga(context.Background(), saga); err != nil {
+                    t.Fatalf("completeSaga error = %v", err)
+                }
+            }
+            if tt.committedBy {
+                inventory.reservations[saga.ReservationKey] = "COMMITTED"
+            }
+
+            cancelled, err := s.cancelOrder(context.Background(), order.ID, customer, "changed my mind")
+            if err != nil {
+                t.Fatalf("cancelOrder error = %v", err)
+            }
+            if cancelled.Status != models.StatusCancelled || cancelled.Restock != models.RestockDone {
+                t.Errorf("order is %s with restock %q, want CANCELLED and DONE", cancelled.Status, cancelled.Restock)
+            }
+            if released := inventory.reservations[saga.ReservationKey] == "RELEASED"; released != tt.released {
+                t.Errorf("reservation released = %v, want %v", released, tt.released)
+            }
+            if _, restocked := inventory.restocks[restockKey(order.ID)]; restocked != tt.restocked {
+                t.Errorf("restocked = %v, want %v", restocked, tt.restocked)
+            }
+        })
+    }
+}
+
+func TestCancelOrderRetriesRestock(t *testing.T) {
+    db := testDB(t)
+    inventory := newFakeInventory()
+    s := &server{db: db, ProductServiceClient: inventory, PaymentServiceClient: newFakePayments()}
+    saga, order := startTestSaga(t, s)
+    if err := s.completeSaga(context.Background(), saga); err != nil {
+        t.Fatalf("completeSaga error = %v", err)
+    }
+
+    // The cancellation stands even though product-service can't be reached
+    inventory.err = status.Errorf(codes.Unavailable, "connection refused")
+    cancelled, err := s.cancelOrder(context.Background(), order.ID, caller{UserID: 3}, "")
+    if err != nil {
+        t.Fatalf("cancelOrder error = %v", err)
+    }
+    if cancelled.Restock != models.RestockPending {
+        t.Fatalf("restock = %q, want PENDING", cancelled.Restock)
+    }
+
+    inventory.err = nil
+    s.restockCancelledOrders()
+    db.First(&order, order.ID)
+    if order.Restock != models.RestockDone {
+        t.Errorf("restock after recovery = %q, want DONE", order.Restock)
+    }
+    req := inventory.restocks[restockKey(order.ID)]
+    if req == nil {
+        t.Fatal("order was not restocked")
+    }
+    restocked := 0
+    for _, update := range req.InventoryUpdates {
+        restocked += int(update.QuantityChange)
+    }
+    if restocked != 3 {
+        t.Errorf("restocked %d units, want the 3 ordered", restocked)
+    }
+}
+
+func TestCancelOrderGivesPaymentBack(t *testing.T) {
+    db := testDB(t)
+    tests := []struct {
+        name     string
+        payment  paymentpb.PaymentStatus
+        want     paymentpb.PaymentStatus
+        refund   models.RefundStatus
+        refunded bool
+    }{
+        {name: "authorized", payment: paymentpb.PaymentStatus_AUTHORIZED, want: paymentpb.PaymentStatus_VOIDED, refund: models.RefundDone},
+        {name: "awaiting 3-D Secure", payment: paymentpb.PaymentStatus_REQUIRES_ACTION, want: paymentpb.PaymentStatus_VOIDED, refund: models.RefundDone},
+        {name: "captured", payment: paymentpb.PaymentStatus_CAPTURED, want: paymentpb.PaymentStatus_REFUNDED, refund: models.RefundDone, refunded: true},
+        {name: "declined", payment: paymentpb.PaymentStatus_DECLINED, want: paymentpb.PaymentStatus_DECLINED, refund: models.RefundDone},
+        // Left for the recovery worker once the authorization has an outcome
+        {name: "processing", payment: paymentpb.PaymentStatus_PROCESSING, want: paymentpb.PaymentStatus_PROCESSING, refund: models.RefundPending},
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            payments := newFakePayments()
+            s := &server{db: db, ProductServiceClient: newFakeInventory(), PaymentServiceClient: payments}
+            _, order := startTestSaga(t, s)
+            payment := &paymentpb.Payment{Id: int64(i + 1), OrderId: int64(order.ID), Status: tt.payment}
+            payments.payments[int64(order.ID)] = payment
+
+            cancelled, err := s.cancelOrder(context.Background(), order.ID, caller{UserID: 3}, "")
+            if err != nil {
+                t.Fatalf("cancelOrder error = %v", err)
+            }
+            if cancelled.Refund != tt.refund {
+                t.Errorf("refund = %q, want %q", cancelled.Refund, tt.refund)
+            }
+            if payment.Status != tt.want {
+                t.Errorf("payment is %s, want %s", payment.Status, tt.want)
+            }
+            if refunded := len(payments.refunds) == 1 && payments.refunds[0].IdempotencyKey == refundKey(order.ID); refunded != tt.refunded {
+                t.Errorf("refunds = %v, want refunded under the order's key %v", payments.refunds, tt.refunded)
+            }
+        })
+    }
+}
+
+func TestCancelOrderRetriesRefund(t *testing.T) {
+    db := testDB(t)
+    inventory := newFakeInventory()
+    payments := newFakePayments()
+    s := &server{db: db, ProductServiceClient: inventory, PaymentServiceClient: payments}
+    _, order := startTestSaga(t, s)
+    payment := &paymentpb.Payment{Id: 1, OrderId: int64(order.ID), Status: paymentpb.PaymentStatus_AUTHORIZED}
+    payments.payments[int64(order.ID)] = payment
+
+    payments.err = status.Errorf(codes.Unavailable, "connection refused")
+    cancelled, err := s.cancelOrder(context.Background(), order.ID, caller{UserID: 3}, "")
+    if err != nil {
+        t.Fatalf("cancelOrder error = %v", err)
+    }
+    if cancelled.Refund != models.RefundPending {
+        t.Fatalf("refund = %q, want PENDING", cancelled.Refund)
+    }
+
+    payments.err = nil
+    s.refundCancelledOrders()
+    db.First(&order, order.ID)
+    if order.Refund != models.RefundDone || payment.Status != paymentpb.PaymentStatus_VOIDED {
+        t.Errorf("refund is %q with the payment %s, want DONE and VOIDED", order.Refund, payment.Status)
+    }
+}
+
+func TestCancelOrderVoidsPaymentAuthorizedLater(t *testing.T) {
+    db := testDB(t)
+    payments := newFakePayments()
+    s := &server{db: db, ProductServiceClient: newFakeInventory(), PaymentServiceClient: payments}
+    _, order := startTestSaga(t, s)
+    payment := &paymentpb.Payment{Id: 1, OrderId: int64(order.ID), Status: paymentpb.PaymentStatus_PROCESSING}
+    payments.payments[int64(order.ID)] = payment
+
+    if _, err := s.cancelOrder(context.Background(), order.ID, caller{UserID: 3}, ""); err != nil {
+        t.Fatalf("cancelOrder error = %v", err)
+    }
+
+    // The authorization went through after the cancellation
+    payment.Status = paymentpb.PaymentStatus_AUTHORIZED
+    s.refundCancelledOrders()
+    db.First(&order, order.ID)
+    if order.Refund != models.RefundDone || payment.Status != paymentpb.PaymentStatus_VOIDED {
+        t.Errorf("refund is %q with the payment %s, want DONE and VOIDED", order.Refund, payment.Status)
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "testing"
+
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+// testOrder is an order of two items:
+//   - 11: 3 x product 5 from warehouse 2 at 10.00, with 3.00 off and 2.16 tax, so 29.16 paid
+//   - 12: 1 x variant 9 of product 6 at 25.00
+func testOrder() models.Order {
+    return models.Order{
+        Model:    gorm.Model{ID: 1},
+        Currency: "USD",
+        Items: []models.OrderItem{
+            {Model: gorm.Model{ID: 11}, ProductID: 5, Quantity: 3, Price: 1000, Discount: 300, Tax: 216, WarehouseID: 2},
+            {Model: gorm.Model{ID: 12}, ProductID: 6, VariantID: 9, Quantity: 1, Price: 2500},
+        },
+    }
+}
+
+// createTestOrder writes testOrder for customer 3 in the given status, with
+// IDs of its own
+func createTestOrder(t *testing.T, db *gorm.DB, orderStatus models.OrderStatus) models.Order {
+    t.Helper()
+    order := testOrder()
+    order.ID = 0
+    order.CustomerID = 3
+    order.Status = orderStatus
+    for i := range order.Items {
+        order.Items[i].ID = 0
+    }
+    if err := db.Create(&order).Error; err != nil {
+        t.Fatalf("failed to create order: %v", err)
+    }
+    return order
+}
@@ -11,10 +11,17 @@ import (
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
     pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    paymentpb "github.com/atullal/ecommerce-backend-protobuf/payment"
     productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    userpb "github.com/atullal/ecommerce-backend-protobuf/user"
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
     "order-service/models"
+    "github.com/atullal/ecommerce-backend-protobuf/events"
+    "order-service/tax"
     "fmt"
     "time"
     "math"
@@ -24,6 +31,9 @@ type server struct {
     pb.OrderServiceServer
     db *gorm.DB
     ProductServiceClient productpb.ProductServiceClient
+    PaymentServiceClient paymentpb.PaymentServiceClient
+    UserServiceClient userpb.UserServiceClient
+    Tax tax.Provider
 }
 func connectWithBackoff(dsn string) (*gorm.DB, error) {
     var db *gorm.DB
@@ -50,6 +60,9 @@ func connectWithBackoff(dsn string) (*gorm.DB, error) {
     return nil, err // Return the last error
 }
 
+// schemaModels are the tables migrated at startup
+var schemaModels = []interface{}{&models.Order{}, &models.OrderItem{}, &models.Saga{}, &models.SagaStep{}, &events.OutboxEvent{}, &models.OrderStatusHistory{}, &models.Promotion{}, &models.PromotionTarget{}, &models.PromotionRedemption{}, &models.TaxRule{}, &models.OrderItemTax{}, &models.Return{}, &models.ReturnItem{}, &models.ReturnStatusHistory{}, &models.Shipment{}, &models.ShipmentItem{}, &models.ShippingMethod{}, &models.ShippingRate{}}
+
 func initDB() *gorm.DB {
     dsn := os.Getenv("DSN")
     db, err := connectWithBackoff(dsn)
@@ -57,10 +70,22 @@ func initDB() *gorm.DB {
         log.Fatalf("failed to connect database: %v", err)
     }
 
+    // Amounts are kept in minor units; orders from before that are in the store currency
+    currency, err := money.Normalize(money.StoreCurrency())
+    if err != nil {
+        log.Fatalf("invalid CURRENCY: %v", err)
+    }
+    if err := migrateMoneyColumns(db, currency); err != nil {
+        log.Fatalf("failed to migrate order amounts: %v", err)
+    }
+
     // Migrate the schema
-    if err := db.AutoMigrate(&models.Order{}, &models.OrderItem{}); err != nil {
+    if err := db.AutoMigrate(schemaModels...); err != nil {
         log.Fatalf("failed to migrate database: %v", err)
     }
+    if err := backfillCurrency(db, currency); err != nil {
+        log.Fatalf("failed to migrate order amounts: %v", err)
+    }
     fmt.Println("Database connection successful")
     return db
 }
@@ -68,7 +93,7 @@ func initDB() *gorm.DB {
 func (s *server) connectToProductService() {
 
 	// Set up a connection to the gRPC server.
-    productServiceConnection, err := grpc.Dial("0.0.0.0:50052", grpc.WithInsecure())
+    productServiceConnection, err := grpc.Dial(serviceAddress("PRODUCT_SERVICE_ADDR", "0.0.0.0:50052"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(serviceToken)))
     if err != nil {
         log.Fatalf("Failed to connect to gRPC server: %v", err)
     }
@@ -78,63 +103,182 @@ func (s *server) connectToProductService() {
     s.ProductServiceClient = productpb.NewProductServiceClient(productServiceConnection)
 }
 
+func (s *server) connectToPaymentService() {
+    // Set up a connection to the gRPC server.
+    paymentServiceConnection, err := grpc.Dial(serviceAddress("PAYMENT_SERVICE_ADDR", "0.0.0.0:50055"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(serviceToken)))
+    if err != nil {
+        log.Fatalf("Failed to connect to gRPC server: %v", err)
+    }
+    fmt.Println("Connected to gRPC server")
+
+    // Returns are refunded on the order's payment
+    s.PaymentServiceClient = paymentpb.NewPaymentServiceClient(paymentServiceConnection)
+}
+
+func (s *server) connectToUserService() {
+    // Set up a connection to the gRPC server.
+    userServiceConnection, err := grpc.Dial(serviceAddress("USER_SERVICE_ADDR", "0.0.0.0:50051"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(serviceToken)))
+    if err != nil {
+        log.Fatalf("Failed to connect to gRPC server: %v", err)
+    }
+    fmt.Println("Connected to gRPC server")
+
+    // Orders snapshot addresses from the customer's address book
+    s.UserServiceClient = userpb.NewUserServiceClient(userServiceConnection)
+}
+
 func (s *server) CreateOrder(ctx context.Context, req *pb.CreateOrderRequest) (*pb.OrderResponse, error) {
     fmt.Println("Create order request", req)
 
-    // Convert req items to order items and prepare inventory updates
-    orderItems := make([]models.OrderItem, 0, len(req.Items))
-    inventoriesReq := &productpb.UpdateMultipleInventoriesRequest{}
+    // Orders are created by the customer unless an internal service places
+    // them; customers can only order for themselves
+    creator, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if creator.System {
+        creator = caller{UserID: uint(req.CustomerId)}
+    }
+    if !creator.IsAdmin() && uint(req.CustomerId) != creator.UserID {
+        return nil, status.Errorf(codes.PermissionDenied, "Customers can only place their own orders")
+    }
 
-    for _, item := range req.Items {
-        orderItem := models.OrderItem{
-            ProductID: uint(item.ProductId),
-            Quantity:  int(item.Quantity),
+    // A retried request gets the outcome of the first rather than a second order
+    if req.IdempotencyKey != "" {
+        saga, err := s.findKeyedSaga(req.IdempotencyKey)
+        if err != nil {
+            return nil, err
+        }
+        if saga != nil {
+            return s.replayCreateOrder(saga, creator)
         }
-        orderItems = append(orderItems, orderItem)
+    }
+
+    // Resolve current prices so each item keeps a snapshot of what the customer paid
+    pricing, err := s.fetchUnitPrices(ctx, req.Items, req.Currency, customerGroupFromContext(ctx))
+    if err != nil {
+        return nil, err
+    }
+
+    // Convert req items to order items and the saga payload
+    orderItems := newOrderItems(req.Items, pricing)
+    payload := createOrderPayload{CustomerID: uint(req.CustomerId)}
+    for _, orderItem := range orderItems {
+        payload.Items = append(payload.Items, createOrderItem{ProductID: orderItem.ProductID, VariantID: orderItem.VariantID, Quantity: orderItem.Quantity})
+    }
+
+    // Ship to the address chosen from the address book, or the destination sent
+    shipTo, billTo, err := s.orderAddresses(ctx, req.CustomerId, req.ShippingAddressId, req.BillingAddressId, req.Destination)
+    if err != nil {
+        return nil, err
+    }
+    destination, err := destinationFromProto(addressDestination(shipTo, req.Destination))
+    if err != nil {
+        return nil, err
+    }
+    newOrder := models.Order{
+        CustomerID:            uint(req.CustomerId),
+        Items:                 orderItems,
+        Status:                models.StatusPending,
+        Currency:              pricing.Currency,
+        DestinationCountry:    destination.Country,
+        DestinationRegion:     destination.Region,
+        DestinationPostalCode: destination.PostalCode,
+        ShipTo:                shipTo,
+        BillTo:                billTo,
+    }
 
-        inventoryReq := &productpb.UpdateInventoryRequest{
-            ProductId: int64(item.ProductId),
-            QuantityChange: -int32(item.Quantity), // Decrease the quantity
+    // Work out the coupon's discount up front; it is redeemed with the order
+    var discount promotionDiscount
+    if req.CouponCode != "" {
+        promotion, result, err := promotionForOrder(s.db, req.CouponCode, newOrder.CustomerID, pricing, newOrder.Items)
+        if err != nil {
+            return nil, err
         }
-        inventoriesReq.InventoryUpdates = append(inventoriesReq.InventoryUpdates, inventoryReq)
+        applyDiscount(&newOrder, promotion, result)
+        discount = result
     }
 
-    // Start a transaction
-    tx := s.db.Begin()
+    // Tax the items as discounted, for the destination
+    if err := s.applyTax(ctx, &newOrder, pricing); err != nil {
+        return nil, err
+    }
 
-    // Update inventory in product-service
-    _, err := s.ProductServiceClient.UpdateMultipleInventories(ctx, inventoriesReq)
+    // Charge the chosen shipping method, or the cheapest that ships the order
+    if err := s.applyShipping(&newOrder, pricing, req.ShippingMethodId); err != nil {
+        return nil, err
+    }
+
+    // Persist the saga before touching any other service
+    saga, err := s.startCreateOrderSaga(payload, req.IdempotencyKey)
     if err != nil {
-        tx.Rollback()
-        return nil, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
+        // The unique index catches a request with the same key started concurrently
+        if saga, _ := s.findKeyedSaga(req.IdempotencyKey); req.IdempotencyKey != "" && saga != nil {
+            return s.replayCreateOrder(saga, creator)
+        }
+        return nil, status.Errorf(codes.Internal, "Error starting order saga: %v", err)
     }
 
-    // Create the order in the database
-    newOrder := models.Order{
-        CustomerID: uint(req.CustomerId),
-        Items:      orderItems,
-        Status:     models.StatusPending,
-        // Set other fields based on your request and models
+    // Step 1: hold the stock in product-service
+    reservation, err := s.reserveInventory(ctx, saga, payload)
+    if err != nil {
+        if compErr := s.compensateSaga(saga, err); compErr != nil {
+            log.Printf("saga %d: compensation failed, will retry: %v", saga.ID, compErr)
+        }
+        // Stock that ran out is reported as such, so callers know nothing was ordered
+        if isDefiniteFailure(err) {
+            return nil, err
+        }
+        return nil, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
     }
 
-    if err := tx.Create(&newOrder).Error; err != nil {
-        tx.Rollback()
+    // Items are fulfilled from the warehouses the reservation allocated them to
+    assignWarehouses(newOrder.Items, reservation.GetItems())
+
+    // Step 2: create the order in the database and record the step atomically
+    applyTotals(&newOrder, computeTotals(newOrder.Items, discount.Discount, newOrder.Tax, newOrder.Shipping))
+
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&newOrder).Error; err != nil {
+            return err
+        }
+        if err := redeemPromotion(tx, newOrder); err != nil {
+            return err
+        }
+        if err := events.Enqueue(tx, events.OrderCreated, "order", newOrder.ID, newOrderEvent(newOrder, "")); err != nil {
+            return err
+        }
+        if err := recordStatusChange(tx, newOrder.ID, "", newOrder.Status, creator, "order created"); err != nil {
+            return err
+        }
+        if err := s.setStepStatus(tx, sagaStep(saga, stepCreateOrder), models.StepDone); err != nil {
+            return err
+        }
+        saga.OrderID = newOrder.ID
+        return tx.Model(saga).Update("order_id", newOrder.ID).Error
+    })
+    if err != nil {
+        if compErr := s.compensateSaga(saga, err); compErr != nil {
+            log.Printf("saga %d: compensation failed, will retry: %v", saga.ID, compErr)
+        }
+        // A coupon used up by concurrent orders is reported as such
+        if _, ok := status.FromError(err); ok {
+            return nil, err
+        }
         return nil, status.Errorf(codes.Internal, "Error creating order: %v", err)
     }
 
-    // Commit the transaction
-    tx.Commit()
-
-    // Prepare and return the response
-    response := &pb.OrderResponse{
-        Order: &pb.Order{
-            Id:         int64(newOrder.ID),
-            CustomerId: int64(newOrder.CustomerID),
-            // Populate other fields of the protobuf Order message
-        },
+    // Step 3: commit the reservation as a sale; the order already exists, so a
+    // failure here is left to the recovery worker rather than reported
+    if err := s.completeSaga(ctx, saga); err != nil {
+        log.Printf("saga %d: failed to complete, will retry: %v", saga.ID, err)
+    }
+    if saga.Status == models.SagaCompensated {
+        return nil, status.Errorf(codes.FailedPrecondition, "Order with ID '%d' was cancelled: %s", newOrder.ID, saga.LastError)
     }
 
-    return response, nil
+    // Prepare and return the response
+    return &pb.OrderResponse{Order: toProtoOrder(newOrder)}, nil
 }
 
 func mapOrderStatusToProto(status models.OrderStatus) pb.OrderStatus {
@@ -159,45 +303,59 @@ func (s *server) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.Ord
     var order models.Order
 
     // Retrieve the order by ID from the database
-    result := s.db.Preload("Items").First(&order, req.OrderId)
+    result := s.db.Preload("Items.TaxLines").First(&order, req.OrderId)
     if result.Error != nil {
         if errors.Is(result.Error, gorm.ErrRecordNotFound) {
             return nil, status.Errorf(codes.NotFound, "Order with ID '%d' not found", req.OrderId)
         }
         return nil, status.Errorf(codes.Internal, "Error retrieving order: %v", result.Error)
     }
-
-    // Convert the order and its items to the protobuf type
-    orderItems := make([]*pb.OrderItem, len(order.Items))
-    for i, item := range order.Items {
-        orderItems[i] = &pb.OrderItem{
-            ProductId: int64(item.ProductID),
-            Quantity:  int32(item.Quantity),
-        }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
     }
-
-    // Prepare and return the response
-    response := &pb.OrderResponse{
-        Order: &pb.Order{
-            Id:         int64(order.ID),
-            CustomerId: int64(order.CustomerID),
-            Items:      orderItems,
-            Status:     mapOrderStatusToProto(order.Status), // Convert to protobuf enum
-            // Include other fields like TotalPrice, etc.
-        },
+    if err := authorizeOrder(actor, order); err != nil {
+        return nil, err
     }
 
-    return response, nil
+    // Prepare and return the response
+    return &pb.OrderResponse{Order: toProtoOrder(order)}, nil
 }
 
 func (s *server) UpdateOrder(ctx context.Context, req *pb.UpdateOrderRequest) (*pb.OrderResponse, error) {
     var order models.Order
 
+    newStatus, err := mapProtoToOrderStatus(req.Status)
+    if err != nil {
+        return nil, err
+    }
+    // Only admins may change the status of an order directly
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if !actor.IsAdmin() {
+        return nil, status.Errorf(codes.PermissionDenied, "Only admins can update orders")
+    }
+
+    // Cancelling has to give the stock back, so it takes the cancel flow
+    if newStatus == models.StatusCancelled {
+        order, err := s.cancelOrder(ctx, uint(req.OrderId), actor, req.Reason)
+        if err != nil {
+            return nil, err
+        }
+        return &pb.OrderResponse{Order: toProtoOrder(order)}, nil
+    }
+    // Orders ship and arrive through their shipments, which record what went out
+    if newStatus == models.StatusShipped || newStatus == models.StatusDelivered {
+        return nil, status.Errorf(codes.FailedPrecondition, "Orders become %s through their shipments", newStatus)
+    }
+
     // Start a transaction
     tx := s.db.Begin()
 
-    // Find the order by ID
-    if err := tx.First(&order, req.OrderId).Error; err != nil {
+    // Find the order by ID, locking it so concurrent transitions are serialized
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items.TaxLines").First(&order, req.OrderId).Error; err != nil {
         tx.Rollback()
         if errors.Is(err, gorm.ErrRecordNotFound) {
             return nil, status.Errorf(codes.NotFound, "Order with ID '%d' not found", req.OrderId)
@@ -205,71 +363,110 @@ func (s *server) UpdateOrder(ctx context.Context, req *pb.UpdateOrderRequest) (*
         return nil, status.Errorf(codes.Internal, "Error retrieving order: %v", err)
     }
 
-    // Update the order fields
-    order.Status = models.OrderStatus(req.Status) // Assuming status is an enum in your protobuf
-    // Update other fields as necessary based on req
-
-    // Save the updated order
-    if err := tx.Save(&order).Error; err != nil {
+    // Move the order through the state machine, recording history and the event
+    if err := transitionOrder(tx, &order, newStatus, actor, req.Reason); err != nil {
         tx.Rollback()
-        return nil, status.Errorf(codes.Internal, "Error updating order: %v", err)
+        return nil, err
     }
 
     // Commit the transaction
-    tx.Commit()
+    if err := tx.Commit().Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error updating order: %v", err)
+    }
 
     // Prepare and return the response
-    response := &pb.OrderResponse{
-        Order: &pb.Order{
-            Id:         int64(order.ID),
-            CustomerId: int64(order.CustomerID),
-            Status:     mapOrderStatusToProto(order.Status),
-            // Include other fields and order items if necessary
-        },
+    return &pb.OrderResponse{Order: toProtoOrder(order)}, nil
+}
+
+func (s *server) GetOrderHistory(ctx context.Context, req *pb.GetOrderHistoryRequest) (*pb.OrderHistoryResponse, error) {
+    var order models.Order
+    if err := s.db.Select("id", "customer_id").First(&order, req.OrderId).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Order with ID '%d' not found", req.OrderId)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+    }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := authorizeOrder(actor, order); err != nil {
+        return nil, err
     }
 
-    return response, nil
+    var history []models.OrderStatusHistory
+    if err := s.db.Where("order_id = ?", order.ID).Order("changed_at, id").Find(&history).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving order history: %v", err)
+    }
+
+    changes := make([]*pb.OrderStatusChange, 0, len(history))
+    for _, change := range history {
+        changes = append(changes, toProtoStatusChange(change))
+    }
+    return &pb.OrderHistoryResponse{Changes: changes}, nil
 }
 
 func (s *server) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
     var orders []models.Order
     query := s.db
 
+    // Customers only ever list their own orders
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if !actor.IsAdmin() {
+        req.CustomerId = int64(actor.UserID)
+    }
+
     // Implement filtering based on the request, e.g., customer ID
     if req.CustomerId != 0 {
         query = query.Where("customer_id = ?", req.CustomerId)
     }
     // Add more filters as needed based on your application requirements
 
-    // Implement pagination (if required)
-    // Example: offset := (req.Page - 1) * req.PageSize
-    // query = query.Offset(offset).Limit(req.PageSize)
+    // Implement pagination: newest first, continuing below the cursor's order
+    if req.PageToken != "" {
+        cursor, err := decodeOrderCursor(req.PageToken, req.CustomerId)
+        if err != nil {
+            return nil, err
+        }
+        query = query.Where("id < ?", cursor.ID)
+    }
+    pageSize := orderPageSize(req.PageSize)
 
-    // Retrieve the orders from the database
-    if err := query.Preload("Items").Find(&orders).Error; err != nil {
+    // Retrieve the orders from the database, with one extra telling whether
+    // there is another page
+    if err := query.Preload("Items.TaxLines").Order("id DESC").Limit(pageSize + 1).Find(&orders).Error; err != nil {
         return nil, status.Errorf(codes.Internal, "Error retrieving orders: %v", err)
     }
+    res := &pb.ListOrdersResponse{}
+    if len(orders) > pageSize {
+        orders = orders[:pageSize]
+        res.NextPageToken = encodeOrderCursor(orderCursor{CustomerID: req.CustomerId, ID: orders[pageSize-1].ID})
+    }
 
     // Convert the orders to the protobuf type and create the response
     pbOrders := []*pb.Order{}
     for _, order := range orders {
-        pbOrder := &pb.Order{
-            Id:         int64(order.ID),
-            CustomerId: int64(order.CustomerID),
-            Status:     mapOrderStatusToProto(order.Status), // Convert to protobuf enum
-            // Map other fields as necessary
-        }
-        // Map order items if needed
-        // ...
-
-        pbOrders = append(pbOrders, pbOrder)
+        pbOrders = append(pbOrders, toProtoOrder(order))
     }
+    res.Orders = pbOrders
 
-    return &pb.ListOrdersResponse{Orders: pbOrders}, nil
+    return res, nil
 }
 
 
 
+// serviceAddress returns the address of another service from the environment
+// variable env, e.g. PRODUCT_SERVICE_ADDR=product-service:50052, or fallback
+func serviceAddress(env, fallback string) string {
+    if address := os.Getenv(env); address != "" {
+        return address
+    }
+    return fallback
+}
+
 func main() {
 	db := initDB()
 	fmt.Println(db)
@@ -277,9 +474,34 @@ func main() {
     if err != nil {
         log.Fatalf("failed to listen: %v", err)
     }
-    s := grpc.NewServer()
+    // Only other services holding the shared token may call order-service
+    if serviceToken, err = serviceauth.TokenFromEnv(); err != nil {
+        log.Fatalf("failed to configure service authentication: %v", err)
+    }
+    s := grpc.NewServer(grpc.UnaryInterceptor(serviceauth.UnaryServerInterceptor(serviceToken)))
     serv := &server{db: db}
     serv.connectToProductService()
+    serv.connectToPaymentService()
+    serv.connectToUserService()
+
+    // Orders are taxed by the configured provider
+    if serv.Tax, err = tax.NewProviderFromEnv(db); err != nil {
+        log.Fatalf("failed to create tax provider: %v", err)
+    }
+    go serv.runSagaRecovery()
+
+    // Publish outbox events to the configured broker
+    broker, err := events.NewBrokerFromEnv("order-service")
+    if err != nil {
+        log.Fatalf("failed to create event broker: %v", err)
+    }
+    if broker != nil {
+        defer broker.Close()
+        go events.NewRelay(db, broker).Run(context.Background())
+    } else {
+        log.Printf("EVENT_BROKER is none, events are kept in the outbox")
+    }
+
     pb.RegisterOrderServiceServer(s, serv)
     log.Printf("server listening at %v", lis.Addr())
     if err := s.Serve(lis); err != nil {
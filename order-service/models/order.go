@@ -1,6 +1,9 @@
 package models
 
 import (
+    "time"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
     "gorm.io/gorm"
 )
 
@@ -10,21 +13,63 @@ type Order struct {
     CustomerID  uint        `gorm:"index"`  // Assuming you have customer IDs as uint
     Items       []OrderItem // Association with OrderItem
     Status      OrderStatus // Custom type defined below
-    TotalPrice  float64     // Total price of the order
-    // Add other fields like shipping address, payment details, etc.
+    Currency    string      `gorm:"size:3;not null;default:''"` // ISO 4217 code of the item prices and all amounts below
+    Subtotal    int64       `gorm:"not null;default:0"` // Sum of item prices times quantities, in minor units
+    Discount    int64       `gorm:"not null;default:0"` // Discount deducted from the subtotal
+    Tax         int64       `gorm:"not null;default:0"` // Tax added to the order
+    Shipping    int64       `gorm:"not null;default:0"` // Shipping cost added to the order
+    TotalPrice  int64       // Total price of the order
+    Restock     RestockStatus `gorm:"not null;default:''"` // Whether a cancelled order's stock has been given back
+    Refund      RefundStatus  `gorm:"not null;default:''"` // Whether a cancelled order's payment has been voided or refunded
+    PromotionID *uint       // Promotion redeemed with CouponCode, if any
+    CouponCode  string      `gorm:"not null;default:''"`
+    FreeShipping bool       `gorm:"not null;default:false"` // Shipping is waived by the promotion
+    IncludedTax int64       `gorm:"not null;default:0"` // Tax contained in the item prices, not added to the total
+    DestinationCountry    string `gorm:"size:2;not null;default:''"` // Where the order ships to, which decides its tax
+    DestinationRegion     string `gorm:"not null;default:''"`
+    DestinationPostalCode string `gorm:"not null;default:''"`
+    ShippingMethodID      uint   `gorm:"not null;default:0"` // Method the order ships with, 0 when none is configured
+    ShippingMethodName    string `gorm:"not null;default:''"`
+    ShipTo      OrderAddress `gorm:"embedded;embeddedPrefix:ship_to_"` // Address the order ships to, as it was when the order was placed
+    BillTo      OrderAddress `gorm:"embedded;embeddedPrefix:bill_to_"` // Address the order is billed to
+}
+
+// OrderAddress is a copy of an address book entry taken when the order was
+// placed, so editing or deleting the entry later doesn't change the order
+type OrderAddress struct {
+    AddressID  uint   `gorm:"not null;default:0"` // Address book entry it was copied from, 0 for none
+    Name       string `gorm:"not null;default:''"`
+    Company    string `gorm:"not null;default:''"`
+    Line1      string `gorm:"not null;default:''"`
+    Line2      string `gorm:"not null;default:''"`
+    City       string `gorm:"not null;default:''"`
+    Region     string `gorm:"not null;default:''"`
+    PostalCode string `gorm:"not null;default:''"`
+    Country    string `gorm:"size:2;not null;default:''"`
+    Phone      string `gorm:"not null;default:''"`
 }
 
 // OrderItem represents an item in an order
 type OrderItem struct {
     gorm.Model
-    OrderID   uint    // Foreign key for the Order
-    ProductID uint    // Assuming product IDs as uint
-    Quantity  int     // Quantity of the product
-    Price     float64 // Price of an individual item
-    Version   int     // Optimistic locking version
+    OrderID     uint    // Foreign key for the Order
+    ProductID   uint    // Assuming product IDs as uint
+    VariantID   uint    `gorm:"not null;default:0"` // Variant ordered, 0 for products without variants
+    Quantity    int     // Quantity of the product
+    Price       int64   // Unit price in minor units of the order's currency, captured when the order was placed; later price changes never touch it
+    Discount    int64   `gorm:"not null;default:0"` // Share of the order discount taken off the line, in minor units
+    Tax         int64   `gorm:"not null;default:0"` // Tax added to the line, the sum of its tax lines that aren't inclusive
+    TaxLines    []OrderItemTax // Tax charged on the line, one per tax rule
+    WarehouseID uint    `gorm:"not null;default:0"` // Warehouse the item is fulfilled from, 0 for the default warehouse
+    Version     int     // Optimistic locking version
     // You can add more fields if necessary
 }
 
+// Money returns an amount in the order's currency
+func (o Order) Money(amount int64) money.Money {
+    return money.Money{Amount: amount, Currency: o.Currency}
+}
+
 // OrderStatus represents the status of an order
 type OrderStatus string
 
@@ -36,3 +81,67 @@ const (
     StatusDelivered  OrderStatus = "DELIVERED"
     StatusCancelled  OrderStatus = "CANCELLED"
 )
+
+// orderTransitions declares the statuses each status may move to:
+// PENDING -> CONFIRMED -> SHIPPED -> DELIVERED, with cancellation
+// possible until the order has shipped
+var orderTransitions = map[OrderStatus][]OrderStatus{
+    StatusPending:   {StatusConfirmed, StatusCancelled},
+    StatusConfirmed: {StatusShipped, StatusCancelled},
+    StatusShipped:   {StatusDelivered},
+    StatusDelivered: {},
+    StatusCancelled: {},
+}
+
+// IsValid reports whether the status is one of the declared statuses
+func (s OrderStatus) IsValid() bool {
+    _, ok := orderTransitions[s]
+    return ok
+}
+
+// CanTransitionTo reports whether an order may move from s to next
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+    for _, allowed := range orderTransitions[s] {
+        if allowed == next {
+            return true
+        }
+    }
+    return false
+}
+
+// RestockStatus tracks returning the stock of a cancelled order
+type RestockStatus string
+
+// Enum values for RestockStatus
+const (
+    RestockNone    RestockStatus = ""        // Nothing to give back
+    RestockPending RestockStatus = "PENDING" // Cancelled, stock not yet returned
+    RestockDone    RestockStatus = "DONE"
+)
+
+// RefundStatus tracks giving back the payment of a cancelled order
+type RefundStatus string
+
+// Enum values for RefundStatus
+const (
+    RefundNone    RefundStatus = ""        // Nothing to give back
+    RefundPending RefundStatus = "PENDING" // Cancelled, payment not yet voided or refunded
+    RefundDone    RefundStatus = "DONE"
+)
+
+// OrderStatusHistory records a single status change of an order
+type OrderStatusHistory struct {
+    ID         uint        `gorm:"primarykey"`
+    OrderID    uint        `gorm:"index"`
+    FromStatus OrderStatus // Empty for the entry recorded when the order was created
+    ToStatus   OrderStatus
+    ActorID    uint        // User who made the change, 0 for the system
+    ActorRole  int
+    Reason     string
+    ChangedAt  time.Time
+}
+
+// TableName keeps the history in a single, explicitly named table
+func (OrderStatusHistory) TableName() string {
+    return "order_status_history"
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Promotion is a discount redeemed with a coupon code. Amounts are in minor
+// units of Currency, which is empty when the promotion sets no amounts.
+type Promotion struct {
+    gorm.Model
+    Code                  string        `gorm:"size:64;index:idx_promotions_code,unique,where:deleted_at IS NULL"` // Upper case
+    Name                  string
+    Type                  PromotionType `gorm:"not null;default:'PERCENTAGE'"`
+    PercentOff            int           `gorm:"not null;default:0"`
+    AmountOff             int64         `gorm:"not null;default:0"`
+    MinSubtotal           int64         `gorm:"not null;default:0"`
+    Currency              string        `gorm:"size:3;not null;default:''"`
+    UsageLimit            int           `gorm:"not null;default:0"` // Redemptions in total, 0 for no limit
+    UsageLimitPerCustomer int           `gorm:"not null;default:0"`
+    TimesUsed             int           `gorm:"not null;default:0"` // Kept with the redemptions under a row lock
+    StartsAt              *time.Time
+    EndsAt                *time.Time
+    Active                bool              `gorm:"not null;default:true"`
+    Targets               []PromotionTarget // Products, categories and customers the promotion is limited to
+}
+
+// PromotionType is the kind of discount a promotion gives
+type PromotionType string
+
+// Enum values for PromotionType
+const (
+    PromotionPercentage   PromotionType = "PERCENTAGE"    // PercentOff of the matching items
+    PromotionFixedAmount  PromotionType = "FIXED_AMOUNT"  // AmountOff the matching items
+    PromotionFreeShipping PromotionType = "FREE_SHIPPING" // Waives the shipping charge
+)
+
+// PromotionTarget limits a promotion to a product, a category or a customer.
+// Without targets of a kind the promotion is not limited in that way.
+type PromotionTarget struct {
+    ID          uint       `gorm:"primarykey"`
+    PromotionID uint       `gorm:"index"`
+    Kind        TargetKind `gorm:"size:16"`
+    TargetID    uint
+}
+
+// TargetKind is what a promotion target refers to
+type TargetKind string
+
+// Enum values for TargetKind
+const (
+    TargetProduct  TargetKind = "PRODUCT"
+    TargetCategory TargetKind = "CATEGORY"
+    TargetCustomer TargetKind = "CUSTOMER"
+)
+
+// PromotionRedemption records a promotion used by an order. Cancelling the
+// order removes it, giving the use back.
+type PromotionRedemption struct {
+    ID          uint `gorm:"primarykey"`
+    PromotionID uint `gorm:"index"`
+    OrderID     uint `gorm:"uniqueIndex"`
+    CustomerID  uint `gorm:"index"`
+    CreatedAt   time.Time
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Return is a return merchandise authorization for items of a delivered
+// order. RefundAmount is in minor units of the order's currency.
+type Return struct {
+    gorm.Model
+    OrderID      uint          `gorm:"index"`
+    CustomerID   uint          `gorm:"index"`
+    Status       ReturnStatus  `gorm:"size:16"`
+    Reason       string
+    Items        []ReturnItem
+    RefundAmount int64         `gorm:"not null;default:0"` // Decided on inspection
+    PaymentID    uint          `gorm:"not null;default:0"` // Payment the refund was issued on
+    Restock      RestockStatus `gorm:"not null;default:''"` // Whether the accepted items are back in stock
+    History      []ReturnStatusHistory // Status changes, oldest first
+}
+
+// ReturnItem is an order item being returned and what became of it
+type ReturnItem struct {
+    ID               uint `gorm:"primarykey"`
+    ReturnID         uint `gorm:"index"`
+    OrderItemID      uint `gorm:"index"`
+    ProductID        uint
+    VariantID        uint `gorm:"not null;default:0"`
+    WarehouseID      uint `gorm:"not null;default:0"` // Warehouse the item was fulfilled from, where it is restocked
+    Quantity         int  // Requested
+    ReceivedQuantity int  `gorm:"not null;default:0"` // Arrived back at the warehouse
+    AcceptedQuantity int  `gorm:"not null;default:0"` // Refunded and put back in stock after inspection
+}
+
+// ReturnStatus represents the status of a return
+type ReturnStatus string
+
+// Enum values for ReturnStatus
+const (
+    ReturnRequested ReturnStatus = "REQUESTED"
+    ReturnApproved  ReturnStatus = "APPROVED"
+    ReturnRejected  ReturnStatus = "REJECTED"
+    ReturnReceived  ReturnStatus = "RECEIVED"
+    ReturnInspected ReturnStatus = "INSPECTED" // Refund decided but not yet issued
+    ReturnRefunded  ReturnStatus = "REFUNDED"
+    ReturnClosed    ReturnStatus = "CLOSED"    // Inspected without a refund
+)
+
+// returnTransitions declares the statuses each status may move to:
+// REQUESTED -> APPROVED -> RECEIVED -> INSPECTED -> REFUNDED, unless the
+// request is rejected or the inspection finds nothing to refund
+var returnTransitions = map[ReturnStatus][]ReturnStatus{
+    ReturnRequested: {ReturnApproved, ReturnRejected},
+    ReturnApproved:  {ReturnReceived},
+    ReturnReceived:  {ReturnInspected},
+    ReturnInspected: {ReturnRefunded, ReturnClosed},
+    ReturnRejected:  {},
+    ReturnRefunded:  {},
+    ReturnClosed:    {},
+}
+
+// CanTransitionTo reports whether a return may move from s to next
+func (s ReturnStatus) CanTransitionTo(next ReturnStatus) bool {
+    for _, allowed := range returnTransitions[s] {
+        if allowed == next {
+            return true
+        }
+    }
+    return false
+}
+
+// ReturnStatusHistory records a single status change of a return
+type ReturnStatusHistory struct {
+    ID         uint         `gorm:"primarykey"`
+    ReturnID   uint         `gorm:"index"`
+    FromStatus ReturnStatus // Empty for the entry recorded when the return was requested
+    ToStatus   ReturnStatus
+    ActorID    uint         // User who made the change, 0 for the system
+    ActorRole  int
+    Reason     string
+    ChangedAt  time.Time
+}
+
+// TableName keeps the history in a single, explicitly named table
+func (ReturnStatusHistory) TableName() string {
+    return "return_status_history"
+}
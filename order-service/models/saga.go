@@ -0,0 +1,55 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// Saga tracks a multi-step operation that spans services, so it can be
+// finished or rolled back after a crash
+type Saga struct {
+    gorm.Model
+    Type           string     `gorm:"index"` // Kind of saga, e.g. SagaTypeCreateOrder
+    Status         SagaStatus `gorm:"index"`
+    IdempotencyKey string     `gorm:"index:idx_sagas_key,unique,where:idempotency_key <> ''"` // Key of the request that started it, if any
+    OrderID        uint       // Order produced by the saga, set once it is created
+    Payload        string     // JSON encoded input the saga was started with
+    ReservationKey string     // Reservation holding the order's stock; replaced if the first hold is lost
+    LastError      string     // Most recent failure, kept for troubleshooting
+    Steps          []SagaStep // Association with SagaStep
+}
+
+// SagaStep is a single persisted step of a saga
+type SagaStep struct {
+    gorm.Model
+    SagaID         uint `gorm:"index"` // Foreign key for the Saga
+    Name           string
+    Status         SagaStepStatus
+    IdempotencyKey string // Key sent to the remote service so retries are applied once
+}
+
+// Saga types
+const (
+    SagaTypeCreateOrder = "CREATE_ORDER"
+)
+
+// SagaStatus represents the overall state of a saga
+type SagaStatus string
+
+// Enum values for SagaStatus
+const (
+    SagaStarted      SagaStatus = "STARTED"
+    SagaCompleted    SagaStatus = "COMPLETED"
+    SagaCompensating SagaStatus = "COMPENSATING"
+    SagaCompensated  SagaStatus = "COMPENSATED"
+)
+
+// SagaStepStatus represents the state of a single saga step
+type SagaStepStatus string
+
+// Enum values for SagaStepStatus
+const (
+    StepPending     SagaStepStatus = "PENDING"
+    StepStarted     SagaStepStatus = "STARTED" // Request sent, outcome not yet known
+    StepDone        SagaStepStatus = "DONE"
+    StepCompensated SagaStepStatus = "COMPENSATED"
+)
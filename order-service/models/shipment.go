@@ -0,0 +1,38 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Shipment is a parcel sent for some or all items of a confirmed order
+type Shipment struct {
+    gorm.Model
+    OrderID        uint           `gorm:"index"`
+    Carrier        string         `gorm:"size:32"`
+    TrackingNumber string         `gorm:"size:64"`
+    Status         ShipmentStatus `gorm:"size:16"`
+    Items          []ShipmentItem
+    ShippedAt      time.Time
+    DeliveredAt    *time.Time // Nil until delivered
+}
+
+// ShipmentItem is a quantity of an order item sent in a shipment
+type ShipmentItem struct {
+    ID          uint `gorm:"primarykey"`
+    ShipmentID  uint `gorm:"index"`
+    OrderItemID uint `gorm:"index"`
+    ProductID   uint
+    VariantID   uint `gorm:"not null;default:0"`
+    Quantity    int
+}
+
+// ShipmentStatus represents the status of a shipment
+type ShipmentStatus string
+
+// Enum values for ShipmentStatus
+const (
+    ShipmentInTransit ShipmentStatus = "IN_TRANSIT"
+    ShipmentDelivered ShipmentStatus = "DELIVERED"
+)
@@ -0,0 +1,35 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// ShippingMethod is a way of shipping orders, e.g. standard or express, with
+// its rates per zone. Amounts are in minor units of Currency.
+type ShippingMethod struct {
+    gorm.Model
+    Name      string
+    Type      ShippingMethodType `gorm:"size:16;not null;default:'FLAT_RATE'"`
+    Currency  string             `gorm:"size:3"` // Only orders in this currency can use the method
+    FreeAbove int64              `gorm:"not null;default:0"` // Shipping is free from this subtotal after discounts, 0 for never
+    Active    bool               `gorm:"not null;default:false"`
+    Rates     []ShippingRate     // What the method charges per zone and, for weight tables, per weight bracket
+}
+
+// ShippingMethodType is how a shipping method works out its charge
+type ShippingMethodType string
+
+// Enum values for ShippingMethodType
+const (
+    ShippingFlatRate    ShippingMethodType = "FLAT_RATE"    // One amount per zone
+    ShippingWeightTable ShippingMethodType = "WEIGHT_TABLE" // An amount per weight bracket per zone
+)
+
+// ShippingRate is what a shipping method charges in a zone, up to a weight
+type ShippingRate struct {
+    ID               uint   `gorm:"primarykey"`
+    ShippingMethodID uint   `gorm:"index"`
+    Countries        string `gorm:"not null;default:''"` // Comma-separated ISO 3166-1 alpha-2 codes of the zone, empty for everywhere else
+    MaxWeight        int    `gorm:"not null;default:0"`  // Grams; 0 for any weight
+    Amount           int64
+}
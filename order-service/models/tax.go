@@ -0,0 +1,30 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// TaxRule is a tax rate for products of a tax class shipped to a country,
+// optionally narrowed to a region and to postal codes starting with a prefix
+type TaxRule struct {
+    gorm.Model
+    Name             string
+    Country          string `gorm:"size:2;index"` // ISO 3166-1 alpha-2 code
+    Region           string `gorm:"not null;default:''"` // Empty for the whole country
+    PostalCodePrefix string `gorm:"not null;default:''"` // Empty for every postal code
+    TaxClass         string `gorm:"size:32;not null;default:''"` // Product tax class, empty for the standard class
+    Rate             int64  // In millionths of the taxed amount, e.g. 88750 for 8.875%
+    Inclusive        bool   `gorm:"not null;default:false"` // Prices already include the tax
+}
+
+// OrderItemTax is the tax one rule charged on an order item, kept so invoices
+// and refunds can reverse exact amounts
+type OrderItemTax struct {
+    ID          uint   `gorm:"primarykey"`
+    OrderItemID uint   `gorm:"index"`
+    TaxRuleID   uint   // The rule may change or go away later; the line keeps what was charged
+    Name        string
+    Rate        int64  // In millionths of the taxed amount
+    Inclusive   bool
+    Amount      int64  // In minor units of the order's currency
+}
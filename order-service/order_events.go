@@ -0,0 +1,40 @@
+package main
+
+import (
+    "order-service/models"
+)
+
+// orderEvent is the data published with order events
+type orderEvent struct {
+    OrderID        uint               `json:"order_id"`
+    CustomerID     uint               `json:"customer_id"`
+    Status         models.OrderStatus `json:"status"`
+    PreviousStatus models.OrderStatus `json:"previous_status,omitempty"`
+    Currency       string             `json:"currency"`
+    TotalPrice     int64              `json:"total_price"` // In minor units of the currency
+    Items          []orderEventItem   `json:"items"`
+}
+
+type orderEventItem struct {
+    ProductID uint  `json:"product_id"`
+    VariantID uint  `json:"variant_id,omitempty"`
+    Quantity  int   `json:"quantity"`
+    Price     int64 `json:"price"` // Unit price in minor units of the order's currency
+}
+
+// newOrderEvent builds the event data for an order
+func newOrderEvent(order models.Order, previousStatus models.OrderStatus) orderEvent {
+    event := orderEvent{
+        OrderID:        order.ID,
+        CustomerID:     order.CustomerID,
+        Status:         order.Status,
+        PreviousStatus: previousStatus,
+        Currency:       order.Currency,
+        TotalPrice:     order.TotalPrice,
+        Items:          make([]orderEventItem, 0, len(order.Items)),
+    }
+    for _, item := range order.Items {
+        event.Items = append(event.Items, orderEventItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity, Price: item.Price})
+    }
+    return event
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "reflect"
+    "testing"
+
+    "github.com/atullal/ecommerce-backend-protobuf/events"
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+func TestNewOrderEvent(t *testing.T) {
+    order := testOrder()
+    order.CustomerID = 3
+    order.Status = models.StatusConfirmed
+    order.TotalPrice = 5416
+
+    got := newOrderEvent(order, models.StatusPending)
+    want := orderEvent{
+        OrderID:        1,
+        CustomerID:     3,
+        Status:         models.StatusConfirmed,
+        PreviousStatus: models.StatusPending,
+        Currency:       "USD",
+        TotalPrice:     5416,
+        Items: []orderEventItem{
+            {ProductID: 5, Quantity: 3, Price: 1000},
+            {ProductID: 6, VariantID: 9, Quantity: 1, Price: 2500},
+        },
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("newOrderEvent = %+v, want %+v", got, want)
+    }
+
+    // A new order has no previous status, and an order without items still
+    // lists them as an empty array
+    data, _ := json.Marshal(newOrderEvent(models.Order{Status: models.StatusPending}, ""))
+    var fields map[string]interface{}
+    json.Unmarshal(data, &fields)
+    if _, ok := fields["previous_status"]; ok {
+        t.Errorf("event %s has a previous_status, want it omitted", data)
+    }
+    if items, ok := fields["items"].([]interface{}); !ok || len(items) != 0 {
+        t.Errorf("event %s items = %v, want []", data, fields["items"])
+    }
+}
+
+func TestOrderEventsFollowTheirTransaction(t *testing.T) {
+    db := testDB(t)
+    order := createTestOrder(t, db, models.StatusPending)
+    published := func() []events.OutboxEvent {
+        var outbox []events.OutboxEvent
+        db.Where("aggregate_type = ? AND aggregate_id = ?", "order", order.ID).Find(&outbox)
+        return outbox
+    }
+
+    // A transition rolled back takes its event with it
+    rollback := errors.New("rolled back")
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if err := transitionOrder(tx, &order, models.StatusConfirmed, caller{}, ""); err != nil {
+            return err
+        }
+        return rollback
+    })
+    if err != rollback {
+        t.Fatalf("transaction error = %v, want %v", err, rollback)
+    }
+    if outbox := published(); len(outbox) != 0 {
+        t.Fatalf("outbox holds %d events of a rolled back transition, want none", len(outbox))
+    }
+
+    order.Status = models.StatusPending
+    if err := transitionOrder(db, &order, models.StatusConfirmed, caller{}, ""); err != nil {
+        t.Fatalf("transitionOrder error = %v", err)
+    }
+    outbox := published()
+    if len(outbox) != 1 {
+        t.Fatalf("outbox holds %d events, want 1", len(outbox))
+    }
+    if outbox[0].Subject != events.OrderUpdated || outbox[0].PublishedAt != nil {
+        t.Errorf("event subject = %s, published %v, want an unpublished %s", outbox[0].Subject, outbox[0].PublishedAt, events.OrderUpdated)
+    }
+    var data orderEvent
+    if err := json.Unmarshal([]byte(outbox[0].Payload), &data); err != nil {
+        t.Fatalf("event payload %q: %v", outbox[0].Payload, err)
+    }
+    if data.Status != models.StatusConfirmed || data.PreviousStatus != models.StatusPending {
+        t.Errorf("event moves the order from %s to %s, want PENDING to CONFIRMED", data.PreviousStatus, data.Status)
+    }
+}
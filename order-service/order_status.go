@@ -0,0 +1,72 @@
+package main
+
+import (
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "gorm.io/gorm"
+    "github.com/atullal/ecommerce-backend-protobuf/events"
+    "order-service/models"
+)
+
+// mapProtoToOrderStatus converts a protobuf status, rejecting undefined values
+func mapProtoToOrderStatus(s pb.OrderStatus) (models.OrderStatus, error) {
+    name, ok := pb.OrderStatus_name[int32(s)]
+    if !ok || !models.OrderStatus(name).IsValid() {
+        return "", status.Errorf(codes.InvalidArgument, "Invalid order status '%d'", s)
+    }
+    return models.OrderStatus(name), nil
+}
+
+// recordStatusChange writes an entry to the order's status history
+func recordStatusChange(tx *gorm.DB, orderID uint, from, to models.OrderStatus, actor caller, reason string) error {
+    return tx.Create(&models.OrderStatusHistory{
+        OrderID:    orderID,
+        FromStatus: from,
+        ToStatus:   to,
+        ActorID:    actor.UserID,
+        ActorRole:  actor.Role,
+        Reason:     reason,
+        ChangedAt:  time.Now(),
+    }).Error
+}
+
+// transitionOrder moves the order to the given status within tx, recording
+// the change in the status history and the outbox. Moving an order to the
+// status it already has is a no-op; any other transition not declared by the
+// state machine fails with codes.FailedPrecondition.
+func transitionOrder(tx *gorm.DB, order *models.Order, to models.OrderStatus, actor caller, reason string) error {
+    from := order.Status
+    if from == to {
+        return nil
+    }
+    if !from.CanTransitionTo(to) {
+        return status.Errorf(codes.FailedPrecondition, "Order with ID '%d' cannot move from %s to %s", order.ID, from, to)
+    }
+
+    order.Status = to
+    if err := tx.Omit("Items").Save(order).Error; err != nil {
+        return status.Errorf(codes.Internal, "Error updating order: %v", err)
+    }
+    if err := recordStatusChange(tx, order.ID, from, to, actor, reason); err != nil {
+        return status.Errorf(codes.Internal, "Error recording status history: %v", err)
+    }
+    if err := events.Enqueue(tx, events.OrderUpdated, "order", order.ID, newOrderEvent(*order, from)); err != nil {
+        return status.Errorf(codes.Internal, "Error recording order event: %v", err)
+    }
+    return nil
+}
+
+// toProtoStatusChange converts a history entry to its protobuf representation
+func toProtoStatusChange(change models.OrderStatusHistory) *pb.OrderStatusChange {
+    return &pb.OrderStatusChange{
+        FromStatus: string(change.FromStatus),
+        ToStatus:   string(change.ToStatus),
+        ActorId:    int64(change.ActorID),
+        ActorRole:  int32(change.ActorRole),
+        Reason:     change.Reason,
+        ChangedAt:  change.ChangedAt.Format(time.RFC3339),
+    }
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+func TestCanTransitionTo(t *testing.T) {
+    statuses := []models.OrderStatus{models.StatusPending, models.StatusConfirmed, models.StatusShipped, models.StatusDelivered, models.StatusCancelled}
+    allowed := map[[2]models.OrderStatus]bool{
+        {models.StatusPending, models.StatusConfirmed}:   true,
+        {models.StatusPending, models.StatusCancelled}:   true,
+        {models.StatusConfirmed, models.StatusShipped}:   true,
+        {models.StatusConfirmed, models.StatusCancelled}: true,
+        {models.StatusShipped, models.StatusDelivered}:   true,
+    }
+    for _, from := range statuses {
+        for _, to := range statuses {
+            if got := from.CanTransitionTo(to); got != allowed[[2]models.OrderStatus{from, to}] {
+                t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", from, to, got, !got)
+            }
+        }
+    }
+    if models.OrderStatus("LOST").IsValid() || models.StatusPending.CanTransitionTo("LOST") {
+        t.Error("an undeclared status is accepted")
+    }
+}
+
+func TestMapProtoToOrderStatus(t *testing.T) {
+    tests := []struct {
+        in   pb.OrderStatus
+        want models.OrderStatus
+        code codes.Code
+    }{
+        {in: pb.OrderStatus_PENDING, want: models.StatusPending},
+        {in: pb.OrderStatus_CONFIRMED, want: models.StatusConfirmed},
+        {in: pb.OrderStatus_CANCELLED, want: models.StatusCancelled},
+        {in: pb.OrderStatus(42), code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        got, err := mapProtoToOrderStatus(tt.in)
+        if got != tt.want || status.Code(err) != tt.code {
+            t.Errorf("mapProtoToOrderStatus(%d) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.code)
+        }
+    }
+}
+
+func TestUpdateOrderRecordsHistory(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    order := createTestOrder(t, db, models.StatusPending)
+    id := int64(order.ID)
+
+    steps := []struct {
+        name string
+        req  *pb.UpdateOrderRequest
+        code codes.Code
+    }{
+        {name: "confirm", req: &pb.UpdateOrderRequest{OrderId: id, Status: pb.OrderStatus_CONFIRMED, Reason: "paid by bank transfer"}},
+        {name: "confirm again", req: &pb.UpdateOrderRequest{OrderId: id, Status: pb.OrderStatus_CONFIRMED}},
+        {name: "back to pending", req: &pb.UpdateOrderRequest{OrderId: id, Status: pb.OrderStatus_PENDING}, code: codes.FailedPrecondition},
+        {name: "unknown order", req: &pb.UpdateOrderRequest{OrderId: id + 1000, Status: pb.OrderStatus_CONFIRMED}, code: codes.NotFound},
+    }
+    for _, step := range steps {
+        res, err := s.UpdateOrder(adminContext(t), step.req)
+        if status.Code(err) != step.code {
+            t.Fatalf("%s: UpdateOrder error = %v, want %v", step.name, err, step.code)
+        }
+        if err == nil && res.Order.Status != pb.OrderStatus_CONFIRMED {
+            t.Errorf("%s: order status = %s, want CONFIRMED", step.name, res.Order.Status)
+        }
+    }
+    if _, err := s.UpdateOrder(customerContext(t, 3), steps[0].req); status.Code(err) != codes.PermissionDenied {
+        t.Errorf("UpdateOrder by the customer error = %v, want %v", err, codes.PermissionDenied)
+    }
+
+    // Only the change that happened is in the history, with who made it and why
+    res, err := s.GetOrderHistory(customerContext(t, 3), &pb.GetOrderHistoryRequest{OrderId: id})
+    if err != nil {
+        t.Fatalf("GetOrderHistory error = %v", err)
+    }
+    if len(res.Changes) != 1 {
+        t.Fatalf("history = %v, want 1 change", res.Changes)
+    }
+    change := res.Changes[0]
+    if change.FromStatus != "PENDING" || change.ToStatus != "CONFIRMED" || change.ActorId != 1 || change.ActorRole != 3 || change.Reason != "paid by bank transfer" {
+        t.Errorf("change = %v, want PENDING to CONFIRMED by admin 1 for the given reason", change)
+    }
+    if _, err := s.GetOrderHistory(customerContext(t, 4), &pb.GetOrderHistoryRequest{OrderId: id}); status.Code(err) != codes.NotFound {
+        t.Errorf("GetOrderHistory by another customer error = %v, want %v", err, codes.NotFound)
+    }
+}
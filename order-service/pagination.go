@@ -0,0 +1,53 @@
+package main
+
+import (
+    "encoding/base64"
+    "encoding/json"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const (
+    // defaultOrderPageSize is used when a listing doesn't ask for a page size
+    defaultOrderPageSize = 20
+    // maxOrderPageSize caps the page size of order listings
+    maxOrderPageSize = 100
+)
+
+// orderCursor is the position after the last order of a page. Orders are
+// listed newest first, so the next page continues below its ID.
+type orderCursor struct {
+    CustomerID int64 `json:"c"` // so a token only continues the listing it came from
+    ID         uint  `json:"i"`
+}
+
+// orderPageSize returns the requested page size within the allowed range
+func orderPageSize(pageSize int32) int {
+    if pageSize < 1 {
+        return defaultOrderPageSize
+    }
+    if pageSize > maxOrderPageSize {
+        return maxOrderPageSize
+    }
+    return int(pageSize)
+}
+
+// encodeOrderCursor turns a cursor into an opaque page token
+func encodeOrderCursor(cursor orderCursor) string {
+    data, _ := json.Marshal(cursor)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeOrderCursor reads a page token of the given customer's listing
+func decodeOrderCursor(token string, customerID int64) (*orderCursor, error) {
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    var cursor orderCursor
+    if err == nil {
+        err = json.Unmarshal(data, &cursor)
+    }
+    if err != nil || cursor.CustomerID != customerID {
+        return nil, status.Errorf(codes.InvalidArgument, "Invalid page token")
+    }
+    return &cursor, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+func TestOrderPageSize(t *testing.T) {
+    tests := []struct {
+        pageSize int32
+        want     int
+    }{
+        {pageSize: 0, want: defaultOrderPageSize},
+        {pageSize: -1, want: defaultOrderPageSize},
+        {pageSize: 5, want: 5},
+        {pageSize: 500, want: maxOrderPageSize},
+    }
+    for _, tt := range tests {
+        if got := orderPageSize(tt.pageSize); got != tt.want {
+            t.Errorf("orderPageSize(%d) = %d, want %d", tt.pageSize, got, tt.want)
+        }
+    }
+}
+
+func TestOrderCursor(t *testing.T) {
+    token := encodeOrderCursor(orderCursor{CustomerID: 3, ID: 42})
+    tests := []struct {
+        name       string
+        token      string
+        customerID int64
+        code       codes.Code
+    }{
+        {name: "same customer", token: token, customerID: 3},
+        {name: "other customer", token: token, customerID: 4, code: codes.InvalidArgument},
+        {name: "all customers", token: token, customerID: 0, code: codes.InvalidArgument},
+        {name: "garbage", token: "not a token", customerID: 3, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cursor, err := decodeOrderCursor(tt.token, tt.customerID)
+            if status.Code(err) != tt.code {
+                t.Fatalf("decodeOrderCursor error = %v, want %v", err, tt.code)
+            }
+            if err == nil && cursor.ID != 42 {
+                t.Errorf("cursor continues below order %d, want 42", cursor.ID)
+            }
+        })
+    }
+}
+
+func TestListOrdersPages(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    var created []int64
+    for i := 0; i < 5; i++ {
+        created = append(created, int64(createTestOrder(t, db, models.StatusPending).ID))
+    }
+    other := models.Order{CustomerID: 4, Status: models.StatusPending, Currency: "USD"}
+    db.Create(&other)
+
+    // Customer 3 pages through their own orders, newest first
+    req := &pb.ListOrdersRequest{PageSize: 2}
+    var listed []int64
+    for pages := 0; pages < 5; pages++ {
+        res, err := s.ListOrders(customerContext(t, 3), req)
+        if err != nil {
+            t.Fatalf("ListOrders error = %v", err)
+        }
+        if len(res.Orders) > 2 {
+            t.Fatalf("page of %d orders, want at most 2", len(res.Orders))
+        }
+        for _, order := range res.Orders {
+            listed = append(listed, order.Id)
+        }
+        if res.NextPageToken == "" {
+            break
+        }
+        req.PageToken = res.NextPageToken
+    }
+    if len(listed) != len(created) {
+        t.Fatalf("listed orders %v, want %v newest first", listed, created)
+    }
+    for i, id := range listed {
+        if want := created[len(created)-1-i]; id != want {
+            t.Errorf("order %d = %d, want %d", i, id, want)
+        }
+    }
+
+    // A customer's token doesn't continue an admin's listing of every order
+    if _, err := s.ListOrders(adminContext(t), &pb.ListOrdersRequest{PageSize: 2, PageToken: req.PageToken}); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("ListOrders with a customer's token error = %v, want %v", err, codes.InvalidArgument)
+    }
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "order-service/models"
+    "order-service/shipping"
+)
+
+// orderTotals holds the amounts of an order in minor units of its currency
+type orderTotals struct {
+    Subtotal int64
+    Discount int64
+    Tax      int64
+    Shipping int64
+    Total    int64
+}
+
+// migrateMoneyColumns converts the amount columns kept as decimals before
+// amounts were stored in minor units. Existing orders are in the store
+// currency and are rounded half away from zero, the rule money.Parse follows.
+// It has to run before AutoMigrate, which would cut them to whole units.
+func migrateMoneyColumns(db *gorm.DB, currency string) error {
+    scale, err := money.Scale(currency)
+    if err != nil {
+        return err
+    }
+    columns := []struct{ table, column string }{
+        {"orders", "subtotal"}, {"orders", "discount"}, {"orders", "tax"}, {"orders", "shipping"}, {"orders", "total_price"},
+        {"order_items", "price"},
+    }
+    return db.Transaction(func(tx *gorm.DB) error {
+        for _, c := range columns {
+            var dataType string
+            err := tx.Raw(`SELECT data_type FROM information_schema.columns
+                WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, c.table, c.column).Scan(&dataType).Error
+            if err != nil {
+                return err
+            }
+            if dataType != "numeric" && dataType != "double precision" && dataType != "real" {
+                continue
+            }
+            if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE bigint USING round(%[2]s::numeric * %[3]d)`, c.table, c.column, scale)).Error; err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// backfillCurrency puts orders from before amounts had a currency in the
+// store currency
+func backfillCurrency(db *gorm.DB, currency string) error {
+    return db.Model(&models.Order{}).Where("currency = ''").UpdateColumn("currency", currency).Error
+}
+
+// priceKey identifies what an order item buys: a product, or one of its variants
+type priceKey struct {
+    ProductID uint
+    VariantID uint
+}
+
+// orderPricing is what product-service resolved for the items of an order
+type orderPricing struct {
+    Currency   string
+    Prices     map[priceKey]int64 // Unit prices in minor units of Currency
+    Categories map[uint][]uint    // Categories of each product and their ancestors
+    TaxClasses map[uint]string    // Tax class of each product
+    Sizes      map[uint]shipping.Item // Shipping weight and size of one unit of each product
+}
+
+// fetchUnitPrices resolves what the customer pays per unit for every product
+// and variant in the order, in the requested currency. product-service applies
+// the customer group's price lists and the quantity breaks the order reaches,
+// so repeated items are priced on their combined quantity.
+func (s *server) fetchUnitPrices(ctx context.Context, items []*pb.OrderItem, currency, customerGroup string) (orderPricing, error) {
+    quantities := make(map[priceKey]int32, len(items))
+    req := &productpb.ResolvePricesRequest{Currency: currency, CustomerGroup: customerGroup}
+    for _, item := range items {
+        key := priceKey{uint(item.ProductId), uint(item.VariantId)}
+        if _, ok := quantities[key]; !ok {
+            req.Items = append(req.Items, &productpb.PriceItem{ProductId: item.ProductId, VariantId: item.VariantId})
+        }
+        quantities[key] += item.Quantity
+    }
+    for _, item := range req.Items {
+        item.Quantity = quantities[priceKey{uint(item.ProductId), uint(item.VariantId)}]
+    }
+
+    resp, err := s.ProductServiceClient.ResolvePrices(ctx, req)
+    if err != nil {
+        switch status.Code(err) {
+        case codes.NotFound, codes.InvalidArgument, codes.FailedPrecondition:
+            return orderPricing{}, err
+        }
+        return orderPricing{}, status.Errorf(codes.Internal, "Error retrieving product prices: %v", err)
+    }
+
+    // Every price is in the requested currency, the store currency by default
+    pricing := orderPricing{
+        Currency:   currency,
+        Prices:     make(map[priceKey]int64, len(resp.Prices)),
+        Categories: make(map[uint][]uint, len(resp.Prices)),
+        TaxClasses: make(map[uint]string, len(resp.Prices)),
+        Sizes:      make(map[uint]shipping.Item, len(resp.Prices)),
+    }
+    if pricing.Currency == "" {
+        pricing.Currency = money.StoreCurrency()
+    }
+    for _, price := range resp.Prices {
+        pricing.Prices[priceKey{uint(price.ProductId), uint(price.VariantId)}] = price.Price.GetAmount()
+        pricing.Currency = price.Price.GetCurrency()
+        categories := make([]uint, 0, len(price.CategoryIds))
+        for _, id := range price.CategoryIds {
+            categories = append(categories, uint(id))
+        }
+        pricing.Categories[uint(price.ProductId)] = categories
+        pricing.TaxClasses[uint(price.ProductId)] = price.TaxClass
+        pricing.Sizes[uint(price.ProductId)] = shipping.Item{
+            Weight: int(price.Weight),
+            Length: int(price.Dimensions.GetLength()),
+            Width:  int(price.Dimensions.GetWidth()),
+            Height: int(price.Dimensions.GetHeight()),
+        }
+    }
+    return pricing, nil
+}
+
+// newOrderItems converts requested items to order items priced with their snapshot
+func newOrderItems(items []*pb.OrderItem, pricing orderPricing) []models.OrderItem {
+    orderItems := make([]models.OrderItem, 0, len(items))
+    for _, item := range items {
+        orderItems = append(orderItems, models.OrderItem{
+            ProductID: uint(item.ProductId),
+            VariantID: uint(item.VariantId),
+            Quantity:  int(item.Quantity),
+            Price:     pricing.Prices[priceKey{uint(item.ProductId), uint(item.VariantId)}],
+        })
+    }
+    return orderItems
+}
+
+// computeTotals works out the order amounts from the item price snapshots.
+// Discount, tax and shipping are passed in by the caller; the discount is capped
+// at the subtotal so the total never goes negative.
+func computeTotals(items []models.OrderItem, discount, tax, shipping int64) orderTotals {
+    totals := orderTotals{Tax: tax, Shipping: shipping}
+    for _, item := range items {
+        totals.Subtotal += item.Price * int64(item.Quantity)
+    }
+    if discount > totals.Subtotal {
+        discount = totals.Subtotal
+    }
+    totals.Discount = discount
+    totals.Total = totals.Subtotal - totals.Discount + totals.Tax + totals.Shipping
+    return totals
+}
+
+// applyTotals stores the computed totals on an order
+func applyTotals(order *models.Order, totals orderTotals) {
+    order.Subtotal = totals.Subtotal
+    order.Discount = totals.Discount
+    order.Tax = totals.Tax
+    order.Shipping = totals.Shipping
+    order.TotalPrice = totals.Total
+}
+
+// toProtoOrder converts an order and its items to the protobuf type
+func toProtoOrder(order models.Order) *pb.Order {
+    orderItems := make([]*pb.OrderItem, len(order.Items))
+    for i, item := range order.Items {
+        orderItems[i] = &pb.OrderItem{
+            Id:          int64(item.ID),
+            ProductId:   int64(item.ProductID),
+            Quantity:    int32(item.Quantity),
+            Version:     int64(item.Version),
+            Price:       toProtoMoney(order.Money(item.Price)),
+            LineTotal:   toProtoMoney(order.Money(item.Price).Mul(int64(item.Quantity))),
+            Discount:    toProtoMoney(order.Money(item.Discount)),
+            TaxLines:    toProtoTaxLines(order, item.TaxLines),
+            Tax:         toProtoMoney(order.Money(item.Tax)),
+            WarehouseId: int64(item.WarehouseID),
+            VariantId:   int64(item.VariantID),
+        }
+    }
+
+    return &pb.Order{
+        Id:           int64(order.ID),
+        CustomerId:   int64(order.CustomerID),
+        Items:        orderItems,
+        Status:       mapOrderStatusToProto(order.Status), // Convert to protobuf enum
+        Subtotal:     toProtoMoney(order.Money(order.Subtotal)),
+        Discount:     toProtoMoney(order.Money(order.Discount)),
+        Tax:          toProtoMoney(order.Money(order.Tax)),
+        Shipping:     toProtoMoney(order.Money(order.Shipping)),
+        TotalPrice:   toProtoMoney(order.Money(order.TotalPrice)),
+        CouponCode:   order.CouponCode,
+        FreeShipping: order.FreeShipping,
+        Destination:  toProtoDestination(order),
+        IncludedTax:  toProtoMoney(order.Money(order.IncludedTax)),
+        ShippingMethodId:   int64(order.ShippingMethodID),
+        ShippingMethodName: order.ShippingMethodName,
+        ShippingAddress:    formatAddress(order.ShipTo),
+        ShipTo:             toProtoOrderAddress(order.ShipTo),
+        BillTo:             toProtoOrderAddress(order.BillTo),
+    }
+}
+
+// toProtoMoney converts an amount to protobuf
+func toProtoMoney(amount money.Money) *pb.Money {
+    return &pb.Money{Amount: amount.Amount, Currency: amount.Currency}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "context"
+    "reflect"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "google.golang.org/grpc"
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+func TestComputeTotals(t *testing.T) {
+    items := []models.OrderItem{{Price: 1999, Quantity: 3}, {Price: 1, Quantity: 1}}
+    tests := []struct {
+        name                    string
+        items                   []models.OrderItem
+        discount, tax, shipping int64
+        want                    orderTotals
+    }{
+        {name: "no items", want: orderTotals{}},
+        {name: "subtotal only", items: items, want: orderTotals{Subtotal: 5998, Total: 5998}},
+        {
+            name: "discount, tax and shipping", items: items, discount: 500, tax: 120, shipping: 399,
+            want: orderTotals{Subtotal: 5998, Discount: 500, Tax: 120, Shipping: 399, Total: 6017},
+        },
+        {
+            // The discount never takes the total below the charges on top of the subtotal
+            name: "discount above the subtotal", items: items, discount: 10000, shipping: 399,
+            want: orderTotals{Subtotal: 5998, Discount: 5998, Shipping: 399, Total: 399},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := computeTotals(tt.items, tt.discount, tt.tax, tt.shipping); got != tt.want {
+                t.Errorf("computeTotals = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNewOrderItems(t *testing.T) {
+    pricing := orderPricing{Currency: "EUR", Prices: map[priceKey]int64{{1, 0}: 1000, {1, 7}: 1250}}
+    items := newOrderItems([]*pb.OrderItem{
+        {ProductId: 1, Quantity: 2},
+        {ProductId: 1, VariantId: 7, Quantity: 1},
+    }, pricing)
+
+    // Each item keeps the unit price it was sold at, whatever the product costs later
+    want := []models.OrderItem{
+        {ProductID: 1, Quantity: 2, Price: 1000},
+        {ProductID: 1, VariantID: 7, Quantity: 1, Price: 1250},
+    }
+    if !reflect.DeepEqual(items, want) {
+        t.Errorf("newOrderItems = %+v, want %+v", items, want)
+    }
+}
+
+func TestToProtoOrderAmounts(t *testing.T) {
+    order := models.Order{Currency: "JPY", Items: []models.OrderItem{{Model: gorm.Model{ID: 4}, ProductID: 1, Price: 1500, Quantity: 2}}}
+    applyTotals(&order, computeTotals(order.Items, 0, 0, 0))
+
+    res := toProtoOrder(order)
+    if res.TotalPrice.GetAmount() != 3000 || res.TotalPrice.GetCurrency() != "JPY" {
+        t.Errorf("totalPrice = %v, want 3000 JPY", res.TotalPrice)
+    }
+    item := res.Items[0]
+    if item.Id != 4 {
+        t.Errorf("item id = %d, want 4", item.Id)
+    }
+    if item.Price.GetAmount() != 1500 || item.LineTotal.GetAmount() != 3000 || item.LineTotal.GetCurrency() != "JPY" {
+        t.Errorf("item price %v and line total %v, want 1500 and 3000 JPY", item.Price, item.LineTotal)
+    }
+}
+
+// fakeProductClient answers ResolvePrices with a fixed unit price per item
+// and records the request
+type fakeProductClient struct {
+    productpb.ProductServiceClient
+    req *productpb.ResolvePricesRequest
+}
+
+func (f *fakeProductClient) ResolvePrices(ctx context.Context, req *productpb.ResolvePricesRequest, opts ...grpc.CallOption) (*productpb.ResolvePricesResponse, error) {
+    f.req = req
+    res := &productpb.ResolvePricesResponse{}
+    for _, item := range req.Items {
+        if item.ProductId == 404 {
+            return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", item.ProductId)
+        }
+        res.Prices = append(res.Prices, &productpb.ResolvedPrice{
+            ProductId:   item.ProductId,
+            VariantId:   item.VariantId,
+            Price:       &productpb.Money{Amount: 1000 - int64(item.Quantity), Currency: req.Currency},
+            CategoryIds: []int64{item.ProductId * 10},
+        })
+    }
+    return res, nil
+}
+
+func TestFetchUnitPrices(t *testing.T) {
+    client := &fakeProductClient{}
+    s := &server{ProductServiceClient: client}
+    items := []*pb.OrderItem{
+        {ProductId: 1, VariantId: 7, Quantity: 2},
+        {ProductId: 2, Quantity: 1},
+        {ProductId: 1, VariantId: 7, Quantity: 3},
+    }
+
+    pricing, err := s.fetchUnitPrices(context.Background(), items, "EUR", "wholesale")
+    if err != nil {
+        t.Fatalf("fetchUnitPrices error = %v", err)
+    }
+    if pricing.Currency != "EUR" || client.req.CustomerGroup != "wholesale" {
+        t.Errorf("currency %q and customer group %q, want EUR and wholesale", pricing.Currency, client.req.CustomerGroup)
+    }
+    // Repeated items are priced once, on their combined quantity
+    if len(client.req.Items) != 2 || client.req.Items[0].Quantity != 5 {
+        t.Errorf("resolved items = %v, want 2 items with 5 of the first", client.req.Items)
+    }
+    if pricing.Prices[priceKey{1, 7}] != 995 || pricing.Prices[priceKey{2, 0}] != 999 {
+        t.Errorf("prices = %v, want 995 for variant 7 and 999 for product 2", pricing.Prices)
+    }
+    if categories := pricing.Categories[2]; len(categories) != 1 || categories[0] != 20 {
+        t.Errorf("categories of product 2 = %v, want [20]", categories)
+    }
+
+    if _, err := s.fetchUnitPrices(context.Background(), []*pb.OrderItem{{ProductId: 404, Quantity: 1}}, "EUR", ""); status.Code(err) != codes.NotFound {
+        t.Errorf("fetchUnitPrices of a missing product error = %v, want %v", err, codes.NotFound)
+    }
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "order-service/models"
+)
+
+// couponCodePattern is the form of coupon codes once upper-cased
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{2,63}$`)
+
+// promotionDiscount is what a promotion takes off an order
+type promotionDiscount struct {
+    Discount      int64   // Total taken off the subtotal
+    ItemDiscounts []int64 // Share of the discount per item, in item order
+    FreeShipping  bool
+}
+
+// normalizeCouponCode makes coupon codes case-insensitive
+func normalizeCouponCode(code string) string {
+    return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// applyPromotion works out the discount a promotion gives a customer on the
+// items of an order in currency. It checks every condition of the promotion
+// except its usage limits, which depend on the redemptions made so far.
+func applyPromotion(promotion models.Promotion, customerID uint, currency string, items []models.OrderItem, categories map[uint][]uint, now time.Time) (promotionDiscount, error) {
+    code := promotion.Code
+    if !promotion.Active || (promotion.StartsAt != nil && now.Before(*promotion.StartsAt)) || (promotion.EndsAt != nil && !now.Before(*promotion.EndsAt)) {
+        return promotionDiscount{}, status.Errorf(codes.FailedPrecondition, "Coupon '%s' is not active", code)
+    }
+
+    targets := make(map[models.TargetKind]map[uint]bool)
+    for _, target := range promotion.Targets {
+        if targets[target.Kind] == nil {
+            targets[target.Kind] = make(map[uint]bool)
+        }
+        targets[target.Kind][target.TargetID] = true
+    }
+    if customers := targets[models.TargetCustomer]; customers != nil && !customers[customerID] {
+        return promotionDiscount{}, status.Errorf(codes.FailedPrecondition, "Coupon '%s' is not available to this customer", code)
+    }
+    if promotion.Currency != "" && promotion.Currency != currency {
+        return promotionDiscount{}, status.Errorf(codes.FailedPrecondition, "Coupon '%s' only applies to orders in %s", code, promotion.Currency)
+    }
+
+    // The discount applies to the items in the targeted products or
+    // categories, or to every item when there are no such targets
+    lines := make([]int64, len(items))
+    var subtotal, eligible int64
+    for i, item := range items {
+        line := item.Price * int64(item.Quantity)
+        subtotal += line
+        if targetsItem(targets, item, categories[item.ProductID]) {
+            lines[i] = line
+            eligible += line
+        }
+    }
+    if subtotal < promotion.MinSubtotal {
+        minimum := money.Money{Amount: promotion.MinSubtotal, Currency: currency}
+        return promotionDiscount{}, status.Errorf(codes.FailedPrecondition, "Coupon '%s' needs a subtotal of at least %s", code, minimum)
+    }
+    if eligible == 0 {
+        return promotionDiscount{}, status.Errorf(codes.FailedPrecondition, "Coupon '%s' doesn't apply to any item", code)
+    }
+
+    result := promotionDiscount{ItemDiscounts: make([]int64, len(items))}
+    switch promotion.Type {
+    case models.PromotionPercentage:
+        // Each line is rounded on its own, so a refund of a line is exact
+        for i, line := range lines {
+            result.ItemDiscounts[i] = money.Money{Amount: line, Currency: currency}.MulRatio(int64(promotion.PercentOff), 100).Amount
+        }
+    case models.PromotionFixedAmount:
+        result.ItemDiscounts = allocateDiscount(min(promotion.AmountOff, eligible), lines)
+    case models.PromotionFreeShipping:
+        result.FreeShipping = true
+    }
+    for _, discount := range result.ItemDiscounts {
+        result.Discount += discount
+    }
+    return result, nil
+}
+
+// targetsItem reports whether an item is in the products or categories a
+// promotion targets
+func targetsItem(targets map[models.TargetKind]map[uint]bool, item models.OrderItem, categories []uint) bool {
+    products, inCategories := targets[models.TargetProduct], targets[models.TargetCategory]
+    if products == nil && inCategories == nil {
+        return true
+    }
+    if products[item.ProductID] {
+        return true
+    }
+    for _, category := range categories {
+        if inCategories[category] {
+            return true
+        }
+    }
+    return false
+}
+
+// allocateDiscount splits a discount over lines in proportion to their totals.
+// Rounding leftovers go to the largest line, so the shares add up exactly.
+func allocateDiscount(discount int64, lines []int64) []int64 {
+    shares := make([]int64, len(lines))
+    var total, allocated int64
+    largest := 0
+    for i, line := range lines {
+        total += line
+        if line > lines[largest] {
+            largest = i
+        }
+    }
+    if total == 0 {
+        return shares
+    }
+    for i, line := range lines {
+        shares[i] = money.Money{Amount: discount}.MulRatio(line, total).Amount
+        allocated += shares[i]
+    }
+    shares[largest] += discount - allocated
+    return shares
+}
+
+// checkPromotionUsage checks the promotion has uses left, in total and for the
+// customer. Anonymous previews only check the total.
+func checkPromotionUsage(db *gorm.DB, promotion models.Promotion, customerID uint) error {
+    if promotion.UsageLimit > 0 && promotion.TimesUsed >= promotion.UsageLimit {
+        return status.Errorf(codes.FailedPrecondition, "Coupon '%s' has been used up", promotion.Code)
+    }
+    if promotion.UsageLimitPerCustomer == 0 || customerID == 0 {
+        return nil
+    }
+    var used int64
+    err := db.Model(&models.PromotionRedemption{}).
+        Where("promotion_id = ? AND customer_id = ?", promotion.ID, customerID).
+        Count(&used).Error
+    if err != nil {
+        return status.Errorf(codes.Internal, "Error checking coupon usage: %v", err)
+    }
+    if used >= int64(promotion.UsageLimitPerCustomer) {
+        return status.Errorf(codes.FailedPrecondition, "Coupon '%s' has already been used the maximum number of times", promotion.Code)
+    }
+    return nil
+}
+
+// promotionForOrder looks up a coupon and works out its discount on the items
+// of an order, without redeeming it
+func promotionForOrder(db *gorm.DB, code string, customerID uint, pricing orderPricing, items []models.OrderItem) (models.Promotion, promotionDiscount, error) {
+    code = normalizeCouponCode(code)
+    var promotion models.Promotion
+    if err := db.Preload("Targets").Where("code = ?", code).First(&promotion).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return promotion, promotionDiscount{}, status.Errorf(codes.NotFound, "Coupon '%s' not found", code)
+        }
+        return promotion, promotionDiscount{}, status.Errorf(codes.Internal, "Error retrieving coupon: %v", err)
+    }
+    if err := checkPromotionUsage(db, promotion, customerID); err != nil {
+        return promotion, promotionDiscount{}, err
+    }
+    discount, err := applyPromotion(promotion, customerID, pricing.Currency, items, pricing.Categories, time.Now())
+    return promotion, discount, err
+}
+
+// applyDiscount stores a promotion's discount on an order and its items
+func applyDiscount(order *models.Order, promotion models.Promotion, discount promotionDiscount) {
+    order.PromotionID = &promotion.ID
+    order.CouponCode = promotion.Code
+    order.FreeShipping = discount.FreeShipping
+    for i := range order.Items {
+        order.Items[i].Discount = discount.ItemDiscounts[i]
+    }
+}
+
+// redeemPromotion records the use of an order's promotion. The promotion row
+// is locked while its limits are checked again, so concurrent orders can't
+// redeem more uses than it has.
+func redeemPromotion(tx *gorm.DB, order models.Order) error {
+    if order.PromotionID == nil {
+        return nil
+    }
+    var promotion models.Promotion
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&promotion, *order.PromotionID).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return status.Errorf(codes.NotFound, "Coupon '%s' not found", order.CouponCode)
+        }
+        return err
+    }
+    if err := checkPromotionUsage(tx, promotion, order.CustomerID); err != nil {
+        return err
+    }
+    redemption := models.PromotionRedemption{PromotionID: promotion.ID, OrderID: order.ID, CustomerID: order.CustomerID}
+    if err := tx.Create(&redemption).Error; err != nil {
+        return err
+    }
+    return tx.Model(&promotion).UpdateColumn("times_used", gorm.Expr("times_used + 1")).Error
+}
+
+// releasePromotion gives back the use of a cancelled order's promotion
+func releasePromotion(tx *gorm.DB, order models.Order) error {
+    result := tx.Where("order_id = ?", order.ID).Delete(&models.PromotionRedemption{})
+    if result.Error != nil || result.RowsAffected == 0 {
+        return result.Error
+    }
+    return tx.Unscoped().Model(&models.Promotion{}).Where("id = ?", order.PromotionID).
+        UpdateColumn("times_used", gorm.Expr("times_used - 1")).Error
+}
+
+func (s *server) PreviewPromotion(ctx context.Context, req *pb.PreviewPromotionRequest) (*pb.PreviewPromotionResponse, error) {
+    if normalizeCouponCode(req.CouponCode) == "" {
+        return nil, status.Errorf(codes.InvalidArgument, "Coupon code is required")
+    }
+    if len(req.Items) == 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "Items are required")
+    }
+    pricing, err := s.fetchUnitPrices(ctx, req.Items, req.Currency, customerGroupFromContext(ctx))
+    if err != nil {
+        return nil, err
+    }
+
+    order := models.Order{CustomerID: uint(req.CustomerId), Currency: pricing.Currency, Items: newOrderItems(req.Items, pricing)}
+    promotion, discount, err := promotionForOrder(s.db, req.CouponCode, order.CustomerID, pricing, order.Items)
+    if err != nil {
+        return nil, err
+    }
+    applyDiscount(&order, promotion, discount)
+    applyTotals(&order, computeTotals(order.Items, discount.Discount, 0, 0))
+
+    res := toProtoOrder(order)
+    return &pb.PreviewPromotionResponse{
+        Items:        res.Items,
+        Subtotal:     res.Subtotal,
+        Discount:     res.Discount,
+        TotalPrice:   res.TotalPrice,
+        FreeShipping: res.FreeShipping,
+        CouponCode:   res.CouponCode,
+    }, nil
+}
+
+func (s *server) CreatePromotion(ctx context.Context, req *pb.CreatePromotionRequest) (*pb.PromotionResponse, error) {
+    promotion, err := newPromotion(req.Promotion)
+    if err != nil {
+        return nil, err
+    }
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if err := checkCouponCodeFree(tx, promotion.Code, 0); err != nil {
+            return err
+        }
+        return tx.Create(&promotion).Error
+    })
+    if err != nil {
+        return nil, promotionError(err)
+    }
+    return &pb.PromotionResponse{Promotion: toProtoPromotion(promotion)}, nil
+}
+
+func (s *server) GetPromotion(ctx context.Context, req *pb.GetPromotionRequest) (*pb.PromotionResponse, error) {
+    promotion, err := findPromotion(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.PromotionResponse{Promotion: toProtoPromotion(*promotion)}, nil
+}
+
+func (s *server) UpdatePromotion(ctx context.Context, req *pb.UpdatePromotionRequest) (*pb.PromotionResponse, error) {
+    update, err := newPromotion(req.Promotion)
+    if err != nil {
+        return nil, err
+    }
+
+    var promotion *models.Promotion
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if promotion, err = findPromotion(tx.Clauses(clause.Locking{Strength: "UPDATE"}), req.Id); err != nil {
+            return err
+        }
+        if err := checkCouponCodeFree(tx, update.Code, promotion.ID); err != nil {
+            return err
+        }
+
+        // Redemptions made so far still count against the new limits
+        update.Model = promotion.Model
+        update.TimesUsed = promotion.TimesUsed
+        if err := tx.Where("promotion_id = ?", promotion.ID).Delete(&models.PromotionTarget{}).Error; err != nil {
+            return err
+        }
+        promotion = &update
+        return tx.Save(promotion).Error
+    })
+    if err != nil {
+        return nil, promotionError(err)
+    }
+    return &pb.PromotionResponse{Promotion: toProtoPromotion(*promotion)}, nil
+}
+
+func (s *server) DeletePromotion(ctx context.Context, req *pb.DeletePromotionRequest) (*pb.DeletePromotionResponse, error) {
+    // Promotions are soft deleted, so orders keep pointing at what they redeemed
+    promotion, err := findPromotion(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.db.Delete(promotion).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error deleting promotion: %v", err)
+    }
+    return &pb.DeletePromotionResponse{Success: true}, nil
+}
+
+func (s *server) ListPromotions(ctx context.Context, req *pb.ListPromotionsRequest) (*pb.ListPromotionsResponse, error) {
+    var promotions []models.Promotion
+    if err := s.db.Preload("Targets").Order("id").Find(&promotions).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving promotions: %v", err)
+    }
+    res := &pb.ListPromotionsResponse{}
+    for _, promotion := range promotions {
+        res.Promotions = append(res.Promotions, toProtoPromotion(promotion))
+    }
+    return res, nil
+}
+
+// newPromotion validates a promotion being saved
+func newPromotion(req *pb.Promotion) (models.Promotion, error) {
+    if req == nil {
+        return models.Promotion{}, status.Errorf(codes.InvalidArgument, "Promotion is required")
+    }
+    promotion := models.Promotion{
+        Code:                  normalizeCouponCode(req.Code),
+        Name:                  strings.TrimSpace(req.Name),
+        UsageLimit:            int(req.UsageLimit),
+        UsageLimitPerCustomer: int(req.UsageLimitPerCustomer),
+        Active:                req.Active,
+    }
+    if !couponCodePattern.MatchString(promotion.Code) {
+        return promotion, status.Errorf(codes.InvalidArgument, "Invalid coupon code '%s'", req.Code)
+    }
+    if promotion.Name == "" {
+        promotion.Name = promotion.Code
+    }
+    if promotion.UsageLimit < 0 || promotion.UsageLimitPerCustomer < 0 {
+        return promotion, status.Errorf(codes.InvalidArgument, "Usage limits can't be negative")
+    }
+
+    switch req.Type {
+    case pb.PromotionType_PERCENTAGE:
+        promotion.Type = models.PromotionPercentage
+        promotion.PercentOff = int(req.PercentOff)
+        if promotion.PercentOff < 1 || promotion.PercentOff > 100 {
+            return promotion, status.Errorf(codes.InvalidArgument, "percentOff must be between 1 and 100")
+        }
+    case pb.PromotionType_FIXED_AMOUNT:
+        promotion.Type = models.PromotionFixedAmount
+        if req.AmountOff.GetAmount() <= 0 {
+            return promotion, status.Errorf(codes.InvalidArgument, "amountOff must be positive")
+        }
+    case pb.PromotionType_FREE_SHIPPING:
+        promotion.Type = models.PromotionFreeShipping
+    default:
+        return promotion, status.Errorf(codes.InvalidArgument, "Invalid promotion type %d", req.Type)
+    }
+
+    // Amounts are in one currency, the store currency unless they name one
+    for _, amount := range []*pb.Money{req.AmountOff, req.MinSubtotal} {
+        if amount.GetAmount() == 0 {
+            continue
+        }
+        if amount.Amount < 0 {
+            return promotion, status.Errorf(codes.InvalidArgument, "Promotion amounts can't be negative")
+        }
+        currency := amount.Currency
+        if currency == "" {
+            currency = money.StoreCurrency()
+        }
+        normalized, err := money.Normalize(currency)
+        if err != nil {
+            return promotion, status.Errorf(codes.InvalidArgument, "Unknown currency '%s'", currency)
+        }
+        if promotion.Currency != "" && promotion.Currency != normalized {
+            return promotion, status.Errorf(codes.InvalidArgument, "Promotion amounts must be in one currency")
+        }
+        promotion.Currency = normalized
+    }
+    if promotion.Type == models.PromotionFixedAmount {
+        promotion.AmountOff = req.AmountOff.GetAmount()
+    }
+    promotion.MinSubtotal = req.MinSubtotal.GetAmount()
+
+    var err error
+    if promotion.StartsAt, err = parseTime(req.StartsAt); err != nil {
+        return promotion, err
+    }
+    if promotion.EndsAt, err = parseTime(req.EndsAt); err != nil {
+        return promotion, err
+    }
+    if promotion.StartsAt != nil && promotion.EndsAt != nil && !promotion.EndsAt.After(*promotion.StartsAt) {
+        return promotion, status.Errorf(codes.InvalidArgument, "Promotion must end after it starts")
+    }
+
+    for _, targets := range []struct {
+        kind models.TargetKind
+        ids  []int64
+    }{
+        {models.TargetProduct, req.ProductIds},
+        {models.TargetCategory, req.CategoryIds},
+        {models.TargetCustomer, req.CustomerIds},
+    } {
+        kind, ids := targets.kind, targets.ids
+        seen := make(map[int64]bool, len(ids))
+        for _, id := range ids {
+            if id <= 0 {
+                return promotion, status.Errorf(codes.InvalidArgument, "Invalid %s ID %d", strings.ToLower(string(kind)), id)
+            }
+            if !seen[id] {
+                seen[id] = true
+                promotion.Targets = append(promotion.Targets, models.PromotionTarget{Kind: kind, TargetID: uint(id)})
+            }
+        }
+    }
+    return promotion, nil
+}
+
+// parseTime reads an optional RFC 3339 timestamp
+func parseTime(value string) (*time.Time, error) {
+    if value == "" {
+        return nil, nil
+    }
+    parsed, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return nil, status.Errorf(codes.InvalidArgument, "Invalid time '%s', expected RFC 3339", value)
+    }
+    return &parsed, nil
+}
+
+// checkCouponCodeFree checks no other promotion uses a coupon code
+func checkCouponCodeFree(tx *gorm.DB, code string, id uint) error {
+    var count int64
+    if err := tx.Model(&models.Promotion{}).Where("code = ? AND id <> ?", code, id).Count(&count).Error; err != nil {
+        return err
+    }
+    if count > 0 {
+        return status.Errorf(codes.AlreadyExists, "Coupon code '%s' is already in use", code)
+    }
+    return nil
+}
+
+// findPromotion loads a promotion and its targets
+func findPromotion(db *gorm.DB, id int64) (*models.Promotion, error) {
+    var promotion models.Promotion
+    if err := db.Preload("Targets").First(&promotion, id).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Promotion with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving promotion: %v", err)
+    }
+    return &promotion, nil
+}
+
+// promotionError passes status errors through and wraps anything else as internal
+func promotionError(err error) error {
+    if _, ok := status.FromError(err); ok {
+        return err
+    }
+    return status.Errorf(codes.Internal, "Error saving promotion: %v", err)
+}
+
+// toProtoPromotion converts a promotion and its loaded targets to protobuf
+func toProtoPromotion(promotion models.Promotion) *pb.Promotion {
+    res := &pb.Promotion{
+        Id:                    int64(promotion.ID),
+        Code:                  promotion.Code,
+        Name:                  promotion.Name,
+        PercentOff:            int32(promotion.PercentOff),
+        UsageLimit:            int32(promotion.UsageLimit),
+        UsageLimitPerCustomer: int32(promotion.UsageLimitPerCustomer),
+        TimesUsed:             int32(promotion.TimesUsed),
+        Active:                promotion.Active,
+    }
+    switch promotion.Type {
+    case models.PromotionFixedAmount:
+        res.Type = pb.PromotionType_FIXED_AMOUNT
+        res.AmountOff = &pb.Money{Amount: promotion.AmountOff, Currency: promotion.Currency}
+    case models.PromotionFreeShipping:
+        res.Type = pb.PromotionType_FREE_SHIPPING
+    default:
+        res.Type = pb.PromotionType_PERCENTAGE
+    }
+    if promotion.MinSubtotal > 0 {
+        res.MinSubtotal = &pb.Money{Amount: promotion.MinSubtotal, Currency: promotion.Currency}
+    }
+    if promotion.StartsAt != nil {
+        res.StartsAt = promotion.StartsAt.Format(time.RFC3339)
+    }
+    if promotion.EndsAt != nil {
+        res.EndsAt = promotion.EndsAt.Format(time.RFC3339)
+    }
+    for _, target := range promotion.Targets {
+        switch target.Kind {
+        case models.TargetProduct:
+            res.ProductIds = append(res.ProductIds, int64(target.TargetID))
+        case models.TargetCategory:
+            res.CategoryIds = append(res.CategoryIds, int64(target.TargetID))
+        case models.TargetCustomer:
+            res.CustomerIds = append(res.CustomerIds, int64(target.TargetID))
+        }
+    }
+    return res
+}
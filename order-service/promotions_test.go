@@ -0,0 +1,151 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+func TestApplyPromotion(t *testing.T) {
+    now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+    later := now.Add(time.Hour)
+    items := []models.OrderItem{
+        {ProductID: 1, Price: 1999, Quantity: 2}, // 39.98 in category 10
+        {ProductID: 2, Price: 500, Quantity: 1},  // 5.00 in category 20
+    }
+    categories := map[uint][]uint{1: {10, 1}, 2: {20, 1}}
+
+    tests := []struct {
+        name      string
+        promotion models.Promotion
+        customer  uint
+        want      []int64
+        shipping  bool
+        code      codes.Code
+    }{
+        {
+            name:      "percentage of every item, rounded per line",
+            promotion: models.Promotion{Type: models.PromotionPercentage, PercentOff: 15},
+            want:      []int64{600, 75},
+        },
+        {
+            name: "percentage of a category",
+            promotion: models.Promotion{Type: models.PromotionPercentage, PercentOff: 50,
+                Targets: []models.PromotionTarget{{Kind: models.TargetCategory, TargetID: 20}}},
+            want: []int64{0, 250},
+        },
+        {
+            name:      "fixed amount split by line total",
+            promotion: models.Promotion{Type: models.PromotionFixedAmount, AmountOff: 1000, Currency: "USD"},
+            want:      []int64{889, 111},
+        },
+        {
+            name: "fixed amount capped at the matching items",
+            promotion: models.Promotion{Type: models.PromotionFixedAmount, AmountOff: 1000, Currency: "USD",
+                Targets: []models.PromotionTarget{{Kind: models.TargetProduct, TargetID: 2}}},
+            want: []int64{0, 500},
+        },
+        {
+            name:      "free shipping",
+            promotion: models.Promotion{Type: models.PromotionFreeShipping},
+            want:      []int64{0, 0},
+            shipping:  true,
+        },
+        {
+            name:      "below the minimum subtotal",
+            promotion: models.Promotion{Type: models.PromotionPercentage, PercentOff: 10, MinSubtotal: 5000, Currency: "USD"},
+            code:      codes.FailedPrecondition,
+        },
+        {
+            name:      "another currency",
+            promotion: models.Promotion{Type: models.PromotionFixedAmount, AmountOff: 100, Currency: "EUR"},
+            code:      codes.FailedPrecondition,
+        },
+        {
+            name: "other customers only",
+            promotion: models.Promotion{Type: models.PromotionPercentage, PercentOff: 10,
+                Targets: []models.PromotionTarget{{Kind: models.TargetCustomer, TargetID: 8}}},
+            customer: 7,
+            code:     codes.FailedPrecondition,
+        },
+        {
+            name:      "not started",
+            promotion: models.Promotion{Type: models.PromotionPercentage, PercentOff: 10, StartsAt: &later},
+            code:      codes.FailedPrecondition,
+        },
+        {
+            name: "no matching item",
+            promotion: models.Promotion{Type: models.PromotionPercentage, PercentOff: 10,
+                Targets: []models.PromotionTarget{{Kind: models.TargetProduct, TargetID: 3}}},
+            code: codes.FailedPrecondition,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.promotion.Active = true
+            got, err := applyPromotion(tt.promotion, tt.customer, "USD", items, categories, now)
+            if status.Code(err) != tt.code {
+                t.Fatalf("error = %v, want %v", err, tt.code)
+            }
+            if err != nil {
+                return
+            }
+            var total int64
+            for i, discount := range got.ItemDiscounts {
+                if discount != tt.want[i] {
+                    t.Errorf("item discounts = %v, want %v", got.ItemDiscounts, tt.want)
+                    break
+                }
+                total += discount
+            }
+            if got.Discount != total || got.FreeShipping != tt.shipping {
+                t.Errorf("discount %d and free shipping %v, want %d and %v", got.Discount, got.FreeShipping, total, tt.shipping)
+            }
+        })
+    }
+}
+
+func TestAllocateDiscount(t *testing.T) {
+    // Thirds can't be split exactly; the largest line takes the leftover
+    shares := allocateDiscount(100, []int64{300, 300, 301})
+    if shares[0]+shares[1]+shares[2] != 100 || shares[2] != 34 {
+        t.Errorf("allocateDiscount = %v, want shares adding up to 100 with 34 on the last", shares)
+    }
+    if shares := allocateDiscount(100, []int64{0, 0}); shares[0] != 0 || shares[1] != 0 {
+        t.Errorf("allocateDiscount without lines = %v, want no shares", shares)
+    }
+}
+
+func TestNewPromotion(t *testing.T) {
+    promotion, err := newPromotion(&pb.Promotion{
+        Code:        " summer-10 ",
+        Type:        pb.PromotionType_FIXED_AMOUNT,
+        AmountOff:   &pb.Money{Amount: 1000, Currency: "eur"},
+        ProductIds:  []int64{4, 4},
+        CustomerIds: []int64{9},
+        Active:      true,
+    })
+    if err != nil {
+        t.Fatalf("newPromotion error = %v", err)
+    }
+    if promotion.Code != "SUMMER-10" || promotion.Name != "SUMMER-10" || promotion.Currency != "EUR" || len(promotion.Targets) != 2 {
+        t.Errorf("promotion = %+v, want code and name SUMMER-10 in EUR with 2 targets", promotion)
+    }
+
+    invalid := []*pb.Promotion{
+        {Code: "X", Type: pb.PromotionType_PERCENTAGE, PercentOff: 10},
+        {Code: "TEN", Type: pb.PromotionType_PERCENTAGE, PercentOff: 101},
+        {Code: "TEN", Type: pb.PromotionType_FIXED_AMOUNT},
+        {Code: "TEN", Type: pb.PromotionType_FIXED_AMOUNT, AmountOff: &pb.Money{Amount: 100, Currency: "USD"}, MinSubtotal: &pb.Money{Amount: 100, Currency: "EUR"}},
+        {Code: "TEN", Type: pb.PromotionType_FREE_SHIPPING, StartsAt: "2026-02-01T00:00:00Z", EndsAt: "2026-01-01T00:00:00Z"},
+    }
+    for _, req := range invalid {
+        if _, err := newPromotion(req); status.Code(err) != codes.InvalidArgument {
+            t.Errorf("newPromotion(%v) error = %v, want %v", req, err, codes.InvalidArgument)
+        }
+    }
+}
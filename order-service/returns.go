@@ -0,0 +1,626 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    paymentpb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "order-service/models"
+)
+
+// RequestReturn opens a return for items of a delivered order. Each item can
+// only be returned up to the quantity ordered, across all of its returns
+// that weren't rejected.
+func (s *server) RequestReturn(ctx context.Context, req *pb.RequestReturnRequest) (*pb.OrderReturnResponse, error) {
+    reason := strings.TrimSpace(req.Reason)
+    if reason == "" {
+        return nil, status.Errorf(codes.InvalidArgument, "Return reason is required")
+    }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    var ret models.Return
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        // The order stays locked so concurrent returns can't exceed its quantities
+        var order models.Order
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, req.OrderId).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Order with ID '%d' not found", req.OrderId)
+            }
+            return status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+        }
+        if err := authorizeOrder(actor, order); err != nil {
+            return err
+        }
+        if order.Status != models.StatusDelivered {
+            return status.Errorf(codes.FailedPrecondition, "Order with ID '%d' can only be returned once delivered", order.ID)
+        }
+
+        var returns []models.Return
+        if err := tx.Preload("Items").Where("order_id = ? AND status <> ?", order.ID, models.ReturnRejected).Find(&returns).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving returns: %v", err)
+        }
+        items, err := newReturnItems(order, req.Items, returnedQuantities(returns))
+        if err != nil {
+            return err
+        }
+
+        ret = models.Return{OrderID: order.ID, CustomerID: order.CustomerID, Status: models.ReturnRequested, Reason: reason, Items: items}
+        if err := tx.Create(&ret).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error creating return: %v", err)
+        }
+        return recordReturnStatusChange(tx, ret.ID, "", models.ReturnRequested, actor, reason)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return s.returnResponse(ret.ID)
+}
+
+func (s *server) GetReturn(ctx context.Context, req *pb.GetReturnRequest) (*pb.OrderReturnResponse, error) {
+    ret, err := findReturn(s.db, req.OrderId, req.Id)
+    if err != nil {
+        return nil, err
+    }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := authorizeReturn(actor, *ret); err != nil {
+        return nil, err
+    }
+    return s.returnResponse(ret.ID)
+}
+
+func (s *server) ListReturns(ctx context.Context, req *pb.ListReturnsRequest) (*pb.ListReturnsResponse, error) {
+    var order models.Order
+    if err := s.db.Select("id", "customer_id", "currency").First(&order, req.OrderId).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Order with ID '%d' not found", req.OrderId)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+    }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := authorizeOrder(actor, order); err != nil {
+        return nil, err
+    }
+
+    var returns []models.Return
+    if err := preloadReturn(s.db).Where("order_id = ?", order.ID).Order("id").Find(&returns).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving returns: %v", err)
+    }
+    res := &pb.ListReturnsResponse{}
+    for _, ret := range returns {
+        res.Returns = append(res.Returns, toProtoReturn(ret, order.Currency))
+    }
+    return res, nil
+}
+
+func (s *server) ApproveReturn(ctx context.Context, req *pb.ReturnDecisionRequest) (*pb.OrderReturnResponse, error) {
+    return s.decideReturn(ctx, req, models.ReturnApproved)
+}
+
+func (s *server) RejectReturn(ctx context.Context, req *pb.ReturnDecisionRequest) (*pb.OrderReturnResponse, error) {
+    return s.decideReturn(ctx, req, models.ReturnRejected)
+}
+
+// decideReturn approves or rejects a requested return
+func (s *server) decideReturn(ctx context.Context, req *pb.ReturnDecisionRequest, to models.ReturnStatus) (*pb.OrderReturnResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireReturnAdmin(actor); err != nil {
+        return nil, err
+    }
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        ret, err := lockReturn(tx, req.OrderId, req.Id)
+        if err != nil {
+            return err
+        }
+        return transitionReturn(tx, ret, to, actor, strings.TrimSpace(req.Reason))
+    })
+    if err != nil {
+        return nil, err
+    }
+    return s.returnResponse(uint(req.Id))
+}
+
+// ReceiveReturn records the items of an approved return as received. They go
+// back in stock once inspection accepts them.
+func (s *server) ReceiveReturn(ctx context.Context, req *pb.ReceiveReturnRequest) (*pb.OrderReturnResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireReturnAdmin(actor); err != nil {
+        return nil, err
+    }
+
+    var ret *models.Return
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        if ret, err = lockReturn(tx, req.OrderId, req.Id); err != nil {
+            return err
+        }
+        if ret.Status == models.ReturnReceived {
+            return nil
+        }
+        if !ret.Status.CanTransitionTo(models.ReturnReceived) {
+            return status.Errorf(codes.FailedPrecondition, "Return with ID '%d' cannot be received while %s", ret.ID, ret.Status)
+        }
+
+        received, err := itemQuantities(ret.Items, req.Items, func(item *pb.ReturnItem) int32 { return item.ReceivedQuantity })
+        if err != nil {
+            return err
+        }
+        for i := range ret.Items {
+            item := &ret.Items[i]
+            item.ReceivedQuantity = item.Quantity
+            if quantity, ok := received[item.OrderItemID]; ok {
+                if quantity > item.Quantity {
+                    return status.Errorf(codes.InvalidArgument, "Only %d of order item '%d' were requested", item.Quantity, item.OrderItemID)
+                }
+                item.ReceivedQuantity = quantity
+            }
+            if err := tx.Save(item).Error; err != nil {
+                return status.Errorf(codes.Internal, "Error updating return: %v", err)
+            }
+        }
+        return transitionReturn(tx, ret, models.ReturnReceived, actor, strings.TrimSpace(req.Reason))
+    })
+    if err != nil {
+        return nil, err
+    }
+    return s.returnResponse(ret.ID)
+}
+
+// InspectReturn records how much of a received return is accepted, puts the
+// accepted items back in stock and refunds them on the order's payment: by
+// default what was paid for the accepted items, or a smaller refundAmount. A
+// return refunding nothing is closed. Inspecting a return again only retries
+// a restock or refund that hasn't gone through.
+func (s *server) InspectReturn(ctx context.Context, req *pb.InspectReturnRequest) (*pb.OrderReturnResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireReturnAdmin(actor); err != nil {
+        return nil, err
+    }
+
+    var ret *models.Return
+    var order models.Order
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        if ret, err = lockReturn(tx, req.OrderId, req.Id); err != nil {
+            return err
+        }
+        // The order is locked so returns of the same items are refunded one at a time
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, ret.OrderID).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+        }
+        if ret.Status == models.ReturnInspected {
+            return nil
+        }
+        if !ret.Status.CanTransitionTo(models.ReturnInspected) {
+            return status.Errorf(codes.FailedPrecondition, "Return with ID '%d' cannot be inspected while %s", ret.ID, ret.Status)
+        }
+
+        accepted, err := itemQuantities(ret.Items, req.Items, func(item *pb.ReturnItem) int32 { return item.AcceptedQuantity })
+        if err != nil {
+            return err
+        }
+        for i := range ret.Items {
+            item := &ret.Items[i]
+            item.AcceptedQuantity = item.ReceivedQuantity
+            if quantity, ok := accepted[item.OrderItemID]; ok {
+                if quantity > item.ReceivedQuantity {
+                    return status.Errorf(codes.InvalidArgument, "Only %d of order item '%d' were received", item.ReceivedQuantity, item.OrderItemID)
+                }
+                item.AcceptedQuantity = quantity
+            }
+            if err := tx.Save(item).Error; err != nil {
+                return status.Errorf(codes.Internal, "Error updating return: %v", err)
+            }
+            if item.AcceptedQuantity > 0 {
+                ret.Restock = models.RestockPending
+            }
+        }
+
+        var inspected []models.Return
+        err = tx.Preload("Items").
+            Where("order_id = ? AND id <> ? AND status IN ?", order.ID, ret.ID, []models.ReturnStatus{models.ReturnInspected, models.ReturnRefunded, models.ReturnClosed}).
+            Find(&inspected).Error
+        if err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving returns: %v", err)
+        }
+        if ret.RefundAmount, err = refundAmount(order, ret.Items, acceptedQuantities(inspected), req.RefundAmount); err != nil {
+            return err
+        }
+        reason := strings.TrimSpace(req.Reason)
+        if err := transitionReturn(tx, ret, models.ReturnInspected, actor, reason); err != nil {
+            return err
+        }
+        if ret.RefundAmount == 0 {
+            return transitionReturn(tx, ret, models.ReturnClosed, actor, "Nothing to refund")
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if ret.Restock == models.RestockPending {
+        // The inspection is committed; a failed restock is retried by the recovery worker
+        if err := s.restockReturn(ctx, ret); err != nil {
+            log.Printf("return %d: restock failed, will retry: %v", ret.ID, err)
+        }
+    }
+    if ret.Status == models.ReturnInspected {
+        if err := s.refundReturn(ctx, ret, order, actor); err != nil {
+            return nil, err
+        }
+    }
+    return s.returnResponse(ret.ID)
+}
+
+// refundReturn refunds an inspected return on the order's captured payment.
+// The refund carries an idempotency key per return, so retrying it after a
+// lost answer never refunds twice.
+func (s *server) refundReturn(ctx context.Context, ret *models.Return, order models.Order, actor caller) error {
+    // The refund is made by order-service itself, once an admin inspected the return
+    ctx = asSystem(ctx)
+    payments, err := s.PaymentServiceClient.ListPayments(ctx, &paymentpb.ListPaymentsRequest{OrderId: int64(order.ID)})
+    if err != nil {
+        return err
+    }
+    // A refund whose answer was lost may have refunded the payment in full already
+    var payment *paymentpb.Payment
+    for _, p := range payments.GetPayments() {
+        if p.Status == paymentpb.PaymentStatus_CAPTURED || p.Status == paymentpb.PaymentStatus_PARTIALLY_REFUNDED || p.Status == paymentpb.PaymentStatus_REFUNDED {
+            payment = p
+            break
+        }
+    }
+    if payment == nil {
+        return status.Errorf(codes.FailedPrecondition, "Order with ID '%d' has no captured payment to refund", order.ID)
+    }
+
+    _, err = s.PaymentServiceClient.RefundPayment(ctx, &paymentpb.RefundPaymentRequest{
+        Id:             payment.Id,
+        Amount:         &paymentpb.Money{Amount: ret.RefundAmount, Currency: order.Currency},
+        Reason:         fmt.Sprintf("Return %d", ret.ID),
+        IdempotencyKey: fmt.Sprintf("return-%d-refund", ret.ID),
+    })
+    if err != nil {
+        return err
+    }
+
+    return s.db.Transaction(func(tx *gorm.DB) error {
+        locked, err := lockReturn(tx, int64(ret.OrderID), int64(ret.ID))
+        if err != nil {
+            return err
+        }
+        if locked.Status != models.ReturnInspected {
+            return nil
+        }
+        locked.PaymentID = uint(payment.Id)
+        return transitionReturn(tx, locked, models.ReturnRefunded, actor, fmt.Sprintf("Refunded on payment %d", payment.Id))
+    })
+}
+
+// restockReturnKey is the idempotency key for putting a return's items back
+// in stock, so the product service applies it at most once
+func restockReturnKey(returnID uint) string {
+    return fmt.Sprintf("return-%d-restock", returnID)
+}
+
+// restockReturn puts the accepted items of an inspected return back in stock
+func (s *server) restockReturn(ctx context.Context, ret *models.Return) error {
+    req := &productpb.UpdateMultipleInventoriesRequest{IdempotencyKey: restockReturnKey(ret.ID)}
+    for _, item := range ret.Items {
+        if item.AcceptedQuantity == 0 {
+            continue
+        }
+        req.InventoryUpdates = append(req.InventoryUpdates, &productpb.UpdateInventoryRequest{
+            ProductId:      int64(item.ProductID),
+            VariantId:      int64(item.VariantID),
+            QuantityChange: int32(item.AcceptedQuantity),
+            WarehouseId:    int64(item.WarehouseID),
+            Reason:         "RETURN",
+            ReferenceId:    fmt.Sprintf("return-%d", ret.ID),
+        })
+    }
+    if _, err := s.ProductServiceClient.UpdateMultipleInventories(ctx, req); err != nil {
+        return err
+    }
+
+    ret.Restock = models.RestockDone
+    return s.db.Model(ret).Update("restock", models.RestockDone).Error
+}
+
+// restockReturns retries the restock of inspected returns whose accepted items were not put back
+func (s *server) restockReturns() {
+    var returns []models.Return
+    inspected := []models.ReturnStatus{models.ReturnInspected, models.ReturnRefunded, models.ReturnClosed}
+    err := s.db.Preload("Items").Where("restock = ? AND status IN ?", models.RestockPending, inspected).Find(&returns).Error
+    if err != nil {
+        log.Printf("return restock recovery: failed to load returns: %v", err)
+        return
+    }
+
+    for i := range returns {
+        ctx, cancel := context.WithTimeout(context.Background(), sagaCompensationTimeout)
+        if err := s.restockReturn(ctx, &returns[i]); err != nil {
+            log.Printf("return %d: restock failed, will retry: %v", returns[i].ID, err)
+        }
+        cancel()
+    }
+}
+
+// returnedQuantities sums, per order item, what the returns take back: the
+// requested quantity until the items arrive, then what was received
+func returnedQuantities(returns []models.Return) map[uint]int {
+    returned := make(map[uint]int)
+    for _, ret := range returns {
+        for _, item := range ret.Items {
+            switch ret.Status {
+            case models.ReturnRequested, models.ReturnApproved:
+                returned[item.OrderItemID] += item.Quantity
+            default:
+                returned[item.OrderItemID] += item.ReceivedQuantity
+            }
+        }
+    }
+    return returned
+}
+
+// newReturnItems validates the items requested for return against the order
+// and what its other returns already take back
+func newReturnItems(order models.Order, requested []*pb.ReturnItem, returned map[uint]int) ([]models.ReturnItem, error) {
+    if len(requested) == 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "At least one item is required")
+    }
+    orderItems := make(map[uint]models.OrderItem, len(order.Items))
+    for _, item := range order.Items {
+        orderItems[item.ID] = item
+    }
+
+    var items []models.ReturnItem
+    seen := make(map[uint]bool)
+    for _, req := range requested {
+        orderItem, ok := orderItems[uint(req.OrderItemId)]
+        if !ok {
+            return nil, status.Errorf(codes.InvalidArgument, "Order item with ID '%d' is not part of order '%d'", req.OrderItemId, order.ID)
+        }
+        if seen[orderItem.ID] {
+            return nil, status.Errorf(codes.InvalidArgument, "Order item with ID '%d' is listed twice", req.OrderItemId)
+        }
+        seen[orderItem.ID] = true
+        if req.Quantity <= 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Quantity must be positive")
+        }
+        if left := orderItem.Quantity - returned[orderItem.ID]; int(req.Quantity) > left {
+            return nil, status.Errorf(codes.FailedPrecondition, "Only %d of order item '%d' can still be returned", left, orderItem.ID)
+        }
+        items = append(items, models.ReturnItem{
+            OrderItemID: orderItem.ID,
+            ProductID:   orderItem.ProductID,
+            VariantID:   orderItem.VariantID,
+            WarehouseID: orderItem.WarehouseID,
+            Quantity:    int(req.Quantity),
+        })
+    }
+    return items, nil
+}
+
+// itemQuantities reads a quantity per order item of a return from a request,
+// rejecting items that aren't part of the return
+func itemQuantities(items []models.ReturnItem, requested []*pb.ReturnItem, quantity func(*pb.ReturnItem) int32) (map[uint]int, error) {
+    inReturn := make(map[uint]bool, len(items))
+    for _, item := range items {
+        inReturn[item.OrderItemID] = true
+    }
+    quantities := make(map[uint]int, len(requested))
+    for _, req := range requested {
+        if !inReturn[uint(req.OrderItemId)] {
+            return nil, status.Errorf(codes.InvalidArgument, "Order item with ID '%d' is not part of the return", req.OrderItemId)
+        }
+        if quantity(req) < 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Quantity must not be negative")
+        }
+        quantities[uint(req.OrderItemId)] = int(quantity(req))
+    }
+    return quantities, nil
+}
+
+// acceptedQuantities sums, per order item, what inspected returns accepted
+func acceptedQuantities(returns []models.Return) map[uint]int {
+    accepted := make(map[uint]int)
+    for _, ret := range returns {
+        for _, item := range ret.Items {
+            accepted[item.OrderItemID] += item.AcceptedQuantity
+        }
+    }
+    return accepted
+}
+
+// refundAmount works out the refund of the accepted items of a return: for
+// each, its share of what the line cost after its discount and with the tax
+// added to it. Shipping isn't refunded. A requested amount may lower it.
+// Shares are rounded on the quantity accepted so far, including the earlier
+// returns in accepted, so the refunds of a line never add up to more than it cost.
+func refundAmount(order models.Order, items []models.ReturnItem, accepted map[uint]int, requested *pb.Money) (int64, error) {
+    orderItems := make(map[uint]models.OrderItem, len(order.Items))
+    for _, item := range order.Items {
+        orderItems[item.ID] = item
+    }
+    var paid int64
+    for _, item := range items {
+        line, ok := orderItems[item.OrderItemID]
+        if !ok || item.AcceptedQuantity == 0 || line.Quantity == 0 {
+            continue
+        }
+        lineTotal := order.Money(line.Price*int64(line.Quantity) - line.Discount + line.Tax)
+        before := int64(accepted[item.OrderItemID])
+        after := before + int64(item.AcceptedQuantity)
+        paid += lineTotal.MulRatio(after, int64(line.Quantity)).Amount - lineTotal.MulRatio(before, int64(line.Quantity)).Amount
+    }
+    if requested == nil {
+        return paid, nil
+    }
+
+    if requested.Currency != "" && !strings.EqualFold(requested.Currency, order.Currency) {
+        return 0, status.Errorf(codes.InvalidArgument, "Refund must be in %s, the currency of the order", order.Currency)
+    }
+    if requested.Amount < 0 || requested.Amount > paid {
+        return 0, status.Errorf(codes.InvalidArgument, "Refund must be between 0 and %s, what was paid for the accepted items", order.Money(paid))
+    }
+    return requested.Amount, nil
+}
+
+// transitionReturn moves a return to the given status within tx, recording
+// the change in its status history
+func transitionReturn(tx *gorm.DB, ret *models.Return, to models.ReturnStatus, actor caller, reason string) error {
+    from := ret.Status
+    if !from.CanTransitionTo(to) {
+        return status.Errorf(codes.FailedPrecondition, "Return with ID '%d' cannot move from %s to %s", ret.ID, from, to)
+    }
+
+    ret.Status = to
+    if err := tx.Omit(clause.Associations).Save(ret).Error; err != nil {
+        return status.Errorf(codes.Internal, "Error updating return: %v", err)
+    }
+    return recordReturnStatusChange(tx, ret.ID, from, to, actor, reason)
+}
+
+// recordReturnStatusChange writes an entry to the return's status history
+func recordReturnStatusChange(tx *gorm.DB, returnID uint, from, to models.ReturnStatus, actor caller, reason string) error {
+    err := tx.Create(&models.ReturnStatusHistory{
+        ReturnID:   returnID,
+        FromStatus: from,
+        ToStatus:   to,
+        ActorID:    actor.UserID,
+        ActorRole:  actor.Role,
+        Reason:     reason,
+        ChangedAt:  time.Now(),
+    }).Error
+    if err != nil {
+        return status.Errorf(codes.Internal, "Error recording return history: %v", err)
+    }
+    return nil
+}
+
+// requireReturnAdmin checks the caller may process returns
+func requireReturnAdmin(actor caller) error {
+    if !actor.IsAdmin() {
+        return status.Errorf(codes.PermissionDenied, "Only admins can process returns")
+    }
+    return nil
+}
+
+// authorizeReturn checks the caller may see the return, as for its order
+func authorizeReturn(actor caller, ret models.Return) error {
+    if !actor.IsAdmin() && ret.CustomerID != actor.UserID {
+        return status.Errorf(codes.NotFound, "Return with ID '%d' not found", ret.ID)
+    }
+    return nil
+}
+
+// preloadReturn loads a return's items and history along with it
+func preloadReturn(db *gorm.DB) *gorm.DB {
+    return db.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+        Preload("History", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+}
+
+// findReturn loads a return of an order
+func findReturn(db *gorm.DB, orderID, id int64) (*models.Return, error) {
+    var ret models.Return
+    if err := preloadReturn(db).Where("order_id = ?", orderID).First(&ret, id).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Return with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving return: %v", err)
+    }
+    return &ret, nil
+}
+
+// lockReturn loads a return of an order with its items, locking it so
+// concurrent changes are serialized
+func lockReturn(tx *gorm.DB, orderID, id int64) (*models.Return, error) {
+    var ret models.Return
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+        Where("order_id = ?", orderID).First(&ret, id).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Return with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving return: %v", err)
+    }
+    return &ret, nil
+}
+
+// returnResponse reloads a return after a change
+func (s *server) returnResponse(id uint) (*pb.OrderReturnResponse, error) {
+    var ret models.Return
+    if err := preloadReturn(s.db).First(&ret, id).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving return: %v", err)
+    }
+    var order models.Order
+    if err := s.db.Select("id", "currency").First(&order, ret.OrderID).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+    }
+    return &pb.OrderReturnResponse{OrderReturn: toProtoReturn(ret, order.Currency)}, nil
+}
+
+// toProtoReturn converts a return to protobuf, with its refund in the
+// order's currency
+func toProtoReturn(ret models.Return, currency string) *pb.OrderReturn {
+    res := &pb.OrderReturn{
+        Id:           int64(ret.ID),
+        OrderId:      int64(ret.OrderID),
+        CustomerId:   int64(ret.CustomerID),
+        Status:       pb.ReturnStatus(pb.ReturnStatus_value[string(ret.Status)]),
+        Reason:       ret.Reason,
+        RefundAmount: &pb.Money{Amount: ret.RefundAmount, Currency: currency},
+        PaymentId:    int64(ret.PaymentID),
+        CreatedAt:    ret.CreatedAt.Format(time.RFC3339),
+    }
+    for _, item := range ret.Items {
+        res.Items = append(res.Items, &pb.ReturnItem{
+            OrderItemId:      int64(item.OrderItemID),
+            ProductId:        int64(item.ProductID),
+            VariantId:        int64(item.VariantID),
+            Quantity:         int32(item.Quantity),
+            ReceivedQuantity: int32(item.ReceivedQuantity),
+            AcceptedQuantity: int32(item.AcceptedQuantity),
+        })
+    }
+    for _, change := range ret.History {
+        res.History = append(res.History, &pb.OrderStatusChange{
+            FromStatus: string(change.FromStatus),
+            ToStatus:   string(change.ToStatus),
+            ActorId:    int64(change.ActorID),
+            ActorRole:  int32(change.ActorRole),
+            Reason:     change.Reason,
+            ChangedAt:  change.ChangedAt.Format(time.RFC3339),
+        })
+    }
+    return res
+}
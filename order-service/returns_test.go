@@ -0,0 +1,280 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    paymentpb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+func TestNewReturnItems(t *testing.T) {
+    order := testOrder()
+    items, err := newReturnItems(order, []*pb.ReturnItem{{OrderItemId: 11, Quantity: 2}}, map[uint]int{11: 1})
+    if err != nil {
+        t.Fatalf("newReturnItems error = %v", err)
+    }
+    if len(items) != 1 || items[0].ProductID != 5 || items[0].WarehouseID != 2 || items[0].Quantity != 2 {
+        t.Errorf("items = %+v, want 2 of product 5 from warehouse 2", items)
+    }
+
+    tests := []struct {
+        name     string
+        items    []*pb.ReturnItem
+        returned map[uint]int
+        code     codes.Code
+    }{
+        {name: "no items", code: codes.InvalidArgument},
+        {name: "item of another order", items: []*pb.ReturnItem{{OrderItemId: 99, Quantity: 1}}, code: codes.InvalidArgument},
+        {name: "item listed twice", items: []*pb.ReturnItem{{OrderItemId: 12, Quantity: 1}, {OrderItemId: 12, Quantity: 1}}, code: codes.InvalidArgument},
+        {name: "no quantity", items: []*pb.ReturnItem{{OrderItemId: 12}}, code: codes.InvalidArgument},
+        {name: "more than ordered", items: []*pb.ReturnItem{{OrderItemId: 11, Quantity: 4}}, code: codes.FailedPrecondition},
+        {name: "already returned", items: []*pb.ReturnItem{{OrderItemId: 11, Quantity: 2}}, returned: map[uint]int{11: 2}, code: codes.FailedPrecondition},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := newReturnItems(order, tt.items, tt.returned); status.Code(err) != tt.code {
+                t.Errorf("newReturnItems error = %v, want %v", err, tt.code)
+            }
+        })
+    }
+}
+
+func TestReturnedQuantities(t *testing.T) {
+    returns := []models.Return{
+        {Status: models.ReturnRequested, Items: []models.ReturnItem{{OrderItemID: 11, Quantity: 1}}},
+        // Only what arrived counts once a return is received
+        {Status: models.ReturnRefunded, Items: []models.ReturnItem{{OrderItemID: 11, Quantity: 2, ReceivedQuantity: 1}}},
+    }
+    if got := returnedQuantities(returns)[11]; got != 2 {
+        t.Errorf("returned quantity = %d, want 2", got)
+    }
+}
+
+func TestRefundAmount(t *testing.T) {
+    order := testOrder()
+    items := []models.ReturnItem{
+        {OrderItemID: 11, ReceivedQuantity: 2, AcceptedQuantity: 2},
+        {OrderItemID: 12, ReceivedQuantity: 1, AcceptedQuantity: 0},
+    }
+
+    // Two thirds of 29.16
+    if got, err := refundAmount(order, items, nil, nil); err != nil || got != 1944 {
+        t.Errorf("refundAmount = %d, %v, want 1944", got, err)
+    }
+    if got, err := refundAmount(order, items, nil, &pb.Money{Amount: 1000, Currency: "usd"}); err != nil || got != 1000 {
+        t.Errorf("refundAmount with a partial amount = %d, %v, want 1000", got, err)
+    }
+    for _, requested := range []*pb.Money{{Amount: 1945, Currency: "USD"}, {Amount: 100, Currency: "EUR"}, {Amount: -1}} {
+        if _, err := refundAmount(order, items, nil, requested); status.Code(err) != codes.InvalidArgument {
+            t.Errorf("refundAmount(%v) error = %v, want %v", requested, err, codes.InvalidArgument)
+        }
+    }
+}
+
+func TestRefundAmountOfPartialReturns(t *testing.T) {
+    // A line of 3 costing 20.00, returned one unit at a time
+    order := models.Order{Currency: "USD", Items: []models.OrderItem{{Model: gorm.Model{ID: 11}, Quantity: 3, Price: 700, Discount: 100}}}
+    accepted := map[uint]int{}
+    var refunded int64
+    for _, want := range []int64{667, 666, 667} {
+        items := []models.ReturnItem{{OrderItemID: 11, ReceivedQuantity: 1, AcceptedQuantity: 1}}
+        got, err := refundAmount(order, items, accepted, nil)
+        if err != nil || got != want {
+            t.Errorf("refund of unit %d = %d, %v, want %d", accepted[11]+1, got, err, want)
+        }
+        refunded += got
+        accepted[11]++
+    }
+    if refunded != 2000 {
+        t.Errorf("refunded %d in total, want the 2000 the line cost", refunded)
+    }
+}
+
+func TestReturnTransitions(t *testing.T) {
+    allowed := [][2]models.ReturnStatus{
+        {models.ReturnRequested, models.ReturnApproved},
+        {models.ReturnRequested, models.ReturnRejected},
+        {models.ReturnApproved, models.ReturnReceived},
+        {models.ReturnReceived, models.ReturnInspected},
+        {models.ReturnInspected, models.ReturnRefunded},
+        {models.ReturnInspected, models.ReturnClosed},
+    }
+    for _, transition := range allowed {
+        if !transition[0].CanTransitionTo(transition[1]) {
+            t.Errorf("%s -> %s not allowed", transition[0], transition[1])
+        }
+    }
+    if models.ReturnRequested.CanTransitionTo(models.ReturnReceived) || models.ReturnRefunded.CanTransitionTo(models.ReturnRequested) {
+        t.Errorf("return skipped a step or left a final status")
+    }
+}
+
+// receivedTestReturn requests, approves and receives a return of 3 units of
+// item 11 of a delivered order, of which receivedQuantity arrive
+func receivedTestReturn(t *testing.T, s *server, receivedQuantity int32) (models.Order, int64) {
+    t.Helper()
+    order := createTestOrder(t, s.db, models.StatusDelivered)
+    item := int64(order.Items[0].ID)
+    requested, err := s.RequestReturn(customerContext(t, order.CustomerID), &pb.RequestReturnRequest{
+        OrderId: int64(order.ID),
+        Reason:  "Too small",
+        Items:   []*pb.ReturnItem{{OrderItemId: item, Quantity: 3}},
+    })
+    if err != nil {
+        t.Fatalf("RequestReturn error = %v", err)
+    }
+    id := requested.OrderReturn.Id
+
+    admin := adminContext(t)
+    if _, err := s.ApproveReturn(admin, &pb.ReturnDecisionRequest{OrderId: int64(order.ID), Id: id}); err != nil {
+        t.Fatalf("ApproveReturn error = %v", err)
+    }
+    received := &pb.ReceiveReturnRequest{OrderId: int64(order.ID), Id: id, Items: []*pb.ReturnItem{{OrderItemId: item, ReceivedQuantity: receivedQuantity}}}
+    if _, err := s.ReceiveReturn(admin, received); err != nil {
+        t.Fatalf("ReceiveReturn error = %v", err)
+    }
+    return order, id
+}
+
+func TestRequestReturnOfItemFromGetOrder(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db, ProductServiceClient: newFakeInventory(), PaymentServiceClient: newFakePayments()}
+    order := createTestOrder(t, db, models.StatusDelivered)
+    customer := customerContext(t, order.CustomerID)
+
+    // The customer picks the variant item out of the order they were shown
+    res, err := s.GetOrder(customer, &pb.GetOrderRequest{OrderId: int64(order.ID)})
+    if err != nil {
+        t.Fatalf("GetOrder error = %v", err)
+    }
+    var item *pb.OrderItem
+    for _, orderItem := range res.Order.Items {
+        if orderItem.VariantId == 9 {
+            item = orderItem
+        }
+    }
+    if item == nil || item.Id == 0 {
+        t.Fatalf("order items = %v, want the variant item with its ID", res.Order.Items)
+    }
+
+    requested, err := s.RequestReturn(customer, &pb.RequestReturnRequest{
+        OrderId: int64(order.ID),
+        Reason:  "Wrong colour",
+        Items:   []*pb.ReturnItem{{OrderItemId: item.Id, Quantity: 1}},
+    })
+    if err != nil {
+        t.Fatalf("RequestReturn error = %v", err)
+    }
+    returned := requested.OrderReturn.Items
+    if len(returned) != 1 || returned[0].OrderItemId != item.Id || returned[0].ProductId != 6 || returned[0].VariantId != 9 {
+        t.Errorf("returned items = %v, want 1 of variant 9 of product 6", returned)
+    }
+}
+
+func TestInspectReturnRestocksAcceptedItems(t *testing.T) {
+    db := testDB(t)
+    inventory, payments := newFakeInventory(), newFakePayments()
+    s := &server{db: db, ProductServiceClient: inventory, PaymentServiceClient: payments}
+    order, id := receivedTestReturn(t, s, 2)
+    payments.payments[int64(order.ID)] = &paymentpb.Payment{Id: 40, OrderId: int64(order.ID), Status: paymentpb.PaymentStatus_CAPTURED}
+
+    if len(inventory.restocks) != 0 {
+        t.Fatalf("received items restocked before inspection: %v", inventory.restocks)
+    }
+
+    inspected, err := s.InspectReturn(adminContext(t), &pb.InspectReturnRequest{
+        OrderId: int64(order.ID),
+        Id:      id,
+        Items:   []*pb.ReturnItem{{OrderItemId: int64(order.Items[0].ID), AcceptedQuantity: 1}},
+    })
+    if err != nil {
+        t.Fatalf("InspectReturn error = %v", err)
+    }
+    if inspected.OrderReturn.Status != pb.ReturnStatus_REFUNDED || inspected.OrderReturn.RefundAmount.GetAmount() != 972 {
+        t.Errorf("return is %s refunding %v, want REFUNDED with 9.72, a third of 29.16", inspected.OrderReturn.Status, inspected.OrderReturn.RefundAmount)
+    }
+
+    restock, ok := inventory.restocks[restockReturnKey(uint(id))]
+    if !ok || len(restock.InventoryUpdates) != 1 {
+        t.Fatalf("restock = %v, want one update", restock)
+    }
+    if update := restock.InventoryUpdates[0]; update.ProductId != 5 || update.QuantityChange != 1 || update.WarehouseId != 2 {
+        t.Errorf("restocked %+v, want the 1 accepted unit of product 5 at warehouse 2", update)
+    }
+    var ret models.Return
+    db.First(&ret, id)
+    if ret.Restock != models.RestockDone {
+        t.Errorf("restock = %q, want DONE", ret.Restock)
+    }
+}
+
+func TestInspectReturnRetriesRestock(t *testing.T) {
+    db := testDB(t)
+    inventory, payments := newFakeInventory(), newFakePayments()
+    s := &server{db: db, ProductServiceClient: inventory, PaymentServiceClient: payments}
+    order, id := receivedTestReturn(t, s, 3)
+    payments.payments[int64(order.ID)] = &paymentpb.Payment{Id: 41, OrderId: int64(order.ID), Status: paymentpb.PaymentStatus_CAPTURED}
+
+    // The inspection is kept and refunded while product-service is down
+    inventory.err = status.Error(codes.Unavailable, "product-service is down")
+    if _, err := s.InspectReturn(adminContext(t), &pb.InspectReturnRequest{OrderId: int64(order.ID), Id: id}); err != nil {
+        t.Fatalf("InspectReturn error = %v", err)
+    }
+    var ret models.Return
+    db.First(&ret, id)
+    if ret.Status != models.ReturnRefunded || ret.Restock != models.RestockPending {
+        t.Fatalf("return is %s with restock %q, want REFUNDED and PENDING", ret.Status, ret.Restock)
+    }
+
+    inventory.err = nil
+    s.restockReturns()
+    db.First(&ret, id)
+    if ret.Restock != models.RestockDone {
+        t.Errorf("restock = %q after recovery, want DONE", ret.Restock)
+    }
+    if restock := inventory.restocks[restockReturnKey(uint(id))]; restock == nil || restock.InventoryUpdates[0].QuantityChange != 3 {
+        t.Errorf("restock = %v, want the 3 accepted units", restock)
+    }
+}
+
+func TestInspectReturnRetriesRefundUnderTheSameKey(t *testing.T) {
+    db := testDB(t)
+    payments := newFakePayments()
+    s := &server{db: db, ProductServiceClient: newFakeInventory(), PaymentServiceClient: payments}
+    order, id := receivedTestReturn(t, s, 3)
+    payments.payments[int64(order.ID)] = &paymentpb.Payment{Id: 42, OrderId: int64(order.ID), Status: paymentpb.PaymentStatus_CAPTURED}
+    inspect := &pb.InspectReturnRequest{OrderId: int64(order.ID), Id: id}
+
+    // The refund goes through but its answer is lost
+    payments.lostErr = status.Error(codes.Unavailable, "connection reset")
+    if _, err := s.InspectReturn(adminContext(t), inspect); err == nil {
+        t.Fatalf("InspectReturn succeeded without an answer from payment-service")
+    }
+    var ret models.Return
+    db.First(&ret, id)
+    if ret.Status != models.ReturnInspected {
+        t.Fatalf("return is %s, want INSPECTED until the refund is confirmed", ret.Status)
+    }
+
+    payments.lostErr = nil
+    retried, err := s.InspectReturn(adminContext(t), inspect)
+    if err != nil {
+        t.Fatalf("InspectReturn retry error = %v", err)
+    }
+    if retried.OrderReturn.Status != pb.ReturnStatus_REFUNDED || retried.OrderReturn.PaymentId != 42 {
+        t.Errorf("return is %s on payment %d, want REFUNDED on 42", retried.OrderReturn.Status, retried.OrderReturn.PaymentId)
+    }
+    if len(payments.refunds) != 2 {
+        t.Fatalf("sent %d refunds, want the first and its retry", len(payments.refunds))
+    }
+    for _, refund := range payments.refunds {
+        if refund.IdempotencyKey != fmt.Sprintf("return-%d-refund", id) || refund.Amount.Amount != 2916 {
+            t.Errorf("refund = %+v, want 29.16 under the return's key", refund)
+        }
+    }
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "order-service/models"
+)
+
+// Step names of the create order saga, in execution order
+const (
+    stepReserveInventory = "RESERVE_INVENTORY"
+    stepCreateOrder      = "CREATE_ORDER"
+    stepCommitInventory  = "COMMIT_INVENTORY"
+)
+
+const (
+    // sagaRecoveryInterval is how often the recovery worker looks for unfinished sagas
+    sagaRecoveryInterval = 30 * time.Second
+    // sagaStaleAfter is how long a saga must be idle before the worker takes it over,
+    // so sagas still being driven by a live request are left alone
+    sagaStaleAfter = time.Minute
+    // sagaCompensationTimeout bounds the remote calls made while rolling a saga back
+    sagaCompensationTimeout = 10 * time.Second
+)
+
+// createOrderPayload is the input persisted with a create order saga
+type createOrderPayload struct {
+    CustomerID uint              `json:"customer_id"`
+    Items      []createOrderItem `json:"items"`
+}
+
+type createOrderItem struct {
+    ProductID uint `json:"product_id"`
+    VariantID uint `json:"variant_id,omitempty"`
+    Quantity  int  `json:"quantity"`
+}
+
+// startCreateOrderSaga persists a new create order saga with all of its steps
+// pending. The steps' keys derive from the request's idempotency key when it
+// has one, so a retried request holds and commits the same stock.
+func (s *server) startCreateOrderSaga(payload createOrderPayload, key string) (*models.Saga, error) {
+    data, err := json.Marshal(payload)
+    if err != nil {
+        return nil, err
+    }
+
+    saga := &models.Saga{
+        Type:           models.SagaTypeCreateOrder,
+        Status:         models.SagaStarted,
+        IdempotencyKey: key,
+        Payload:        string(data),
+    }
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(saga).Error; err != nil {
+            return err
+        }
+        for _, name := range []string{stepReserveInventory, stepCreateOrder, stepCommitInventory} {
+            step := models.SagaStep{
+                SagaID:         saga.ID,
+                Name:           name,
+                Status:         models.StepPending,
+                IdempotencyKey: sagaStepKey(saga, name),
+            }
+            if err := tx.Create(&step).Error; err != nil {
+                return err
+            }
+            saga.Steps = append(saga.Steps, step)
+        }
+        // The first hold is made under the RESERVE_INVENTORY step's key
+        saga.ReservationKey = sagaStep(saga, stepReserveInventory).IdempotencyKey
+        return tx.Model(saga).Update("reservation_key", saga.ReservationKey).Error
+    })
+    if err != nil {
+        return nil, err
+    }
+    return saga, nil
+}
+
+// sagaStepKey is the idempotency key sent to other services for a saga step
+func sagaStepKey(saga *models.Saga, name string) string {
+    if saga.IdempotencyKey != "" {
+        return fmt.Sprintf("%s-%s", saga.IdempotencyKey, name)
+    }
+    return fmt.Sprintf("order-saga-%d-%s", saga.ID, name)
+}
+
+// findKeyedSaga returns the create order saga started by a request with the
+// given idempotency key, or nil when there is none
+func (s *server) findKeyedSaga(key string) (*models.Saga, error) {
+    var saga models.Saga
+    err := s.db.Where("type = ? AND idempotency_key = ?", models.SagaTypeCreateOrder, key).First(&saga).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving order saga: %v", err)
+    }
+    return &saga, nil
+}
+
+// replayCreateOrder answers a create order request retried with the key of
+// an earlier one: the order it created, the failure that rolled it back, or
+// Unavailable while it is still running
+func (s *server) replayCreateOrder(saga *models.Saga, creator caller) (*pb.OrderResponse, error) {
+    switch {
+    case saga.OrderID != 0 && saga.Status != models.SagaCompensated:
+        var order models.Order
+        if err := s.db.Preload("Items.TaxLines").First(&order, saga.OrderID).Error; err != nil {
+            return nil, status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+        }
+        if !creator.IsAdmin() && order.CustomerID != creator.UserID {
+            return nil, status.Errorf(codes.AlreadyExists, "Idempotency key '%s' was used by another customer", saga.IdempotencyKey)
+        }
+        return &pb.OrderResponse{Order: toProtoOrder(order)}, nil
+    case saga.Status == models.SagaCompensated:
+        return nil, status.Errorf(codes.Aborted, "Order request '%s' failed: %s", saga.IdempotencyKey, saga.LastError)
+    default:
+        return nil, status.Errorf(codes.Unavailable, "Order request '%s' is still being processed", saga.IdempotencyKey)
+    }
+}
+
+// sagaStep returns the named step of a saga
+func sagaStep(saga *models.Saga, name string) *models.SagaStep {
+    for i := range saga.Steps {
+        if saga.Steps[i].Name == name {
+            return &saga.Steps[i]
+        }
+    }
+    return nil
+}
+
+// setStepStatus persists a new status for a saga step
+func (s *server) setStepStatus(db *gorm.DB, step *models.SagaStep, stepStatus models.SagaStepStatus) error {
+    step.Status = stepStatus
+    return db.Model(step).Update("status", stepStatus).Error
+}
+
+// setSagaStatus persists a new status, and optionally the failure that caused it, for a saga
+func (s *server) setSagaStatus(db *gorm.DB, saga *models.Saga, sagaStatus models.SagaStatus, cause error) error {
+    updates := map[string]interface{}{"status": sagaStatus}
+    if cause != nil {
+        updates["last_error"] = cause.Error()
+        saga.LastError = cause.Error()
+    }
+    saga.Status = sagaStatus
+    return db.Model(saga).Updates(updates).Error
+}
+
+// decodePayload returns the input a create order saga was started with
+func decodePayload(saga *models.Saga) (createOrderPayload, error) {
+    var payload createOrderPayload
+    err := json.Unmarshal([]byte(saga.Payload), &payload)
+    return payload, err
+}
+
+// reservationRequest builds the request holding each item's quantity, keyed
+// by the step so that retries return the same reservation
+func reservationRequest(payload createOrderPayload, key string) *productpb.ReserveInventoryRequest {
+    req := &productpb.ReserveInventoryRequest{ReservationKey: key}
+    for _, item := range payload.Items {
+        req.Items = append(req.Items, &productpb.ReservationItem{
+            ProductId: int64(item.ProductID),
+            VariantId: int64(item.VariantID),
+            Quantity:  int32(item.Quantity),
+        })
+    }
+    return req
+}
+
+// assignWarehouses sets the warehouse each order item is fulfilled from to
+// the one its reservation item was held at. Items are matched by product and
+// variant rather than position, so a replayed reservation listing them in
+// another order still assigns each its own warehouse.
+func assignWarehouses(items []models.OrderItem, reserved []*productpb.ReservationItem) {
+    used := make([]bool, len(reserved))
+    for i := range items {
+        for j, r := range reserved {
+            if !used[j] && uint(r.ProductId) == items[i].ProductID && uint(r.VariantId) == items[i].VariantID {
+                items[i].WarehouseID = uint(r.WarehouseId)
+                used[j] = true
+                break
+            }
+        }
+    }
+}
+
+// isDefiniteFailure reports whether a failed remote call is known to have had no effect.
+// Transport errors and timeouts leave the outcome unknown.
+func isDefiniteFailure(err error) bool {
+    switch status.Code(err) {
+    case codes.InvalidArgument, codes.NotFound, codes.Aborted, codes.FailedPrecondition, codes.AlreadyExists:
+        return true
+    }
+    return false
+}
+
+// reserveInventory runs the forward action of the RESERVE_INVENTORY step,
+// holding the stock in product-service until the order is written.
+// The step is marked STARTED before the call so a crash mid-call is detectable.
+// The returned reservation names the warehouse chosen for each item.
+func (s *server) reserveInventory(ctx context.Context, saga *models.Saga, payload createOrderPayload) (*productpb.Reservation, error) {
+    step := sagaStep(saga, stepReserveInventory)
+    if err := s.setStepStatus(s.db, step, models.StepStarted); err != nil {
+        return nil, err
+    }
+
+    res, err := s.ProductServiceClient.ReserveInventory(ctx, reservationRequest(payload, step.IdempotencyKey))
+    if err != nil {
+        if isDefiniteFailure(err) {
+            // Nothing was applied, so there is nothing to compensate
+            if stepErr := s.setStepStatus(s.db, step, models.StepPending); stepErr != nil {
+                log.Printf("saga %d: failed to reset step %s: %v", saga.ID, step.Name, stepErr)
+            }
+        }
+        return nil, err
+    }
+    return res.Reservation, s.setStepStatus(s.db, step, models.StepDone)
+}
+
+// releaseInventory compensates the RESERVE_INVENTORY step by releasing the held stock
+func (s *server) releaseInventory(ctx context.Context, saga *models.Saga) error {
+    step := sagaStep(saga, stepReserveInventory)
+    req := &productpb.ReleaseReservationRequest{ReservationKey: step.IdempotencyKey}
+    if _, err := s.ProductServiceClient.ReleaseReservation(ctx, req); err != nil && status.Code(err) != codes.NotFound {
+        return err
+    }
+    return s.setStepStatus(s.db, step, models.StepCompensated)
+}
+
+// completeSaga runs the COMMIT_INVENTORY step, turning the held stock into a
+// sale once the order exists, and marks the saga COMPLETED. If the hold is
+// gone, e.g. it expired, the stock is reserved again under the step's own key
+// and committed; if that fails too the order is cancelled, so an order never
+// stands without its stock taken off hand. If product-service can't be
+// reached the saga stays STARTED for the recovery worker.
+func (s *server) completeSaga(ctx context.Context, saga *models.Saga) error {
+    if step := sagaStep(saga, stepCommitInventory); step != nil && step.Status != models.StepDone {
+        err := s.commitInventory(ctx, saga)
+        if err != nil && isDefiniteFailure(err) && saga.ReservationKey != step.IdempotencyKey {
+            log.Printf("saga %d: failed to commit reservation, reserving again: %v", saga.ID, err)
+            err = s.reserveAgain(ctx, saga, step)
+        }
+        if err != nil {
+            if !isDefiniteFailure(err) {
+                return err
+            }
+            log.Printf("saga %d: stock could not be committed, cancelling order %d: %v", saga.ID, saga.OrderID, err)
+            return s.cancelUnstockedOrder(saga, step, err)
+        }
+        if err := s.setStepStatus(s.db, step, models.StepDone); err != nil {
+            return err
+        }
+    }
+    return s.setSagaStatus(s.db, saga, models.SagaCompleted, nil)
+}
+
+// commitInventory commits the reservation holding the saga's stock
+func (s *server) commitInventory(ctx context.Context, saga *models.Saga) error {
+    req := &productpb.CommitReservationRequest{ReservationKey: saga.ReservationKey}
+    _, err := s.ProductServiceClient.CommitReservation(ctx, req)
+    return err
+}
+
+// reserveAgain replaces a lost hold with a new reservation keyed by the
+// COMMIT_INVENTORY step and commits it. The order's items are moved to the
+// warehouses the new reservation allocated them to. An order cancelled
+// meanwhile is not reserved for again.
+func (s *server) reserveAgain(ctx context.Context, saga *models.Saga, step *models.SagaStep) error {
+    var order models.Order
+    if err := s.db.Preload("Items").First(&order, saga.OrderID).Error; err != nil {
+        return err
+    }
+    if order.Status == models.StatusCancelled {
+        return status.Errorf(codes.FailedPrecondition, "Order with ID '%d' was cancelled", order.ID)
+    }
+    payload, err := decodePayload(saga)
+    if err != nil {
+        return err
+    }
+
+    res, err := s.ProductServiceClient.ReserveInventory(ctx, reservationRequest(payload, step.IdempotencyKey))
+    if err != nil {
+        return err
+    }
+    assignWarehouses(order.Items, res.Reservation.GetItems())
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        for _, item := range order.Items {
+            if err := tx.Model(&item).Update("warehouse_id", item.WarehouseID).Error; err != nil {
+                return err
+            }
+        }
+        return tx.Model(saga).Update("reservation_key", step.IdempotencyKey).Error
+    })
+    if err != nil {
+        return err
+    }
+    saga.ReservationKey = step.IdempotencyKey
+    return s.commitInventory(ctx, saga)
+}
+
+// cancelUnstockedOrder compensates a saga whose stock could not be committed
+// by cancelling its order. Nothing was taken off hand, so there is nothing to
+// restock; the coupon's use is given back, and the recovery worker refunds
+// anything already paid.
+func (s *server) cancelUnstockedOrder(saga *models.Saga, step *models.SagaStep, cause error) error {
+    system := caller{Role: roleAdmin, System: true}
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var order models.Order
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, saga.OrderID).Error; err != nil {
+            return err
+        }
+        if order.Status == models.StatusCancelled {
+            return nil
+        }
+        if err := releasePromotion(tx, order); err != nil {
+            return err
+        }
+        order.Refund = models.RefundPending
+        return transitionOrder(tx, &order, models.StatusCancelled, system, "stock could not be committed")
+    })
+    if err != nil {
+        return err
+    }
+    if err := s.setStepStatus(s.db, step, models.StepCompensated); err != nil {
+        return err
+    }
+    return s.setSagaStatus(s.db, saga, models.SagaCompensated, cause)
+}
+
+// compensateSaga rolls back the completed steps of a create order saga.
+// If a remote call fails the saga is left COMPENSATING for the recovery worker.
+func (s *server) compensateSaga(saga *models.Saga, cause error) error {
+    ctx, cancel := context.WithTimeout(context.Background(), sagaCompensationTimeout)
+    defer cancel()
+
+    if err := s.setSagaStatus(s.db, saga, models.SagaCompensating, cause); err != nil {
+        return err
+    }
+    payload, err := decodePayload(saga)
+    if err != nil {
+        return err
+    }
+
+    step := sagaStep(saga, stepReserveInventory)
+    if step.Status == models.StepStarted {
+        // The outcome of the reservation is unknown. Replaying it with the same
+        // key either returns the existing reservation or fails cleanly, which
+        // tells us whether there is stock to give back.
+        if _, err := s.reserveInventory(ctx, saga, payload); err != nil && !isDefiniteFailure(err) {
+            return err
+        }
+    }
+    if step.Status == models.StepDone {
+        if err := s.releaseInventory(ctx, saga); err != nil {
+            return err
+        }
+    }
+
+    return s.setSagaStatus(s.db, saga, models.SagaCompensated, nil)
+}
+
+// recoverSagas finishes or rolls back create order sagas left unfinished by a crash
+func (s *server) recoverSagas() {
+    var sagas []models.Saga
+    err := s.db.Preload("Steps").
+        Where("type = ? AND status IN ?", models.SagaTypeCreateOrder, []models.SagaStatus{models.SagaStarted, models.SagaCompensating}).
+        Where("updated_at < ?", time.Now().Add(-sagaStaleAfter)).
+        Find(&sagas).Error
+    if err != nil {
+        log.Printf("saga recovery: failed to load sagas: %v", err)
+        return
+    }
+
+    for i := range sagas {
+        saga := &sagas[i]
+        if step := sagaStep(saga, stepCreateOrder); step != nil && step.Status == models.StepDone {
+            // The order was written, so the reservation only needs to be committed
+            ctx, cancel := context.WithTimeout(context.Background(), sagaCompensationTimeout)
+            if err := s.completeSaga(ctx, saga); err != nil {
+                log.Printf("saga %d: failed to complete, will retry: %v", saga.ID, err)
+            }
+            cancel()
+            continue
+        }
+
+        // The order was never written; the caller saw a failure, so roll back
+        cause := saga.LastError
+        if cause == "" {
+            cause = "saga interrupted before completion"
+        }
+        if err := s.compensateSaga(saga, fmt.Errorf("%s", cause)); err != nil {
+            log.Printf("saga %d: compensation failed, will retry: %v", saga.ID, err)
+        }
+    }
+}
+
+// runSagaRecovery periodically recovers unfinished sagas, restocks and
+// refunds of cancelled orders, and restocks of received returns, until the
+// process exits
+func (s *server) runSagaRecovery() {
+    ticker := time.NewTicker(sagaRecoveryInterval)
+    defer ticker.Stop()
+    for {
+        s.recoverSagas()
+        s.restockCancelledOrders()
+        s.refundCancelledOrders()
+        s.restockReturns()
+        <-ticker.C
+    }
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    productpb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+func TestAssignWarehouses(t *testing.T) {
+    tests := []struct {
+        name     string
+        items    []models.OrderItem
+        reserved []*productpb.ReservationItem
+        want     []uint
+    }{
+        {
+            name:  "same order",
+            items: []models.OrderItem{{ProductID: 1}, {ProductID: 2}},
+            reserved: []*productpb.ReservationItem{
+                {ProductId: 1, WarehouseId: 10},
+                {ProductId: 2, WarehouseId: 20},
+            },
+            want: []uint{10, 20},
+        },
+        {
+            name:  "replayed in another order",
+            items: []models.OrderItem{{ProductID: 1}, {ProductID: 2, VariantID: 5}, {ProductID: 2, VariantID: 6}},
+            reserved: []*productpb.ReservationItem{
+                {ProductId: 2, VariantId: 6, WarehouseId: 26},
+                {ProductId: 1, WarehouseId: 10},
+                {ProductId: 2, VariantId: 5, WarehouseId: 25},
+            },
+            want: []uint{10, 25, 26},
+        },
+        {
+            name:  "the same product twice",
+            items: []models.OrderItem{{ProductID: 1}, {ProductID: 1}},
+            reserved: []*productpb.ReservationItem{
+                {ProductId: 1, WarehouseId: 10},
+                {ProductId: 1, WarehouseId: 11},
+            },
+            want: []uint{10, 11},
+        },
+        {
+            name:     "not reserved",
+            items:    []models.OrderItem{{ProductID: 1}},
+            reserved: nil,
+            want:     []uint{0},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            assignWarehouses(tt.items, tt.reserved)
+            for i, item := range tt.items {
+                if item.WarehouseID != tt.want[i] {
+                    t.Errorf("item %d warehouse = %d, want %d", i, item.WarehouseID, tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+// fakeInventory is a product-service keeping reservations in memory, by key
+type fakeInventory struct {
+    productpb.ProductServiceClient
+    reservations map[string]string // Status of each reservation
+    warehouse    int64             // Warehouse new reservations are held at
+    reserveErr   error             // Returned instead of reserving, e.g. when out of stock
+    err          error             // Returned by every call, e.g. when unreachable
+    restocks     map[string]*productpb.UpdateMultipleInventoriesRequest
+}
+
+func newFakeInventory() *fakeInventory {
+    return &fakeInventory{reservations: map[string]string{}, restocks: map[string]*productpb.UpdateMultipleInventoriesRequest{}}
+}
+
+func (f *fakeInventory) ReserveInventory(ctx context.Context, req *productpb.ReserveInventoryRequest, opts ...grpc.CallOption) (*productpb.ReservationResponse, error) {
+    if f.err != nil {
+        return nil, f.err
+    }
+    res := &productpb.Reservation{ReservationKey: req.ReservationKey, Status: f.reservations[req.ReservationKey]}
+    if res.Status == "" {
+        if f.reserveErr != nil {
+            return nil, f.reserveErr
+        }
+        res.Status = "HELD"
+        f.reservations[req.ReservationKey] = res.Status
+    }
+    for _, item := range req.Items {
+        res.Items = append(res.Items, &productpb.ReservationItem{ProductId: item.ProductId, VariantId: item.VariantId, Quantity: item.Quantity, WarehouseId: f.warehouse})
+    }
+    return &productpb.ReservationResponse{Reservation: res}, nil
+}
+
+// settle moves a reservation the way product-service does
+func (f *fakeInventory) settle(key, outcome string) (*productpb.ReservationResponse, error) {
+    if f.err != nil {
+        return nil, f.err
+    }
+    current, ok := f.reservations[key]
+    switch {
+    case !ok:
+        return nil, status.Errorf(codes.NotFound, "Reservation '%s' not found", key)
+    case current == outcome, current == "EXPIRED" && outcome == "RELEASED":
+    case current != "HELD":
+        return nil, status.Errorf(codes.FailedPrecondition, "Reservation '%s' is already %s", key, current)
+    default:
+        f.reservations[key] = outcome
+    }
+    return &productpb.ReservationResponse{Reservation: &productpb.Reservation{ReservationKey: key, Status: f.reservations[key]}}, nil
+}
+
+func (f *fakeInventory) CommitReservation(ctx context.Context, req *productpb.CommitReservationRequest, opts ...grpc.CallOption) (*productpb.ReservationResponse, error) {
+    return f.settle(req.ReservationKey, "COMMITTED")
+}
+
+func (f *fakeInventory) ReleaseReservation(ctx context.Context, req *productpb.ReleaseReservationRequest, opts ...grpc.CallOption) (*productpb.ReservationResponse, error) {
+    return f.settle(req.ReservationKey, "RELEASED")
+}
+
+func (f *fakeInventory) UpdateMultipleInventories(ctx context.Context, req *productpb.UpdateMultipleInventoriesRequest, opts ...grpc.CallOption) (*productpb.InventoriesResponse, error) {
+    if f.err != nil {
+        return nil, f.err
+    }
+    f.restocks[req.IdempotencyKey] = req
+    return &productpb.InventoriesResponse{}, nil
+}
+
+// startTestSaga runs a create order saga up to its COMMIT_INVENTORY step:
+// the stock is held and the PENDING order written
+func startTestSaga(t *testing.T, s *server) (*models.Saga, models.Order) {
+    t.Helper()
+    payload := createOrderPayload{CustomerID: 3, Items: []createOrderItem{{ProductID: 5, Quantity: 2}, {ProductID: 6, VariantID: 9, Quantity: 1}}}
+    saga, err := s.startCreateOrderSaga(payload, "")
+    if err != nil {
+        t.Fatalf("startCreateOrderSaga error = %v", err)
+    }
+    reservation, err := s.reserveInventory(context.Background(), saga, payload)
+    if err != nil {
+        t.Fatalf("reserveInventory error = %v", err)
+    }
+
+    order := models.Order{CustomerID: 3, Status: models.StatusPending, Currency: "USD"}
+    for _, item := range payload.Items {
+        order.Items = append(order.Items, models.OrderItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity, Price: 1000})
+    }
+    assignWarehouses(order.Items, reservation.GetItems())
+    if err := s.db.Create(&order).Error; err != nil {
+        t.Fatalf("failed to create order: %v", err)
+    }
+    if err := s.setStepStatus(s.db, sagaStep(saga, stepCreateOrder), models.StepDone); err != nil {
+        t.Fatalf("setStepStatus error = %v", err)
+    }
+    saga.OrderID = order.ID
+    if err := s.db.Model(saga).Update("order_id", order.ID).Error; err != nil {
+        t.Fatalf("failed to record order: %v", err)
+    }
+    return saga, order
+}
+
+func TestCompleteSaga(t *testing.T) {
+    db := testDB(t)
+    tests := []struct {
+        name       string
+        expire     bool  // The first hold expires before it is committed
+        reserveErr error // Reserving again fails
+        err        error // product-service fails every call
+        wantErr    bool
+        saga       models.SagaStatus
+        order      models.OrderStatus
+        committed  string // Reservation committed, if any
+        warehouse  uint
+    }{
+        {name: "hold committed", saga: models.SagaCompleted, order: models.StatusPending, committed: "RESERVE_INVENTORY", warehouse: 1},
+        {name: "hold expired", expire: true, saga: models.SagaCompleted, order: models.StatusPending, committed: "COMMIT_INVENTORY", warehouse: 2},
+        {name: "hold expired and out of stock", expire: true, reserveErr: status.Errorf(codes.FailedPrecondition, "Insufficient stock"), saga: models.SagaCompensated, order: models.StatusCancelled, warehouse: 1},
+        {name: "product-service unreachable", err: status.Errorf(codes.Unavailable, "connection refused"), wantErr: true, saga: models.SagaStarted, order: models.StatusPending, warehouse: 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            inventory := newFakeInventory()
+            inventory.warehouse = 1
+            s := &server{db: db, ProductServiceClient: inventory}
+            saga, order := startTestSaga(t, s)
+
+            if tt.expire {
+                inventory.reservations[saga.ReservationKey] = "EXPIRED"
+            }
+            inventory.warehouse, inventory.reserveErr, inventory.err = 2, tt.reserveErr, tt.err
+            if err := s.completeSaga(context.Background(), saga); (err != nil) != tt.wantErr {
+                t.Fatalf("completeSaga error = %v, want error %v", err, tt.wantErr)
+            }
+
+            var stored models.Saga
+            db.First(&stored, saga.ID)
+            if stored.Status != tt.saga {
+                t.Errorf("saga status = %s, want %s", stored.Status, tt.saga)
+            }
+            db.Preload("Items").First(&order, order.ID)
+            if order.Status != tt.order {
+                t.Errorf("order status = %s, want %s", order.Status, tt.order)
+            }
+            if order.Restock != models.RestockNone {
+                t.Errorf("order restock = %q, want none as no stock was taken", order.Restock)
+            }
+            for _, item := range order.Items {
+                if item.WarehouseID != tt.warehouse {
+                    t.Errorf("item %d warehouse = %d, want %d", item.ID, item.WarehouseID, tt.warehouse)
+                }
+            }
+            for key, reservation := range inventory.reservations {
+                wantCommitted := tt.committed != "" && key == fmt.Sprintf("order-saga-%d-%s", saga.ID, tt.committed)
+                if (reservation == "COMMITTED") != wantCommitted {
+                    t.Errorf("reservation %s is %s, want committed %v", key, reservation, wantCommitted)
+                }
+            }
+        })
+    }
+}
+
+func TestCompleteSagaOfCancelledOrder(t *testing.T) {
+    db := testDB(t)
+    inventory := newFakeInventory()
+    s := &server{db: db, ProductServiceClient: inventory}
+    saga, order := startTestSaga(t, s)
+
+    // The customer cancelled before the commit, which released the hold
+    inventory.reservations[saga.ReservationKey] = "RELEASED"
+    if err := db.Model(&order).Update("status", models.StatusCancelled).Error; err != nil {
+        t.Fatalf("failed to cancel order: %v", err)
+    }
+
+    if err := s.completeSaga(context.Background(), saga); err != nil {
+        t.Fatalf("completeSaga error = %v", err)
+    }
+    if saga.Status != models.SagaCompensated {
+        t.Errorf("saga status = %s, want %s", saga.Status, models.SagaCompensated)
+    }
+    if len(inventory.reservations) != 1 {
+        t.Errorf("reservations = %v, want no new reservation for a cancelled order", inventory.reservations)
+    }
+}
+
+func TestRecoverSagas(t *testing.T) {
+    db := testDB(t)
+    payload := createOrderPayload{CustomerID: 3, Items: []createOrderItem{{ProductID: 5, Quantity: 1}}}
+    tests := []struct {
+        name        string
+        start       func(t *testing.T, s *server) *models.Saga
+        reserveErr  error // Reserving again fails
+        fresh       bool  // The saga was last touched by a request still running
+        saga        models.SagaStatus
+        reservation string // Status of the saga's reservation afterwards, "" if none was made
+    }{
+        {
+            name: "order written",
+            start: func(t *testing.T, s *server) *models.Saga {
+                saga, _ := startTestSaga(t, s)
+                return saga
+            },
+            saga:        models.SagaCompleted,
+            reservation: "COMMITTED",
+        },
+        {
+            name: "stock held, order never written",
+            start: func(t *testing.T, s *server) *models.Saga {
+                saga, _ := s.startCreateOrderSaga(payload, "")
+                if _, err := s.reserveInventory(context.Background(), saga, payload); err != nil {
+                    t.Fatalf("reserveInventory error = %v", err)
+                }
+                return saga
+            },
+            saga:        models.SagaCompensated,
+            reservation: "RELEASED",
+        },
+        {
+            name: "reservation answer lost",
+            start: func(t *testing.T, s *server) *models.Saga {
+                saga, _ := s.startCreateOrderSaga(payload, "")
+                if _, err := s.reserveInventory(context.Background(), saga, payload); err != nil {
+                    t.Fatalf("reserveInventory error = %v", err)
+                }
+                s.setStepStatus(db, sagaStep(saga, stepReserveInventory), models.StepStarted)
+                return saga
+            },
+            saga:        models.SagaCompensated,
+            reservation: "RELEASED",
+        },
+        {
+            name: "reservation never made",
+            start: func(t *testing.T, s *server) *models.Saga {
+                saga, _ := s.startCreateOrderSaga(payload, "")
+                s.setStepStatus(db, sagaStep(saga, stepReserveInventory), models.StepStarted)
+                return saga
+            },
+            reserveErr: status.Errorf(codes.FailedPrecondition, "Insufficient stock"),
+            saga:       models.SagaCompensated,
+        },
+        {
+            name: "still running",
+            start: func(t *testing.T, s *server) *models.Saga {
+                saga, _ := s.startCreateOrderSaga(payload, "")
+                if _, err := s.reserveInventory(context.Background(), saga, payload); err != nil {
+                    t.Fatalf("reserveInventory error = %v", err)
+                }
+                return saga
+            },
+            fresh:       true,
+            saga:        models.SagaStarted,
+            reservation: "HELD",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            inventory := newFakeInventory()
+            s := &server{db: db, ProductServiceClient: inventory}
+            saga := tt.start(t, s)
+            if !tt.fresh {
+                stale := time.Now().Add(-2 * sagaStaleAfter)
+                if err := db.Model(&models.Saga{}).Where("id = ?", saga.ID).UpdateColumn("updated_at", stale).Error; err != nil {
+                    t.Fatalf("failed to age saga: %v", err)
+                }
+            }
+
+            inventory.reserveErr = tt.reserveErr
+            s.recoverSagas()
+
+            var stored models.Saga
+            db.First(&stored, saga.ID)
+            if stored.Status != tt.saga {
+                t.Errorf("saga status = %s, want %s", stored.Status, tt.saga)
+            }
+            if got := inventory.reservations[saga.ReservationKey]; got != tt.reservation {
+                t.Errorf("reservation = %q, want %q", got, tt.reservation)
+            }
+        })
+    }
+}
+
+func TestSagaStepKey(t *testing.T) {
+    tests := []struct {
+        name string
+        saga models.Saga
+        want string
+    }{
+        {name: "started by a keyed request", saga: models.Saga{Model: gorm.Model{ID: 4}, IdempotencyKey: "cart-9-checkout"}, want: "cart-9-checkout-RESERVE_INVENTORY"},
+        {name: "without a key", saga: models.Saga{Model: gorm.Model{ID: 4}}, want: "order-saga-4-RESERVE_INVENTORY"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := sagaStepKey(&tt.saga, stepReserveInventory); got != tt.want {
+                t.Errorf("sagaStepKey = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestReplayCreateOrder(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db, ProductServiceClient: newFakeInventory()}
+    payload := createOrderPayload{CustomerID: 3, Items: []createOrderItem{{ProductID: 5, Quantity: 1}}}
+    started := func(key string) *models.Saga {
+        saga, err := s.startCreateOrderSaga(payload, key)
+        if err != nil {
+            t.Fatalf("startCreateOrderSaga error = %v", err)
+        }
+        return saga
+    }
+
+    created := started("cart-1-checkout")
+    order := models.Order{CustomerID: 3, Status: models.StatusPending, Currency: "USD"}
+    db.Create(&order)
+    db.Model(created).Update("order_id", order.ID)
+    failed := started("cart-2-checkout")
+    s.setSagaStatus(db, failed, models.SagaCompensated, status.Errorf(codes.FailedPrecondition, "Insufficient stock"))
+    started("cart-3-checkout")
+
+    // A second saga can't be started with a key in use
+    if _, err := s.startCreateOrderSaga(payload, "cart-1-checkout"); err == nil {
+        t.Error("startCreateOrderSaga with a used key succeeded, want the unique index to refuse it")
+    }
+
+    tests := []struct {
+        key     string
+        creator caller
+        code    codes.Code
+        order   uint
+    }{
+        {key: "cart-1-checkout", creator: caller{UserID: 3}, order: order.ID},
+        {key: "cart-1-checkout", creator: caller{UserID: 4}, code: codes.AlreadyExists},
+        {key: "cart-2-checkout", creator: caller{UserID: 3}, code: codes.Aborted},
+        {key: "cart-3-checkout", creator: caller{UserID: 3}, code: codes.Unavailable},
+    }
+    for _, tt := range tests {
+        t.Run(tt.key, func(t *testing.T) {
+            saga, err := s.findKeyedSaga(tt.key)
+            if err != nil || saga == nil {
+                t.Fatalf("findKeyedSaga = %v, %v", saga, err)
+            }
+            res, err := s.replayCreateOrder(saga, tt.creator)
+            if status.Code(err) != tt.code {
+                t.Fatalf("replayCreateOrder error = %v, want %v", err, tt.code)
+            }
+            if err == nil && uint(res.Order.Id) != tt.order {
+                t.Errorf("replayed order %d, want %d", res.Order.Id, tt.order)
+            }
+        })
+    }
+
+    if saga, err := s.findKeyedSaga("cart-4-checkout"); saga != nil || err != nil {
+        t.Errorf("findKeyedSaga of an unused key = %v, %v, want nil", saga, err)
+    }
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "order-service/models"
+)
+
+// trackingPages are the tracking pages of well-known carriers, keyed by the
+// lower-cased carrier name; %s is replaced by the tracking number
+var trackingPages = map[string]string{
+    "ups":   "https://www.ups.com/track?tracknum=%s",
+    "usps":  "https://tools.usps.com/go/TrackConfirmAction?tLabels=%s",
+    "fedex": "https://www.fedex.com/fedextrack/?trknbr=%s",
+    "dhl":   "https://www.dhl.com/en/express/tracking.html?AWB=%s",
+}
+
+// CreateShipment ships items of a confirmed order, by default everything not
+// shipped yet. Once every item has shipped the order moves to SHIPPED.
+func (s *server) CreateShipment(ctx context.Context, req *pb.CreateShipmentRequest) (*pb.ShipmentResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireShipmentAdmin(actor); err != nil {
+        return nil, err
+    }
+    carrier := strings.TrimSpace(req.Carrier)
+    if carrier == "" {
+        return nil, status.Errorf(codes.InvalidArgument, "Carrier is required")
+    }
+    trackingNumber := strings.TrimSpace(req.TrackingNumber)
+    if trackingNumber == "" {
+        return nil, status.Errorf(codes.InvalidArgument, "Tracking number is required")
+    }
+    shippedAt, err := parseTime(req.ShippedAt)
+    if err != nil {
+        return nil, err
+    }
+    if shippedAt == nil {
+        now := time.Now()
+        shippedAt = &now
+    }
+
+    var shipment models.Shipment
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        // The order stays locked so concurrent shipments can't exceed its quantities
+        order, err := lockShippedOrder(tx, req.OrderId)
+        if err != nil {
+            return err
+        }
+        if order.Status != models.StatusConfirmed {
+            return status.Errorf(codes.FailedPrecondition, "Order with ID '%d' cannot be shipped while %s", order.ID, order.Status)
+        }
+
+        var shipments []models.Shipment
+        if err := tx.Preload("Items").Where("order_id = ?", order.ID).Find(&shipments).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving shipments: %v", err)
+        }
+        shipped := shippedQuantities(shipments)
+        items, err := newShipmentItems(order, req.Items, shipped)
+        if err != nil {
+            return err
+        }
+
+        shipment = models.Shipment{
+            OrderID:        order.ID,
+            Carrier:        carrier,
+            TrackingNumber: trackingNumber,
+            Status:         models.ShipmentInTransit,
+            Items:          items,
+            ShippedAt:      *shippedAt,
+        }
+        if err := tx.Create(&shipment).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error creating shipment: %v", err)
+        }
+
+        for _, item := range items {
+            shipped[item.OrderItemID] += item.Quantity
+        }
+        if !fullyShipped(order, shipped) {
+            return nil
+        }
+        return transitionOrder(tx, &order, models.StatusShipped, actor, fmt.Sprintf("Shipment %d", shipment.ID))
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.ShipmentResponse{Shipment: toProtoShipment(shipment)}, nil
+}
+
+// DeliverShipment records a shipment as delivered. Once the whole order has
+// shipped and every shipment arrived the order moves to DELIVERED.
+// Delivering a shipment again leaves it unchanged.
+func (s *server) DeliverShipment(ctx context.Context, req *pb.DeliverShipmentRequest) (*pb.ShipmentResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireShipmentAdmin(actor); err != nil {
+        return nil, err
+    }
+    deliveredAt, err := parseTime(req.DeliveredAt)
+    if err != nil {
+        return nil, err
+    }
+    if deliveredAt == nil {
+        now := time.Now()
+        deliveredAt = &now
+    }
+
+    var shipment models.Shipment
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        order, err := lockShippedOrder(tx, req.OrderId)
+        if err != nil {
+            return err
+        }
+
+        var shipments []models.Shipment
+        if err := tx.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+            Where("order_id = ?", order.ID).Order("id").Find(&shipments).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving shipments: %v", err)
+        }
+        found := false
+        for i := range shipments {
+            if shipments[i].ID != uint(req.Id) {
+                continue
+            }
+            found = true
+            if shipments[i].Status == models.ShipmentDelivered {
+                shipment = shipments[i]
+                return nil
+            }
+            shipments[i].Status = models.ShipmentDelivered
+            shipments[i].DeliveredAt = deliveredAt
+            if err := tx.Omit(clause.Associations).Save(&shipments[i]).Error; err != nil {
+                return status.Errorf(codes.Internal, "Error updating shipment: %v", err)
+            }
+            shipment = shipments[i]
+        }
+        if !found {
+            return status.Errorf(codes.NotFound, "Shipment with ID '%d' not found", req.Id)
+        }
+
+        // A partially shipped order is still CONFIRMED and waits for the rest
+        if order.Status != models.StatusShipped || !allDelivered(shipments) {
+            return nil
+        }
+        return transitionOrder(tx, &order, models.StatusDelivered, actor, "All shipments delivered")
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.ShipmentResponse{Shipment: toProtoShipment(shipment)}, nil
+}
+
+// ListShipments is the tracking view of an order: its shipments, oldest first,
+// with where each one is
+func (s *server) ListShipments(ctx context.Context, req *pb.ListShipmentsRequest) (*pb.ListShipmentsResponse, error) {
+    var order models.Order
+    if err := s.db.Select("id", "customer_id", "status").First(&order, req.OrderId).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Order with ID '%d' not found", req.OrderId)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+    }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := authorizeOrder(actor, order); err != nil {
+        return nil, err
+    }
+
+    var shipments []models.Shipment
+    if err := s.db.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+        Where("order_id = ?", order.ID).Order("id").Find(&shipments).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving shipments: %v", err)
+    }
+    res := &pb.ListShipmentsResponse{OrderStatus: mapOrderStatusToProto(order.Status)}
+    for _, shipment := range shipments {
+        res.Shipments = append(res.Shipments, toProtoShipment(shipment))
+    }
+    return res, nil
+}
+
+// shippedQuantities sums, per order item, what the shipments have sent
+func shippedQuantities(shipments []models.Shipment) map[uint]int {
+    shipped := make(map[uint]int)
+    for _, shipment := range shipments {
+        for _, item := range shipment.Items {
+            shipped[item.OrderItemID] += item.Quantity
+        }
+    }
+    return shipped
+}
+
+// newShipmentItems validates the items to ship against the order and what was
+// already shipped. Without requested items everything left is shipped.
+func newShipmentItems(order models.Order, requested []*pb.ShipmentItem, shipped map[uint]int) ([]models.ShipmentItem, error) {
+    var items []models.ShipmentItem
+    if len(requested) == 0 {
+        for _, orderItem := range order.Items {
+            if left := orderItem.Quantity - shipped[orderItem.ID]; left > 0 {
+                items = append(items, newShipmentItem(orderItem, left))
+            }
+        }
+        if len(items) == 0 {
+            return nil, status.Errorf(codes.FailedPrecondition, "Order with ID '%d' has no items left to ship", order.ID)
+        }
+        return items, nil
+    }
+
+    orderItems := make(map[uint]models.OrderItem, len(order.Items))
+    for _, item := range order.Items {
+        orderItems[item.ID] = item
+    }
+    seen := make(map[uint]bool)
+    for _, req := range requested {
+        orderItem, ok := orderItems[uint(req.OrderItemId)]
+        if !ok {
+            return nil, status.Errorf(codes.InvalidArgument, "Order item with ID '%d' is not part of order '%d'", req.OrderItemId, order.ID)
+        }
+        if seen[orderItem.ID] {
+            return nil, status.Errorf(codes.InvalidArgument, "Order item with ID '%d' is listed twice", req.OrderItemId)
+        }
+        seen[orderItem.ID] = true
+        if req.Quantity <= 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Quantity must be positive")
+        }
+        if left := orderItem.Quantity - shipped[orderItem.ID]; int(req.Quantity) > left {
+            return nil, status.Errorf(codes.FailedPrecondition, "Only %d of order item '%d' are left to ship", left, orderItem.ID)
+        }
+        items = append(items, newShipmentItem(orderItem, int(req.Quantity)))
+    }
+    return items, nil
+}
+
+// newShipmentItem ships a quantity of an order item
+func newShipmentItem(orderItem models.OrderItem, quantity int) models.ShipmentItem {
+    return models.ShipmentItem{
+        OrderItemID: orderItem.ID,
+        ProductID:   orderItem.ProductID,
+        VariantID:   orderItem.VariantID,
+        Quantity:    quantity,
+    }
+}
+
+// fullyShipped reports whether every item of the order has shipped in full
+func fullyShipped(order models.Order, shipped map[uint]int) bool {
+    for _, item := range order.Items {
+        if shipped[item.ID] < item.Quantity {
+            return false
+        }
+    }
+    return true
+}
+
+// allDelivered reports whether every shipment has arrived
+func allDelivered(shipments []models.Shipment) bool {
+    for _, shipment := range shipments {
+        if shipment.Status != models.ShipmentDelivered {
+            return false
+        }
+    }
+    return true
+}
+
+// trackingURL links to the carrier's tracking page, if the carrier is known
+func trackingURL(carrier, trackingNumber string) string {
+    page, ok := trackingPages[strings.ToLower(carrier)]
+    if !ok {
+        return ""
+    }
+    return fmt.Sprintf(page, url.QueryEscape(trackingNumber))
+}
+
+// requireShipmentAdmin checks the caller may ship orders
+func requireShipmentAdmin(actor caller) error {
+    if !actor.IsAdmin() {
+        return status.Errorf(codes.PermissionDenied, "Only admins can ship orders")
+    }
+    return nil
+}
+
+// lockShippedOrder loads an order with its items, locking it so shipments of
+// the order are serialized
+func lockShippedOrder(tx *gorm.DB, orderID int64) (models.Order, error) {
+    var order models.Order
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items.TaxLines").First(&order, orderID).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return order, status.Errorf(codes.NotFound, "Order with ID '%d' not found", orderID)
+        }
+        return order, status.Errorf(codes.Internal, "Error retrieving order: %v", err)
+    }
+    return order, nil
+}
+
+// toProtoShipment converts a shipment to protobuf
+func toProtoShipment(shipment models.Shipment) *pb.Shipment {
+    res := &pb.Shipment{
+        Id:             int64(shipment.ID),
+        OrderId:        int64(shipment.OrderID),
+        Carrier:        shipment.Carrier,
+        TrackingNumber: shipment.TrackingNumber,
+        TrackingUrl:    trackingURL(shipment.Carrier, shipment.TrackingNumber),
+        Status:         pb.ShipmentStatus_IN_TRANSIT,
+        ShippedAt:      shipment.ShippedAt.Format(time.RFC3339),
+    }
+    if shipment.Status == models.ShipmentDelivered {
+        res.Status = pb.ShipmentStatus_DELIVERED_SHIPMENT
+    }
+    if shipment.DeliveredAt != nil {
+        res.DeliveredAt = shipment.DeliveredAt.Format(time.RFC3339)
+    }
+    for _, item := range shipment.Items {
+        res.Items = append(res.Items, &pb.ShipmentItem{
+            OrderItemId: int64(item.OrderItemID),
+            ProductId:   int64(item.ProductID),
+            VariantId:   int64(item.VariantID),
+            Quantity:    int32(item.Quantity),
+        })
+    }
+    return res
+}
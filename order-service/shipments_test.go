@@ -0,0 +1,180 @@
+package main
+
+import (
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+)
+
+func TestNewShipmentItems(t *testing.T) {
+    order := testOrder()
+
+    // Without items everything left is shipped
+    items, err := newShipmentItems(order, nil, map[uint]int{11: 1})
+    if err != nil {
+        t.Fatalf("newShipmentItems error = %v", err)
+    }
+    if len(items) != 2 || items[0].Quantity != 2 || items[1].VariantID != 9 || items[1].Quantity != 1 {
+        t.Errorf("items = %+v, want the 2 left of item 11 and item 12", items)
+    }
+
+    items, err = newShipmentItems(order, []*pb.ShipmentItem{{OrderItemId: 11, Quantity: 2}}, nil)
+    if err != nil {
+        t.Fatalf("newShipmentItems error = %v", err)
+    }
+    if len(items) != 1 || items[0].ProductID != 5 || items[0].Quantity != 2 {
+        t.Errorf("items = %+v, want 2 of product 5", items)
+    }
+
+    tests := []struct {
+        name    string
+        items   []*pb.ShipmentItem
+        shipped map[uint]int
+        code    codes.Code
+    }{
+        {name: "nothing left", shipped: map[uint]int{11: 3, 12: 1}, code: codes.FailedPrecondition},
+        {name: "item of another order", items: []*pb.ShipmentItem{{OrderItemId: 99, Quantity: 1}}, code: codes.InvalidArgument},
+        {name: "item listed twice", items: []*pb.ShipmentItem{{OrderItemId: 12, Quantity: 1}, {OrderItemId: 12, Quantity: 1}}, code: codes.InvalidArgument},
+        {name: "no quantity", items: []*pb.ShipmentItem{{OrderItemId: 12}}, code: codes.InvalidArgument},
+        {name: "more than ordered", items: []*pb.ShipmentItem{{OrderItemId: 11, Quantity: 4}}, code: codes.FailedPrecondition},
+        {name: "already shipped", items: []*pb.ShipmentItem{{OrderItemId: 11, Quantity: 2}}, shipped: map[uint]int{11: 2}, code: codes.FailedPrecondition},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := newShipmentItems(order, tt.items, tt.shipped); status.Code(err) != tt.code {
+                t.Errorf("newShipmentItems error = %v, want %v", err, tt.code)
+            }
+        })
+    }
+}
+
+func TestFullyShipped(t *testing.T) {
+    order := testOrder()
+    shipments := []models.Shipment{
+        {Items: []models.ShipmentItem{{OrderItemID: 11, Quantity: 1}, {OrderItemID: 12, Quantity: 1}}},
+        {Items: []models.ShipmentItem{{OrderItemID: 11, Quantity: 1}}},
+    }
+    shipped := shippedQuantities(shipments)
+    if shipped[11] != 2 || fullyShipped(order, shipped) {
+        t.Errorf("shipped = %v, want 2 of item 11 and the order not fully shipped", shipped)
+    }
+    shipped[11]++
+    if !fullyShipped(order, shipped) {
+        t.Errorf("order not fully shipped with %v", shipped)
+    }
+}
+
+func TestAllDelivered(t *testing.T) {
+    shipments := []models.Shipment{{Status: models.ShipmentDelivered}, {Status: models.ShipmentInTransit}}
+    if allDelivered(shipments) {
+        t.Errorf("allDelivered with a shipment in transit")
+    }
+    shipments[1].Status = models.ShipmentDelivered
+    if !allDelivered(shipments) {
+        t.Errorf("allDelivered = false, want true")
+    }
+}
+
+func TestTrackingURL(t *testing.T) {
+    if got, want := trackingURL("UPS", "1Z 999"), "https://www.ups.com/track?tracknum=1Z+999"; got != want {
+        t.Errorf("trackingURL = %q, want %q", got, want)
+    }
+    if got := trackingURL("Local courier", "42"); got != "" {
+        t.Errorf("trackingURL of an unknown carrier = %q, want none", got)
+    }
+}
+
+func TestUpdateOrderLeavesShippingToShipments(t *testing.T) {
+    s := &server{}
+    for _, next := range []pb.OrderStatus{pb.OrderStatus_SHIPPED, pb.OrderStatus_DELIVERED} {
+        _, err := s.UpdateOrder(adminContext(t), &pb.UpdateOrderRequest{OrderId: 1, Status: next})
+        if status.Code(err) != codes.FailedPrecondition {
+            t.Errorf("UpdateOrder to %s error = %v, want FailedPrecondition", next, err)
+        }
+    }
+}
+
+func TestShipmentsMoveOrder(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    admin := adminContext(t)
+    order := createTestOrder(t, db, models.StatusConfirmed)
+    orderID := int64(order.ID)
+    orderStatus := func() models.OrderStatus {
+        var current models.Order
+        db.First(&current, order.ID)
+        return current.Status
+    }
+
+    // Part of the item of product 5, picked by its ID in the order, ships and
+    // arrives; the rest is still to go
+    res, err := s.GetOrder(admin, &pb.GetOrderRequest{OrderId: orderID})
+    if err != nil {
+        t.Fatalf("GetOrder error = %v", err)
+    }
+    var itemID int64
+    for _, item := range res.Order.Items {
+        if item.ProductId == 5 {
+            itemID = item.Id
+        }
+    }
+    first, err := s.CreateShipment(admin, &pb.CreateShipmentRequest{
+        OrderId:        orderID,
+        Carrier:        "UPS",
+        TrackingNumber: "1Z1",
+        Items:          []*pb.ShipmentItem{{OrderItemId: itemID, Quantity: 2}},
+    })
+    if err != nil {
+        t.Fatalf("CreateShipment error = %v", err)
+    }
+    if items := first.Shipment.Items; len(items) != 1 || items[0].OrderItemId != int64(order.Items[0].ID) || items[0].Quantity != 2 {
+        t.Errorf("first shipment items = %v, want 2 of order item %d", items, order.Items[0].ID)
+    }
+    if _, err := s.DeliverShipment(admin, &pb.DeliverShipmentRequest{OrderId: orderID, Id: first.Shipment.Id}); err != nil {
+        t.Fatalf("DeliverShipment error = %v", err)
+    }
+    if got := orderStatus(); got != models.StatusConfirmed {
+        t.Errorf("partly shipped order is %s, want CONFIRMED", got)
+    }
+
+    // The rest ships by default
+    second, err := s.CreateShipment(admin, &pb.CreateShipmentRequest{OrderId: orderID, Carrier: "UPS", TrackingNumber: "1Z2"})
+    if err != nil {
+        t.Fatalf("CreateShipment error = %v", err)
+    }
+    if len(second.Shipment.Items) != 2 || orderStatus() != models.StatusShipped {
+        t.Errorf("second shipment = %v with the order %s, want the rest of both items and SHIPPED", second.Shipment.Items, orderStatus())
+    }
+    if _, err := s.CreateShipment(admin, &pb.CreateShipmentRequest{OrderId: orderID, Carrier: "UPS", TrackingNumber: "1Z3"}); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("CreateShipment of a shipped order error = %v, want FailedPrecondition", err)
+    }
+
+    for i := 0; i < 2; i++ {
+        // Delivering again changes nothing
+        if _, err := s.DeliverShipment(admin, &pb.DeliverShipmentRequest{OrderId: orderID, Id: second.Shipment.Id}); err != nil {
+            t.Fatalf("DeliverShipment error = %v", err)
+        }
+    }
+    if got := orderStatus(); got != models.StatusDelivered {
+        t.Errorf("order is %s once every shipment arrived, want DELIVERED", got)
+    }
+
+    var history []models.OrderStatusHistory
+    db.Where("order_id = ?", order.ID).Order("id").Find(&history)
+    if len(history) != 2 || history[0].ToStatus != models.StatusShipped || history[1].ToStatus != models.StatusDelivered {
+        t.Errorf("history = %+v, want SHIPPED then DELIVERED", history)
+    }
+}
+
+func TestCreateShipmentOfUnconfirmedOrder(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    order := createTestOrder(t, db, models.StatusPending)
+    _, err := s.CreateShipment(adminContext(t), &pb.CreateShipmentRequest{OrderId: int64(order.ID), Carrier: "UPS", TrackingNumber: "1Z1"})
+    if status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("CreateShipment error = %v, want FailedPrecondition", err)
+    }
+}
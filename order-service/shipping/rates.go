@@ -0,0 +1,132 @@
+// Package shipping works out what the configured shipping methods charge
+// for an order
+package shipping
+
+import (
+    "sort"
+    "strings"
+
+    "order-service/models"
+)
+
+// DimensionalDivisor turns a packed volume in cubic millimetres into a
+// dimensional weight in grams: the usual 5000 cubic centimetres per kilogram
+const DimensionalDivisor = 5000
+
+// Item is an order line to ship
+type Item struct {
+    Weight   int // Grams per unit
+    Length   int // Millimetres per unit, 0 when unknown
+    Width    int
+    Height   int
+    Quantity int
+}
+
+// Parcel is what an order ships and where to
+type Parcel struct {
+    Country  string // ISO 3166-1 alpha-2 code, empty when the order has no destination
+    Currency string
+    Weight   int   // Billable weight in grams
+    Subtotal int64 // After discounts, for free shipping thresholds
+}
+
+// Quote is what a shipping method charges for a parcel
+type Quote struct {
+    Method models.ShippingMethod
+    Amount int64
+}
+
+// BillableWeight sums the weight charged for the items: for each unit its
+// weight, or its dimensional weight when that is more, as carriers bill bulky
+// light parcels
+func BillableWeight(items []Item) int {
+    total := 0
+    for _, item := range items {
+        weight := item.Weight
+        if dimensional := item.Length * item.Width * item.Height / DimensionalDivisor; dimensional > weight {
+            weight = dimensional
+        }
+        total += weight * item.Quantity
+    }
+    return total
+}
+
+// Countries splits the country list of a rate's zone
+func Countries(zone string) []string {
+    if zone == "" {
+        return nil
+    }
+    return strings.Split(zone, ",")
+}
+
+// ZoneRates returns the rates of the zone a country falls in: the rates
+// listing the country, or else the rates for everywhere else
+func ZoneRates(rates []models.ShippingRate, country string) []models.ShippingRate {
+    var listed, rest []models.ShippingRate
+    for _, rate := range rates {
+        countries := Countries(rate.Countries)
+        if len(countries) == 0 {
+            rest = append(rest, rate)
+            continue
+        }
+        for _, c := range countries {
+            if country != "" && c == country {
+                listed = append(listed, rate)
+                break
+            }
+        }
+    }
+    if len(listed) > 0 {
+        return listed
+    }
+    return rest
+}
+
+// Rate works out what a method charges for a parcel. It reports false when
+// the method doesn't ship the parcel: it is inactive, in another currency,
+// has no rate for the destination or, for weight tables, none for the weight.
+func Rate(method models.ShippingMethod, parcel Parcel) (int64, bool) {
+    if !method.Active || method.Currency != parcel.Currency {
+        return 0, false
+    }
+    rates := ZoneRates(method.Rates, parcel.Country)
+    if len(rates) == 0 {
+        return 0, false
+    }
+
+    amount := rates[0].Amount
+    if method.Type == models.ShippingWeightTable {
+        // The smallest bracket the weight fits in, brackets without a limit last
+        sort.SliceStable(rates, func(i, j int) bool {
+            a, b := rates[i].MaxWeight, rates[j].MaxWeight
+            return a != 0 && (b == 0 || a < b)
+        })
+        found := false
+        for _, rate := range rates {
+            if rate.MaxWeight == 0 || parcel.Weight <= rate.MaxWeight {
+                amount, found = rate.Amount, true
+                break
+            }
+        }
+        if !found {
+            return 0, false
+        }
+    }
+
+    if method.FreeAbove > 0 && parcel.Subtotal >= method.FreeAbove {
+        return 0, true
+    }
+    return amount, true
+}
+
+// Quotes returns what each method that ships the parcel charges, cheapest first
+func Quotes(methods []models.ShippingMethod, parcel Parcel) []Quote {
+    var quotes []Quote
+    for _, method := range methods {
+        if amount, ok := Rate(method, parcel); ok {
+            quotes = append(quotes, Quote{Method: method, Amount: amount})
+        }
+    }
+    sort.SliceStable(quotes, func(i, j int) bool { return quotes[i].Amount < quotes[j].Amount })
+    return quotes
+}
@@ -0,0 +1,98 @@
+package shipping
+
+import (
+    "testing"
+
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+func TestBillableWeight(t *testing.T) {
+    items := []Item{
+        {Weight: 500, Quantity: 2},                                          // 1000 g
+        {Weight: 200, Length: 400, Width: 300, Height: 200, Quantity: 1},    // 24000 cm³ is billed as 4800 g
+        {Weight: 3000, Length: 100, Width: 100, Height: 100, Quantity: 1},   // Heavier than its 200 g size
+    }
+    if got := BillableWeight(items); got != 8800 {
+        t.Errorf("BillableWeight = %d, want 8800", got)
+    }
+}
+
+func TestZoneRates(t *testing.T) {
+    rates := []models.ShippingRate{
+        {ID: 1, Countries: "US,CA", Amount: 500},
+        {ID: 2, Amount: 2500},
+    }
+    tests := []struct {
+        country string
+        want    uint
+    }{
+        {country: "CA", want: 1},
+        {country: "DE", want: 2},
+        {country: "", want: 2},
+    }
+    for _, tt := range tests {
+        if got := ZoneRates(rates, tt.country); len(got) != 1 || got[0].ID != tt.want {
+            t.Errorf("ZoneRates(%q) = %+v, want rate %d", tt.country, got, tt.want)
+        }
+    }
+    if got := ZoneRates(rates[:1], "DE"); len(got) != 0 {
+        t.Errorf("ZoneRates outside every zone = %+v, want none", got)
+    }
+}
+
+func TestRate(t *testing.T) {
+    flat := models.ShippingMethod{
+        Type: models.ShippingFlatRate, Currency: "USD", Active: true, FreeAbove: 10000,
+        Rates: []models.ShippingRate{{Countries: "US", Amount: 599}},
+    }
+    table := models.ShippingMethod{
+        Type: models.ShippingWeightTable, Currency: "USD", Active: true,
+        Rates: []models.ShippingRate{
+            {Countries: "US", Amount: 1500},                 // Any weight
+            {Countries: "US", MaxWeight: 1000, Amount: 700},
+            {Countries: "US", MaxWeight: 5000, Amount: 1100},
+            {MaxWeight: 2000, Amount: 3000},                 // Everywhere else, light parcels only
+        },
+    }
+    inactive := flat
+    inactive.Active = false
+
+    tests := []struct {
+        name   string
+        method models.ShippingMethod
+        parcel Parcel
+        want   int64
+        ok     bool
+    }{
+        {name: "flat rate", method: flat, parcel: Parcel{Country: "US", Currency: "USD", Subtotal: 9999}, want: 599, ok: true},
+        {name: "free above the threshold", method: flat, parcel: Parcel{Country: "US", Currency: "USD", Subtotal: 10000}, want: 0, ok: true},
+        {name: "outside the zones", method: flat, parcel: Parcel{Country: "DE", Currency: "USD"}, ok: false},
+        {name: "other currency", method: flat, parcel: Parcel{Country: "US", Currency: "EUR"}, ok: false},
+        {name: "inactive", method: inactive, parcel: Parcel{Country: "US", Currency: "USD"}, ok: false},
+        {name: "lightest bracket", method: table, parcel: Parcel{Country: "US", Currency: "USD", Weight: 1000}, want: 700, ok: true},
+        {name: "next bracket", method: table, parcel: Parcel{Country: "US", Currency: "USD", Weight: 1001}, want: 1100, ok: true},
+        {name: "bracket without a limit", method: table, parcel: Parcel{Country: "US", Currency: "USD", Weight: 20000}, want: 1500, ok: true},
+        {name: "rest of the world", method: table, parcel: Parcel{Country: "DE", Currency: "USD", Weight: 2000}, want: 3000, ok: true},
+        {name: "too heavy for the zone", method: table, parcel: Parcel{Country: "DE", Currency: "USD", Weight: 2001}, ok: false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, ok := Rate(tt.method, tt.parcel)
+            if got != tt.want || ok != tt.ok {
+                t.Errorf("Rate = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+            }
+        })
+    }
+}
+
+func TestQuotes(t *testing.T) {
+    express := models.ShippingMethod{Model: gorm.Model{ID: 1}, Type: models.ShippingFlatRate, Currency: "USD", Active: true, Rates: []models.ShippingRate{{Amount: 1500}}}
+    standard := models.ShippingMethod{Model: gorm.Model{ID: 2}, Type: models.ShippingFlatRate, Currency: "USD", Active: true, Rates: []models.ShippingRate{{Amount: 500}}}
+    local := models.ShippingMethod{Model: gorm.Model{ID: 3}, Type: models.ShippingFlatRate, Currency: "USD", Active: true, Rates: []models.ShippingRate{{Countries: "US", Amount: 100}}}
+
+    quotes := Quotes([]models.ShippingMethod{express, standard, local}, Parcel{Country: "CA", Currency: "USD"})
+    if len(quotes) != 2 || quotes[0].Method.ID != 2 || quotes[1].Method.ID != 1 {
+        t.Errorf("Quotes = %+v, want standard then express", quotes)
+    }
+}
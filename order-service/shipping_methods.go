@@ -0,0 +1,323 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "gorm.io/gorm"
+    "order-service/models"
+    "order-service/shipping"
+)
+
+func (s *server) CreateShippingMethod(ctx context.Context, req *pb.CreateShippingMethodRequest) (*pb.ShippingMethodResponse, error) {
+    method, err := newShippingMethod(req.ShippingMethod)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.db.Create(&method).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error creating shipping method: %v", err)
+    }
+    return &pb.ShippingMethodResponse{ShippingMethod: toProtoShippingMethod(method)}, nil
+}
+
+func (s *server) UpdateShippingMethod(ctx context.Context, req *pb.UpdateShippingMethodRequest) (*pb.ShippingMethodResponse, error) {
+    update, err := newShippingMethod(req.ShippingMethod)
+    if err != nil {
+        return nil, err
+    }
+    method, err := findShippingMethod(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+
+    // Orders keep the method's name and the cost they were charged, so
+    // changing a method only affects new orders
+    update.Model = method.Model
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("shipping_method_id = ?", method.ID).Delete(&models.ShippingRate{}).Error; err != nil {
+            return err
+        }
+        return tx.Save(&update).Error
+    })
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error updating shipping method: %v", err)
+    }
+    return &pb.ShippingMethodResponse{ShippingMethod: toProtoShippingMethod(update)}, nil
+}
+
+func (s *server) DeleteShippingMethod(ctx context.Context, req *pb.DeleteShippingMethodRequest) (*pb.DeleteShippingMethodResponse, error) {
+    // Methods are soft deleted, so orders keep pointing at what they shipped with
+    method, err := findShippingMethod(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.db.Delete(method).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error deleting shipping method: %v", err)
+    }
+    return &pb.DeleteShippingMethodResponse{Success: true}, nil
+}
+
+func (s *server) ListShippingMethods(ctx context.Context, req *pb.ListShippingMethodsRequest) (*pb.ListShippingMethodsResponse, error) {
+    var methods []models.ShippingMethod
+    if err := preloadRates(s.db).Order("name, id").Find(&methods).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving shipping methods: %v", err)
+    }
+    res := &pb.ListShippingMethodsResponse{}
+    for _, method := range methods {
+        res.ShippingMethods = append(res.ShippingMethods, toProtoShippingMethod(method))
+    }
+    return res, nil
+}
+
+// QuoteShipping prices items with every shipping method that ships them to
+// the destination, the way CreateOrder charges them
+func (s *server) QuoteShipping(ctx context.Context, req *pb.QuoteShippingRequest) (*pb.QuoteShippingResponse, error) {
+    if len(req.Items) == 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "Items are required")
+    }
+    shipTo, _, err := s.orderAddresses(ctx, req.CustomerId, req.ShippingAddressId, 0, req.Destination)
+    if err != nil {
+        return nil, err
+    }
+    destination, err := destinationFromProto(addressDestination(shipTo, req.Destination))
+    if err != nil {
+        return nil, err
+    }
+    pricing, err := s.fetchUnitPrices(ctx, req.Items, req.Currency, customerGroupFromContext(ctx))
+    if err != nil {
+        return nil, err
+    }
+
+    // The coupon's discount counts towards free shipping thresholds
+    order := models.Order{CustomerID: uint(req.CustomerId), Currency: pricing.Currency, Items: newOrderItems(req.Items, pricing), DestinationCountry: destination.Country}
+    if normalizeCouponCode(req.CouponCode) != "" {
+        promotion, discount, err := promotionForOrder(s.db, req.CouponCode, order.CustomerID, pricing, order.Items)
+        if err != nil {
+            return nil, err
+        }
+        applyDiscount(&order, promotion, discount)
+    }
+
+    methods, err := activeShippingMethods(s.db)
+    if err != nil {
+        return nil, err
+    }
+    parcel := newParcel(order, pricing)
+    res := &pb.QuoteShippingResponse{Weight: int32(parcel.Weight), FreeShipping: order.FreeShipping}
+    for _, quote := range shipping.Quotes(methods, parcel) {
+        amount := quote.Amount
+        if order.FreeShipping {
+            amount = 0
+        }
+        res.Quotes = append(res.Quotes, &pb.ShippingQuote{
+            ShippingMethodId: int64(quote.Method.ID),
+            Name:             quote.Method.Name,
+            Type:             pb.ShippingMethodType(pb.ShippingMethodType_value[string(quote.Method.Type)]),
+            Amount:           toProtoMoney(order.Money(amount)),
+        })
+    }
+    return res, nil
+}
+
+// applyShipping charges an order for shipping with the requested method, or
+// the cheapest that ships it. While no shipping method is configured orders
+// ship free; once one is, an order no method ships can't be placed. A free
+// shipping promotion waives the charge but the order keeps its method.
+func (s *server) applyShipping(order *models.Order, pricing orderPricing, methodID int64) error {
+    methods, err := activeShippingMethods(s.db)
+    if err != nil {
+        return err
+    }
+    if len(methods) == 0 {
+        if methodID != 0 {
+            return status.Errorf(codes.NotFound, "Shipping method with ID '%d' not found", methodID)
+        }
+        return nil
+    }
+
+    quotes := shipping.Quotes(methods, newParcel(*order, pricing))
+    var chosen *shipping.Quote
+    for i := range quotes {
+        if methodID == 0 || quotes[i].Method.ID == uint(methodID) {
+            chosen = &quotes[i]
+            break
+        }
+    }
+    if chosen == nil {
+        if methodID == 0 {
+            return status.Errorf(codes.FailedPrecondition, "No shipping method ships this order")
+        }
+        for _, method := range methods {
+            if method.ID == uint(methodID) {
+                return status.Errorf(codes.FailedPrecondition, "Shipping method with ID '%d' doesn't ship this order", methodID)
+            }
+        }
+        return status.Errorf(codes.NotFound, "Shipping method with ID '%d' not found", methodID)
+    }
+
+    order.ShippingMethodID = chosen.Method.ID
+    order.ShippingMethodName = chosen.Method.Name
+    order.Shipping = chosen.Amount
+    if order.FreeShipping {
+        order.Shipping = 0
+    }
+    return nil
+}
+
+// newParcel describes what an order ships: where to, its billable weight and
+// its subtotal after discounts
+func newParcel(order models.Order, pricing orderPricing) shipping.Parcel {
+    parcel := shipping.Parcel{Country: order.DestinationCountry, Currency: order.Currency}
+    items := make([]shipping.Item, 0, len(order.Items))
+    for _, item := range order.Items {
+        size := pricing.Sizes[item.ProductID]
+        size.Quantity = item.Quantity
+        items = append(items, size)
+        parcel.Subtotal += item.Price*int64(item.Quantity) - item.Discount
+    }
+    parcel.Weight = shipping.BillableWeight(items)
+    return parcel
+}
+
+// activeShippingMethods loads the methods orders can ship with, with their rates
+func activeShippingMethods(db *gorm.DB) ([]models.ShippingMethod, error) {
+    var methods []models.ShippingMethod
+    if err := preloadRates(db).Where("active = ?", true).Order("id").Find(&methods).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving shipping methods: %v", err)
+    }
+    return methods, nil
+}
+
+// newShippingMethod validates a shipping method being saved
+func newShippingMethod(req *pb.ShippingMethod) (models.ShippingMethod, error) {
+    if req == nil {
+        return models.ShippingMethod{}, status.Errorf(codes.InvalidArgument, "Shipping method is required")
+    }
+    method := models.ShippingMethod{
+        Name:   strings.TrimSpace(req.Name),
+        Active: req.Active,
+    }
+    if method.Name == "" {
+        return method, status.Errorf(codes.InvalidArgument, "Shipping method name is required")
+    }
+    switch req.Type {
+    case pb.ShippingMethodType_FLAT_RATE:
+        method.Type = models.ShippingFlatRate
+    case pb.ShippingMethodType_WEIGHT_TABLE:
+        method.Type = models.ShippingWeightTable
+    default:
+        return method, status.Errorf(codes.InvalidArgument, "Invalid shipping method type %d", req.Type)
+    }
+
+    currency := req.Currency
+    if currency == "" {
+        currency = money.StoreCurrency()
+    }
+    normalized, err := money.Normalize(currency)
+    if err != nil {
+        return method, status.Errorf(codes.InvalidArgument, "Unknown currency '%s'", currency)
+    }
+    method.Currency = normalized
+    if method.FreeAbove, err = shippingAmount(method, req.FreeAbove); err != nil {
+        return method, err
+    }
+
+    if len(req.Rates) == 0 {
+        return method, status.Errorf(codes.InvalidArgument, "At least one rate is required")
+    }
+    // A flat rate has one rate per zone; a weight table one per bracket and zone
+    brackets := make(map[string]bool, len(req.Rates))
+    for _, rate := range req.Rates {
+        var countries []string
+        for _, country := range rate.Countries {
+            country = strings.ToUpper(strings.TrimSpace(country))
+            if !countryPattern.MatchString(country) {
+                return method, status.Errorf(codes.InvalidArgument, "Invalid country '%s'", country)
+            }
+            countries = append(countries, country)
+        }
+        if rate.MaxWeight < 0 {
+            return method, status.Errorf(codes.InvalidArgument, "maxWeight must not be negative")
+        }
+        if method.Type == models.ShippingFlatRate && rate.MaxWeight != 0 {
+            return method, status.Errorf(codes.InvalidArgument, "Flat rates don't have a maxWeight")
+        }
+        zone := strings.Join(countries, ",")
+        keys := []string{fmt.Sprintf("*/%d", rate.MaxWeight)} // Everywhere else
+        if len(countries) > 0 {
+            keys = keys[:0]
+            for _, country := range countries {
+                keys = append(keys, fmt.Sprintf("%s/%d", country, rate.MaxWeight))
+            }
+        }
+        for _, key := range keys {
+            if brackets[key] {
+                return method, status.Errorf(codes.InvalidArgument, "More than one rate for %s up to %d g", strings.Split(key, "/")[0], rate.MaxWeight)
+            }
+            brackets[key] = true
+        }
+        amount, err := shippingAmount(method, rate.Amount)
+        if err != nil {
+            return method, err
+        }
+        method.Rates = append(method.Rates, models.ShippingRate{Countries: zone, MaxWeight: int(rate.MaxWeight), Amount: amount})
+    }
+    return method, nil
+}
+
+// shippingAmount reads an amount of a shipping method, which must not be
+// negative and is in the method's currency unless it names none
+func shippingAmount(method models.ShippingMethod, amount *pb.Money) (int64, error) {
+    if amount.GetAmount() < 0 {
+        return 0, status.Errorf(codes.InvalidArgument, "Shipping amounts can't be negative")
+    }
+    if amount.GetCurrency() != "" && !strings.EqualFold(amount.GetCurrency(), method.Currency) {
+        return 0, status.Errorf(codes.InvalidArgument, "Shipping amounts must be in %s, the currency of the method", method.Currency)
+    }
+    return amount.GetAmount(), nil
+}
+
+// preloadRates loads a shipping method's rates along with it
+func preloadRates(db *gorm.DB) *gorm.DB {
+    return db.Preload("Rates", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+}
+
+// findShippingMethod loads a shipping method with its rates
+func findShippingMethod(db *gorm.DB, id int64) (*models.ShippingMethod, error) {
+    var method models.ShippingMethod
+    if err := preloadRates(db).First(&method, id).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Shipping method with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving shipping method: %v", err)
+    }
+    return &method, nil
+}
+
+// toProtoShippingMethod converts a shipping method and its rates to protobuf
+func toProtoShippingMethod(method models.ShippingMethod) *pb.ShippingMethod {
+    res := &pb.ShippingMethod{
+        Id:       int64(method.ID),
+        Name:     method.Name,
+        Type:     pb.ShippingMethodType(pb.ShippingMethodType_value[string(method.Type)]),
+        Currency: method.Currency,
+        Active:   method.Active,
+    }
+    if method.FreeAbove > 0 {
+        res.FreeAbove = toProtoMoney(money.Money{Amount: method.FreeAbove, Currency: method.Currency})
+    }
+    for _, rate := range method.Rates {
+        res.Rates = append(res.Rates, &pb.ShippingRate{
+            Countries: shipping.Countries(rate.Countries),
+            MaxWeight: int32(rate.MaxWeight),
+            Amount:    toProtoMoney(money.Money{Amount: rate.Amount, Currency: method.Currency}),
+        })
+    }
+    return res
+}
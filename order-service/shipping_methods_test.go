@@ -0,0 +1,80 @@
+package main
+
+import (
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "order-service/models"
+    "order-service/shipping"
+)
+
+func TestNewShippingMethod(t *testing.T) {
+    method, err := newShippingMethod(&pb.ShippingMethod{
+        Name:      " Standard ",
+        Type:      pb.ShippingMethodType_WEIGHT_TABLE,
+        Currency:  "usd",
+        FreeAbove: &pb.Money{Amount: 5000},
+        Active:    true,
+        Rates: []*pb.ShippingRate{
+            {Countries: []string{"us", "CA"}, MaxWeight: 1000, Amount: &pb.Money{Amount: 500, Currency: "USD"}},
+            {Countries: []string{"US", "CA"}, Amount: &pb.Money{Amount: 900}},
+            {Amount: &pb.Money{Amount: 2500}},
+        },
+    })
+    if err != nil {
+        t.Fatalf("newShippingMethod error = %v", err)
+    }
+    if method.Name != "Standard" || method.Currency != "USD" || method.FreeAbove != 5000 || len(method.Rates) != 3 || method.Rates[0].Countries != "US,CA" {
+        t.Errorf("method = %+v", method)
+    }
+
+    valid := func() *pb.ShippingMethod {
+        return &pb.ShippingMethod{Name: "Flat", Currency: "USD", Rates: []*pb.ShippingRate{{Amount: &pb.Money{Amount: 500}}}}
+    }
+    tests := []struct {
+        name   string
+        modify func(*pb.ShippingMethod)
+    }{
+        {name: "no name", modify: func(m *pb.ShippingMethod) { m.Name = "" }},
+        {name: "unknown type", modify: func(m *pb.ShippingMethod) { m.Type = 9 }},
+        {name: "unknown currency", modify: func(m *pb.ShippingMethod) { m.Currency = "XYZ" }},
+        {name: "no rates", modify: func(m *pb.ShippingMethod) { m.Rates = nil }},
+        {name: "invalid country", modify: func(m *pb.ShippingMethod) { m.Rates[0].Countries = []string{"USA"} }},
+        {name: "negative amount", modify: func(m *pb.ShippingMethod) { m.Rates[0].Amount.Amount = -1 }},
+        {name: "amount in another currency", modify: func(m *pb.ShippingMethod) { m.Rates[0].Amount.Currency = "EUR" }},
+        {name: "flat rate with a weight", modify: func(m *pb.ShippingMethod) { m.Rates[0].MaxWeight = 1000 }},
+        {name: "two rates for a zone", modify: func(m *pb.ShippingMethod) {
+            m.Rates = append(m.Rates, &pb.ShippingRate{Amount: &pb.Money{Amount: 900}})
+        }},
+        {name: "two rates for a country", modify: func(m *pb.ShippingMethod) {
+            m.Rates = []*pb.ShippingRate{{Countries: []string{"US"}}, {Countries: []string{"CA", "US"}}}
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := valid()
+            tt.modify(req)
+            if _, err := newShippingMethod(req); status.Code(err) != codes.InvalidArgument {
+                t.Errorf("newShippingMethod error = %v, want %v", err, codes.InvalidArgument)
+            }
+        })
+    }
+}
+
+func TestNewParcel(t *testing.T) {
+    order := models.Order{
+        Currency:           "USD",
+        DestinationCountry: "US",
+        Items: []models.OrderItem{
+            {ProductID: 1, Quantity: 2, Price: 1000, Discount: 500},
+            {ProductID: 2, Quantity: 1, Price: 300},
+        },
+    }
+    pricing := orderPricing{Sizes: map[uint]shipping.Item{1: {Weight: 250}}}
+    parcel := newParcel(order, pricing)
+    if parcel.Country != "US" || parcel.Currency != "USD" || parcel.Weight != 500 || parcel.Subtotal != 1800 {
+        t.Errorf("parcel = %+v, want 500 g worth 1800 to US", parcel)
+    }
+}
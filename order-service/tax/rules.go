@@ -0,0 +1,102 @@
+package tax
+
+import (
+    "context"
+    "strings"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+// RuleProvider applies the tax rules kept in the database. Every rule for an
+// item's tax class that matches the destination applies, each adding a line,
+// so e.g. a state rate and a county rate narrowed by postal code stack.
+type RuleProvider struct {
+    db *gorm.DB
+}
+
+// NewRuleProvider creates a provider over the tax_rules table
+func NewRuleProvider(db *gorm.DB) *RuleProvider {
+    return &RuleProvider{db: db}
+}
+
+// Calculate taxes the items by the rules for the destination
+func (p *RuleProvider) Calculate(ctx context.Context, req Request) (Result, error) {
+    result := Result{Items: make([][]Line, len(req.Items))}
+    if req.Destination.Country == "" {
+        return result, nil
+    }
+    var rules []models.TaxRule
+    if err := p.db.WithContext(ctx).Where("country = ?", req.Destination.Country).Order("id").Find(&rules).Error; err != nil {
+        return result, err
+    }
+    rules = MatchingRules(rules, req.Destination)
+    for i, item := range req.Items {
+        var itemRules []models.TaxRule
+        for _, rule := range rules {
+            if rule.TaxClass == item.TaxClass {
+                itemRules = append(itemRules, rule)
+            }
+        }
+        result.Items[i] = Lines(itemRules, item.Amount)
+    }
+    return result, nil
+}
+
+// MatchingRules returns the rules whose region and postal code prefix match
+// an address in their country
+func MatchingRules(rules []models.TaxRule, address Address) []models.TaxRule {
+    var matching []models.TaxRule
+    postalCode := strings.ToUpper(strings.ReplaceAll(address.PostalCode, " ", ""))
+    for _, rule := range rules {
+        if rule.Country != address.Country {
+            continue
+        }
+        if rule.Region != "" && !strings.EqualFold(rule.Region, address.Region) {
+            continue
+        }
+        if !strings.HasPrefix(postalCode, strings.ToUpper(strings.ReplaceAll(rule.PostalCodePrefix, " ", ""))) {
+            continue
+        }
+        matching = append(matching, rule)
+    }
+    return matching
+}
+
+// Lines works out the tax each rule charges on an amount. Inclusive taxes are
+// taken out of the amount first: the net amount is amount / (1 + their rates),
+// and the difference is split between them by rate. Taxes that aren't
+// inclusive are charged on the net amount. Every line is rounded half away
+// from zero.
+func Lines(rules []models.TaxRule, amount int64) []Line {
+    var inclusiveRate int64
+    for _, rule := range rules {
+        if rule.Inclusive {
+            inclusiveRate += rule.Rate
+        }
+    }
+    net := money.Money{Amount: amount}.MulRatio(RateScale, RateScale+inclusiveRate).Amount
+    included := amount - net
+
+    lines := make([]Line, 0, len(rules))
+    var allocated int64
+    last := -1
+    for _, rule := range rules {
+        line := Line{RuleID: rule.ID, Name: rule.Name, Rate: rule.Rate, Inclusive: rule.Inclusive}
+        if rule.Inclusive {
+            line.Amount = money.Money{Amount: included}.MulRatio(rule.Rate, inclusiveRate).Amount
+            allocated += line.Amount
+            last = len(lines)
+        } else {
+            line.Amount = money.Money{Amount: net}.MulRatio(rule.Rate, RateScale).Amount
+        }
+        lines = append(lines, line)
+    }
+    // Rounding leftovers of the inclusive split go to its last line, so the
+    // inclusive lines add up to exactly what the amount contains
+    if last >= 0 {
+        lines[last].Amount += included - allocated
+    }
+    return lines
+}
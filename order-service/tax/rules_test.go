@@ -0,0 +1,95 @@
+package tax
+
+import (
+    "testing"
+
+    "gorm.io/gorm"
+    "order-service/models"
+)
+
+func TestLines(t *testing.T) {
+    state := models.TaxRule{Name: "State", Rate: 40000}                 // 4%
+    county := models.TaxRule{Name: "County", Rate: 48750}               // 4.875%
+    vat := models.TaxRule{Name: "VAT", Rate: 200000, Inclusive: true}   // 20%
+    levy := models.TaxRule{Name: "Levy", Rate: 10000, Inclusive: true}  // 1%
+
+    tests := []struct {
+        name   string
+        rules  []models.TaxRule
+        amount int64
+        want   []int64
+    }{
+        {name: "stacked rates, rounded per line", rules: []models.TaxRule{state, county}, amount: 1999, want: []int64{80, 97}},
+        {name: "inclusive rate taken out of the amount", rules: []models.TaxRule{vat}, amount: 1200, want: []int64{200}},
+        {name: "inclusive split adds up to what the amount contains", rules: []models.TaxRule{vat, levy}, amount: 1000, want: []int64{166, 8}},
+        {name: "exclusive rate charged on the net of inclusive ones", rules: []models.TaxRule{vat, state}, amount: 1200, want: []int64{200, 40}},
+        {name: "no rules", amount: 1000, want: []int64{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            lines := Lines(tt.rules, tt.amount)
+            if len(lines) != len(tt.want) {
+                t.Fatalf("Lines = %+v, want %d lines", lines, len(tt.want))
+            }
+            for i, line := range lines {
+                if line.Amount != tt.want[i] {
+                    t.Errorf("line %d amount = %d, want %d", i, line.Amount, tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestMatchingRules(t *testing.T) {
+    rules := []models.TaxRule{
+        {Model: gorm.Model{ID: 1}, Country: "US"},
+        {Model: gorm.Model{ID: 2}, Country: "US", Region: "NY"},
+        {Model: gorm.Model{ID: 3}, Country: "US", Region: "NY", PostalCodePrefix: "100"},
+        {Model: gorm.Model{ID: 4}, Country: "US", Region: "CA"},
+        {Model: gorm.Model{ID: 5}, Country: "GB", PostalCodePrefix: "SW1A"},
+    }
+    tests := []struct {
+        address Address
+        want    []uint
+    }{
+        {Address{Country: "US", Region: "ny", PostalCode: "10001"}, []uint{1, 2, 3}},
+        {Address{Country: "US", Region: "NY", PostalCode: "14201"}, []uint{1, 2}},
+        {Address{Country: "US"}, []uint{1}},
+        {Address{Country: "GB", PostalCode: "sw1a 1aa"}, []uint{5}},
+        {Address{Country: "FR"}, nil},
+    }
+    for _, tt := range tests {
+        var got []uint
+        for _, rule := range MatchingRules(rules, tt.address) {
+            got = append(got, rule.ID)
+        }
+        if len(got) != len(tt.want) {
+            t.Errorf("MatchingRules(%+v) = %v, want %v", tt.address, got, tt.want)
+            continue
+        }
+        for i := range got {
+            if got[i] != tt.want[i] {
+                t.Errorf("MatchingRules(%+v) = %v, want %v", tt.address, got, tt.want)
+                break
+            }
+        }
+    }
+}
+
+func TestParseRate(t *testing.T) {
+    valid := map[string]int64{"8.875": 88750, "20": 200000, "0.0001": 1, "100": RateScale}
+    for rate, want := range valid {
+        got, err := ParseRate(rate)
+        if err != nil || got != want {
+            t.Errorf("ParseRate(%q) = %d, %v, want %d", rate, got, err, want)
+        }
+        if formatted := FormatRate(got); formatted != rate {
+            t.Errorf("FormatRate(%d) = %q, want %q", got, formatted, rate)
+        }
+    }
+    for _, rate := range []string{"", ".5", "-1", "8.12345", "100.01", "8%"} {
+        if _, err := ParseRate(rate); err == nil {
+            t.Errorf("ParseRate(%q) succeeded, want an error", rate)
+        }
+    }
+}
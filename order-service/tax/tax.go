@@ -0,0 +1,106 @@
+package tax
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+
+    "gorm.io/gorm"
+)
+
+// RateScale is what a rate of 100% is stored as: rates are kept in millionths
+const RateScale = 1000000
+
+// Address is where an order ships to, which decides the tax rules that apply
+type Address struct {
+    Country    string // ISO 3166-1 alpha-2 code
+    Region     string
+    PostalCode string
+}
+
+// Item is an order line to tax
+type Item struct {
+    TaxClass string // Empty for the standard class
+    Amount   int64  // Line total after discounts, in minor units
+}
+
+// Line is the tax one rule charges on an item
+type Line struct {
+    RuleID    uint
+    Name      string
+    Rate      int64 // In millionths
+    Inclusive bool  // Contained in the item amount rather than added to it
+    Amount    int64
+}
+
+// Request asks for the tax on the items of an order
+type Request struct {
+    Currency    string
+    Destination Address
+    Items       []Item
+}
+
+// Result holds the tax lines of each item of a request, in item order
+type Result struct {
+    Items [][]Line
+}
+
+// Provider calculates taxes. Implementations must be safe for concurrent use.
+type Provider interface {
+    Calculate(ctx context.Context, req Request) (Result, error)
+}
+
+// NewProviderFromEnv creates the provider selected by the TAX_PROVIDER environment variable.
+// "rules" (the default) applies the tax rules kept in the database, "none" charges no tax.
+func NewProviderFromEnv(db *gorm.DB) (Provider, error) {
+    switch kind := os.Getenv("TAX_PROVIDER"); kind {
+    case "", "rules":
+        return NewRuleProvider(db), nil
+    case "none":
+        return NoTax{}, nil
+    default:
+        return nil, fmt.Errorf("unknown TAX_PROVIDER %q", kind)
+    }
+}
+
+// NoTax is a provider that never charges tax
+type NoTax struct{}
+
+// Calculate returns no tax lines
+func (NoTax) Calculate(ctx context.Context, req Request) (Result, error) {
+    return Result{Items: make([][]Line, len(req.Items))}, nil
+}
+
+// ParseRate reads a percentage with up to 4 decimals, e.g. "8.875", as millionths
+func ParseRate(rate string) (int64, error) {
+    whole, fraction, _ := strings.Cut(strings.TrimSpace(rate), ".")
+    if whole == "" || len(fraction) > 4 || !isDigits(whole) || !isDigits(fraction) {
+        return 0, fmt.Errorf("invalid rate %q", rate)
+    }
+    fraction += strings.Repeat("0", 4-len(fraction))
+    value, err := strconv.ParseInt(whole+fraction, 10, 64)
+    if err != nil || value > RateScale {
+        return 0, fmt.Errorf("invalid rate %q", rate)
+    }
+    return value, nil
+}
+
+// FormatRate formats a rate in millionths as a percentage, e.g. "8.875"
+func FormatRate(rate int64) string {
+    formatted := strconv.FormatInt(rate/10000, 10)
+    if fraction := rate % 10000; fraction != 0 {
+        formatted += "." + strings.TrimRight(fmt.Sprintf("%04d", fraction), "0")
+    }
+    return formatted
+}
+
+func isDigits(s string) bool {
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "gorm.io/gorm"
+    "order-service/models"
+    "order-service/tax"
+)
+
+// countryPattern is the form of ISO 3166-1 alpha-2 country codes
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// taxClassPattern is the form of product tax classes, as product-service accepts them
+var taxClassPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// destinationFromProto validates where an order ships to. Orders without a
+// destination are not taxed.
+func destinationFromProto(destination *pb.Destination) (tax.Address, error) {
+    address := tax.Address{
+        Country:    strings.ToUpper(strings.TrimSpace(destination.GetCountry())),
+        Region:     strings.ToUpper(strings.TrimSpace(destination.GetRegion())),
+        PostalCode: strings.TrimSpace(destination.GetPostalCode()),
+    }
+    if address.Country != "" && !countryPattern.MatchString(address.Country) {
+        return address, status.Errorf(codes.InvalidArgument, "Invalid country '%s'", destination.GetCountry())
+    }
+    if address.Country == "" && (address.Region != "" || address.PostalCode != "") {
+        return address, status.Errorf(codes.InvalidArgument, "Destination country is required")
+    }
+    return address, nil
+}
+
+// applyTax works out the tax on the items of an order after their discounts
+// and stores the tax lines on them. Taxes that aren't inclusive are added to
+// the items and the order; inclusive taxes are only recorded.
+func (s *server) applyTax(ctx context.Context, order *models.Order, pricing orderPricing) error {
+    req := tax.Request{
+        Currency:    order.Currency,
+        Destination: tax.Address{Country: order.DestinationCountry, Region: order.DestinationRegion, PostalCode: order.DestinationPostalCode},
+    }
+    for _, item := range order.Items {
+        req.Items = append(req.Items, tax.Item{
+            TaxClass: pricing.TaxClasses[item.ProductID],
+            Amount:   item.Price*int64(item.Quantity) - item.Discount,
+        })
+    }
+    result, err := s.Tax.Calculate(ctx, req)
+    if err != nil {
+        return status.Errorf(codes.Internal, "Error calculating tax: %v", err)
+    }
+
+    order.Tax, order.IncludedTax = 0, 0
+    for i := range order.Items {
+        item := &order.Items[i]
+        item.Tax, item.TaxLines = 0, nil
+        for _, line := range result.Items[i] {
+            item.TaxLines = append(item.TaxLines, models.OrderItemTax{
+                TaxRuleID: line.RuleID,
+                Name:      line.Name,
+                Rate:      line.Rate,
+                Inclusive: line.Inclusive,
+                Amount:    line.Amount,
+            })
+            if line.Inclusive {
+                order.IncludedTax += line.Amount
+            } else {
+                item.Tax += line.Amount
+            }
+        }
+        order.Tax += item.Tax
+    }
+    return nil
+}
+
+func (s *server) CreateTaxRule(ctx context.Context, req *pb.CreateTaxRuleRequest) (*pb.TaxRuleResponse, error) {
+    rule, err := newTaxRule(req.TaxRule)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.db.Create(&rule).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error creating tax rule: %v", err)
+    }
+    return &pb.TaxRuleResponse{TaxRule: toProtoTaxRule(rule)}, nil
+}
+
+func (s *server) UpdateTaxRule(ctx context.Context, req *pb.UpdateTaxRuleRequest) (*pb.TaxRuleResponse, error) {
+    update, err := newTaxRule(req.TaxRule)
+    if err != nil {
+        return nil, err
+    }
+    rule, err := findTaxRule(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+
+    // Orders keep the tax lines they were charged, so changing a rule only
+    // affects new orders
+    update.Model = rule.Model
+    if err := s.db.Save(&update).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error updating tax rule: %v", err)
+    }
+    return &pb.TaxRuleResponse{TaxRule: toProtoTaxRule(update)}, nil
+}
+
+func (s *server) DeleteTaxRule(ctx context.Context, req *pb.DeleteTaxRuleRequest) (*pb.DeleteTaxRuleResponse, error) {
+    rule, err := findTaxRule(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.db.Delete(rule).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error deleting tax rule: %v", err)
+    }
+    return &pb.DeleteTaxRuleResponse{Success: true}, nil
+}
+
+func (s *server) ListTaxRules(ctx context.Context, req *pb.ListTaxRulesRequest) (*pb.ListTaxRulesResponse, error) {
+    query := s.db.Order("country, region, postal_code_prefix, tax_class, id")
+    if req.Country != "" {
+        query = query.Where("country = ?", strings.ToUpper(req.Country))
+    }
+    var rules []models.TaxRule
+    if err := query.Find(&rules).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving tax rules: %v", err)
+    }
+    res := &pb.ListTaxRulesResponse{}
+    for _, rule := range rules {
+        res.TaxRules = append(res.TaxRules, toProtoTaxRule(rule))
+    }
+    return res, nil
+}
+
+// newTaxRule validates a tax rule being saved
+func newTaxRule(req *pb.TaxRule) (models.TaxRule, error) {
+    if req == nil {
+        return models.TaxRule{}, status.Errorf(codes.InvalidArgument, "Tax rule is required")
+    }
+    rule := models.TaxRule{
+        Name:             strings.TrimSpace(req.Name),
+        Country:          strings.ToUpper(strings.TrimSpace(req.Country)),
+        Region:           strings.ToUpper(strings.TrimSpace(req.Region)),
+        PostalCodePrefix: strings.TrimSpace(req.PostalCodePrefix),
+        TaxClass:         strings.ToLower(strings.TrimSpace(req.TaxClass)),
+        Inclusive:        req.Inclusive,
+    }
+    if rule.Name == "" {
+        return rule, status.Errorf(codes.InvalidArgument, "Tax rule name is required")
+    }
+    if !countryPattern.MatchString(rule.Country) {
+        return rule, status.Errorf(codes.InvalidArgument, "Invalid country '%s'", req.Country)
+    }
+    if rule.TaxClass != "" && !taxClassPattern.MatchString(rule.TaxClass) {
+        return rule, status.Errorf(codes.InvalidArgument, "Invalid tax class '%s'", req.TaxClass)
+    }
+    rate, err := tax.ParseRate(req.Rate)
+    if err != nil {
+        return rule, status.Errorf(codes.InvalidArgument, "Invalid rate '%s', expected a percentage such as 8.875", req.Rate)
+    }
+    rule.Rate = rate
+    return rule, nil
+}
+
+// findTaxRule loads a tax rule
+func findTaxRule(db *gorm.DB, id int64) (*models.TaxRule, error) {
+    var rule models.TaxRule
+    if err := db.First(&rule, id).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Tax rule with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving tax rule: %v", err)
+    }
+    return &rule, nil
+}
+
+// toProtoTaxRule converts a tax rule to protobuf
+func toProtoTaxRule(rule models.TaxRule) *pb.TaxRule {
+    return &pb.TaxRule{
+        Id:               int64(rule.ID),
+        Name:             rule.Name,
+        Country:          rule.Country,
+        Region:           rule.Region,
+        PostalCodePrefix: rule.PostalCodePrefix,
+        TaxClass:         rule.TaxClass,
+        Rate:             tax.FormatRate(rule.Rate),
+        Inclusive:        rule.Inclusive,
+    }
+}
+
+// toProtoTaxLines converts the tax lines of an order item to protobuf
+func toProtoTaxLines(order models.Order, lines []models.OrderItemTax) []*pb.TaxLine {
+    res := make([]*pb.TaxLine, 0, len(lines))
+    for _, line := range lines {
+        res = append(res, &pb.TaxLine{
+            TaxRuleId: int64(line.TaxRuleID),
+            Name:      line.Name,
+            Rate:      tax.FormatRate(line.Rate),
+            Inclusive: line.Inclusive,
+            Amount:    toProtoMoney(order.Money(line.Amount)),
+        })
+    }
+    return res
+}
+
+// toProtoDestination converts where an order ships to to protobuf
+func toProtoDestination(order models.Order) *pb.Destination {
+    if order.DestinationCountry == "" {
+        return nil
+    }
+    return &pb.Destination{
+        Country:    order.DestinationCountry,
+        Region:     order.DestinationRegion,
+        PostalCode: order.DestinationPostalCode,
+    }
+}
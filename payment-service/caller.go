@@ -0,0 +1,113 @@
+package main
+
+import (
+    "context"
+    "strconv"
+
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "payment-service/models"
+)
+
+// Metadata keys rest-service uses to forward the authenticated user
+const (
+    userIDMetadataKey   = "x-user-id"
+    userRoleMetadataKey = "x-user-role"
+)
+
+// roleAdmin is the user role allowed to manage every payment
+const roleAdmin = 3
+
+// serviceToken is the SERVICE_TOKEN other services prove their calls with.
+// Forwarded users are only trusted on calls that carry it.
+var serviceToken string
+
+// caller is the user on whose behalf a request is made
+type caller struct {
+    UserID uint
+    Role   int
+    System bool // A service acting on its own behalf rather than for a user
+}
+
+// callerFromContext reads the forwarded user from the incoming gRPC metadata.
+// ok is false when the request carries no user, or doesn't come from a service.
+func callerFromContext(ctx context.Context) (c caller, ok bool) {
+    if !serviceauth.Verified(ctx, serviceToken) {
+        return caller{}, false
+    }
+    md, found := metadata.FromIncomingContext(ctx)
+    if !found {
+        return caller{}, false
+    }
+    ids := md.Get(userIDMetadataKey)
+    if len(ids) == 0 {
+        return caller{}, false
+    }
+    userID, err := strconv.ParseUint(ids[0], 10, 64)
+    if err != nil {
+        return caller{}, false
+    }
+    c.UserID = uint(userID)
+    if roles := md.Get(userRoleMetadataKey); len(roles) > 0 {
+        c.Role, _ = strconv.Atoi(roles[0])
+    }
+    return c, true
+}
+
+// IsAdmin reports whether the caller has the admin role
+func (c caller) IsAdmin() bool {
+    return c.Role == roleAdmin
+}
+
+// systemCaller is payment-service acting on its own behalf
+var systemCaller = caller{Role: roleAdmin, System: true}
+
+// actorFromContext returns the forwarded user, or an admin-equivalent system
+// caller for services calling on their own behalf. A call with neither is
+// denied rather than trusted.
+func actorFromContext(ctx context.Context) (caller, error) {
+    if c, ok := callerFromContext(ctx); ok {
+        return c, nil
+    }
+    if serviceauth.IsSystem(ctx, serviceToken) {
+        return systemCaller, nil
+    }
+    return caller{}, status.Errorf(codes.Unauthenticated, "Request has no user")
+}
+
+// authorizePayment checks the caller may see the payment: customers only the
+// payments of their own orders, admins every payment. A denied payment is
+// reported as not found so payment IDs cannot be enumerated.
+func authorizePayment(actor caller, payment models.Payment) error {
+    if !actor.IsAdmin() && payment.CustomerID != actor.UserID {
+        return status.Errorf(codes.NotFound, "Payment with ID '%d' not found", payment.ID)
+    }
+    return nil
+}
+
+// requireAdmin checks the caller may move money on any payment
+func requireAdmin(actor caller) error {
+    if !actor.IsAdmin() {
+        return status.Errorf(codes.PermissionDenied, "Only admins can capture, void or refund payments")
+    }
+    return nil
+}
+
+// forwardCaller copies the forwarded user onto an outgoing context, so
+// order-service authorizes calls made on their behalf
+func forwardCaller(ctx context.Context) context.Context {
+    md, _ := metadata.FromIncomingContext(ctx)
+    for _, key := range []string{userIDMetadataKey, userRoleMetadataKey} {
+        for _, value := range md.Get(key) {
+            ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+        }
+    }
+    return ctx
+}
+
+// asSystem marks a call to another service as made by payment-service itself
+func asSystem(ctx context.Context) context.Context {
+    return serviceauth.AsSystem(ctx, "payment-service")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "os"
+    "log"
+    "net"
+    "fmt"
+    "time"
+    "math"
+
+    "google.golang.org/grpc"
+    pb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "payment-service/models"
+    "payment-service/provider"
+)
+
+type server struct {
+    pb.PaymentServiceServer
+    db *gorm.DB
+    Provider provider.Provider
+    OrderServiceClient orderpb.OrderServiceClient
+}
+
+func connectWithBackoff(dsn string) (*gorm.DB, error) {
+    var db *gorm.DB
+    var err error
+
+    maxAttempts := 5
+    for i := 0; i < maxAttempts; i++ {
+        db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+        if err == nil {
+            sqlDB, err := db.DB()
+            if err == nil {
+                err = sqlDB.Ping() // Try to ping the database
+                if err == nil {
+                    return db, nil // Success
+                }
+            }
+        }
+
+        // Exponential backoff logic
+        backoff := math.Pow(2, float64(i))
+        time.Sleep(time.Duration(backoff) * time.Second)
+    }
+
+    return nil, err // Return the last error
+}
+
+func initDB() *gorm.DB {
+    dsn := os.Getenv("DSN")
+    db, err := connectWithBackoff(dsn)
+    if err != nil {
+        log.Fatalf("failed to connect database: %v", err)
+    }
+
+    // Migrate the schema
+    if err := db.AutoMigrate(&models.Payment{}, &models.PaymentTransaction{}); err != nil {
+        log.Fatalf("failed to migrate database: %v", err)
+    }
+    fmt.Println("Database connection successful")
+    return db
+}
+
+func (s *server) connectToOrderService() {
+    // Set up a connection to the gRPC server.
+    orderServiceConnection, err := grpc.Dial(serviceAddress("ORDER_SERVICE_ADDR", "0.0.0.0:50053"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(serviceToken)))
+    if err != nil {
+        log.Fatalf("Failed to connect to gRPC server: %v", err)
+    }
+    fmt.Println("Connected to gRPC server")
+
+    s.OrderServiceClient = orderpb.NewOrderServiceClient(orderServiceConnection)
+}
+
+// serviceAddress returns the address of another service from the environment
+// variable env, e.g. PRODUCT_SERVICE_ADDR=product-service:50052, or fallback
+func serviceAddress(env, fallback string) string {
+    if address := os.Getenv(env); address != "" {
+        return address
+    }
+    return fallback
+}
+
+func main() {
+    db := initDB()
+    lis, err := net.Listen("tcp", ":50055")
+    if err != nil {
+        log.Fatalf("failed to listen: %v", err)
+    }
+    // Only other services holding the shared token may call payment-service
+    if serviceToken, err = serviceauth.TokenFromEnv(); err != nil {
+        log.Fatalf("failed to configure service authentication: %v", err)
+    }
+    s := grpc.NewServer(grpc.UnaryInterceptor(serviceauth.UnaryServerInterceptor(serviceToken)))
+    serv := &server{db: db}
+    serv.connectToOrderService()
+
+    // Payments go through the configured provider
+    if serv.Provider, err = provider.NewProviderFromEnv(); err != nil {
+        log.Fatalf("failed to create payment provider: %v", err)
+    }
+    go serv.runOrderConfirmation()
+    go serv.runPaymentSweeper()
+
+    pb.RegisterPaymentServiceServer(s, serv)
+    log.Printf("server listening at %v", lis.Addr())
+    if err := s.Serve(lis); err != nil {
+        log.Fatalf("failed to serve: %v", err)
+    }
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Payment is the payment of an order's total through a provider. Amounts are
+// in minor units of Currency. An order has at most one payment that hasn't
+// been declined or voided.
+type Payment struct {
+    gorm.Model
+    OrderID        uint          `gorm:"index:idx_payments_open_order,unique,where:status <> 'DECLINED' AND status <> 'VOIDED' AND deleted_at IS NULL"`
+    CustomerID     uint          `gorm:"index"`
+    Status         PaymentStatus `gorm:"size:32;index"`
+    Provider       string
+    Reference      string // The provider's ID for the authorization
+    Amount         int64  // Authorized amount
+    CapturedAmount int64  `gorm:"not null;default:0"`
+    RefundedAmount int64  `gorm:"not null;default:0"`
+    Currency       string `gorm:"size:3"`
+    ActionURL      string
+    FailureReason  string
+    AutoCapture    bool                 `gorm:"not null;default:false"` // Capture as soon as the authorization is approved
+    OrderConfirmed bool                 `gorm:"not null;default:false"` // Whether the capture is settled: its order confirmed, or refunded when it couldn't be
+    Transactions   []PaymentTransaction // Operations at the provider, oldest first
+}
+
+// PaymentStatus represents the status of a payment
+type PaymentStatus string
+
+// Enum values for PaymentStatus
+const (
+    PaymentProcessing        PaymentStatus = "PROCESSING"
+    PaymentRequiresAction    PaymentStatus = "REQUIRES_ACTION"
+    PaymentAuthorized        PaymentStatus = "AUTHORIZED"
+    PaymentCaptured          PaymentStatus = "CAPTURED"
+    PaymentPartiallyRefunded PaymentStatus = "PARTIALLY_REFUNDED"
+    PaymentRefunded          PaymentStatus = "REFUNDED"
+    PaymentVoided            PaymentStatus = "VOIDED"
+    PaymentDeclined          PaymentStatus = "DECLINED"
+)
+
+// PaymentTransaction records an operation on a payment at the provider
+type PaymentTransaction struct {
+    ID                uint            `gorm:"primarykey"`
+    PaymentID         uint            `gorm:"index"`
+    Kind              TransactionKind `gorm:"size:16"`
+    Status            string          `gorm:"size:16"` // SUCCEEDED, PENDING or FAILED
+    Amount            int64
+    ProviderReference string
+    FailureReason     string
+    Reason            string          // Why a refund was given
+    IdempotencyKey    string          `gorm:"index;not null;default:''"` // Key of the request that made it, if any
+    CreatedAt         time.Time
+}
+
+// TransactionKind is the operation a transaction records
+type TransactionKind string
+
+// Enum values for TransactionKind
+const (
+    TransactionAuthorize TransactionKind = "AUTHORIZE"
+    TransactionConfirm   TransactionKind = "CONFIRM"
+    TransactionCapture   TransactionKind = "CAPTURE"
+    TransactionVoid      TransactionKind = "VOID"
+    TransactionRefund    TransactionKind = "REFUND"
+)
+
+// Transaction statuses
+const (
+    TransactionSucceeded = "SUCCEEDED"
+    TransactionPending   = "PENDING"
+    TransactionFailed    = "FAILED"
+)
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "payment-service/models"
+)
+
+const (
+    // confirmationRetryInterval is how often captured payments whose order
+    // wasn't confirmed are retried
+    confirmationRetryInterval = 30 * time.Second
+    // confirmationTimeout bounds each call to order-service
+    confirmationTimeout = 10 * time.Second
+)
+
+// confirmOrder moves the order of a captured payment to CONFIRMED. The call
+// is made by payment-service itself, not for the user who captured. An order
+// that can no longer be confirmed, e.g. because it was cancelled meanwhile,
+// has what is left of the payment refunded instead.
+func (s *server) confirmOrder(ctx context.Context, payment *models.Payment) error {
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), confirmationTimeout)
+    defer cancel()
+    _, err := s.OrderServiceClient.UpdateOrder(asSystem(ctx), &orderpb.UpdateOrderRequest{
+        OrderId: int64(payment.OrderID),
+        Status:  orderpb.OrderStatus_CONFIRMED,
+        Reason:  fmt.Sprintf("Payment %d captured", payment.ID),
+    })
+    switch status.Code(err) {
+    case codes.OK:
+    case codes.FailedPrecondition, codes.NotFound:
+        log.Printf("payment %d: order %d can't be confirmed, refunding: %v", payment.ID, payment.OrderID, err)
+        if err := s.refundUnconfirmed(ctx, payment); err != nil {
+            return err
+        }
+    default:
+        return err
+    }
+
+    payment.OrderConfirmed = true
+    return s.db.Model(payment).Update("order_confirmed", true).Error
+}
+
+// refundUnconfirmed refunds what is left of a payment whose order can't be
+// confirmed. The key makes retries refund once. A refund the provider
+// declines is left for an admin, with the payment still CAPTURED.
+func (s *server) refundUnconfirmed(ctx context.Context, payment *models.Payment) error {
+    if payment.RefundedAmount >= payment.CapturedAmount {
+        return nil
+    }
+    refunded, err := s.refund(ctx, systemCaller, int64(payment.ID), nil,
+        fmt.Sprintf("Order %d could not be confirmed", payment.OrderID), fmt.Sprintf("payment-%d-unconfirmed", payment.ID))
+    switch status.Code(err) {
+    case codes.OK:
+        *payment = refunded
+    case codes.FailedPrecondition:
+        log.Printf("payment %d: not refunded, left for an admin: %v", payment.ID, err)
+    default:
+        return err
+    }
+    return nil
+}
+
+// runOrderConfirmation periodically retries confirming the orders of
+// captured payments until the process exits
+func (s *server) runOrderConfirmation() {
+    ticker := time.NewTicker(confirmationRetryInterval)
+    defer ticker.Stop()
+    for {
+        s.confirmCapturedOrders()
+        <-ticker.C
+    }
+}
+
+// confirmCapturedOrders confirms the orders of captured payments that
+// order-service hasn't been told about
+func (s *server) confirmCapturedOrders() {
+    var payments []models.Payment
+    captured := []models.PaymentStatus{models.PaymentCaptured, models.PaymentPartiallyRefunded, models.PaymentRefunded}
+    if err := s.db.Where("status IN ? AND order_confirmed = ?", captured, false).Find(&payments).Error; err != nil {
+        log.Printf("order confirmation: failed to load payments: %v", err)
+        return
+    }
+
+    for i := range payments {
+        if err := s.confirmOrder(context.Background(), &payments[i]); err != nil {
+            log.Printf("payment %d: confirming order %d failed, will retry: %v", payments[i].ID, payments[i].OrderID, err)
+        }
+    }
+}
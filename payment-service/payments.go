@@ -0,0 +1,619 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    pb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "payment-service/models"
+    "payment-service/provider"
+)
+
+const (
+    // authorizationTimeout bounds the provider call authorizing a payment
+    authorizationTimeout = 30 * time.Second
+    // processingTimeout is how long a payment may stay PROCESSING before it
+    // is taken to have been interrupted; well past authorizationTimeout, so
+    // live authorizations are left alone
+    processingTimeout = 5 * time.Minute
+    // sweepInterval is how often interrupted payments are looked for
+    sweepInterval = time.Minute
+)
+
+// AuthorizePayment holds the total of a PENDING order on the customer's
+// payment method. Declines aren't errors: the payment comes back DECLINED
+// with the provider's reason, and the order can be paid again.
+func (s *server) AuthorizePayment(ctx context.Context, req *pb.AuthorizePaymentRequest) (*pb.PaymentResponse, error) {
+    token := strings.TrimSpace(req.PaymentToken)
+    if token == "" {
+        return nil, status.Errorf(codes.InvalidArgument, "Payment token is required")
+    }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    // Customers can only pay for their own orders, which order-service checks
+    orderResp, err := s.OrderServiceClient.GetOrder(forwardCaller(ctx), &orderpb.GetOrderRequest{OrderId: req.OrderId})
+    if err != nil {
+        return nil, err
+    }
+    order := orderResp.GetOrder()
+    if order.GetStatus() != orderpb.OrderStatus_PENDING {
+        return nil, status.Errorf(codes.FailedPrecondition, "Order with ID '%d' is not awaiting payment", req.OrderId)
+    }
+    total := order.GetTotalPrice()
+    if total.GetAmount() <= 0 {
+        return nil, status.Errorf(codes.FailedPrecondition, "Order with ID '%d' has nothing to pay", req.OrderId)
+    }
+    if err := checkNoOpenPayment(s.db, uint(req.OrderId)); err != nil {
+        return nil, err
+    }
+
+    payment := models.Payment{
+        OrderID:      uint(order.Id),
+        CustomerID:   uint(order.CustomerId),
+        Status:       models.PaymentProcessing,
+        Provider:     s.Provider.Name(),
+        Amount:       total.Amount,
+        Currency:     total.Currency,
+        AutoCapture:  req.Capture,
+        Transactions: []models.PaymentTransaction{{Kind: models.TransactionAuthorize, Status: models.TransactionPending, Amount: total.Amount}},
+    }
+    if err := s.db.Create(&payment).Error; err != nil {
+        // The unique index catches a payment created concurrently for the order
+        if err := checkNoOpenPayment(s.db, payment.OrderID); err != nil {
+            return nil, err
+        }
+        return nil, status.Errorf(codes.Internal, "Error creating payment: %v", err)
+    }
+
+    txn := &payment.Transactions[0]
+    authCtx, cancel := context.WithTimeout(ctx, authorizationTimeout)
+    defer cancel()
+    result, err := s.Provider.Authorize(authCtx, provider.AuthorizeRequest{
+        Key:      strconv.FormatUint(uint64(txn.ID), 10),
+        Amount:   payment.Amount,
+        Currency: payment.Currency,
+        Token:    token,
+    })
+    if err != nil {
+        // Without an answer the payment is declined so the order can be paid
+        // again; a hold the provider did make lapses uncaptured
+        result = provider.Result{Status: provider.Declined, FailureReason: "provider_error"}
+    }
+    applyResult(txn, result)
+    payment.Reference = result.Reference
+    switch result.Status {
+    case provider.Approved:
+        payment.Status = models.PaymentAuthorized
+    case provider.Pending:
+        payment.Status = models.PaymentRequiresAction
+        payment.ActionURL = result.ActionURL
+    default:
+        payment.Status = models.PaymentDeclined
+        payment.FailureReason = result.FailureReason
+    }
+    if saveErr := savePayment(s.db, &payment, txn); saveErr != nil {
+        return nil, saveErr
+    }
+    if err != nil {
+        return nil, status.Errorf(codes.Unavailable, "Payment provider error: %v", err)
+    }
+
+    if payment.Status == models.PaymentAuthorized && payment.AutoCapture {
+        return s.capture(ctx, actor, int64(payment.ID), nil)
+    }
+    return s.paymentResponse(payment.ID)
+}
+
+// runPaymentSweeper periodically declines interrupted payments until the process exits
+func (s *server) runPaymentSweeper() {
+    ticker := time.NewTicker(sweepInterval)
+    defer ticker.Stop()
+    for {
+        s.declineInterruptedPayments()
+        <-ticker.C
+    }
+}
+
+// declineInterruptedPayments declines payments left PROCESSING by an
+// authorization that never finished, e.g. because the service stopped while
+// waiting for the provider. Until then they block every new payment of their
+// order. A hold the provider did make lapses uncaptured.
+func (s *server) declineInterruptedPayments() {
+    var payments []models.Payment
+    err := s.db.Where("status = ? AND updated_at < ?", models.PaymentProcessing, time.Now().Add(-processingTimeout)).Find(&payments).Error
+    if err != nil {
+        log.Printf("payment sweeper: failed to load payments: %v", err)
+        return
+    }
+
+    for _, payment := range payments {
+        err := s.db.Transaction(func(tx *gorm.DB) error {
+            result := tx.Model(&models.Payment{}).Where("id = ? AND status = ?", payment.ID, models.PaymentProcessing).
+                Updates(map[string]interface{}{"status": models.PaymentDeclined, "failure_reason": "authorization_interrupted"})
+            if result.Error != nil || result.RowsAffected == 0 {
+                return result.Error
+            }
+            return tx.Model(&models.PaymentTransaction{}).
+                Where("payment_id = ? AND kind = ? AND status = ?", payment.ID, models.TransactionAuthorize, models.TransactionPending).
+                Updates(map[string]interface{}{"status": models.TransactionFailed, "failure_reason": "authorization_interrupted"}).Error
+        })
+        if err != nil {
+            log.Printf("payment %d: failed to decline interrupted payment: %v", payment.ID, err)
+        }
+    }
+}
+
+// ConfirmPayment completes an authorization once the customer has finished
+// the 3-D Secure challenge at the payment's actionUrl
+func (s *server) ConfirmPayment(ctx context.Context, req *pb.ConfirmPaymentRequest) (*pb.PaymentResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    payment, err := s.operate(ctx, actor, req.Id, operation{
+        kind: models.TransactionConfirm,
+        amount: func(payment models.Payment) (int64, error) {
+            if payment.Status != models.PaymentRequiresAction {
+                return 0, status.Errorf(codes.FailedPrecondition, "Payment with ID '%d' is %s and doesn't need confirming", payment.ID, payment.Status)
+            }
+            return payment.Amount, nil
+        },
+        call: s.Provider.Confirm,
+        apply: func(payment *models.Payment, amount int64, result provider.Result) error {
+            payment.ActionURL = ""
+            if result.Status != provider.Approved {
+                payment.Status = models.PaymentDeclined
+                payment.FailureReason = result.FailureReason
+                return nil
+            }
+            payment.Status = models.PaymentAuthorized
+            return nil
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if payment.Status == models.PaymentAuthorized && payment.AutoCapture {
+        return s.capture(ctx, actor, req.Id, nil)
+    }
+    return s.paymentResponse(payment.ID)
+}
+
+func (s *server) CapturePayment(ctx context.Context, req *pb.CapturePaymentRequest) (*pb.PaymentResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireAdmin(actor); err != nil {
+        return nil, err
+    }
+    return s.capture(ctx, actor, req.Id, req.Amount)
+}
+
+// capture collects some or all of an authorized payment and confirms its
+// order. What isn't captured is released.
+func (s *server) capture(ctx context.Context, actor caller, id int64, amount *pb.Money) (*pb.PaymentResponse, error) {
+    payment, err := s.operate(ctx, actor, id, operation{
+        kind: models.TransactionCapture,
+        amount: func(payment models.Payment) (int64, error) {
+            if payment.Status != models.PaymentAuthorized {
+                return 0, status.Errorf(codes.FailedPrecondition, "Payment with ID '%d' is %s and can't be captured", payment.ID, payment.Status)
+            }
+            captured, err := amountFromProto(amount, payment, payment.Amount)
+            if err != nil {
+                return 0, err
+            }
+            if captured > payment.Amount {
+                return 0, status.Errorf(codes.InvalidArgument, "Capture of %s exceeds the %s authorized", formatAmount(payment, captured), formatAmount(payment, payment.Amount))
+            }
+            return captured, nil
+        },
+        call: s.Provider.Capture,
+        apply: func(payment *models.Payment, amount int64, result provider.Result) error {
+            if result.Status != provider.Approved {
+                return status.Errorf(codes.FailedPrecondition, "Capture of payment with ID '%d' was declined: %s", payment.ID, result.FailureReason)
+            }
+            payment.Status = models.PaymentCaptured
+            payment.CapturedAmount = amount
+            return nil
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // The money is collected whether or not order-service answers now; a
+    // failed confirmation is retried in the background
+    if err := s.confirmOrder(ctx, &payment); err != nil {
+        log.Printf("payment %d: confirming order %d failed, will retry: %v", payment.ID, payment.OrderID, err)
+    }
+    return s.paymentResponse(payment.ID)
+}
+
+func (s *server) VoidPayment(ctx context.Context, req *pb.VoidPaymentRequest) (*pb.PaymentResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireAdmin(actor); err != nil {
+        return nil, err
+    }
+    payment, err := s.operate(ctx, actor, req.Id, operation{
+        kind: models.TransactionVoid,
+        amount: func(payment models.Payment) (int64, error) {
+            // A payment waiting on 3-D Secure is voided too, so the challenge can't authorize it later
+            if payment.Status != models.PaymentAuthorized && payment.Status != models.PaymentRequiresAction {
+                return 0, status.Errorf(codes.FailedPrecondition, "Payment with ID '%d' is %s and can't be voided", payment.ID, payment.Status)
+            }
+            return payment.Amount, nil
+        },
+        call: s.Provider.Void,
+        apply: func(payment *models.Payment, amount int64, result provider.Result) error {
+            if result.Status != provider.Approved {
+                return status.Errorf(codes.FailedPrecondition, "Void of payment with ID '%d' was declined: %s", payment.ID, result.FailureReason)
+            }
+            payment.ActionURL = ""
+            payment.Status = models.PaymentVoided
+            return nil
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+    return s.paymentResponse(payment.ID)
+}
+
+func (s *server) RefundPayment(ctx context.Context, req *pb.RefundPaymentRequest) (*pb.PaymentResponse, error) {
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireAdmin(actor); err != nil {
+        return nil, err
+    }
+    payment, err := s.refund(ctx, actor, req.Id, req.Amount, strings.TrimSpace(req.Reason), req.IdempotencyKey)
+    if err != nil {
+        return nil, err
+    }
+    return s.paymentResponse(payment.ID)
+}
+
+// refund gives back some or, when amount is unset, all that is left of a
+// captured payment
+func (s *server) refund(ctx context.Context, actor caller, id int64, amount *pb.Money, reason, key string) (models.Payment, error) {
+    return s.operate(ctx, actor, id, operation{
+        kind:   models.TransactionRefund,
+        reason: reason,
+        key:    key,
+        amount: func(payment models.Payment) (int64, error) {
+            if payment.Status != models.PaymentCaptured && payment.Status != models.PaymentPartiallyRefunded {
+                return 0, status.Errorf(codes.FailedPrecondition, "Payment with ID '%d' is %s and can't be refunded", payment.ID, payment.Status)
+            }
+            left := payment.CapturedAmount - payment.RefundedAmount
+            refund, err := amountFromProto(amount, payment, left)
+            if err != nil {
+                return 0, err
+            }
+            if refund > left {
+                return 0, status.Errorf(codes.InvalidArgument, "Refund of %s exceeds the %s left to refund", formatAmount(payment, refund), formatAmount(payment, left))
+            }
+            return refund, nil
+        },
+        call: s.Provider.Refund,
+        apply: func(payment *models.Payment, amount int64, result provider.Result) error {
+            if result.Status != provider.Approved {
+                return status.Errorf(codes.FailedPrecondition, "Refund of payment with ID '%d' was declined: %s", payment.ID, result.FailureReason)
+            }
+            payment.RefundedAmount += amount
+            payment.Status = models.PaymentPartiallyRefunded
+            if payment.RefundedAmount == payment.CapturedAmount {
+                payment.Status = models.PaymentRefunded
+            }
+            return nil
+        },
+    })
+}
+
+func (s *server) GetPayment(ctx context.Context, req *pb.GetPaymentRequest) (*pb.PaymentResponse, error) {
+    payment, err := findPayment(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := authorizePayment(actor, *payment); err != nil {
+        return nil, err
+    }
+    return &pb.PaymentResponse{Payment: toProtoPayment(*payment)}, nil
+}
+
+// ListPayments returns the payments of an order, including declined ones.
+// Customers only see the payments of their own orders.
+func (s *server) ListPayments(ctx context.Context, req *pb.ListPaymentsRequest) (*pb.ListPaymentsResponse, error) {
+    query := s.db.Preload("Transactions", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+        Where("order_id = ?", req.OrderId).Order("id DESC")
+    actor, err := actorFromContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if !actor.IsAdmin() {
+        query = query.Where("customer_id = ?", actor.UserID)
+    }
+    var payments []models.Payment
+    if err := query.Find(&payments).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving payments: %v", err)
+    }
+    res := &pb.ListPaymentsResponse{}
+    for _, payment := range payments {
+        res.Payments = append(res.Payments, toProtoPayment(payment))
+    }
+    return res, nil
+}
+
+// operation is a provider call on an existing payment
+type operation struct {
+    kind   models.TransactionKind
+    reason string
+    key    string // Sent to the provider; an operation that already succeeded with the key is skipped
+    // amount checks the operation is allowed and returns the amount it moves
+    amount func(payment models.Payment) (int64, error)
+    call   func(ctx context.Context, req provider.OperationRequest) (provider.Result, error)
+    // apply updates the payment from the provider's answer. An error reports
+    // a decline, which is still recorded.
+    apply func(payment *models.Payment, amount int64, result provider.Result) error
+}
+
+// operate runs an operation on a payment. The operation is recorded as a
+// PENDING transaction and committed before the provider is called, so a
+// retry after a failed call finds it again and sends the same idempotency
+// key: op.key when set, otherwise the transaction's ID. The payment stays
+// locked while the provider is called, so operations on it can't interleave.
+func (s *server) operate(ctx context.Context, actor caller, id int64, op operation) (models.Payment, error) {
+    var payment models.Payment
+    var txn *models.PaymentTransaction
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := lockPayment(tx, &payment, id); err != nil {
+            return err
+        }
+        if err := authorizePayment(actor, payment); err != nil {
+            return err
+        }
+        if op.key != "" {
+            var done int64
+            err := tx.Model(&models.PaymentTransaction{}).
+                Where("payment_id = ? AND kind = ? AND idempotency_key = ? AND status = ?", payment.ID, op.kind, op.key, models.TransactionSucceeded).
+                Count(&done).Error
+            if err != nil {
+                return status.Errorf(codes.Internal, "Error retrieving payment transactions: %v", err)
+            }
+            if done > 0 {
+                return nil
+            }
+        }
+        amount, err := op.amount(payment)
+        if err != nil {
+            return err
+        }
+        txn, err = pendingTransaction(tx, payment.ID, op, amount)
+        return err
+    })
+    if err != nil || txn == nil {
+        return payment, err
+    }
+
+    var declined error
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if err := lockPayment(tx, &payment, id); err != nil {
+            return err
+        }
+        // A concurrent retry may have finished the operation meanwhile
+        if err := tx.First(txn, txn.ID).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving payment transaction: %v", err)
+        }
+        if txn.Status != models.TransactionPending {
+            return nil
+        }
+        // The payment may have changed since the operation was recorded
+        amount, err := op.amount(payment)
+        if err == nil && amount != txn.Amount {
+            err = status.Errorf(codes.FailedPrecondition, "Payment with ID '%d' changed while the operation was being made", payment.ID)
+        }
+        if err != nil {
+            // Never sent, so nothing happened at the provider
+            declined = err
+            txn.Status = models.TransactionFailed
+            txn.FailureReason = "not_sent"
+            return tx.Save(txn).Error
+        }
+
+        key := op.key
+        if key == "" {
+            key = strconv.FormatUint(uint64(txn.ID), 10)
+        }
+        result, err := op.call(ctx, provider.OperationRequest{
+            Key:       key,
+            Reference: payment.Reference,
+            Amount:    amount,
+            Currency:  payment.Currency,
+        })
+        if err != nil {
+            // The transaction stays PENDING for the retry
+            return status.Errorf(codes.Unavailable, "Payment provider error: %v", err)
+        }
+        applyResult(txn, result)
+        declined = op.apply(&payment, amount, result)
+        return savePayment(tx, &payment, txn)
+    })
+    if err != nil {
+        return payment, err
+    }
+    return payment, declined
+}
+
+// lockPayment loads a payment for update within tx
+func lockPayment(tx *gorm.DB, payment *models.Payment, id int64) error {
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(payment, id).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return status.Errorf(codes.NotFound, "Payment with ID '%d' not found", id)
+        }
+        return status.Errorf(codes.Internal, "Error retrieving payment: %v", err)
+    }
+    return nil
+}
+
+// pendingTransaction records an operation about to be sent to the provider.
+// A PENDING transaction left by an earlier attempt with the same key and
+// amount is returned instead, so the retry is sent under the same key.
+func pendingTransaction(tx *gorm.DB, paymentID uint, op operation, amount int64) (*models.PaymentTransaction, error) {
+    var txn models.PaymentTransaction
+    err := tx.Where("payment_id = ? AND kind = ? AND idempotency_key = ? AND status = ?", paymentID, op.kind, op.key, models.TransactionPending).
+        Order("id DESC").First(&txn).Error
+    switch {
+    case err == nil && txn.Amount == amount:
+        return &txn, nil
+    case err == nil && op.key != "":
+        return nil, status.Errorf(codes.InvalidArgument, "Idempotency key '%s' was used for another amount", op.key)
+    case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+        return nil, status.Errorf(codes.Internal, "Error retrieving payment transactions: %v", err)
+    }
+
+    txn = models.PaymentTransaction{PaymentID: paymentID, Kind: op.kind, Status: models.TransactionPending, Amount: amount, Reason: op.reason, IdempotencyKey: op.key}
+    if err := tx.Create(&txn).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error recording payment transaction: %v", err)
+    }
+    return &txn, nil
+}
+
+// applyResult records the provider's answer on a transaction
+func applyResult(txn *models.PaymentTransaction, result provider.Result) {
+    txn.ProviderReference = result.Reference
+    switch result.Status {
+    case provider.Approved:
+        txn.Status = models.TransactionSucceeded
+    case provider.Pending:
+        txn.Status = models.TransactionPending
+    default:
+        txn.Status = models.TransactionFailed
+        txn.FailureReason = result.FailureReason
+    }
+}
+
+// savePayment saves a payment and the transaction that changed it
+func savePayment(db *gorm.DB, payment *models.Payment, txn *models.PaymentTransaction) error {
+    if err := db.Save(txn).Error; err != nil {
+        return status.Errorf(codes.Internal, "Error recording payment transaction: %v", err)
+    }
+    if err := db.Omit("Transactions").Save(payment).Error; err != nil {
+        return status.Errorf(codes.Internal, "Error updating payment: %v", err)
+    }
+    return nil
+}
+
+// checkNoOpenPayment checks an order has no payment that is being made or
+// has been made
+func checkNoOpenPayment(db *gorm.DB, orderID uint) error {
+    var count int64
+    err := db.Model(&models.Payment{}).
+        Where("order_id = ? AND status NOT IN ?", orderID, []models.PaymentStatus{models.PaymentDeclined, models.PaymentVoided}).
+        Count(&count).Error
+    if err != nil {
+        return status.Errorf(codes.Internal, "Error retrieving payments: %v", err)
+    }
+    if count > 0 {
+        return status.Errorf(codes.AlreadyExists, "Order with ID '%d' already has a payment", orderID)
+    }
+    return nil
+}
+
+// amountFromProto reads an optional amount in the currency of the payment,
+// which is fallback when unset
+func amountFromProto(amount *pb.Money, payment models.Payment, fallback int64) (int64, error) {
+    if amount == nil {
+        return fallback, nil
+    }
+    if amount.Currency != "" && !strings.EqualFold(amount.Currency, payment.Currency) {
+        return 0, status.Errorf(codes.InvalidArgument, "Amount must be in %s, the currency of the payment", payment.Currency)
+    }
+    if amount.Amount <= 0 {
+        return 0, status.Errorf(codes.InvalidArgument, "Amount must be positive")
+    }
+    return amount.Amount, nil
+}
+
+// formatAmount formats an amount in the currency of a payment for messages
+func formatAmount(payment models.Payment, amount int64) string {
+    return money.Money{Amount: amount, Currency: payment.Currency}.String()
+}
+
+// findPayment loads a payment with its transactions
+func findPayment(db *gorm.DB, id int64) (*models.Payment, error) {
+    var payment models.Payment
+    err := db.Preload("Transactions", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&payment, id).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Payment with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving payment: %v", err)
+    }
+    return &payment, nil
+}
+
+// paymentResponse reloads a payment after an operation
+func (s *server) paymentResponse(id uint) (*pb.PaymentResponse, error) {
+    payment, err := findPayment(s.db, int64(id))
+    if err != nil {
+        return nil, err
+    }
+    return &pb.PaymentResponse{Payment: toProtoPayment(*payment)}, nil
+}
+
+// toProtoPayment converts a payment to protobuf
+func toProtoPayment(payment models.Payment) *pb.Payment {
+    res := &pb.Payment{
+        Id:             int64(payment.ID),
+        OrderId:        int64(payment.OrderID),
+        CustomerId:     int64(payment.CustomerID),
+        Status:         pb.PaymentStatus(pb.PaymentStatus_value[string(payment.Status)]),
+        Provider:       payment.Provider,
+        Amount:         toProtoMoney(payment, payment.Amount),
+        CapturedAmount: toProtoMoney(payment, payment.CapturedAmount),
+        RefundedAmount: toProtoMoney(payment, payment.RefundedAmount),
+        ActionUrl:      payment.ActionURL,
+        FailureReason:  payment.FailureReason,
+        CreatedAt:      payment.CreatedAt.Format(time.RFC3339),
+    }
+    for _, txn := range payment.Transactions {
+        res.Transactions = append(res.Transactions, &pb.PaymentTransaction{
+            Id:                int64(txn.ID),
+            Kind:              string(txn.Kind),
+            Status:            txn.Status,
+            Amount:            toProtoMoney(payment, txn.Amount),
+            ProviderReference: txn.ProviderReference,
+            FailureReason:     txn.FailureReason,
+            CreatedAt:         txn.CreatedAt.Format(time.RFC3339),
+            Reason:            txn.Reason,
+        })
+    }
+    return res
+}
+
+// toProtoMoney converts an amount in the currency of a payment to protobuf
+func toProtoMoney(payment models.Payment, amount int64) *pb.Money {
+    return &pb.Money{Amount: amount, Currency: payment.Currency}
+}
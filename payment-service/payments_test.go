@@ -0,0 +1,337 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    pb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "payment-service/models"
+    "payment-service/provider"
+)
+
+// flakyProvider is the fake gateway failing every operation while err is
+// set, recording the key of each operation sent to it
+type flakyProvider struct {
+    provider.Fake
+    err  error
+    keys []string
+}
+
+func (p *flakyProvider) send(req provider.OperationRequest) error {
+    p.keys = append(p.keys, req.Key)
+    return p.err
+}
+
+func (p *flakyProvider) Capture(ctx context.Context, req provider.OperationRequest) (provider.Result, error) {
+    if err := p.send(req); err != nil {
+        return provider.Result{}, err
+    }
+    return p.Fake.Capture(ctx, req)
+}
+
+func (p *flakyProvider) Void(ctx context.Context, req provider.OperationRequest) (provider.Result, error) {
+    if err := p.send(req); err != nil {
+        return provider.Result{}, err
+    }
+    return p.Fake.Void(ctx, req)
+}
+
+func (p *flakyProvider) Refund(ctx context.Context, req provider.OperationRequest) (provider.Result, error) {
+    if err := p.send(req); err != nil {
+        return provider.Result{}, err
+    }
+    return p.Fake.Refund(ctx, req)
+}
+
+// fakeOrders is an order-service recording the status updates sent to it
+type fakeOrders struct {
+    orderpb.OrderServiceClient
+    err     error // Returned by UpdateOrder, e.g. when the order was cancelled
+    updates []*orderpb.UpdateOrderRequest
+}
+
+func (f *fakeOrders) UpdateOrder(ctx context.Context, req *orderpb.UpdateOrderRequest, opts ...grpc.CallOption) (*orderpb.OrderResponse, error) {
+    f.updates = append(f.updates, req)
+    if f.err != nil {
+        return nil, f.err
+    }
+    return &orderpb.OrderResponse{Order: &orderpb.Order{Id: req.OrderId, Status: req.Status}}, nil
+}
+
+// adminContext is an incoming call from rest-service for an admin
+func adminContext(t *testing.T) context.Context {
+    serviceToken = "secret"
+    t.Cleanup(func() { serviceToken = "" })
+    md := metadata.Pairs(serviceauth.TokenMetadataKey, "secret", userIDMetadataKey, "1", userRoleMetadataKey, "3")
+    return metadata.NewIncomingContext(context.Background(), md)
+}
+
+// testOrderID is the order of the last test payment; each payment gets an
+// order of its own, as an order has only one open payment
+var testOrderID uint
+
+// createTestPayment stores a payment of 10.00 in the given status, as if
+// authorized and, for captured statuses, captured in full
+func createTestPayment(t *testing.T, s *server, paymentStatus models.PaymentStatus) models.Payment {
+    t.Helper()
+    testOrderID++
+    payment := models.Payment{OrderID: testOrderID, CustomerID: 3, Status: paymentStatus, Provider: "fake", Reference: "fake_auth_1", Amount: 1000, Currency: "USD"}
+    if paymentStatus != models.PaymentAuthorized {
+        payment.CapturedAmount = 1000
+    }
+    if err := s.db.Create(&payment).Error; err != nil {
+        t.Fatalf("failed to create payment: %v", err)
+    }
+    return payment
+}
+
+func TestPaymentOperations(t *testing.T) {
+    db := testDB(t)
+    tests := []struct {
+        name     string
+        from     models.PaymentStatus
+        run      func(s *server, ctx context.Context, id int64) error
+        code     codes.Code
+        want     models.PaymentStatus
+        refunded int64
+    }{
+        {
+            name: "capture",
+            from: models.PaymentAuthorized,
+            run: func(s *server, ctx context.Context, id int64) error {
+                _, err := s.CapturePayment(ctx, &pb.CapturePaymentRequest{Id: id})
+                return err
+            },
+            want: models.PaymentCaptured,
+        },
+        {
+            name: "capture twice",
+            from: models.PaymentCaptured,
+            run: func(s *server, ctx context.Context, id int64) error {
+                _, err := s.CapturePayment(ctx, &pb.CapturePaymentRequest{Id: id})
+                return err
+            },
+            code: codes.FailedPrecondition,
+            want: models.PaymentCaptured,
+        },
+        {
+            name: "void",
+            from: models.PaymentAuthorized,
+            run: func(s *server, ctx context.Context, id int64) error {
+                _, err := s.VoidPayment(ctx, &pb.VoidPaymentRequest{Id: id})
+                return err
+            },
+            want: models.PaymentVoided,
+        },
+        {
+            // A 3-D Secure challenge completed after the void can't authorize the payment
+            name: "void awaiting 3-D Secure",
+            from: models.PaymentRequiresAction,
+            run: func(s *server, ctx context.Context, id int64) error {
+                if _, err := s.VoidPayment(ctx, &pb.VoidPaymentRequest{Id: id}); err != nil {
+                    return err
+                }
+                _, err := s.ConfirmPayment(ctx, &pb.ConfirmPaymentRequest{Id: id})
+                return err
+            },
+            code: codes.FailedPrecondition,
+            want: models.PaymentVoided,
+        },
+        {
+            name: "void after capture",
+            from: models.PaymentCaptured,
+            run: func(s *server, ctx context.Context, id int64) error {
+                _, err := s.VoidPayment(ctx, &pb.VoidPaymentRequest{Id: id})
+                return err
+            },
+            code: codes.FailedPrecondition,
+            want: models.PaymentCaptured,
+        },
+        {
+            name: "refund before capture",
+            from: models.PaymentAuthorized,
+            run: func(s *server, ctx context.Context, id int64) error {
+                _, err := s.RefundPayment(ctx, &pb.RefundPaymentRequest{Id: id})
+                return err
+            },
+            code: codes.FailedPrecondition,
+            want: models.PaymentAuthorized,
+        },
+        {
+            name: "partial refund",
+            from: models.PaymentCaptured,
+            run: func(s *server, ctx context.Context, id int64) error {
+                _, err := s.RefundPayment(ctx, &pb.RefundPaymentRequest{Id: id, Amount: &pb.Money{Amount: 300}})
+                return err
+            },
+            want:     models.PaymentPartiallyRefunded,
+            refunded: 300,
+        },
+        {
+            name: "refund of the rest",
+            from: models.PaymentCaptured,
+            run: func(s *server, ctx context.Context, id int64) error {
+                if _, err := s.RefundPayment(ctx, &pb.RefundPaymentRequest{Id: id, Amount: &pb.Money{Amount: 300}}); err != nil {
+                    return err
+                }
+                _, err := s.RefundPayment(ctx, &pb.RefundPaymentRequest{Id: id})
+                return err
+            },
+            want:     models.PaymentRefunded,
+            refunded: 1000,
+        },
+        {
+            name: "refund of more than captured",
+            from: models.PaymentCaptured,
+            run: func(s *server, ctx context.Context, id int64) error {
+                _, err := s.RefundPayment(ctx, &pb.RefundPaymentRequest{Id: id, Amount: &pb.Money{Amount: 1001}})
+                return err
+            },
+            code: codes.InvalidArgument,
+            want: models.PaymentCaptured,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := &server{db: db, Provider: &flakyProvider{}, OrderServiceClient: &fakeOrders{}}
+            ctx := adminContext(t)
+            payment := createTestPayment(t, s, tt.from)
+
+            if err := tt.run(s, ctx, int64(payment.ID)); status.Code(err) != tt.code {
+                t.Fatalf("error = %v, want %v", err, tt.code)
+            }
+            db.First(&payment, payment.ID)
+            if payment.Status != tt.want || payment.RefundedAmount != tt.refunded {
+                t.Errorf("payment is %s with %d refunded, want %s with %d", payment.Status, payment.RefundedAmount, tt.want, tt.refunded)
+            }
+        })
+    }
+}
+
+func TestOperateRetriesUnderTheSameKey(t *testing.T) {
+    db := testDB(t)
+    gateway := &flakyProvider{err: errors.New("connection reset")}
+    s := &server{db: db, Provider: gateway, OrderServiceClient: &fakeOrders{}}
+    ctx := adminContext(t)
+    payment := createTestPayment(t, s, models.PaymentAuthorized)
+
+    if _, err := s.CapturePayment(ctx, &pb.CapturePaymentRequest{Id: int64(payment.ID)}); status.Code(err) != codes.Unavailable {
+        t.Fatalf("capture error = %v, want %v", err, codes.Unavailable)
+    }
+    // The operation was recorded before the provider failed
+    var txns []models.PaymentTransaction
+    db.Where("payment_id = ?", payment.ID).Find(&txns)
+    if len(txns) != 1 || txns[0].Status != models.TransactionPending {
+        t.Fatalf("transactions = %+v, want one PENDING capture", txns)
+    }
+
+    gateway.err = nil
+    if _, err := s.CapturePayment(ctx, &pb.CapturePaymentRequest{Id: int64(payment.ID)}); err != nil {
+        t.Fatalf("retried capture error = %v", err)
+    }
+    if len(gateway.keys) != 2 || gateway.keys[0] != gateway.keys[1] {
+        t.Errorf("provider keys = %v, want the retry sent under the first key", gateway.keys)
+    }
+    db.Where("payment_id = ?", payment.ID).Find(&txns)
+    if len(txns) != 1 || txns[0].Status != models.TransactionSucceeded {
+        t.Errorf("transactions = %+v, want the one capture SUCCEEDED", txns)
+    }
+}
+
+func TestRefundIdempotencyKey(t *testing.T) {
+    db := testDB(t)
+    gateway := &flakyProvider{err: errors.New("timeout")}
+    s := &server{db: db, Provider: gateway, OrderServiceClient: &fakeOrders{}}
+    ctx := adminContext(t)
+    payment := createTestPayment(t, s, models.PaymentCaptured)
+    refund := &pb.RefundPaymentRequest{Id: int64(payment.ID), Amount: &pb.Money{Amount: 400}, IdempotencyKey: "return-9-refund"}
+
+    if _, err := s.RefundPayment(ctx, refund); status.Code(err) != codes.Unavailable {
+        t.Fatalf("refund error = %v, want %v", err, codes.Unavailable)
+    }
+    // The key can't be reused for another amount while the first is unresolved
+    other := &pb.RefundPaymentRequest{Id: refund.Id, Amount: &pb.Money{Amount: 500}, IdempotencyKey: refund.IdempotencyKey}
+    if _, err := s.RefundPayment(ctx, other); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("refund of another amount error = %v, want %v", err, codes.InvalidArgument)
+    }
+
+    gateway.err = nil
+    for i := 0; i < 2; i++ {
+        if _, err := s.RefundPayment(ctx, refund); err != nil {
+            t.Fatalf("refund %d error = %v", i, err)
+        }
+    }
+    // Sent twice under the caller's key, and made once
+    if len(gateway.keys) != 2 || gateway.keys[0] != "return-9-refund" || gateway.keys[1] != "return-9-refund" {
+        t.Errorf("provider keys = %v, want the caller's key on both calls", gateway.keys)
+    }
+    db.First(&payment, payment.ID)
+    if payment.RefundedAmount != 400 {
+        t.Errorf("refunded = %d, want 400", payment.RefundedAmount)
+    }
+}
+
+func TestDeclineInterruptedPayments(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    create := func(age time.Duration) models.Payment {
+        payment := createTestPayment(t, s, models.PaymentProcessing)
+        txn := models.PaymentTransaction{PaymentID: payment.ID, Kind: models.TransactionAuthorize, Status: models.TransactionPending, Amount: payment.Amount}
+        if err := db.Create(&txn).Error; err != nil {
+            t.Fatalf("failed to create transaction: %v", err)
+        }
+        db.Model(&payment).UpdateColumn("updated_at", time.Now().Add(-age))
+        return payment
+    }
+    interrupted := create(processingTimeout + time.Minute)
+    live := create(time.Second)
+
+    s.declineInterruptedPayments()
+
+    db.Preload("Transactions").First(&interrupted, interrupted.ID)
+    if interrupted.Status != models.PaymentDeclined || interrupted.Transactions[0].Status != models.TransactionFailed {
+        t.Errorf("interrupted payment is %s with its authorization %s, want DECLINED and FAILED", interrupted.Status, interrupted.Transactions[0].Status)
+    }
+    // The order can be paid again
+    if err := checkNoOpenPayment(db, interrupted.OrderID); err != nil {
+        t.Errorf("checkNoOpenPayment error = %v", err)
+    }
+    db.First(&live, live.ID)
+    if live.Status != models.PaymentProcessing {
+        t.Errorf("live payment is %s, want it left PROCESSING", live.Status)
+    }
+}
+
+func TestCaptureRefundsUnconfirmedOrder(t *testing.T) {
+    db := testDB(t)
+    gateway := &flakyProvider{}
+    orders := &fakeOrders{err: status.Errorf(codes.FailedPrecondition, "Order with ID '1' cannot move from CANCELLED to CONFIRMED")}
+    s := &server{db: db, Provider: gateway, OrderServiceClient: orders}
+    payment := createTestPayment(t, s, models.PaymentAuthorized)
+
+    res, err := s.CapturePayment(adminContext(t), &pb.CapturePaymentRequest{Id: int64(payment.ID)})
+    if err != nil {
+        t.Fatalf("capture error = %v", err)
+    }
+    if res.Payment.Status != pb.PaymentStatus_REFUNDED || res.Payment.RefundedAmount.GetAmount() != 1000 {
+        t.Errorf("payment is %s with %v refunded, want REFUNDED in full", res.Payment.Status, res.Payment.RefundedAmount)
+    }
+    db.First(&payment, payment.ID)
+    if !payment.OrderConfirmed {
+        t.Error("payment is left for the confirmation retry, want it settled")
+    }
+
+    // Settling again refunds nothing more
+    s.confirmCapturedOrders()
+    if len(gateway.keys) != 2 {
+        t.Errorf("provider calls = %v, want the capture and one refund", gateway.keys)
+    }
+}
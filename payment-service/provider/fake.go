@@ -0,0 +1,75 @@
+package provider
+
+import (
+    "context"
+    "strings"
+)
+
+// Payment tokens the fake provider recognises. Any other token is approved.
+const (
+    TokenDecline           = "tok_decline"            // Declined as card_declined
+    TokenInsufficientFunds = "tok_insufficient_funds" // Declined as insufficient_funds
+    Token3DS               = "tok_3ds"                // Pending, then approved on confirmation
+    Token3DSFail           = "tok_3ds_fail"           // Pending, then declined on confirmation
+)
+
+// Suffixes of pending authorization references, telling Confirm the outcome
+const (
+    pending3DS     = "_3ds"
+    pending3DSFail = "_3ds_fail"
+)
+
+// Fake is a local gateway for tests and development. It never moves money
+// and answers deterministically from the payment token, keeping no state:
+// everything Confirm needs is in the reference Authorize returned.
+type Fake struct{}
+
+// Name identifies the provider on payments
+func (Fake) Name() string {
+    return "fake"
+}
+
+// Authorize approves, declines or asks for 3-D Secure depending on the token
+func (Fake) Authorize(ctx context.Context, req AuthorizeRequest) (Result, error) {
+    reference := "fake_auth_" + req.Key
+    switch req.Token {
+    case TokenDecline:
+        return Result{Status: Declined, Reference: reference, FailureReason: "card_declined"}, nil
+    case TokenInsufficientFunds:
+        return Result{Status: Declined, Reference: reference, FailureReason: "insufficient_funds"}, nil
+    case Token3DS:
+        reference += pending3DS
+    case Token3DSFail:
+        reference += pending3DSFail
+    default:
+        return Result{Status: Approved, Reference: reference}, nil
+    }
+    return Result{Status: Pending, Reference: reference, ActionURL: "https://fake-gateway.local/3ds/" + reference}, nil
+}
+
+// Confirm completes a pending authorization as its token decided
+func (Fake) Confirm(ctx context.Context, req OperationRequest) (Result, error) {
+    switch {
+    case strings.HasSuffix(req.Reference, pending3DSFail):
+        return Result{Status: Declined, Reference: req.Reference, FailureReason: "authentication_failed"}, nil
+    case strings.HasSuffix(req.Reference, pending3DS):
+        return Result{Status: Approved, Reference: req.Reference}, nil
+    default:
+        return Result{Status: Declined, Reference: req.Reference, FailureReason: "not_pending"}, nil
+    }
+}
+
+// Capture approves every capture
+func (Fake) Capture(ctx context.Context, req OperationRequest) (Result, error) {
+    return Result{Status: Approved, Reference: "fake_capture_" + req.Key}, nil
+}
+
+// Void approves every void
+func (Fake) Void(ctx context.Context, req OperationRequest) (Result, error) {
+    return Result{Status: Approved, Reference: "fake_void_" + req.Key}, nil
+}
+
+// Refund approves every refund
+func (Fake) Refund(ctx context.Context, req OperationRequest) (Result, error) {
+    return Result{Status: Approved, Reference: "fake_refund_" + req.Key}, nil
+}
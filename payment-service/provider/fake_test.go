@@ -0,0 +1,65 @@
+package provider
+
+import (
+    "context"
+    "testing"
+)
+
+func TestFakeAuthorize(t *testing.T) {
+    ctx := context.Background()
+    tests := []struct {
+        token   string
+        status  Status
+        confirm Status
+        reason  string
+    }{
+        {token: "tok_visa", status: Approved},
+        {token: TokenDecline, status: Declined, reason: "card_declined"},
+        {token: TokenInsufficientFunds, status: Declined, reason: "insufficient_funds"},
+        {token: Token3DS, status: Pending, confirm: Approved},
+        {token: Token3DSFail, status: Pending, confirm: Declined, reason: "authentication_failed"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.token, func(t *testing.T) {
+            result, err := Fake{}.Authorize(ctx, AuthorizeRequest{Key: "7", Amount: 1999, Currency: "USD", Token: tt.token})
+            if err != nil {
+                t.Fatalf("Authorize error = %v", err)
+            }
+            if result.Status != tt.status {
+                t.Fatalf("Authorize status = %s, want %s", result.Status, tt.status)
+            }
+            if tt.status != Pending {
+                if result.FailureReason != tt.reason {
+                    t.Errorf("Authorize failure reason = %q, want %q", result.FailureReason, tt.reason)
+                }
+                return
+            }
+
+            if result.ActionURL == "" {
+                t.Errorf("Authorize returned no action URL for a pending result")
+            }
+            confirmed, err := Fake{}.Confirm(ctx, OperationRequest{Key: "8", Reference: result.Reference})
+            if err != nil {
+                t.Fatalf("Confirm error = %v", err)
+            }
+            if confirmed.Status != tt.confirm || confirmed.FailureReason != tt.reason {
+                t.Errorf("Confirm = %s %q, want %s %q", confirmed.Status, confirmed.FailureReason, tt.confirm, tt.reason)
+            }
+        })
+    }
+}
+
+func TestFakeIsDeterministic(t *testing.T) {
+    ctx := context.Background()
+    first, _ := Fake{}.Authorize(ctx, AuthorizeRequest{Key: "7", Token: Token3DS})
+    second, _ := Fake{}.Authorize(ctx, AuthorizeRequest{Key: "7", Token: Token3DS})
+    if first != second {
+        t.Errorf("Authorize with the same key = %+v and %+v, want the same result", first, second)
+    }
+
+    // Confirming an authorization that was never pending is declined
+    approved, _ := Fake{}.Authorize(ctx, AuthorizeRequest{Key: "9", Token: "tok_visa"})
+    if result, _ := (Fake{}).Confirm(ctx, OperationRequest{Reference: approved.Reference}); result.Status != Declined {
+        t.Errorf("Confirm of an approved authorization = %s, want %s", result.Status, Declined)
+    }
+}
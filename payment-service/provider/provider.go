@@ -0,0 +1,64 @@
+package provider
+
+import (
+    "context"
+    "fmt"
+    "os"
+)
+
+// Status is the outcome of an operation at the provider
+type Status string
+
+// Enum values for Status
+const (
+    Approved Status = "APPROVED"
+    Declined Status = "DECLINED"
+    Pending  Status = "PENDING" // The customer has to complete a 3-D Secure challenge first
+)
+
+// AuthorizeRequest asks the provider to hold an amount on a payment method
+type AuthorizeRequest struct {
+    Key      string // Our ID for the operation; providers deduplicate retries by it
+    Amount   int64  // In minor units
+    Currency string
+    Token    string // Payment method token from the provider's client-side tokenization
+}
+
+// OperationRequest asks the provider to act on an authorization
+type OperationRequest struct {
+    Key       string // Our ID for the operation; providers deduplicate retries by it
+    Reference string // The provider's ID for the authorization
+    Amount    int64  // In minor units, for captures and refunds
+    Currency  string
+}
+
+// Result is the provider's answer. Declines are results rather than errors.
+type Result struct {
+    Status        Status
+    Reference     string // The provider's ID for the authorization or operation
+    ActionURL     string // Where the customer completes 3-D Secure, when Pending
+    FailureReason string // Why the operation was declined
+}
+
+// Provider is a payment gateway. An error means the provider couldn't be
+// reached or failed, not that it declined. Implementations must be safe for
+// concurrent use.
+type Provider interface {
+    Name() string
+    Authorize(ctx context.Context, req AuthorizeRequest) (Result, error)
+    Confirm(ctx context.Context, req OperationRequest) (Result, error) // Completes a Pending authorization
+    Capture(ctx context.Context, req OperationRequest) (Result, error)
+    Void(ctx context.Context, req OperationRequest) (Result, error) // Releases an authorization, Pending or not
+    Refund(ctx context.Context, req OperationRequest) (Result, error)
+}
+
+// NewProviderFromEnv creates the provider selected by the PAYMENT_PROVIDER environment variable.
+// "fake" (the default) is the local gateway that never moves money.
+func NewProviderFromEnv() (Provider, error) {
+    switch kind := os.Getenv("PAYMENT_PROVIDER"); kind {
+    case "", "fake":
+        return Fake{}, nil
+    default:
+        return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", kind)
+    }
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "errors"
+    "math"
+    "os"
+    "sort"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "product-service/models"
+)
+
+// Names of the allocation strategies
+const (
+    allocateNearest   = "NEAREST"
+    allocateMostStock = "MOST_STOCK"
+    allocatePriority  = "PRIORITY"
+)
+
+// allocationStrategy orders the stock levels that could fulfil an item, best first
+type allocationStrategy func(levels []models.StockLevel, destination *pb.GeoPoint)
+
+var allocationStrategies = map[string]allocationStrategy{
+    allocateNearest:   byDistance,
+    allocateMostStock: byAvailableStock,
+    allocatePriority:  byPriority,
+}
+
+// byPriority prefers warehouses with the lowest priority value
+func byPriority(levels []models.StockLevel, destination *pb.GeoPoint) {
+    sort.SliceStable(levels, func(i, j int) bool {
+        if levels[i].Warehouse.Priority != levels[j].Warehouse.Priority {
+            return levels[i].Warehouse.Priority < levels[j].Warehouse.Priority
+        }
+        return levels[i].WarehouseID < levels[j].WarehouseID
+    })
+}
+
+// byAvailableStock prefers warehouses with the most available stock
+func byAvailableStock(levels []models.StockLevel, destination *pb.GeoPoint) {
+    byPriority(levels, destination)
+    sort.SliceStable(levels, func(i, j int) bool {
+        return levels[i].Available() > levels[j].Available()
+    })
+}
+
+// byDistance prefers the warehouses closest to the destination, and falls
+// back to priority order when the destination is unknown
+func byDistance(levels []models.StockLevel, destination *pb.GeoPoint) {
+    byPriority(levels, destination)
+    if destination == nil {
+        return
+    }
+    sort.SliceStable(levels, func(i, j int) bool {
+        return distanceKm(levels[i].Warehouse, destination) < distanceKm(levels[j].Warehouse, destination)
+    })
+}
+
+// distanceKm is the great-circle distance between a warehouse and a point
+func distanceKm(warehouse models.Warehouse, point *pb.GeoPoint) float64 {
+    const earthRadiusKm = 6371
+    toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+    lat1, lat2 := toRad(warehouse.Latitude), toRad(point.Latitude)
+    dLat := lat2 - lat1
+    dLon := toRad(point.Longitude - warehouse.Longitude)
+    a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+    return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// lookupAllocationStrategy returns the named strategy, or the one configured
+// with ALLOCATION_STRATEGY when name is empty (PRIORITY if that is unset)
+func lookupAllocationStrategy(name string) (allocationStrategy, error) {
+    if name == "" {
+        name = os.Getenv("ALLOCATION_STRATEGY")
+    }
+    if name == "" {
+        name = allocatePriority
+    }
+    strategy, ok := allocationStrategies[strings.ToUpper(name)]
+    if !ok {
+        return nil, status.Errorf(codes.InvalidArgument, "Unknown allocation strategy '%s'", name)
+    }
+    return strategy, nil
+}
+
+// allocateWarehouse picks the warehouse that fulfils quantity of a product,
+// or of one of its variants. The product row is locked first so the stock
+// levels read here can't change before the caller holds the stock.
+func allocateWarehouse(tx *gorm.DB, productID, variantID uint, quantity int, strategy allocationStrategy, destination *pb.GeoPoint) (uint, error) {
+    var product models.Product
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&product, productID).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return 0, status.Errorf(codes.NotFound, "Product with ID '%d' not found", productID)
+        }
+        return 0, status.Errorf(codes.Internal, "Error retrieving product: %v", err)
+    }
+
+    var levels []models.StockLevel
+    err := tx.Joins("Warehouse").
+        Where("stock_levels.product_id = ? AND stock_levels.variant_id = ? AND \"Warehouse\".active", productID, variantID).
+        Find(&levels).Error
+    if err != nil {
+        return 0, status.Errorf(codes.Internal, "Error retrieving stock levels: %v", err)
+    }
+
+    strategy(levels, destination)
+    for _, level := range levels {
+        if level.Available() >= quantity {
+            return level.WarehouseID, nil
+        }
+    }
+    return 0, status.Errorf(codes.InvalidArgument, "Insufficient inventory")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "math"
+    "reflect"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "gorm.io/gorm"
+    "product-service/models"
+)
+
+var (
+    london = &pb.GeoPoint{Latitude: 51.5074, Longitude: -0.1278}
+    paris  = &pb.GeoPoint{Latitude: 48.8566, Longitude: 2.3522}
+    berlin = &pb.GeoPoint{Latitude: 52.52, Longitude: 13.405}
+)
+
+// testLevel is the stock level of a warehouse at a location
+func testLevel(warehouseID uint, priority int, location *pb.GeoPoint, quantity, reserved int) models.StockLevel {
+    return models.StockLevel{
+        WarehouseID: warehouseID,
+        Warehouse:   models.Warehouse{Model: gorm.Model{ID: warehouseID}, Priority: priority, Latitude: location.Latitude, Longitude: location.Longitude},
+        Quantity:    quantity,
+        Reserved:    reserved,
+    }
+}
+
+func TestAllocationStrategies(t *testing.T) {
+    levels := func() []models.StockLevel {
+        return []models.StockLevel{
+            testLevel(1, 2, berlin, 10, 0),
+            testLevel(2, 1, paris, 8, 6),
+            testLevel(3, 1, london, 5, 0),
+        }
+    }
+    tests := []struct {
+        strategy    string
+        destination *pb.GeoPoint
+        want        []uint
+    }{
+        {strategy: allocatePriority, want: []uint{2, 3, 1}},
+        {strategy: allocateMostStock, want: []uint{1, 3, 2}},
+        {strategy: allocateNearest, destination: london, want: []uint{3, 2, 1}},
+        {strategy: allocateNearest, destination: berlin, want: []uint{1, 2, 3}},
+        {strategy: allocateNearest, want: []uint{2, 3, 1}}, // Without a destination, by priority
+    }
+    for _, tt := range tests {
+        t.Run(tt.strategy, func(t *testing.T) {
+            ordered := levels()
+            allocationStrategies[tt.strategy](ordered, tt.destination)
+            var got []uint
+            for _, level := range ordered {
+                got = append(got, level.WarehouseID)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("warehouses = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDistanceKm(t *testing.T) {
+    warehouse := models.Warehouse{Latitude: london.Latitude, Longitude: london.Longitude}
+    if got := distanceKm(warehouse, london); got != 0 {
+        t.Errorf("distance to itself = %v, want 0", got)
+    }
+    if got := distanceKm(warehouse, paris); math.Abs(got-344) > 1 {
+        t.Errorf("London to Paris = %.0f km, want about 344", got)
+    }
+}
+
+func TestLookupAllocationStrategy(t *testing.T) {
+    tests := []struct {
+        name string
+        env  string
+        want string
+        code codes.Code
+    }{
+        {name: "", want: allocatePriority},
+        {name: "", env: "nearest", want: allocateNearest},
+        {name: "most_stock", env: "NEAREST", want: allocateMostStock},
+        {name: "CHEAPEST", code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Setenv("ALLOCATION_STRATEGY", tt.env)
+        got, err := lookupAllocationStrategy(tt.name)
+        if status.Code(err) != tt.code {
+            t.Errorf("lookupAllocationStrategy(%q) with %q configured error = %v, want %v", tt.name, tt.env, err, tt.code)
+            continue
+        }
+        if err == nil && reflect.ValueOf(got).Pointer() != reflect.ValueOf(allocationStrategies[tt.want]).Pointer() {
+            t.Errorf("lookupAllocationStrategy(%q) with %q configured is not %s", tt.name, tt.env, tt.want)
+        }
+    }
+}
+
+func TestAllocateWarehouse(t *testing.T) {
+    db := testDB(t)
+    near := createTestWarehouse(t, db, "LONDON", 2, london)
+    far := createTestWarehouse(t, db, "BERLIN", 1, berlin)
+    closed := createTestWarehouse(t, db, "PARIS", 0, paris)
+    product := createTestProduct(t, db, map[uint]int{near.ID: 2, far.ID: 5, closed.ID: 9})
+    db.Model(&closed).Update("active", false)
+
+    tests := []struct {
+        name     string
+        strategy string
+        quantity int
+        want     uint
+        code     codes.Code
+    }{
+        {name: "nearest", strategy: allocateNearest, quantity: 2, want: near.ID},
+        {name: "nearest without enough stock", strategy: allocateNearest, quantity: 3, want: far.ID},
+        {name: "priority", strategy: allocatePriority, quantity: 1, want: far.ID},
+        {name: "more than any open warehouse holds", strategy: allocateMostStock, quantity: 6, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := allocateWarehouse(db, product.ID, 0, tt.quantity, allocationStrategies[tt.strategy], paris)
+            if status.Code(err) != tt.code || got != tt.want {
+                t.Errorf("allocateWarehouse = %d, %v, want %d, %v", got, err, tt.want, tt.code)
+            }
+        })
+    }
+
+    if _, err := allocateWarehouse(db, product.ID+1000, 0, 1, byPriority, nil); status.Code(err) != codes.NotFound {
+        t.Errorf("allocateWarehouse of a missing product error = %v, want %v", err, codes.NotFound)
+    }
+}
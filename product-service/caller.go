@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "strconv"
+
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    "google.golang.org/grpc/metadata"
+)
+
+// serviceToken is the SERVICE_TOKEN other services prove their calls with.
+// Forwarded users are only trusted on calls that carry it.
+var serviceToken string
+
+// userIDMetadataKey is the metadata key rest-service uses to forward the authenticated user
+const userIDMetadataKey = "x-user-id"
+
+// actorIDFromContext returns the forwarded user, or 0 for calls from other services
+func actorIDFromContext(ctx context.Context) uint {
+    if !serviceauth.Verified(ctx, serviceToken) {
+        return 0
+    }
+    md, ok := metadata.FromIncomingContext(ctx)
+    if !ok {
+        return 0
+    }
+    ids := md.Get(userIDMetadataKey)
+    if len(ids) == 0 {
+        return 0
+    }
+    id, err := strconv.ParseUint(ids[0], 10, 64)
+    if err != nil {
+        return 0
+    }
+    return uint(id)
+}
+
+// customerGroupMetadataKey is the metadata key rest-service uses to forward
+// the customer group of the authenticated user
+const customerGroupMetadataKey = "x-customer-group"
+
+// customerGroupFromContext returns the forwarded customer group, or "" for
+// anonymous users and customers outside any group
+func customerGroupFromContext(ctx context.Context) string {
+    if !serviceauth.Verified(ctx, serviceToken) {
+        return ""
+    }
+    md, ok := metadata.FromIncomingContext(ctx)
+    if !ok {
+        return ""
+    }
+    if groups := md.Get(customerGroupMetadataKey); len(groups) > 0 {
+        return groups[0]
+    }
+    return ""
+}
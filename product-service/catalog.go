@@ -0,0 +1,464 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "product-service/models"
+)
+
+// Sort orders of product listings
+const (
+    sortRelevance = "RELEVANCE"
+    sortPriceAsc  = "PRICE_ASC"
+    sortPriceDesc = "PRICE_DESC"
+    sortNewest    = "NEWEST"
+    sortName      = "NAME"
+)
+
+// Filter dimensions of product listings; each facet is named after its dimension
+const (
+    facetSearch       = "search"
+    facetCategory     = "category"
+    facetPrice        = "price"
+    facetAvailability = "availability"
+    // attributeFacetPrefix prefixes the dimensions of variant options, e.g. "attribute:size"
+    attributeFacetPrefix = "attribute:"
+)
+
+// listPriceSQL is the price a product is listed at: the lowest price of its
+// variants, or its own price when it has none. Price filters, price facets and
+// price sorting all use it.
+const listPriceSQL = `COALESCE((SELECT MIN(COALESCE(v.price_override, products.price)) FROM variants v
+    WHERE v.product_id = products.id AND v.deleted_at IS NULL), products.price)`
+
+// priceBuckets are the upper bounds of the price facet's ranges in major
+// currency units; the last range is open-ended
+var priceBuckets = []int64{25, 50, 100, 250, 500}
+
+// productFilter is one condition of a product listing
+type productFilter struct {
+    dimension string
+    apply     func(db *gorm.DB) *gorm.DB
+}
+
+// productHit is a product on a page of a listing with its sort keys. Rank
+// and highlights are only set for searches.
+type productHit struct {
+    ID                   uint
+    Name                 string
+    CreatedAt            time.Time
+    ListPrice            int64
+    Rank                 float32
+    NameHighlight        string
+    DescriptionHighlight string
+}
+
+// newProductFilters turns the filters of a listing request into conditions
+func newProductFilters(db *gorm.DB, req *pb.ListProductsRequest) ([]productFilter, error) {
+    var filters []productFilter
+    if req.SearchKeyword != "" {
+        filters = append(filters, productFilter{facetSearch, func(db *gorm.DB) *gorm.DB {
+            return searchMatch(db, req.SearchKeyword)
+        }})
+    }
+
+    if len(req.Categories) > 0 {
+        categoryIDs, err := categoryTreeIDs(db, req.Categories)
+        if err != nil {
+            return nil, status.Errorf(codes.Internal, "Error retrieving categories: %v", err)
+        }
+        filters = append(filters, productFilter{facetCategory, func(query *gorm.DB) *gorm.DB {
+            return query.Where("products.id IN (?)", db.Table("product_categories").Select("product_id").Where("category_id IN ?", categoryIDs))
+        }})
+    }
+
+    currency, err := priceCurrency(req)
+    if err != nil {
+        return nil, err
+    }
+    if (req.MinPrice != nil && req.MinPrice.Amount < 0) || (req.MaxPrice != nil && req.MaxPrice.Amount < 0) ||
+        (req.MinPrice != nil && req.MaxPrice != nil && req.MinPrice.Amount > req.MaxPrice.Amount) {
+        return nil, status.Errorf(codes.InvalidArgument, "Invalid price range")
+    }
+    if req.MinPrice != nil || req.MaxPrice != nil {
+        filters = append(filters, productFilter{facetPrice, func(db *gorm.DB) *gorm.DB {
+            db = db.Where("products.currency = ?", currency)
+            if req.MinPrice != nil {
+                db = db.Where(listPriceSQL+" >= ?", req.MinPrice.Amount)
+            }
+            if req.MaxPrice != nil {
+                db = db.Where(listPriceSQL+" <= ?", req.MaxPrice.Amount)
+            }
+            return db
+        }})
+    }
+
+    if req.InStock {
+        filters = append(filters, productFilter{facetAvailability, func(db *gorm.DB) *gorm.DB {
+            return db.Where("products.quantity - products.reserved > 0")
+        }})
+    }
+
+    for _, attribute := range req.Attributes {
+        name := strings.ToLower(strings.TrimSpace(attribute.Name))
+        if name == "" || len(attribute.Values) == 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Attribute filters need a name and at least one value")
+        }
+        values := make([]string, 0, len(attribute.Values))
+        for _, value := range attribute.Values {
+            values = append(values, strings.ToLower(strings.TrimSpace(value)))
+        }
+        filters = append(filters, productFilter{attributeFacetPrefix + name, func(db *gorm.DB) *gorm.DB {
+            return db.Where(`EXISTS (SELECT 1 FROM variants v
+                JOIN variant_options vo ON vo.variant_id = v.id AND vo.deleted_at IS NULL
+                JOIN product_options po ON po.id = vo.option_id
+                WHERE v.product_id = products.id AND v.deleted_at IS NULL AND LOWER(po.name) = ? AND LOWER(vo.value) IN ?)`, name, values)
+        }})
+    }
+    return filters, nil
+}
+
+// priceCurrency returns the currency of a listing's price bounds, which is
+// the store currency unless they name one. The price facet is counted in it.
+func priceCurrency(req *pb.ListProductsRequest) (string, error) {
+    currency := ""
+    for _, bound := range []*pb.Money{req.MinPrice, req.MaxPrice} {
+        if bound == nil || bound.Currency == "" {
+            continue
+        }
+        normalized, err := money.Normalize(bound.Currency)
+        if err != nil {
+            return "", status.Errorf(codes.InvalidArgument, "Unknown currency '%s'", bound.Currency)
+        }
+        if currency != "" && normalized != currency {
+            return "", status.Errorf(codes.InvalidArgument, "Price bounds must be in one currency")
+        }
+        currency = normalized
+    }
+    if currency == "" && req.Currency != "" {
+        normalized, err := money.Normalize(req.Currency)
+        if err != nil {
+            return "", status.Errorf(codes.InvalidArgument, "Unknown currency '%s'", req.Currency)
+        }
+        currency = normalized
+    }
+    if currency == "" {
+        currency = money.StoreCurrency()
+    }
+    return currency, nil
+}
+
+// filteredProducts selects the products matching every filter except those
+// of the excluded dimension
+func (s *server) filteredProducts(filters []productFilter, except string) *gorm.DB {
+    query := s.db.Model(&models.Product{})
+    for _, filter := range filters {
+        if filter.dimension != except {
+            query = filter.apply(query)
+        }
+    }
+    return query
+}
+
+// productSort is a sort order of listings: its ORDER BY clause, and the
+// condition selecting the products that come after a cursor in that order
+type productSort struct {
+    order string
+    after func(db *gorm.DB, cursor *productCursor) *gorm.DB
+}
+
+var productSorts = map[string]productSort{
+    sortRelevance: {"rank DESC, products.id", func(db *gorm.DB, c *productCursor) *gorm.DB {
+        return db.Where("(ts_rank_cd(products.search_vector, q) < ? OR (ts_rank_cd(products.search_vector, q) = ? AND products.id > ?))", c.Rank, c.Rank, c.ID)
+    }},
+    sortPriceAsc: {listPriceSQL + " ASC, products.id", func(db *gorm.DB, c *productCursor) *gorm.DB {
+        return db.Where("("+listPriceSQL+", products.id) > (?, ?)", c.Price, c.ID)
+    }},
+    sortPriceDesc: {listPriceSQL + " DESC, products.id", func(db *gorm.DB, c *productCursor) *gorm.DB {
+        return db.Where("("+listPriceSQL+" < ? OR ("+listPriceSQL+" = ? AND products.id > ?))", c.Price, c.Price, c.ID)
+    }},
+    sortNewest: {"products.created_at DESC, products.id DESC", func(db *gorm.DB, c *productCursor) *gorm.DB {
+        return db.Where("(products.created_at, products.id) < (?, ?)", c.CreatedAt, c.ID)
+    }},
+    sortName: {"products.name, products.id", func(db *gorm.DB, c *productCursor) *gorm.DB {
+        return db.Where("(products.name, products.id) > (?, ?)", c.Name, c.ID)
+    }},
+}
+
+// lookupProductSort returns the named sort order of a listing. Relevance
+// needs a search keyword, so without one listings fall back to the newest first.
+func lookupProductSort(name, keyword string) (productSort, error) {
+    name = strings.ToUpper(name)
+    if name == "" || (name == sortRelevance && keyword == "") {
+        if keyword != "" {
+            name = sortRelevance
+        } else {
+            name = sortNewest
+        }
+    }
+    sort, ok := productSorts[name]
+    if !ok {
+        return productSort{}, status.Errorf(codes.InvalidArgument, "Unknown sort order '%s'", name)
+    }
+    return sort, nil
+}
+
+// productPage loads one page of the products a query selects, in sort order,
+// with the search highlights of each when a keyword is given. The page starts
+// after the cursor when there is one, and at offset otherwise. The returned
+// cursor points past the last product, or is nil on the last page.
+func (s *server) productPage(query *gorm.DB, keyword string, sort productSort, cursor *productCursor, offset, limit int) ([]models.Product, []*pb.SearchHighlight, *productCursor, error) {
+    columns := "products.id, products.name, products.created_at, " + listPriceSQL + " AS list_price"
+    if keyword != "" {
+        query = withSearchRank(query, keyword, columns)
+    } else {
+        query = query.Select(columns)
+    }
+    if cursor != nil {
+        query = sort.after(query, cursor)
+    } else {
+        query = query.Offset(offset)
+    }
+
+    // One extra row tells whether there is another page
+    var hits []productHit
+    if err := query.Order(sort.order).Limit(limit + 1).Scan(&hits).Error; err != nil {
+        return nil, nil, nil, status.Errorf(codes.Internal, "Error retrieving products: %v", err)
+    }
+    var next *productCursor
+    if len(hits) > limit {
+        hits = hits[:limit]
+        last := hits[limit-1]
+        next = &productCursor{ID: last.ID, Name: last.Name, Price: last.ListPrice, Rank: last.Rank, CreatedAt: last.CreatedAt}
+    }
+    if len(hits) == 0 {
+        return nil, nil, nil, nil
+    }
+
+    ids := make([]uint, 0, len(hits))
+    for _, hit := range hits {
+        ids = append(ids, hit.ID)
+    }
+    var found []models.Product
+    if err := s.db.Preload("Categories").Where("id IN ?", ids).Find(&found).Error; err != nil {
+        return nil, nil, nil, status.Errorf(codes.Internal, "Error retrieving products: %v", err)
+    }
+    byID := make(map[uint]models.Product, len(found))
+    for _, product := range found {
+        byID[product.ID] = product
+    }
+
+    // Keep the products in the order of the page
+    var products []models.Product
+    var highlights []*pb.SearchHighlight
+    for _, hit := range hits {
+        product, ok := byID[hit.ID]
+        if !ok {
+            continue
+        }
+        products = append(products, product)
+        if keyword != "" {
+            highlights = append(highlights, searchHighlight(hit))
+        }
+    }
+    return products, highlights, next, nil
+}
+
+// productFacets counts the products matching each value of every filter
+// dimension. The counts of a dimension ignore that dimension's own filter, so
+// picking one category still shows how many products the others have.
+func (s *server) productFacets(filters []productFilter, currency string) ([]*pb.Facet, error) {
+    priceFacet := func(filters []productFilter) (*pb.Facet, error) {
+        return s.priceFacet(filters, currency)
+    }
+    var facets []*pb.Facet
+    for _, build := range []func([]productFilter) (*pb.Facet, error){s.categoryFacet, priceFacet, s.availabilityFacet} {
+        facet, err := build(filters)
+        if err != nil {
+            return nil, status.Errorf(codes.Internal, "Error counting products: %v", err)
+        }
+        facets = append(facets, facet)
+    }
+
+    attributes, err := s.attributeFacets(filters)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error counting products: %v", err)
+    }
+    return append(facets, attributes...), nil
+}
+
+// facetCount is the number of products having one value of a dimension
+type facetCount struct {
+    Name  string
+    Value string
+    Count int64
+}
+
+// categoryFacet counts products per category; like the category filter, a
+// category counts the products of its descendants too
+func (s *server) categoryFacet(filters []productFilter) (*pb.Facet, error) {
+    var counts []facetCount
+    err := s.db.Raw(`WITH RECURSIVE ancestry AS (
+            SELECT id AS category_id, id AS ancestor_id FROM categories WHERE deleted_at IS NULL
+            UNION
+            SELECT a.category_id, c.parent_id FROM ancestry a JOIN categories c ON c.id = a.ancestor_id
+            WHERE c.parent_id IS NOT NULL AND c.deleted_at IS NULL
+        )
+        SELECT c.slug AS value, COUNT(DISTINCT pc.product_id) AS count
+        FROM product_categories pc
+        JOIN ancestry a ON a.category_id = pc.category_id
+        JOIN categories c ON c.id = a.ancestor_id AND c.deleted_at IS NULL
+        WHERE pc.product_id IN (?)
+        GROUP BY c.slug
+        ORDER BY count DESC, c.slug`, s.filteredProducts(filters, facetCategory).Select("products.id")).
+        Scan(&counts).Error
+    if err != nil {
+        return nil, err
+    }
+
+    facet := &pb.Facet{Name: facetCategory}
+    for _, count := range counts {
+        facet.Values = append(facet.Values, &pb.FacetValue{Value: count.Value, Count: count.Count})
+    }
+    return facet, nil
+}
+
+// priceFacet counts the products priced in a currency per price range
+func (s *server) priceFacet(filters []productFilter, currency string) (*pb.Facet, error) {
+    scale, err := money.Scale(currency)
+    if err != nil {
+        return nil, err
+    }
+    bounds := make([]string, 0, len(priceBuckets))
+    for _, bound := range priceBuckets {
+        bounds = append(bounds, fmt.Sprint(bound*scale))
+    }
+
+    // width_bucket numbers the ranges from 0, below the first bound, upwards
+    var counts []struct {
+        Bucket int
+        Count  int64
+    }
+    err = s.filteredProducts(filters, facetPrice).
+        Where("products.currency = ?", currency).
+        Select(fmt.Sprintf("width_bucket(%s, ARRAY[%s]::bigint[]) AS bucket, COUNT(*) AS count", listPriceSQL, strings.Join(bounds, ","))).
+        Group("bucket").
+        Order("bucket").
+        Scan(&counts).Error
+    if err != nil {
+        return nil, err
+    }
+
+    facet := &pb.Facet{Name: facetPrice}
+    for _, count := range counts {
+        var min int64
+        if count.Bucket > 0 {
+            min = priceBuckets[count.Bucket-1]
+        }
+        value := &pb.FacetValue{Count: count.Count, Min: &pb.Money{Amount: min * scale, Currency: currency}}
+        if count.Bucket < len(priceBuckets) {
+            max := priceBuckets[count.Bucket]
+            value.Max = &pb.Money{Amount: max * scale, Currency: currency}
+            value.Value = fmt.Sprintf("%d-%d", min, max)
+        } else {
+            value.Value = fmt.Sprintf("%d+", min)
+        }
+        facet.Values = append(facet.Values, value)
+    }
+    return facet, nil
+}
+
+// availabilityFacet counts products with and without available stock
+func (s *server) availabilityFacet(filters []productFilter) (*pb.Facet, error) {
+    var counts []struct {
+        InStock bool
+        Count   int64
+    }
+    err := s.filteredProducts(filters, facetAvailability).
+        Select("products.quantity - products.reserved > 0 AS in_stock, COUNT(*) AS count").
+        Group("in_stock").
+        Order("in_stock DESC").
+        Scan(&counts).Error
+    if err != nil {
+        return nil, err
+    }
+
+    facet := &pb.Facet{Name: facetAvailability}
+    for _, count := range counts {
+        value := "out_of_stock"
+        if count.InStock {
+            value = "in_stock"
+        }
+        facet.Values = append(facet.Values, &pb.FacetValue{Value: value, Count: count.Count})
+    }
+    return facet, nil
+}
+
+// attributeFacets counts products per value of each variant option. Options
+// are matched by name ignoring case, so "Size" and "size" form one facet.
+func (s *server) attributeFacets(filters []productFilter) ([]*pb.Facet, error) {
+    count := func(query *gorm.DB) ([]facetCount, error) {
+        var counts []facetCount
+        err := s.db.Raw(`SELECT LOWER(po.name) AS name, vo.value AS value, COUNT(DISTINCT v.product_id) AS count
+            FROM variant_options vo
+            JOIN variants v ON v.id = vo.variant_id AND v.deleted_at IS NULL
+            JOIN product_options po ON po.id = vo.option_id
+            WHERE vo.deleted_at IS NULL AND v.product_id IN (?)
+            GROUP BY LOWER(po.name), vo.value
+            ORDER BY name, count DESC, value`, query.Select("products.id")).
+            Scan(&counts).Error
+        return counts, err
+    }
+
+    // Options without a filter are counted under every filter at once, and
+    // each filtered option separately without its own filter
+    counts, err := count(s.filteredProducts(filters, ""))
+    if err != nil {
+        return nil, err
+    }
+    filtered := make(map[string]bool)
+    for _, filter := range filters {
+        if strings.HasPrefix(filter.dimension, attributeFacetPrefix) {
+            filtered[strings.TrimPrefix(filter.dimension, attributeFacetPrefix)] = true
+        }
+    }
+    var all []facetCount
+    for _, c := range counts {
+        if !filtered[c.Name] {
+            all = append(all, c)
+        }
+    }
+    for name := range filtered {
+        counts, err := count(s.filteredProducts(filters, attributeFacetPrefix+name))
+        if err != nil {
+            return nil, err
+        }
+        for _, c := range counts {
+            if c.Name == name {
+                all = append(all, c)
+            }
+        }
+    }
+
+    var facets []*pb.Facet
+    byName := make(map[string]*pb.Facet)
+    for _, c := range all {
+        facet, ok := byName[c.Name]
+        if !ok {
+            facet = &pb.Facet{Name: c.Name, Attribute: true}
+            byName[c.Name] = facet
+            facets = append(facets, facet)
+        }
+        facet.Values = append(facet.Values, &pb.FacetValue{Value: c.Value, Count: c.Count})
+    }
+    sort.SliceStable(facets, func(i, j int) bool { return facets[i].Name < facets[j].Name })
+    return facets, nil
+}
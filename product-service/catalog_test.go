@@ -0,0 +1,194 @@
+package main
+
+import (
+    "context"
+    "reflect"
+    "testing"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "product-service/models"
+)
+
+func TestPriceCurrency(t *testing.T) {
+    t.Setenv("CURRENCY", "")
+    tests := []struct {
+        name string
+        req  *pb.ListProductsRequest
+        want string
+        code codes.Code
+    }{
+        {name: "store currency", req: &pb.ListProductsRequest{}, want: money.DefaultCurrency},
+        {name: "listing currency", req: &pb.ListProductsRequest{Currency: "eur"}, want: "EUR"},
+        {name: "bound currency", req: &pb.ListProductsRequest{Currency: "EUR", MaxPrice: &pb.Money{Amount: 100, Currency: "gbp"}}, want: "GBP"},
+        {name: "bounds without a currency", req: &pb.ListProductsRequest{MinPrice: &pb.Money{Amount: 100}}, want: money.DefaultCurrency},
+        {name: "bounds in two currencies", req: &pb.ListProductsRequest{MinPrice: &pb.Money{Currency: "EUR"}, MaxPrice: &pb.Money{Currency: "USD"}}, code: codes.InvalidArgument},
+        {name: "unknown currency", req: &pb.ListProductsRequest{Currency: "XYZ"}, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := priceCurrency(tt.req)
+            if got != tt.want || status.Code(err) != tt.code {
+                t.Errorf("priceCurrency = %q, %v, want %q, %v", got, err, tt.want, tt.code)
+            }
+        })
+    }
+}
+
+func TestNewProductFilters(t *testing.T) {
+    tests := []struct {
+        name       string
+        req        *pb.ListProductsRequest
+        dimensions []string
+        code       codes.Code
+    }{
+        {name: "none", req: &pb.ListProductsRequest{}},
+        {
+            name: "all but categories",
+            req: &pb.ListProductsRequest{
+                SearchKeyword: "shoe",
+                MinPrice:      &pb.Money{Amount: 1000},
+                InStock:       true,
+                Attributes:    []*pb.AttributeFilter{{Name: " Size ", Values: []string{"M"}}},
+            },
+            dimensions: []string{facetSearch, facetPrice, facetAvailability, "attribute:size"},
+        },
+        {name: "negative price", req: &pb.ListProductsRequest{MaxPrice: &pb.Money{Amount: -1}}, code: codes.InvalidArgument},
+        {name: "empty price range", req: &pb.ListProductsRequest{MinPrice: &pb.Money{Amount: 500}, MaxPrice: &pb.Money{Amount: 100}}, code: codes.InvalidArgument},
+        {name: "attribute without values", req: &pb.ListProductsRequest{Attributes: []*pb.AttributeFilter{{Name: "size"}}}, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            // Only category filters look anything up
+            filters, err := newProductFilters(nil, tt.req)
+            if status.Code(err) != tt.code {
+                t.Fatalf("newProductFilters error = %v, want %v", err, tt.code)
+            }
+            var dimensions []string
+            for _, filter := range filters {
+                dimensions = append(dimensions, filter.dimension)
+            }
+            if !reflect.DeepEqual(dimensions, tt.dimensions) {
+                t.Errorf("filter dimensions = %v, want %v", dimensions, tt.dimensions)
+            }
+        })
+    }
+}
+
+// facetCounts flattens the facets of a listing to counts by facet and value
+func facetCounts(facets []*pb.Facet) map[string]map[string]int64 {
+    counts := make(map[string]map[string]int64, len(facets))
+    for _, facet := range facets {
+        counts[facet.Name] = make(map[string]int64, len(facet.Values))
+        for _, value := range facet.Values {
+            counts[facet.Name][value.Value] = value.Count
+        }
+    }
+    return counts
+}
+
+func TestProductFacets(t *testing.T) {
+    t.Setenv("CURRENCY", "USD")
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+
+    category := func(name string) int64 {
+        res, err := s.CreateCategory(ctx, &pb.CreateCategoryRequest{Name: name})
+        if err != nil {
+            t.Fatalf("CreateCategory error = %v", err)
+        }
+        return res.Category.Id
+    }
+    shoes, socks := category("Shoes"), category("Socks")
+    product := func(price int64, stock int, categoryID int64) models.Product {
+        product := createTestProduct(t, db, map[uint]int{0: stock})
+        db.Model(&product).Update("price", price)
+        if _, err := s.SetProductCategories(ctx, &pb.SetProductCategoriesRequest{ProductId: int64(product.ID), CategoryIds: []int64{categoryID}}); err != nil {
+            t.Fatalf("SetProductCategories error = %v", err)
+        }
+        return product
+    }
+    product(2000, 3, shoes)  // In stock at 20.00
+    product(6000, 0, shoes)  // Out of stock at 60.00
+    sock := product(800, 0, socks)
+
+    // The socks come in sizes S and M, only S in stock
+    if _, err := s.SetProductOptions(ctx, &pb.SetProductOptionsRequest{ProductId: int64(sock.ID), Options: []*pb.ProductOption{{Name: "Size", Values: []string{"S", "M"}}}}); err != nil {
+        t.Fatalf("SetProductOptions error = %v", err)
+    }
+    var small uint
+    for _, size := range []string{"S", "M"} {
+        res, err := s.AddVariant(ctx, &pb.AddVariantRequest{ProductId: int64(sock.ID), Sku: "SOCK-" + size, Options: []*pb.VariantOption{{Name: "Size", Value: size}}})
+        if err != nil {
+            t.Fatalf("AddVariant error = %v", err)
+        }
+        if small == 0 {
+            small = uint(res.Product.Variants[0].Id)
+        }
+    }
+    if _, err := adjustStock(db, sock.ID, small, 0, 2, 0, "", movementSource{Reason: models.MovementRestock}); err != nil {
+        t.Fatalf("adjustStock error = %v", err)
+    }
+
+    tests := []struct {
+        name     string
+        req      *pb.ListProductsRequest
+        products int64
+        want     map[string]map[string]int64
+    }{
+        {
+            name:     "unfiltered",
+            req:      &pb.ListProductsRequest{},
+            products: 3,
+            want: map[string]map[string]int64{
+                facetCategory:     {"shoes": 2, "socks": 1},
+                facetPrice:        {"0-25": 2, "50-100": 1},
+                facetAvailability: {"in_stock": 2, "out_of_stock": 1},
+                "size":            {"S": 1, "M": 1},
+            },
+        },
+        {
+            // Each facet ignores its own filter, so the other values stay selectable
+            name:     "shoes in stock",
+            req:      &pb.ListProductsRequest{Categories: []string{"shoes"}, InStock: true},
+            products: 1,
+            want: map[string]map[string]int64{
+                facetCategory:     {"shoes": 1, "socks": 1},
+                facetPrice:        {"0-25": 1},
+                facetAvailability: {"in_stock": 1, "out_of_stock": 1},
+                "size":            {},
+            },
+        },
+        {
+            name:     "size M up to 25.00",
+            req:      &pb.ListProductsRequest{Attributes: []*pb.AttributeFilter{{Name: "size", Values: []string{"m"}}}, MaxPrice: &pb.Money{Amount: 2500}},
+            products: 1,
+            want: map[string]map[string]int64{
+                facetCategory:     {"socks": 1},
+                facetPrice:        {"0-25": 1},
+                facetAvailability: {"in_stock": 1},
+                "size":            {"S": 1, "M": 1},
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            res, err := s.ListProducts(ctx, tt.req)
+            if err != nil {
+                t.Fatalf("ListProducts error = %v", err)
+            }
+            if res.TotalCount != tt.products {
+                t.Errorf("listed %d products, want %d", res.TotalCount, tt.products)
+            }
+            got := facetCounts(res.Facets)
+            for name, want := range tt.want {
+                if len(got[name]) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got[name], want)) {
+                    t.Errorf("facet %s = %v, want %v", name, got[name], want)
+                }
+            }
+        })
+    }
+}
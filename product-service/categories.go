@@ -0,0 +1,347 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "product-service/models"
+)
+
+var (
+    slugPattern    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+    slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// slugify derives a slug from a category name, e.g. "Men's Shoes" -> "men-s-shoes"
+func slugify(name string) string {
+    return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// categoryTreeIDs returns the IDs of the categories with the given slugs and
+// of all their descendants
+func categoryTreeIDs(db *gorm.DB, slugs []string) ([]uint, error) {
+    var ids []uint
+    err := db.Raw(`WITH RECURSIVE tree AS (
+            SELECT id FROM categories WHERE slug IN ? AND deleted_at IS NULL
+            UNION
+            SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id WHERE c.deleted_at IS NULL
+        ) SELECT id FROM tree`, slugs).Scan(&ids).Error
+    return ids, err
+}
+
+// categoryParents maps every category to its parent, 0 for top-level categories
+func categoryParents(db *gorm.DB) (map[uint]uint, error) {
+    var all []models.Category
+    if err := db.Select("id", "parent_id").Find(&all).Error; err != nil {
+        return nil, err
+    }
+    parents := make(map[uint]uint, len(all))
+    for _, category := range all {
+        parents[category.ID] = 0
+        if category.ParentID != nil {
+            parents[category.ID] = *category.ParentID
+        }
+    }
+    return parents, nil
+}
+
+// categoryLineage returns the IDs of the categories and all their ancestors
+func categoryLineage(parents map[uint]uint, categories []models.Category) []int64 {
+    seen := make(map[uint]bool)
+    var ids []int64
+    for _, category := range categories {
+        for id := category.ID; id != 0 && !seen[id]; id = parents[id] {
+            seen[id] = true
+            ids = append(ids, int64(id))
+        }
+    }
+    return ids
+}
+
+// validateCategory checks the name, slug and parent of a category being saved.
+// A category can't be moved under itself or one of its descendants.
+func validateCategory(tx *gorm.DB, category *models.Category) error {
+    if strings.TrimSpace(category.Name) == "" {
+        return status.Errorf(codes.InvalidArgument, "Category name is required")
+    }
+    if category.Slug == "" {
+        category.Slug = slugify(category.Name)
+    }
+    if !slugPattern.MatchString(category.Slug) {
+        return status.Errorf(codes.InvalidArgument, "Invalid category slug '%s'", category.Slug)
+    }
+
+    var conflicts int64
+    if err := tx.Model(&models.Category{}).Where("slug = ? AND id <> ?", category.Slug, category.ID).Count(&conflicts).Error; err != nil {
+        return status.Errorf(codes.Internal, "Error retrieving category: %v", err)
+    }
+    if conflicts > 0 {
+        return status.Errorf(codes.AlreadyExists, "Category with slug '%s' already exists", category.Slug)
+    }
+
+    // Walk up from the new parent; meeting the category itself means a cycle
+    for parentID := category.ParentID; parentID != nil; {
+        if category.ID != 0 && *parentID == category.ID {
+            return status.Errorf(codes.InvalidArgument, "Category can't be moved under itself or its descendants")
+        }
+        var parent models.Category
+        if err := tx.Select("id", "parent_id").First(&parent, *parentID).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Parent category with ID '%d' not found", *parentID)
+            }
+            return status.Errorf(codes.Internal, "Error retrieving category: %v", err)
+        }
+        parentID = parent.ParentID
+    }
+    return nil
+}
+
+// parentIDFromProto converts a protobuf parent ID, where 0 means top-level
+func parentIDFromProto(id int64) *uint {
+    if id == 0 {
+        return nil
+    }
+    parentID := uint(id)
+    return &parentID
+}
+
+func (s *server) CreateCategory(ctx context.Context, req *pb.CreateCategoryRequest) (*pb.CategoryResponse, error) {
+    category := models.Category{
+        Name:     strings.TrimSpace(req.Name),
+        Slug:     req.Slug,
+        ParentID: parentIDFromProto(req.ParentId),
+    }
+
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := validateCategory(tx, &category); err != nil {
+            return err
+        }
+        return tx.Create(&category).Error
+    })
+    if err != nil {
+        return nil, categoryError(err)
+    }
+
+    return &pb.CategoryResponse{Category: toProtoCategory(category)}, nil
+}
+
+func (s *server) GetCategory(ctx context.Context, req *pb.GetCategoryRequest) (*pb.CategoryResponse, error) {
+    category, err := s.findCategory(s.db, req.Id, req.Slug)
+    if err != nil {
+        return nil, err
+    }
+
+    // Load the whole tree once and nest the category's descendants
+    var all []models.Category
+    if err := s.db.Order("name").Find(&all).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving categories: %v", err)
+    }
+    category.Children = buildCategoryTree(all, &category.ID)
+
+    return &pb.CategoryResponse{Category: toProtoCategory(*category)}, nil
+}
+
+func (s *server) UpdateCategory(ctx context.Context, req *pb.UpdateCategoryRequest) (*pb.CategoryResponse, error) {
+    var category *models.Category
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        category, err = s.findCategory(tx.Clauses(clause.Locking{Strength: "UPDATE"}), req.Id, "")
+        if err != nil {
+            return err
+        }
+
+        category.Name = strings.TrimSpace(req.Name)
+        category.Slug = req.Slug
+        category.ParentID = parentIDFromProto(req.ParentId)
+        if err := validateCategory(tx, category); err != nil {
+            return err
+        }
+        return tx.Select("name", "slug", "parent_id").Save(category).Error
+    })
+    if err != nil {
+        return nil, categoryError(err)
+    }
+
+    return &pb.CategoryResponse{Category: toProtoCategory(*category)}, nil
+}
+
+func (s *server) DeleteCategory(ctx context.Context, req *pb.DeleteCategoryRequest) (*pb.DeleteCategoryResponse, error) {
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        category, err := s.findCategory(tx, req.Id, "")
+        if err != nil {
+            return err
+        }
+
+        // Children would be orphaned, so they have to be moved or deleted first
+        var children int64
+        if err := tx.Model(&models.Category{}).Where("parent_id = ?", category.ID).Count(&children).Error; err != nil {
+            return err
+        }
+        if children > 0 {
+            return status.Errorf(codes.FailedPrecondition, "Category with ID '%d' still has subcategories", category.ID)
+        }
+
+        if err := tx.Exec("DELETE FROM product_categories WHERE category_id = ?", category.ID).Error; err != nil {
+            return err
+        }
+        return tx.Delete(category).Error
+    })
+    if err != nil {
+        return nil, categoryError(err)
+    }
+
+    return &pb.DeleteCategoryResponse{Success: true}, nil
+}
+
+func (s *server) ListCategories(ctx context.Context, req *pb.ListCategoriesRequest) (*pb.ListCategoriesResponse, error) {
+    var all []models.Category
+    if err := s.db.Order("name").Find(&all).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving categories: %v", err)
+    }
+
+    res := &pb.ListCategoriesResponse{}
+    for _, category := range buildCategoryTree(all, nil) {
+        res.Categories = append(res.Categories, toProtoCategory(category))
+    }
+    return res, nil
+}
+
+func (s *server) SetProductCategories(ctx context.Context, req *pb.SetProductCategoriesRequest) (*pb.ProductResponse, error) {
+    var product models.Product
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, req.ProductId).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.ProductId)
+            }
+            return err
+        }
+
+        categories := []models.Category{}
+        if len(req.CategoryIds) > 0 {
+            if err := tx.Where("id IN ?", req.CategoryIds).Find(&categories).Error; err != nil {
+                return err
+            }
+        }
+        if len(categories) != len(uniqueIDs(req.CategoryIds)) {
+            return status.Errorf(codes.NotFound, "One or more categories not found")
+        }
+        return tx.Model(&product).Association("Categories").Replace(categories)
+    })
+    if err != nil {
+        return nil, categoryError(err)
+    }
+
+    if err := s.db.Preload("Categories").First(&product, product.ID).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving product: %v", err)
+    }
+    return &pb.ProductResponse{Product: toProtoProduct(product)}, nil
+}
+
+// findCategory loads a category by ID, or by slug when id is zero
+func (s *server) findCategory(db *gorm.DB, id int64, slug string) (*models.Category, error) {
+    var category models.Category
+    query := db
+    if id != 0 {
+        query = query.Where("id = ?", id)
+    } else {
+        query = query.Where("slug = ?", slug)
+    }
+    if err := query.First(&category).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Category not found")
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving category: %v", err)
+    }
+    return &category, nil
+}
+
+// buildCategoryTree nests the categories under parentID, recursively
+func buildCategoryTree(all []models.Category, parentID *uint) []models.Category {
+    var nodes []models.Category
+    for _, category := range all {
+        if (parentID == nil && category.ParentID == nil) || (parentID != nil && category.ParentID != nil && *category.ParentID == *parentID) {
+            id := category.ID
+            category.Children = buildCategoryTree(all, &id)
+            nodes = append(nodes, category)
+        }
+    }
+    return nodes
+}
+
+// uniqueIDs removes duplicate IDs
+func uniqueIDs(ids []int64) map[int64]bool {
+    unique := make(map[int64]bool, len(ids))
+    for _, id := range ids {
+        unique[id] = true
+    }
+    return unique
+}
+
+// categoryError passes status errors through and wraps anything else as internal
+func categoryError(err error) error {
+    if _, ok := status.FromError(err); ok {
+        return err
+    }
+    return status.Errorf(codes.Internal, "Error updating categories: %v", err)
+}
+
+// toProtoCategory converts a category and its loaded children to protobuf
+func toProtoCategory(category models.Category) *pb.Category {
+    res := &pb.Category{
+        Id:   int64(category.ID),
+        Name: category.Name,
+        Slug: category.Slug,
+    }
+    if category.ParentID != nil {
+        res.ParentId = int64(*category.ParentID)
+    }
+    for _, child := range category.Children {
+        res.Children = append(res.Children, toProtoCategory(child))
+    }
+    return res
+}
+
+// toProtoDimensions converts a product's packed size, leaving it unset when unknown
+func toProtoDimensions(product models.Product) *pb.Dimensions {
+    if product.Length == 0 && product.Width == 0 && product.Height == 0 {
+        return nil
+    }
+    return &pb.Dimensions{Length: int32(product.Length), Width: int32(product.Width), Height: int32(product.Height)}
+}
+
+// toProtoProduct converts a product and its loaded categories, options and
+// variants to protobuf
+func toProtoProduct(product models.Product) *pb.Product {
+    res := &pb.Product{
+        Id:          int64(product.ID),
+        Name:        product.Name,
+        Description: product.Description,
+        Price:       toProtoMoney(product.Money(product.Price)),
+        Quantity:    int32(product.Quantity),
+        Version:     int64(product.Version),
+        TaxClass:    product.TaxClass,
+        Weight:      int32(product.Weight),
+        Dimensions:  toProtoDimensions(product),
+    }
+    for _, category := range product.Categories {
+        res.Categories = append(res.Categories, category.Slug)
+    }
+    for _, option := range product.Options {
+        productOption := &pb.ProductOption{Name: option.Name}
+        for _, value := range option.Values {
+            productOption.Values = append(productOption.Values, value.Value)
+        }
+        res.Options = append(res.Options, productOption)
+    }
+    for _, variant := range product.Variants {
+        res.Variants = append(res.Variants, toProtoVariant(product, variant))
+    }
+    return res
+}
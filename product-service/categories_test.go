@@ -0,0 +1,160 @@
+package main
+
+import (
+    "context"
+    "reflect"
+    "sort"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "gorm.io/gorm"
+    "product-service/models"
+)
+
+func TestSlugify(t *testing.T) {
+    tests := []struct {
+        name string
+        want string
+    }{
+        {name: "Shoes", want: "shoes"},
+        {name: "Men's Shoes", want: "men-s-shoes"},
+        {name: "  Home & Garden!  ", want: "home-garden"},
+        {name: "4K TVs", want: "4k-tvs"},
+        {name: "Ümlaut", want: "mlaut"},
+    }
+    for _, tt := range tests {
+        if got := slugify(tt.name); got != tt.want {
+            t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestCategoryLineage(t *testing.T) {
+    // 1 > 2 > 3, and 4 on its own
+    parents := map[uint]uint{1: 0, 2: 1, 3: 2, 4: 0}
+    category := func(id uint) models.Category { return models.Category{Model: gorm.Model{ID: id}} }
+    tests := []struct {
+        name       string
+        categories []models.Category
+        want       []int64
+    }{
+        {name: "top-level", categories: []models.Category{category(4)}, want: []int64{4}},
+        {name: "nested", categories: []models.Category{category(3)}, want: []int64{3, 2, 1}},
+        {name: "sharing ancestors", categories: []models.Category{category(3), category(2), category(4)}, want: []int64{3, 2, 1, 4}},
+        {name: "none"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := categoryLineage(parents, tt.categories); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("categoryLineage = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+    id := func(id uint) *uint { return &id }
+    all := []models.Category{
+        {Model: gorm.Model{ID: 1}, Slug: "clothing"},
+        {Model: gorm.Model{ID: 2}, Slug: "shoes", ParentID: id(1)},
+        {Model: gorm.Model{ID: 3}, Slug: "running", ParentID: id(2)},
+        {Model: gorm.Model{ID: 4}, Slug: "garden"},
+    }
+    tree := buildCategoryTree(all, nil)
+    if len(tree) != 2 || tree[0].Slug != "clothing" || tree[1].Slug != "garden" {
+        t.Fatalf("top-level categories = %+v, want clothing and garden", tree)
+    }
+    shoes := tree[0].Children
+    if len(shoes) != 1 || len(shoes[0].Children) != 1 || shoes[0].Children[0].Slug != "running" {
+        t.Errorf("clothing's subtree = %+v, want shoes with running below", shoes)
+    }
+
+    res := toProtoCategory(shoes[0])
+    if res.ParentId != 1 || len(res.Children) != 1 || res.Children[0].ParentId != 2 {
+        t.Errorf("toProtoCategory = %v, want shoes under 1 with running under 2", res)
+    }
+}
+
+func TestCategoryTree(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+    create := func(name string, parentID int64) int64 {
+        res, err := s.CreateCategory(ctx, &pb.CreateCategoryRequest{Name: name, ParentId: parentID})
+        if err != nil {
+            t.Fatalf("CreateCategory(%q) error = %v", name, err)
+        }
+        return res.Category.Id
+    }
+    clothing := create("Clothing", 0)
+    shoes := create("Shoes", clothing)
+    running := create("Running Shoes", shoes)
+
+    failures := []struct {
+        name string
+        call func() error
+        code codes.Code
+    }{
+        {name: "duplicate slug", call: func() error {
+            _, err := s.CreateCategory(ctx, &pb.CreateCategoryRequest{Name: "shoes"})
+            return err
+        }, code: codes.AlreadyExists},
+        {name: "invalid slug", call: func() error {
+            _, err := s.CreateCategory(ctx, &pb.CreateCategoryRequest{Name: "Boots", Slug: "Boots!"})
+            return err
+        }, code: codes.InvalidArgument},
+        {name: "unknown parent", call: func() error {
+            _, err := s.CreateCategory(ctx, &pb.CreateCategoryRequest{Name: "Boots", ParentId: running + 1000})
+            return err
+        }, code: codes.NotFound},
+        {name: "moved under its descendant", call: func() error {
+            _, err := s.UpdateCategory(ctx, &pb.UpdateCategoryRequest{Id: clothing, Name: "Clothing", ParentId: running})
+            return err
+        }, code: codes.InvalidArgument},
+        {name: "deleted with subcategories", call: func() error {
+            _, err := s.DeleteCategory(ctx, &pb.DeleteCategoryRequest{Id: shoes})
+            return err
+        }, code: codes.FailedPrecondition},
+    }
+    for _, tt := range failures {
+        if err := tt.call(); status.Code(err) != tt.code {
+            t.Errorf("%s: error = %v, want %v", tt.name, err, tt.code)
+        }
+    }
+
+    res, err := s.GetCategory(ctx, &pb.GetCategoryRequest{Slug: "clothing"})
+    if err != nil {
+        t.Fatalf("GetCategory error = %v", err)
+    }
+    if children := res.Category.Children; len(children) != 1 || children[0].Slug != "shoes" || len(children[0].Children) != 1 || children[0].Children[0].Slug != "running-shoes" {
+        t.Errorf("clothing's subtree = %v, want shoes with running-shoes below", children)
+    }
+
+    // A category matches the products of its descendants too
+    ids, err := categoryTreeIDs(db, []string{"shoes"})
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    if err != nil || !reflect.DeepEqual(ids, []uint{uint(shoes), uint(running)}) {
+        t.Errorf("categoryTreeIDs(shoes) = %v, %v, want [%d %d]", ids, err, shoes, running)
+    }
+    product := createTestProduct(t, db, nil)
+    if _, err := s.SetProductCategories(ctx, &pb.SetProductCategoriesRequest{ProductId: int64(product.ID), CategoryIds: []int64{running}}); err != nil {
+        t.Fatalf("SetProductCategories error = %v", err)
+    }
+    listed, err := s.ListProducts(ctx, &pb.ListProductsRequest{Categories: []string{"clothing"}})
+    if err != nil || len(listed.Products) != 1 || listed.Products[0].Id != int64(product.ID) {
+        t.Errorf("ListProducts in clothing = %v, %v, want the running shoe", listed.GetProducts(), err)
+    }
+    if _, err := s.SetProductCategories(ctx, &pb.SetProductCategoriesRequest{ProductId: int64(product.ID), CategoryIds: []int64{running, running + 1000}}); status.Code(err) != codes.NotFound {
+        t.Errorf("SetProductCategories with an unknown category error = %v, want %v", err, codes.NotFound)
+    }
+
+    if _, err := s.DeleteCategory(ctx, &pb.DeleteCategoryRequest{Id: running}); err != nil {
+        t.Fatalf("DeleteCategory error = %v", err)
+    }
+    listed, _ = s.ListProducts(ctx, &pb.ListProductsRequest{Categories: []string{"clothing"}})
+    if len(listed.GetProducts()) != 0 {
+        t.Errorf("ListProducts in clothing = %v after deleting the product's category, want none", listed.GetProducts())
+    }
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "product-service/models"
+)
+
+// createTestWarehouse stores an active warehouse at a location
+func createTestWarehouse(t *testing.T, db *gorm.DB, code string, priority int, location *pb.GeoPoint) models.Warehouse {
+    t.Helper()
+    warehouse := models.Warehouse{Code: code, Name: code, Priority: priority, Active: true}
+    if location != nil {
+        warehouse.Latitude, warehouse.Longitude = location.Latitude, location.Longitude
+    }
+    if err := db.Create(&warehouse).Error; err != nil {
+        t.Fatalf("failed to create warehouse: %v", err)
+    }
+    return warehouse
+}
+
+// createTestProduct stores a product priced at 10.00 USD and restocks it with
+// the given quantity at each warehouse, 0 being the default warehouse
+func createTestProduct(t *testing.T, db *gorm.DB, stock map[uint]int) models.Product {
+    t.Helper()
+    product := models.Product{Name: t.Name(), Price: 1000, Currency: "USD"}
+    if err := db.Create(&product).Error; err != nil {
+        t.Fatalf("failed to create product: %v", err)
+    }
+    for warehouseID, quantity := range stock {
+        var err error
+        product, err = adjustStock(db, product.ID, 0, warehouseID, quantity, 0, "", movementSource{Reason: models.MovementRestock})
+        if err != nil {
+            t.Fatalf("failed to stock product: %v", err)
+        }
+    }
+    return product
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "github.com/atullal/ecommerce-backend-protobuf/events"
+    "product-service/models"
+)
+
+// newMovementSource reads the ledger reason and reference of an inventory
+// update, defaulting to an adjustment
+func newMovementSource(ctx context.Context, req *pb.UpdateInventoryRequest) (movementSource, error) {
+    reason := models.MovementReason(strings.ToUpper(req.Reason))
+    if reason == "" {
+        reason = models.MovementAdjustment
+    }
+    if !reason.IsValid() || reason == models.MovementOpening {
+        return movementSource{}, status.Errorf(codes.InvalidArgument, "Invalid movement reason '%s'", req.Reason)
+    }
+    return movementSource{Reason: reason, ReferenceID: req.ReferenceId, ActorID: actorIDFromContext(ctx)}, nil
+}
+
+// ensureOpeningBalances records an opening movement for every stock level that
+// has stock but no movements yet, i.e. stock that existed before the ledger
+func ensureOpeningBalances(db *gorm.DB) error {
+    return db.Exec(`INSERT INTO stock_movements (product_id, variant_id, warehouse_id, delta, quantity_after, reason, reference_id, actor_id, created_at)
+        SELECT l.product_id, l.variant_id, l.warehouse_id, l.quantity, l.quantity, ?, '', 0, NOW() FROM stock_levels l
+        WHERE l.deleted_at IS NULL AND l.quantity <> 0 AND NOT EXISTS (
+            SELECT 1 FROM stock_movements m WHERE m.product_id = l.product_id AND m.variant_id = l.variant_id AND m.warehouse_id = l.warehouse_id)`,
+        models.MovementOpening).Error
+}
+
+func (s *server) ListStockMovements(ctx context.Context, req *pb.ListStockMovementsRequest) (*pb.ListStockMovementsResponse, error) {
+    page, pageSize := req.Page, req.PageSize
+    if page < 1 {
+        page = 1
+    }
+    if pageSize < 1 || pageSize > 100 {
+        pageSize = 50
+    }
+
+    query := s.db.Model(&models.StockMovement{}).Where("product_id = ?", req.ProductId)
+    if req.WarehouseId != 0 {
+        query = query.Where("warehouse_id = ?", req.WarehouseId)
+    }
+    if req.VariantId != 0 {
+        query = query.Where("variant_id = ?", req.VariantId)
+    }
+
+    var total int64
+    if err := query.Count(&total).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error counting stock movements: %v", err)
+    }
+
+    var movements []models.StockMovement
+    offset := (page - 1) * pageSize
+    if err := query.Order("id DESC").Offset(int(offset)).Limit(int(pageSize)).Find(&movements).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving stock movements: %v", err)
+    }
+
+    res := &pb.ListStockMovementsResponse{
+        Movements:   make([]*pb.StockMovement, 0, len(movements)),
+        TotalPages:  int32((total + int64(pageSize) - 1) / int64(pageSize)),
+        CurrentPage: page,
+    }
+    for _, movement := range movements {
+        res.Movements = append(res.Movements, toProtoStockMovement(movement))
+    }
+    return res, nil
+}
+
+// stockKey identifies the stock level of a variant (0 for none) at a warehouse
+type stockKey struct {
+    VariantID   uint
+    WarehouseID uint
+}
+
+// RebuildInventory sums the ledger of each of a product's stock levels and
+// compares it with the recorded quantities. With apply set, drifted stock
+// levels and the product's and variants' totals are overwritten with the
+// ledger quantities.
+func (s *server) RebuildInventory(ctx context.Context, req *pb.RebuildInventoryRequest) (*pb.RebuildInventoryResponse, error) {
+    res := &pb.RebuildInventoryResponse{}
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var product models.Product
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, req.ProductId).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.ProductId)
+            }
+            return status.Errorf(codes.Internal, "Error retrieving product: %v", err)
+        }
+
+        var levels []models.StockLevel
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", product.ID).Order("variant_id, warehouse_id").Find(&levels).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving stock levels: %v", err)
+        }
+
+        var sums []struct {
+            VariantID   uint
+            WarehouseID uint
+            Total       int
+        }
+        err := tx.Model(&models.StockMovement{}).
+            Select("variant_id, warehouse_id, SUM(delta) AS total").
+            Where("product_id = ?", product.ID).
+            Group("variant_id, warehouse_id").
+            Scan(&sums).Error
+        if err != nil {
+            return status.Errorf(codes.Internal, "Error summing stock movements: %v", err)
+        }
+        ledger := make(map[stockKey]int, len(sums))
+        for _, sum := range sums {
+            ledger[stockKey{sum.VariantID, sum.WarehouseID}] = sum.Total
+        }
+
+        // Movements without a stock level count as drift too
+        for key := range ledger {
+            found := false
+            for _, level := range levels {
+                found = found || (stockKey{level.VariantID, level.WarehouseID} == key)
+            }
+            if !found {
+                levels = append(levels, models.StockLevel{ProductID: product.ID, VariantID: key.VariantID, WarehouseID: key.WarehouseID})
+            }
+        }
+
+        totalQuantity := 0
+        variantQuantities := make(map[uint]int)
+        var corrected []models.StockLevel
+        var corrections []int
+        for i := range levels {
+            level := &levels[i]
+            key := stockKey{level.VariantID, level.WarehouseID}
+            drift := level.Quantity - ledger[key]
+            res.Warehouses = append(res.Warehouses, &pb.InventoryDrift{
+                VariantId:   int64(level.VariantID),
+                WarehouseId: int64(level.WarehouseID),
+                Recorded:    int32(level.Quantity),
+                Ledger:      int32(ledger[key]),
+                Drift:       int32(drift),
+            })
+            if req.Apply && drift != 0 {
+                level.Quantity = ledger[key]
+                if err := tx.Save(level).Error; err != nil {
+                    return status.Errorf(codes.Internal, "Error updating stock level: %v", err)
+                }
+                corrected = append(corrected, *level)
+                corrections = append(corrections, -drift)
+            }
+            totalQuantity += level.Quantity
+            if level.VariantID != 0 {
+                variantQuantities[level.VariantID] += level.Quantity
+            }
+        }
+
+        if req.Apply {
+            for variantID, quantity := range variantQuantities {
+                if err := tx.Model(&models.Variant{}).Where("id = ?", variantID).Update("quantity", quantity).Error; err != nil {
+                    return status.Errorf(codes.Internal, "Error updating variant: %v", err)
+                }
+            }
+        }
+
+        if req.Apply && totalQuantity != product.Quantity {
+            product.Quantity = totalQuantity
+            product.Version++
+            if err := tx.Save(&product).Error; err != nil {
+                return status.Errorf(codes.Internal, "Error updating inventory: %v", err)
+            }
+        }
+        for i, level := range corrected {
+            if err := events.Enqueue(tx, events.InventoryChanged, "product", product.ID, newInventoryEvent(product, level, corrections[i], "")); err != nil {
+                return status.Errorf(codes.Internal, "Error recording inventory event: %v", err)
+            }
+        }
+        res.Applied = req.Apply
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return res, nil
+}
+
+// toProtoStockMovement converts a ledger entry to its protobuf representation
+func toProtoStockMovement(movement models.StockMovement) *pb.StockMovement {
+    return &pb.StockMovement{
+        Id:            int64(movement.ID),
+        ProductId:     int64(movement.ProductID),
+        VariantId:     int64(movement.VariantID),
+        WarehouseId:   int64(movement.WarehouseID),
+        Delta:         int32(movement.Delta),
+        QuantityAfter: int32(movement.QuantityAfter),
+        Reason:        string(movement.Reason),
+        ReferenceId:   movement.ReferenceID,
+        ActorId:       int64(movement.ActorID),
+        CreatedAt:     movement.CreatedAt.Format(time.RFC3339),
+    }
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "product-service/models"
+)
+
+// userContext is an incoming call from rest-service for the user
+func userContext(t *testing.T, userID string) context.Context {
+    serviceToken = "secret"
+    t.Cleanup(func() { serviceToken = "" })
+    md := metadata.Pairs(serviceauth.TokenMetadataKey, "secret", userIDMetadataKey, userID)
+    return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestNewMovementSource(t *testing.T) {
+    tests := []struct {
+        reason string
+        want   models.MovementReason
+        code   codes.Code
+    }{
+        {reason: "", want: models.MovementAdjustment},
+        {reason: "restock", want: models.MovementRestock},
+        {reason: "RETURN", want: models.MovementReturn},
+        {reason: "OPENING", code: codes.InvalidArgument}, // Only recorded by the service itself
+        {reason: "THEFT", code: codes.InvalidArgument},
+    }
+    ctx := userContext(t, "8")
+    for _, tt := range tests {
+        source, err := newMovementSource(ctx, &pb.UpdateInventoryRequest{Reason: tt.reason, ReferenceId: "po-1"})
+        if status.Code(err) != tt.code || source.Reason != tt.want {
+            t.Errorf("newMovementSource(%q) = %+v, %v, want reason %q, %v", tt.reason, source, err, tt.want, tt.code)
+            continue
+        }
+        if err == nil && (source.ActorID != 8 || source.ReferenceID != "po-1") {
+            t.Errorf("newMovementSource(%q) = %+v, want actor 8 and reference po-1", tt.reason, source)
+        }
+    }
+
+    // Without the service token the forwarded user isn't trusted
+    md := metadata.Pairs(userIDMetadataKey, "8")
+    if source, _ := newMovementSource(metadata.NewIncomingContext(context.Background(), md), &pb.UpdateInventoryRequest{}); source.ActorID != 0 {
+        t.Errorf("actor of an unverified call = %d, want 0", source.ActorID)
+    }
+}
+
+func TestUpdateInventoryRecordsMovements(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    product := createTestProduct(t, db, map[uint]int{0: 5})
+    ctx := userContext(t, "8")
+
+    res, err := s.UpdateInventory(ctx, &pb.UpdateInventoryRequest{ProductId: int64(product.ID), QuantityChange: -2, Version: int64(product.Version), Reason: "sale", ReferenceId: "order-4"})
+    if err != nil {
+        t.Fatalf("UpdateInventory error = %v", err)
+    }
+    if _, err := s.UpdateInventory(ctx, &pb.UpdateInventoryRequest{ProductId: int64(product.ID), QuantityChange: -10, Version: res.Version}); status.Code(err) != codes.InvalidArgument {
+        t.Fatalf("UpdateInventory below zero error = %v, want %v", err, codes.InvalidArgument)
+    }
+
+    list, err := s.ListStockMovements(ctx, &pb.ListStockMovementsRequest{ProductId: int64(product.ID)})
+    if err != nil {
+        t.Fatalf("ListStockMovements error = %v", err)
+    }
+    // Newest first; the rejected change left no trace
+    want := []struct {
+        delta, after int32
+        reason       string
+    }{{-2, 3, "SALE"}, {5, 5, "RESTOCK"}}
+    if len(list.Movements) != len(want) {
+        t.Fatalf("movements = %v, want %d", list.Movements, len(want))
+    }
+    for i, movement := range list.Movements {
+        if movement.Delta != want[i].delta || movement.QuantityAfter != want[i].after || movement.Reason != want[i].reason {
+            t.Errorf("movement %d = %v, want %+v", i, movement, want[i])
+        }
+    }
+    if sale := list.Movements[0]; sale.ActorId != 8 || sale.ReferenceId != "order-4" {
+        t.Errorf("sale by %d for %q, want by 8 for order-4", sale.ActorId, sale.ReferenceId)
+    }
+}
+
+func TestRebuildInventory(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+    warehouse := createTestWarehouse(t, db, "EAST", 1, nil)
+    product := createTestProduct(t, db, map[uint]int{0: 5, warehouse.ID: 3})
+
+    // The east warehouse's stock level drifts from its ledger
+    db.Model(&models.StockLevel{}).Where("product_id = ? AND warehouse_id = ?", product.ID, warehouse.ID).Update("quantity", 7)
+    db.Model(&product).Update("quantity", 12)
+
+    drifts := func(res *pb.RebuildInventoryResponse) map[int64]int32 {
+        got := map[int64]int32{}
+        for _, drift := range res.Warehouses {
+            got[drift.WarehouseId] = drift.Drift
+        }
+        return got
+    }
+
+    res, err := s.RebuildInventory(ctx, &pb.RebuildInventoryRequest{ProductId: int64(product.ID)})
+    if err != nil {
+        t.Fatalf("RebuildInventory error = %v", err)
+    }
+    if got := drifts(res); len(got) != 2 || got[int64(warehouse.ID)] != 4 || res.Applied {
+        t.Errorf("drifts = %v, applied %v, want only 4 at the east warehouse, not applied", got, res.Applied)
+    }
+    if quantity, _ := stockOf(t, s, product); quantity != 12 {
+        t.Errorf("quantity after a dry run = %d, want 12 unchanged", quantity)
+    }
+
+    if res, err = s.RebuildInventory(ctx, &pb.RebuildInventoryRequest{ProductId: int64(product.ID), Apply: true}); err != nil || !res.Applied {
+        t.Fatalf("RebuildInventory = %v, %v, want it applied", res, err)
+    }
+    if quantity, _ := stockOf(t, s, product); quantity != 8 {
+        t.Errorf("quantity after the rebuild = %d, want the ledger's 8", quantity)
+    }
+    res, _ = s.RebuildInventory(ctx, &pb.RebuildInventoryRequest{ProductId: int64(product.ID)})
+    for warehouseID, drift := range drifts(res) {
+        if drift != 0 {
+            t.Errorf("warehouse %d drifts by %d after the rebuild, want 0", warehouseID, drift)
+        }
+    }
+
+    if _, err := s.RebuildInventory(ctx, &pb.RebuildInventoryRequest{ProductId: int64(product.ID) + 1000}); status.Code(err) != codes.NotFound {
+        t.Errorf("RebuildInventory of a missing product error = %v, want %v", err, codes.NotFound)
+    }
+}
+
+func TestEnsureOpeningBalances(t *testing.T) {
+    db := testDB(t)
+
+    // Stock from before the ledger has a stock level but no movements
+    product := models.Product{Name: "Legacy", Price: 1000, Currency: "USD", Quantity: 4}
+    db.Create(&product)
+    if err := ensureDefaultWarehouse(db); err != nil {
+        t.Fatalf("ensureDefaultWarehouse error = %v", err)
+    }
+
+    for i := 0; i < 2; i++ {
+        if err := ensureOpeningBalances(db); err != nil {
+            t.Fatalf("ensureOpeningBalances error = %v", err)
+        }
+    }
+    var movements []models.StockMovement
+    db.Where("product_id = ?", product.ID).Find(&movements)
+    if len(movements) != 1 || movements[0].Reason != models.MovementOpening || movements[0].Delta != 4 {
+        t.Errorf("movements = %+v, want a single opening balance of 4", movements)
+    }
+}
@@ -6,14 +6,20 @@ import (
     "log"
     "net"
     "errors"
+    "strings"
 
     "google.golang.org/grpc"
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
     pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "google.golang.org/protobuf/proto"
+    "github.com/atullal/ecommerce-backend-protobuf/money"
     "product-service/models"
+    "github.com/atullal/ecommerce-backend-protobuf/events"
     "fmt"
     "time"
     "math"
@@ -48,6 +54,9 @@ func connectWithBackoff(dsn string) (*gorm.DB, error) {
     return nil, err // Return the last error
 }
 
+// schemaModels are the tables migrated at startup
+var schemaModels = []interface{}{&models.Product{}, &models.InventoryOperation{}, &events.OutboxEvent{}, &models.Reservation{}, &models.ReservationItem{}, &models.Warehouse{}, &models.StockLevel{}, &models.StockMovement{}, &models.Category{}, &models.ProductOption{}, &models.ProductOptionValue{}, &models.Variant{}, &models.VariantOption{}, &models.PriceList{}, &models.PriceListPrice{}}
+
 func initDB() *gorm.DB {
     dsn := os.Getenv("DSN")
     db, err := connectWithBackoff(dsn)
@@ -55,39 +64,134 @@ func initDB() *gorm.DB {
         log.Fatalf("failed to connect database: %v", err)
     }
 
+    // Prices are kept in minor units of the store currency unless a product names another
+    currency, err := money.Normalize(money.StoreCurrency())
+    if err != nil {
+        log.Fatalf("invalid CURRENCY: %v", err)
+    }
+    if err := migrateMoneyColumns(db, currency); err != nil {
+        log.Fatalf("failed to migrate prices: %v", err)
+    }
+
     // Migrate the schema
-    if err := db.AutoMigrate(&models.Product{}); err != nil {
+    if err := db.AutoMigrate(schemaModels...); err != nil {
         log.Fatalf("failed to migrate database: %v", err)
     }
+    // Stock levels are unique per variant now; the old index would reject a second variant at a warehouse
+    if db.Migrator().HasIndex(&models.StockLevel{}, "idx_stock_levels_product_warehouse") {
+        if err := db.Migrator().DropIndex(&models.StockLevel{}, "idx_stock_levels_product_warehouse"); err != nil {
+            log.Fatalf("failed to migrate database: %v", err)
+        }
+    }
+    if err := backfillCurrency(db, currency); err != nil {
+        log.Fatalf("failed to migrate prices: %v", err)
+    }
+    if err := ensureDefaultWarehouse(db); err != nil {
+        log.Fatalf("failed to set up the default warehouse: %v", err)
+    }
+    if err := ensureOpeningBalances(db); err != nil {
+        log.Fatalf("failed to set up the stock movement ledger: %v", err)
+    }
+    if err := ensureSearchIndex(db); err != nil {
+        log.Fatalf("failed to set up product search: %v", err)
+    }
     fmt.Println("Database connection successful")
     return db
 }
 
+// taxClassFromProto reads a product tax class, a slug such as "reduced" or
+// empty for the standard class
+func taxClassFromProto(taxClass string) (string, error) {
+    taxClass = strings.ToLower(strings.TrimSpace(taxClass))
+    if taxClass != "" && !slugPattern.MatchString(taxClass) {
+        return "", status.Errorf(codes.InvalidArgument, "Invalid tax class '%s'", taxClass)
+    }
+    return taxClass, nil
+}
+
+// shippingSize holds a product's shipping weight and packed size
+type shippingSize struct {
+    Weight, Length, Width, Height int
+}
+
+// shippingSizeFromProto reads a product's weight in grams and dimensions in millimetres
+func shippingSizeFromProto(weight int32, dimensions *pb.Dimensions) (shippingSize, error) {
+    size := shippingSize{
+        Weight: int(weight),
+        Length: int(dimensions.GetLength()),
+        Width:  int(dimensions.GetWidth()),
+        Height: int(dimensions.GetHeight()),
+    }
+    if size.Weight < 0 || size.Length < 0 || size.Width < 0 || size.Height < 0 {
+        return size, status.Errorf(codes.InvalidArgument, "Weight and dimensions must not be negative")
+    }
+    return size, nil
+}
+
 // AddProduct handles the creation of a new product
 func (s *server) AddProduct(ctx context.Context, req *pb.AddProductRequest) (*pb.ProductResponse, error) {
+    price, err := priceFromProto(req.Price)
+    if err != nil {
+        return nil, err
+    }
+    taxClass, err := taxClassFromProto(req.TaxClass)
+    if err != nil {
+        return nil, err
+    }
+    size, err := shippingSizeFromProto(req.Weight, req.Dimensions)
+    if err != nil {
+        return nil, err
+    }
+
     // Create a new Product models instance from the request
     newProduct := models.Product{
         Name:        req.Name,
         Description: req.Description,
-        Price:       float64(req.Price),      // Convert to float64
+        Price:       price.Amount,
+        Currency:    price.Currency,
+        TaxClass:    taxClass,
+        Weight:      size.Weight,
+        Length:      size.Length,
+        Width:       size.Width,
+        Height:      size.Height,
         Quantity:    int(req.Quantity),       // Convert to int
     }
 
-    // Save the new product to the database
-    if err := s.db.Create(&newProduct).Error; err != nil {
+    // Save the new product and its outbox event in one transaction
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&newProduct).Error; err != nil {
+            return err
+        }
+        // The initial stock is kept at the default warehouse
+        warehouseID, err := resolveWarehouse(tx, 0)
+        if err != nil {
+            return err
+        }
+        if err := tx.Create(&models.StockLevel{ProductID: newProduct.ID, WarehouseID: warehouseID, Quantity: newProduct.Quantity}).Error; err != nil {
+            return err
+        }
+        if newProduct.Quantity != 0 {
+            movement := models.StockMovement{
+                ProductID:     newProduct.ID,
+                WarehouseID:   warehouseID,
+                Delta:         newProduct.Quantity,
+                QuantityAfter: newProduct.Quantity,
+                Reason:        models.MovementOpening,
+                ActorID:       actorIDFromContext(ctx),
+            }
+            if err := tx.Create(&movement).Error; err != nil {
+                return err
+            }
+        }
+        return events.Enqueue(tx, events.ProductCreated, "product", newProduct.ID, newProductEvent(newProduct))
+    })
+    if err != nil {
         return nil, err // Handle and return the error appropriately
     }
 
     // Prepare and return the response
     response := &pb.ProductResponse{
-	    Product: &pb.Product{
-	        Id:          int64(newProduct.ID),  // Convert to int64
-	        Name:        newProduct.Name,
-	        Description: newProduct.Description,
-	        Price:       float32(newProduct.Price), // Convert to float32
-	        Quantity:    int32(newProduct.Quantity),
-			Version:    int64(newProduct.Version),
-	    },
+        Product: toProtoProduct(newProduct),
     }
 
     return response, nil
@@ -95,27 +199,18 @@ func (s *server) AddProduct(ctx context.Context, req *pb.AddProductRequest) (*pb
 
 // GetProduct handles fetching a product by ID
 func (s *server) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.ProductResponse, error) {
-    var product models.Product
-
-    // Retrieve the product by ID from the database
-    result := s.db.First(&product, req.Id)
-    if result.Error != nil {
-        if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-            return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.Id)
-        }
-        return nil, status.Errorf(codes.Internal, "Error retrieving product: %v", result.Error)
+    // Retrieve the product and its variant matrix from the database
+    product, err := findProduct(s.db, uint(req.Id))
+    if err != nil {
+        return nil, err
     }
 
-    // Prepare and return the response
+    // Prepare and return the response, priced for the caller
     response := &pb.ProductResponse{
-        Product: &pb.Product{
-            Id:          int64(product.ID),
-            Name:        product.Name,
-            Description: product.Description,
-            Price:       float32(product.Price),
-            Quantity:    int32(product.Quantity),
-            Version:	 int64(product.Version),
-        },
+        Product: toProtoProduct(product),
+    }
+    if err := s.priceProducts(ctx, req.Currency, []models.Product{product}, []*pb.Product{response.Product}); err != nil {
+        return nil, err
     }
 
     return response, nil
@@ -123,12 +218,24 @@ func (s *server) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb
 
 func (s *server) UpdateProduct(ctx context.Context, req *pb.UpdateProductRequest) (*pb.ProductResponse, error) {
     var product models.Product
+    price, err := priceFromProto(req.Price)
+    if err != nil {
+        return nil, err
+    }
+    taxClass, err := taxClassFromProto(req.TaxClass)
+    if err != nil {
+        return nil, err
+    }
+    size, err := shippingSizeFromProto(req.Weight, req.Dimensions)
+    if err != nil {
+        return nil, err
+    }
 
     // Start a transaction
     tx := s.db.Begin()
 
     // Find the product by ID
-    if err := tx.First(&product, req.Id).Error; err != nil {
+    if err := tx.Preload("Categories").First(&product, req.Id).Error; err != nil {
         tx.Rollback()
         if errors.Is(err, gorm.ErrRecordNotFound) {
             return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.Id)
@@ -142,31 +249,47 @@ func (s *server) UpdateProduct(ctx context.Context, req *pb.UpdateProductRequest
         return nil, status.Errorf(codes.Aborted, "Update aborted due to version mismatch")
     }
 
+    // Variant price overrides are in the product's currency, so it can only
+    // change while there are none
+    if price.Currency != product.Currency {
+        var overrides int64
+        if err := tx.Model(&models.Variant{}).Where("product_id = ? AND price_override IS NOT NULL", product.ID).Count(&overrides).Error; err != nil {
+            tx.Rollback()
+            return nil, status.Errorf(codes.Internal, "Error retrieving variants: %v", err)
+        }
+        if overrides > 0 {
+            tx.Rollback()
+            return nil, status.Errorf(codes.FailedPrecondition, "Product with ID '%d' has variant prices in %s", product.ID, product.Currency)
+        }
+    }
+
     // Update the product fields
     product.Name = req.Name
     product.Description = req.Description
-    product.Price = float64(req.Price)
+    product.Price = price.Amount
+    product.Currency = price.Currency
+    product.TaxClass = taxClass
+    product.Weight, product.Length, product.Width, product.Height = size.Weight, size.Length, size.Width, size.Height
     product.Version++ // Increment the version
 
-    // Save the updated product
-    if err := tx.Save(&product).Error; err != nil {
+    // Save the updated product; category assignments are changed through SetProductCategories
+    if err := tx.Omit("Categories").Save(&product).Error; err != nil {
         tx.Rollback()
         return nil, status.Errorf(codes.Internal, "Error updating product: %v", err)
     }
 
+    // Record the change in the outbox within the same transaction
+    if err := events.Enqueue(tx, events.ProductUpdated, "product", product.ID, newProductEvent(product)); err != nil {
+        tx.Rollback()
+        return nil, status.Errorf(codes.Internal, "Error recording product event: %v", err)
+    }
+
     // Commit the transaction
     tx.Commit()
 
     // Prepare and return the response
     return &pb.ProductResponse{
-        Product: &pb.Product{
-            Id:          int64(product.ID),
-            Name:        product.Name,
-            Description: product.Description,
-            Price:       float32(product.Price),
-            Quantity:    int32(product.Quantity),
-            Version:     int64(product.Version),
-        },
+        Product: toProtoProduct(product),
     }, nil
 }
 
@@ -181,8 +304,14 @@ func (s *server) DeleteProduct(ctx context.Context, req *pb.DeleteProductRequest
         return nil, status.Errorf(codes.Internal, "Error retrieving product: %v", result.Error)
     }
 
-    // Delete the product
-    if err := s.db.Delete(&product).Error; err != nil {
+    // Delete the product and record the event in one transaction
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Delete(&product).Error; err != nil {
+            return err
+        }
+        return events.Enqueue(tx, events.ProductDeleted, "product", product.ID, newProductEvent(product))
+    })
+    if err != nil {
         return nil, status.Errorf(codes.Internal, "Error deleting product: %v", err)
     }
 
@@ -191,52 +320,87 @@ func (s *server) DeleteProduct(ctx context.Context, req *pb.DeleteProductRequest
 }
 
 func (s *server) ListProducts(ctx context.Context, req *pb.ListProductsRequest) (*pb.ListProductsResponse, error) {
-    var products []models.Product
-    query := s.db
+    // Implement filtering based on the request: search, categories, price, stock and attributes
+    filters, err := newProductFilters(s.db, req)
+    if err != nil {
+        return nil, err
+    }
+    sort, err := lookupProductSort(req.Sort, req.SearchKeyword)
+    if err != nil {
+        return nil, err
+    }
 
-    // Implement filtering based on the request, e.g., search keyword, categories
-    if req.SearchKeyword != "" {
-        query = query.Where("name ILIKE ? OR description ILIKE ?", "%"+req.SearchKeyword+"%", "%"+req.SearchKeyword+"%")
+    // Implement pagination: pages continue after the cursor in pageToken, or
+    // start at the offset of the deprecated page number
+    listing := listingHash(req)
+    var cursor *productCursor
+    if req.PageToken != "" {
+        if cursor, err = decodeProductCursor(req.PageToken, listing); err != nil {
+            return nil, err
+        }
     }
-    if len(req.Categories) > 0 {
-        query = query.Where("category IN ?", req.Categories)
+    page, pageSize := req.Page, productPageSize(req.PageSize)
+    if page < 1 || cursor != nil {
+        page = 1
     }
-    // Add more filters as needed
+    offset := int(page-1) * pageSize
 
-    // Implement pagination
-    offset := (req.Page - 1) * req.PageSize
-    query = query.Offset(int(offset)).Limit(int(req.PageSize))
+    var total int64
+    if err := s.filteredProducts(filters, "").Count(&total).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error counting products: %v", err)
+    }
 
-    // Retrieve the products from the database
-    if err := query.Find(&products).Error; err != nil {
-        return nil, status.Errorf(codes.Internal, "Error retrieving products: %v", err)
+    // Retrieve the products from the database; searches also explain each match
+    products, highlights, next, err := s.productPage(s.filteredProducts(filters, ""), req.SearchKeyword, sort, cursor, offset, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    currency, err := priceCurrency(req)
+    if err != nil {
+        return nil, err
+    }
+    facets, err := s.productFacets(filters, currency)
+    if err != nil {
+        return nil, err
     }
 
     // Convert the products to the protobuf type and create the response
     pbProducts := []*pb.Product{}
     for _, product := range products {
-        pbProducts = append(pbProducts, &pb.Product{
-            Id:          int64(product.ID),
-            Name:        product.Name,
-            Description: product.Description,
-            Price:       float32(product.Price),
-            Quantity:    int32(product.Quantity),
-            Version:    int64(product.Version),
-            // Map other fields as necessary
-        })
-    }
-
-    return &pb.ListProductsResponse{Products: pbProducts}, nil
+        pbProducts = append(pbProducts, toProtoProduct(product))
+    }
+    if err := s.priceProducts(ctx, req.Currency, products, pbProducts); err != nil {
+        return nil, err
+    }
+
+    res := &pb.ListProductsResponse{
+        Products:    pbProducts,
+        TotalPages:  int32((total + int64(pageSize) - 1) / int64(pageSize)),
+        CurrentPage: page,
+        Highlights:  highlights,
+        TotalCount:  total,
+        Facets:      facets,
+    }
+    if next != nil {
+        next.Listing = listing
+        res.NextPageToken = encodeProductCursor(*next)
+    }
+    return res, nil
 }
 
 func (s *server) UpdateInventory(ctx context.Context, req *pb.UpdateInventoryRequest) (*pb.InventoryResponse, error) {
     var product models.Product
 
+    source, err := newMovementSource(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+
     // Start a transaction
     tx := s.db.Begin()
 
     // Retrieve the product with a SELECT FOR UPDATE lock
-    if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&product, req.ProductId).Error; err != nil {
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, req.ProductId).Error; err != nil {
         tx.Rollback()
         if errors.Is(err, gorm.ErrRecordNotFound) {
             return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.ProductId)
@@ -250,89 +414,120 @@ func (s *server) UpdateInventory(ctx context.Context, req *pb.UpdateInventoryReq
         return nil, status.Errorf(codes.Aborted, "Inventory update aborted due to version mismatch")
     }
 
-    // Update the inventory
-    product.Quantity += int(req.QuantityChange) // Adjust this line based on your business logic
-    product.Version++
-
-    if product.Quantity < 0 {
-    	tx.Rollback()
-		return nil, status.Errorf(codes.InvalidArgument, "Insufficient inventory")
-    }
-
-    // Save the changes
-    if err := tx.Save(&product).Error; err != nil {
+    // Update the stock at the warehouse; stock held by reservations can't be taken away
+    product, err = adjustStock(tx, product.ID, uint(req.VariantId), uint(req.WarehouseId), int(req.QuantityChange), 0, "", source)
+    if err != nil {
         tx.Rollback()
-        return nil, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
+        return nil, err
     }
 
     // Commit the transaction
     tx.Commit()
 
     // Prepare and return the response
-    return &pb.InventoryResponse{
-        ProductId: int64(product.ID),
-        Quantity:  int32(product.Quantity),
-        Version:   int64(product.Version),
-    }, nil
+    return inventoryResponse(product), nil
 }
 
 func (s *server) UpdateMultipleInventories(ctx context.Context, req *pb.UpdateMultipleInventoriesRequest) (*pb.InventoriesResponse, error) {
     fmt.Println("UpdateMultipleInventories: ", req)
-	res := &pb.InventoriesResponse{}
+    res := &pb.InventoriesResponse{}
     res.Inventories = make([]*pb.InventoryResponse, 0)
-	// Start a transaction
+
+    // Replay the recorded result if this keyed request was already applied
+    if req.IdempotencyKey != "" {
+        if replayed, err := s.findInventoryOperation(s.db, req.IdempotencyKey); err != nil || replayed != nil {
+            return replayed, err
+        }
+    }
+
+    // Start a transaction
     tx := s.db.Begin()
 
-	for _, inventory := range req.InventoryUpdates {
-		if inventory.ProductId == 0 {
-			continue
-		}
-		var product models.Product
-
-	    // Retrieve the product with a SELECT FOR UPDATE lock
-	    if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&product, inventory.ProductId).Error; err != nil {
-	        tx.Rollback()
-	        if errors.Is(err, gorm.ErrRecordNotFound) {
-	            return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", inventory.ProductId)
-	        }
-	        return nil, status.Errorf(codes.Internal, "Error retrieving product: %v", err)
-	    }
-		fmt.Println("product: ", product)
-		fmt.Println("inventory: ", inventory)
-	    // Check the version for optimistic locking
-	    if int64(inventory.Version) != int64(product.Version) {
-	        tx.Rollback()
-	        return nil, status.Errorf(codes.Aborted, "Inventory update aborted due to version mismatch")
-	    }
-
-	    // Update the inventory
-	    product.Quantity += int(inventory.QuantityChange) // Adjust this line based on your business logic
-	    product.Version++
-
-	    if product.Quantity < 0 {
-	    	tx.Rollback()
-				return nil, status.Errorf(codes.InvalidArgument, "Insufficient inventory")
-	    }
-
-	    // Save the changes
-	    if err := tx.Save(&product).Error; err != nil {
-	        tx.Rollback()
-	        return nil, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
-	    }
-		res.Inventories = append(res.Inventories, &pb.InventoryResponse{
-			ProductId: int64(product.ID),
-	        Quantity:  int32(product.Quantity),
-	        Version:   int64(product.Version),
-		})
+    for _, inventory := range req.InventoryUpdates {
+        if inventory.ProductId == 0 {
+            continue
+        }
+        var product models.Product
+
+        source, err := newMovementSource(ctx, inventory)
+        if err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+
+        // Retrieve the product with a SELECT FOR UPDATE lock
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, inventory.ProductId).Error; err != nil {
+            tx.Rollback()
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", inventory.ProductId)
+            }
+            return nil, status.Errorf(codes.Internal, "Error retrieving product: %v", err)
+        }
+        fmt.Println("product: ", product)
+        fmt.Println("inventory: ", inventory)
+        // Check the version for optimistic locking. Keyed requests come from other
+        // services applying relative changes under the row lock, so they skip it.
+        if req.IdempotencyKey == "" && int64(inventory.Version) != int64(product.Version) {
+            tx.Rollback()
+            return nil, status.Errorf(codes.Aborted, "Inventory update aborted due to version mismatch")
+        }
+
+        // Update the stock at the warehouse; stock held by reservations can't be taken away
+        product, err = adjustStock(tx, product.ID, uint(inventory.VariantId), uint(inventory.WarehouseId), int(inventory.QuantityChange), 0, req.IdempotencyKey, source)
+        if err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+        res.Inventories = append(res.Inventories, inventoryResponse(product))
+    }
+
+    // Record the result under the idempotency key in the same transaction
+    if req.IdempotencyKey != "" {
+        if err := s.recordInventoryOperation(tx, req.IdempotencyKey, res); err != nil {
+            tx.Rollback()
+            // A concurrent request with the same key won the race; return its result
+            if replayed, findErr := s.findInventoryOperation(s.db, req.IdempotencyKey); findErr == nil && replayed != nil {
+                return replayed, nil
+            }
+            return nil, status.Errorf(codes.Internal, "Error recording inventory operation: %v", err)
+        }
     }
 
     // Commit the transaction
-    tx.Commit()
+    if err := tx.Commit().Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
+    }
 
     // Prepare and return the response
     return res, nil
 }
 
+// findInventoryOperation returns the recorded response for an idempotency key, or nil if none exists
+func (s *server) findInventoryOperation(db *gorm.DB, key string) (*pb.InventoriesResponse, error) {
+    var operation models.InventoryOperation
+    if err := db.Where("idempotency_key = ?", key).First(&operation).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, nil
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving inventory operation: %v", err)
+    }
+
+    res := &pb.InventoriesResponse{}
+    if err := proto.Unmarshal(operation.Response, res); err != nil {
+        return nil, status.Errorf(codes.Internal, "Error decoding inventory operation: %v", err)
+    }
+    return res, nil
+}
+
+// recordInventoryOperation stores the response of a keyed inventory update
+func (s *server) recordInventoryOperation(tx *gorm.DB, key string, res *pb.InventoriesResponse) error {
+    data, err := proto.Marshal(res)
+    if err != nil {
+        return err
+    }
+    return tx.Create(&models.InventoryOperation{IdempotencyKey: key, Response: data}).Error
+}
+
 func (s *server) GetInventory(ctx context.Context, req *pb.GetInventoryRequest) (*pb.InventoryResponse, error) {
     var product models.Product
 
@@ -345,17 +540,29 @@ func (s *server) GetInventory(ctx context.Context, req *pb.GetInventoryRequest)
         return nil, status.Errorf(codes.Internal, "Error retrieving product: %v", result.Error)
     }
 
-    // Prepare and return the response with the inventory details
-    response := &pb.InventoryResponse{
-        ProductId: int64(product.ID),
-        Quantity:  int32(product.Quantity),
-        Version:   int64(product.Version),
+    // Report the stock at each warehouse and of each variant, or only the ones asked for
+    var levels []models.StockLevel
+    query := s.db.Joins("Warehouse").Where("stock_levels.product_id = ?", product.ID)
+    if req.WarehouseId != 0 {
+        query = query.Where("stock_levels.warehouse_id = ?", req.WarehouseId)
+    }
+    if req.VariantId != 0 {
+        query = query.Where("stock_levels.variant_id = ?", req.VariantId)
+    }
+    if err := query.Order("stock_levels.variant_id, stock_levels.warehouse_id").Find(&levels).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving stock levels: %v", err)
     }
 
+    // Prepare and return the response with the inventory details
+    response := inventoryResponse(product)
+    for _, level := range levels {
+        response.StockLevels = append(response.StockLevels, toProtoStockLevel(level))
+    }
     return response, nil
 }
 
 
+
 func main() {
 	db := initDB()
 	fmt.Println(db)
@@ -363,8 +570,28 @@ func main() {
     if err != nil {
         log.Fatalf("failed to listen: %v", err)
     }
-    s := grpc.NewServer()
+    // Only other services holding the shared token may call product-service
+    if serviceToken, err = serviceauth.TokenFromEnv(); err != nil {
+        log.Fatalf("failed to configure service authentication: %v", err)
+    }
+    s := grpc.NewServer(grpc.UnaryInterceptor(serviceauth.UnaryServerInterceptor(serviceToken)))
     serv := &server{db: db}
+
+    // Publish outbox events to the configured broker
+    broker, err := events.NewBrokerFromEnv("product-service")
+    if err != nil {
+        log.Fatalf("failed to create event broker: %v", err)
+    }
+    if broker != nil {
+        defer broker.Close()
+        go events.NewRelay(db, broker).Run(context.Background())
+    } else {
+        log.Printf("EVENT_BROKER is none, events are kept in the outbox")
+    }
+
+    // Give back the stock of reservations that were never committed
+    go serv.runReservationSweeper()
+
     pb.RegisterProductServiceServer(s, serv)
     log.Printf("server listening at %v", lis.Addr())
     if err := s.Serve(lis); err != nil {
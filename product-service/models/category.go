@@ -0,0 +1,15 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// Category is a node in the category tree. Products are assigned to
+// categories through the product_categories join table.
+type Category struct {
+    gorm.Model
+    Name     string
+    Slug     string     `gorm:"uniqueIndex"`
+    ParentID *uint      `gorm:"index"` // Nil for top-level categories
+    Children []Category `gorm:"foreignKey:ParentID"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// InventoryOperation records a keyed inventory update so that retries with the
+// same idempotency key replay the original result instead of changing stock again
+type InventoryOperation struct {
+    gorm.Model
+    IdempotencyKey string `gorm:"uniqueIndex"`
+    Response       []byte // Serialized InventoriesResponse returned the first time
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+    "time"
+)
+
+// StockMovement is an append-only ledger entry for a change to the on hand
+// stock of a product at a warehouse. Summing the deltas of a product at a
+// warehouse (and variant) gives the quantity its stock level should hold.
+type StockMovement struct {
+    ID            uint           `gorm:"primarykey"`
+    ProductID     uint           `gorm:"index:idx_stock_movements_product_warehouse"`
+    VariantID     uint           `gorm:"not null;default:0"` // 0 for products without variants
+    WarehouseID   uint           `gorm:"index:idx_stock_movements_product_warehouse"`
+    Delta         int
+    QuantityAfter int            // On hand at the warehouse after the change
+    Reason        MovementReason `gorm:"index"`
+    ReferenceID   string         `gorm:"index"` // What caused the change, e.g. a reservation key or order
+    ActorID       uint           // User who made the change, 0 for other services
+    CreatedAt     time.Time
+}
+
+// MovementReason says why stock changed
+type MovementReason string
+
+// Enum values for MovementReason
+const (
+    MovementOpening    MovementReason = "OPENING" // Stock that existed when the product or the ledger was created
+    MovementSale       MovementReason = "SALE"
+    MovementRestock    MovementReason = "RESTOCK"
+    MovementAdjustment MovementReason = "ADJUSTMENT"
+    MovementReturn     MovementReason = "RETURN"
+    MovementCancel     MovementReason = "CANCEL"
+)
+
+// IsValid reports whether the reason is one of the declared reasons
+func (r MovementReason) IsValid() bool {
+    switch r {
+    case MovementOpening, MovementSale, MovementRestock, MovementAdjustment, MovementReturn, MovementCancel:
+        return true
+    }
+    return false
+}
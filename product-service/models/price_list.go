@@ -0,0 +1,32 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// PriceList holds prices in one currency for every customer, or for one
+// customer group, within an optional validity window
+type PriceList struct {
+    gorm.Model
+    Name          string
+    Currency      string     `gorm:"size:3;not null;index"`
+    CustomerGroup string     `gorm:"not null;default:''"` // Empty for every customer
+    Priority      int        `gorm:"not null;default:0"`  // Higher wins when several lists price an item
+    ValidFrom     *time.Time // Nil for no start
+    ValidUntil    *time.Time // Nil for no end
+    Prices        []PriceListPrice
+}
+
+// PriceListPrice is the price of a product, or one of its variants, on a
+// price list from a minimum quantity. The prices of a list are replaced as a
+// whole, so they are deleted outright.
+type PriceListPrice struct {
+    ID          uint  `gorm:"primarykey"`
+    PriceListID uint  `gorm:"uniqueIndex:idx_price_list_prices_item"`
+    ProductID   uint  `gorm:"uniqueIndex:idx_price_list_prices_item;index"`
+    VariantID   uint  `gorm:"uniqueIndex:idx_price_list_prices_item"` // 0 prices the product and all of its variants
+    MinQuantity int   `gorm:"uniqueIndex:idx_price_list_prices_item"` // Quantity break the price starts at
+    Amount      int64 // In minor units of the list's currency
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+    "github.com/atullal/ecommerce-backend-protobuf/money"
     "gorm.io/gorm"
 )
 
@@ -8,7 +9,27 @@ type Product struct {
     gorm.Model
     Name        string
     Description string
-    Price       float64
-    Quantity    int
+    Price       int64  // In minor units of Currency
+    Currency    string `gorm:"size:3;not null;default:''"` // ISO 4217 code of the price and of all variant prices
+    TaxClass    string `gorm:"size:32;not null;default:''"` // Tax class for tax rules, empty for the standard class
+    Weight      int    `gorm:"not null;default:0"` // Shipping weight in grams
+    Length      int    `gorm:"not null;default:0"` // Packed size in millimetres, 0 when unknown
+    Width       int    `gorm:"not null;default:0"`
+    Height      int    `gorm:"not null;default:0"`
+    Quantity    int // Stock on hand, summed over all warehouses
+    Reserved    int `gorm:"not null;default:0"` // Stock held by reservations that are not yet committed, summed over all warehouses
     Version     int // Optimistic locking version
+    Categories  []Category `gorm:"many2many:product_categories;"`
+    Options     []ProductOption // Option axes of the variants, ordered by Position
+    Variants    []Variant       // Products with variants keep their stock per variant
+}
+
+// Money returns an amount in the product's currency
+func (p Product) Money(amount int64) money.Money {
+    return money.Money{Amount: amount, Currency: p.Currency}
+}
+
+// Available is the stock that can still be sold or reserved
+func (p Product) Available() int {
+    return p.Quantity - p.Reserved
 }
@@ -0,0 +1,38 @@
+package models
+
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Reservation holds stock for a checkout until it is committed as a sale,
+// released, or expires
+type Reservation struct {
+    gorm.Model
+    ReservationKey string            `gorm:"uniqueIndex"` // Client chosen key, makes reserving idempotent
+    Status         ReservationStatus `gorm:"index"`
+    ExpiresAt      time.Time         `gorm:"index"`
+    Items          []ReservationItem // Association with ReservationItem
+}
+
+// ReservationItem is the quantity of a product held by a reservation
+type ReservationItem struct {
+    gorm.Model
+    ReservationID uint `gorm:"index"` // Foreign key for the Reservation
+    ProductID     uint
+    VariantID     uint `gorm:"not null;default:0"` // 0 for products without variants
+    WarehouseID   uint `gorm:"not null;default:0"` // Warehouse the item is held at, 0 for the default warehouse
+    Quantity      int
+}
+
+// ReservationStatus represents the state of a reservation
+type ReservationStatus string
+
+// Enum values for ReservationStatus
+const (
+    ReservationHeld      ReservationStatus = "HELD"
+    ReservationCommitted ReservationStatus = "COMMITTED"
+    ReservationReleased  ReservationStatus = "RELEASED"
+    ReservationExpired   ReservationStatus = "EXPIRED" // Released by the sweeper after ExpiresAt
+)
@@ -0,0 +1,57 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// ProductOption is an axis a product varies along, e.g. size or color
+type ProductOption struct {
+    gorm.Model
+    ProductID uint `gorm:"index"`
+    Name      string
+    Position  int                  // Order of the axis on the product
+    Values    []ProductOptionValue `gorm:"foreignKey:OptionID"`
+}
+
+// ProductOptionValue is one value an option can take, e.g. "M" for size
+type ProductOptionValue struct {
+    gorm.Model
+    OptionID uint `gorm:"index"`
+    Value    string
+    Position int // Order of the value within the option
+}
+
+// Variant is a sellable SKU of a product: one combination of its option
+// values, with its own price and stock. Quantity and Reserved hold the sums
+// over the variant's stock levels.
+type Variant struct {
+    gorm.Model
+    ProductID     uint   `gorm:"index"`
+    SKU           string `gorm:"uniqueIndex"`
+    Barcode       string `gorm:"index"`
+    PriceOverride *int64          // Replaces the product price when set, in the product's currency
+    Quantity      int             `gorm:"not null;default:0"`
+    Reserved      int             `gorm:"not null;default:0"`
+    Options       []VariantOption // One value per option of the product
+}
+
+// VariantOption is the value a variant has for one option of its product
+type VariantOption struct {
+    gorm.Model
+    VariantID uint `gorm:"index"`
+    OptionID  uint
+    Value     string
+}
+
+// Price is what the variant sells for: its override, or the product price
+func (v Variant) Price(product Product) int64 {
+    if v.PriceOverride != nil {
+        return *v.PriceOverride
+    }
+    return product.Price
+}
+
+// Available is the stock of the variant that can still be sold or reserved
+func (v Variant) Available() int {
+    return v.Quantity - v.Reserved
+}
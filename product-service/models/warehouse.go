@@ -0,0 +1,38 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// DefaultWarehouseCode identifies the warehouse used when a request names none.
+// Stock that existed before warehouses were introduced is moved there.
+const DefaultWarehouseCode = "DEFAULT"
+
+// Warehouse is a location that holds stock
+type Warehouse struct {
+    gorm.Model
+    Code      string `gorm:"uniqueIndex"`
+    Name      string
+    Latitude  float64
+    Longitude float64
+    Priority  int  `gorm:"not null;default:0"` // Lower values are preferred by the PRIORITY strategy
+    Active    bool `gorm:"not null;default:true"`
+}
+
+// StockLevel is the stock of one product, or one of its variants, at one
+// warehouse. Product.Quantity and Product.Reserved hold the sums over all of a
+// product's stock levels.
+type StockLevel struct {
+    gorm.Model
+    ProductID   uint `gorm:"uniqueIndex:idx_stock_levels_product_variant_warehouse"`
+    VariantID   uint `gorm:"not null;default:0;uniqueIndex:idx_stock_levels_product_variant_warehouse"` // 0 for products without variants
+    WarehouseID uint `gorm:"uniqueIndex:idx_stock_levels_product_variant_warehouse"`
+    Warehouse   Warehouse
+    Quantity    int // Stock on hand
+    Reserved    int // Stock held by reservations that are not yet committed
+}
+
+// Available is the stock at this warehouse that can still be sold or reserved
+func (l StockLevel) Available() int {
+    return l.Quantity - l.Reserved
+}
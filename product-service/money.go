@@ -0,0 +1,91 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "product-service/models"
+)
+
+// migrateMoneyColumns converts the price columns kept as decimals before
+// prices were stored in minor units. Existing prices are in the store
+// currency and are rounded half away from zero, the rule money.Parse follows.
+// It has to run before AutoMigrate, which would cut them to whole units.
+func migrateMoneyColumns(db *gorm.DB, currency string) error {
+    scale, err := money.Scale(currency)
+    if err != nil {
+        return err
+    }
+    columns := []struct{ table, column string }{{"products", "price"}, {"variants", "price_override"}}
+    return db.Transaction(func(tx *gorm.DB) error {
+        for _, c := range columns {
+            var dataType string
+            err := tx.Raw(`SELECT data_type FROM information_schema.columns
+                WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, c.table, c.column).Scan(&dataType).Error
+            if err != nil {
+                return err
+            }
+            if dataType != "numeric" && dataType != "double precision" && dataType != "real" {
+                continue
+            }
+            if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE bigint USING round(%[2]s::numeric * %[3]d)`, c.table, c.column, scale)).Error; err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// backfillCurrency puts products from before prices had a currency in the
+// store currency
+func backfillCurrency(db *gorm.DB, currency string) error {
+    return db.Model(&models.Product{}).Where("currency = ''").UpdateColumn("currency", currency).Error
+}
+
+// priceFromProto reads a product price. Prices can't be negative, and are in
+// the store currency unless they name another.
+func priceFromProto(price *pb.Money) (money.Money, error) {
+    if price == nil {
+        price = &pb.Money{}
+    }
+    currency := price.Currency
+    if currency == "" {
+        currency = money.StoreCurrency()
+    }
+    amount, err := money.New(price.Amount, currency)
+    if err != nil {
+        return money.Money{}, status.Errorf(codes.InvalidArgument, "Unknown currency '%s'", currency)
+    }
+    if amount.Amount < 0 {
+        return money.Money{}, status.Errorf(codes.InvalidArgument, "Price can't be negative")
+    }
+    return amount, nil
+}
+
+// priceOverride reads the price override of a variant, which has to be in the
+// currency of its product. Unset means none.
+func priceOverride(price *pb.Money, product models.Product) (*int64, error) {
+    if price == nil {
+        return nil, nil
+    }
+    if price.Currency == "" {
+        price = &pb.Money{Amount: price.Amount, Currency: product.Currency}
+    }
+    amount, err := priceFromProto(price)
+    if err != nil {
+        return nil, err
+    }
+    if amount.Currency != product.Currency {
+        return nil, status.Errorf(codes.InvalidArgument, "Price override must be in the product's currency %s", product.Currency)
+    }
+    return &amount.Amount, nil
+}
+
+// toProtoMoney converts an amount to protobuf
+func toProtoMoney(amount money.Money) *pb.Money {
+    return &pb.Money{Amount: amount.Amount, Currency: amount.Currency}
+}
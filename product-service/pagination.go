@@ -0,0 +1,71 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/protobuf/proto"
+)
+
+const (
+    // defaultProductPageSize is used when a listing doesn't ask for a page size
+    defaultProductPageSize = 10
+    // maxProductPageSize caps the page size of product listings
+    maxProductPageSize = 100
+)
+
+// productCursor is the position after the last product of a page: the sort
+// key of that product, and the listing it belongs to
+type productCursor struct {
+    Listing   string    `json:"l"` // listingHash of the request, so a token only continues its own listing
+    ID        uint      `json:"i"`
+    Name      string    `json:"n,omitempty"`
+    Price     int64     `json:"p,omitempty"`
+    Rank      float32   `json:"r,omitempty"`
+    CreatedAt time.Time `json:"c,omitempty"`
+}
+
+// productPageSize returns the requested page size within the allowed range
+func productPageSize(pageSize int32) int {
+    if pageSize < 1 {
+        return defaultProductPageSize
+    }
+    if pageSize > maxProductPageSize {
+        return maxProductPageSize
+    }
+    return int(pageSize)
+}
+
+// listingHash identifies the filters and sort of a listing request
+func listingHash(req *pb.ListProductsRequest) string {
+    listing := proto.Clone(req).(*pb.ListProductsRequest)
+    listing.Page, listing.PageSize, listing.PageToken = 0, 0, ""
+    data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(listing)
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:8])
+}
+
+// encodeProductCursor turns a cursor into an opaque page token
+func encodeProductCursor(cursor productCursor) string {
+    data, _ := json.Marshal(cursor)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeProductCursor reads a page token of the given listing
+func decodeProductCursor(token, listing string) (*productCursor, error) {
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    var cursor productCursor
+    if err == nil {
+        err = json.Unmarshal(data, &cursor)
+    }
+    if err != nil || cursor.Listing != listing {
+        return nil, status.Errorf(codes.InvalidArgument, "Invalid page token")
+    }
+    return &cursor, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "product-service/models"
+)
+
+func TestProductPageSize(t *testing.T) {
+    tests := []struct {
+        pageSize int32
+        want     int
+    }{
+        {pageSize: 0, want: defaultProductPageSize},
+        {pageSize: -3, want: defaultProductPageSize},
+        {pageSize: 25, want: 25},
+        {pageSize: 1000, want: maxProductPageSize},
+    }
+    for _, tt := range tests {
+        if got := productPageSize(tt.pageSize); got != tt.want {
+            t.Errorf("productPageSize(%d) = %d, want %d", tt.pageSize, got, tt.want)
+        }
+    }
+}
+
+func TestListingHash(t *testing.T) {
+    listing := &pb.ListProductsRequest{Categories: []string{"shoes"}, Sort: "PRICE_ASC"}
+    tests := []struct {
+        name string
+        req  *pb.ListProductsRequest
+        same bool
+    }{
+        {name: "next page", req: &pb.ListProductsRequest{Categories: []string{"shoes"}, Sort: "PRICE_ASC", Page: 2, PageSize: 50, PageToken: "abc"}, same: true},
+        {name: "other filters", req: &pb.ListProductsRequest{Categories: []string{"socks"}, Sort: "PRICE_ASC"}},
+        {name: "other sort", req: &pb.ListProductsRequest{Categories: []string{"shoes"}, Sort: "NAME"}},
+    }
+    for _, tt := range tests {
+        if same := listingHash(tt.req) == listingHash(listing); same != tt.same {
+            t.Errorf("%s: same listing = %v, want %v", tt.name, same, tt.same)
+        }
+    }
+    if listing.PageSize != 0 || len(listing.Categories) != 1 {
+        t.Errorf("listingHash changed the request to %v", listing)
+    }
+}
+
+func TestProductCursor(t *testing.T) {
+    cursor := productCursor{Listing: "abc", ID: 7, Name: "Road Shoe", Price: 5000, Rank: 0.5, CreatedAt: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+    token := encodeProductCursor(cursor)
+
+    tests := []struct {
+        name    string
+        token   string
+        listing string
+        code    codes.Code
+    }{
+        {name: "same listing", token: token, listing: "abc"},
+        {name: "other listing", token: token, listing: "def", code: codes.InvalidArgument},
+        {name: "not base64", token: "%%%", listing: "abc", code: codes.InvalidArgument},
+        {name: "not a cursor", token: "bm9wZQ", listing: "abc", code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := decodeProductCursor(tt.token, tt.listing)
+            if status.Code(err) != tt.code {
+                t.Fatalf("decodeProductCursor error = %v, want %v", err, tt.code)
+            }
+            if err == nil && (*got != cursor) {
+                t.Errorf("decodeProductCursor = %+v, want %+v", *got, cursor)
+            }
+        })
+    }
+}
+
+func TestListProductsPages(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+
+    // Prices repeat, so pages have to break ties by ID
+    for i := 0; i < 7; i++ {
+        product := models.Product{Name: fmt.Sprintf("Product %d", 7-i), Price: int64(1000 * (i % 3)), Currency: "USD"}
+        if err := db.Create(&product).Error; err != nil {
+            t.Fatalf("failed to create product: %v", err)
+        }
+    }
+
+    for _, sort := range []string{sortNewest, sortName, sortPriceAsc, sortPriceDesc} {
+        t.Run(sort, func(t *testing.T) {
+            // The whole listing on one page is the order the pages must follow
+            all, err := s.ListProducts(ctx, &pb.ListProductsRequest{Sort: sort, PageSize: 100})
+            if err != nil {
+                t.Fatalf("ListProducts error = %v", err)
+            }
+            if len(all.Products) != 7 || all.NextPageToken != "" {
+                t.Fatalf("listed %d products with next page %q, want 7 on a single page", len(all.Products), all.NextPageToken)
+            }
+
+            req := &pb.ListProductsRequest{Sort: sort, PageSize: 3}
+            var paged []int64
+            for pages := 0; pages < 5; pages++ {
+                res, err := s.ListProducts(ctx, req)
+                if err != nil {
+                    t.Fatalf("ListProducts error = %v", err)
+                }
+                for _, product := range res.Products {
+                    paged = append(paged, product.Id)
+                }
+                if res.NextPageToken == "" {
+                    break
+                }
+                req.PageToken = res.NextPageToken
+            }
+            if len(paged) != len(all.Products) {
+                t.Fatalf("paged through %v, want %d products", paged, len(all.Products))
+            }
+            for i, product := range all.Products {
+                if paged[i] != product.Id {
+                    t.Errorf("product %d = %d, want %d", i, paged[i], product.Id)
+                }
+            }
+
+            // A token only continues its own listing
+            req.Sort = sortName
+            if sort == sortName {
+                req.Sort = sortNewest
+            }
+            if _, err := s.ListProducts(ctx, req); status.Code(err) != codes.InvalidArgument {
+                t.Errorf("ListProducts with another listing's token error = %v, want %v", err, codes.InvalidArgument)
+            }
+        })
+    }
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "product-service/models"
+)
+
+// priceQuery is whom prices are resolved for: a customer group buying in a
+// currency at a point in time
+type priceQuery struct {
+    Currency      string
+    CustomerGroup string
+    At            time.Time
+}
+
+// listPrice is a price list entry that may apply to an item
+type listPrice struct {
+    PriceListID   uint
+    CustomerGroup string
+    Priority      int
+    ProductID     uint
+    VariantID     uint
+    MinQuantity   int
+    Amount        int64
+}
+
+// newPriceQuery validates the currency and customer group prices are
+// resolved for. An empty currency means the store currency.
+func newPriceQuery(currency, customerGroup string) (priceQuery, error) {
+    if currency == "" {
+        currency = money.StoreCurrency()
+    }
+    normalized, err := money.Normalize(currency)
+    if err != nil {
+        return priceQuery{}, status.Errorf(codes.InvalidArgument, "Unknown currency '%s'", currency)
+    }
+    return priceQuery{Currency: normalized, CustomerGroup: strings.ToLower(customerGroup), At: time.Now()}, nil
+}
+
+// listPrices loads the entries for the given products of the price lists
+// that apply to a query
+func listPrices(db *gorm.DB, query priceQuery, productIDs []uint) ([]listPrice, error) {
+    var prices []listPrice
+    err := db.Table("price_list_prices p").
+        Select("p.price_list_id, l.customer_group, l.priority, p.product_id, p.variant_id, p.min_quantity, p.amount").
+        Joins("JOIN price_lists l ON l.id = p.price_list_id AND l.deleted_at IS NULL").
+        Where("l.currency = ? AND l.customer_group IN ?", query.Currency, []string{"", query.CustomerGroup}).
+        Where("(l.valid_from IS NULL OR l.valid_from <= ?) AND (l.valid_until IS NULL OR l.valid_until > ?)", query.At, query.At).
+        Where("p.product_id IN ?", productIDs).
+        Scan(&prices).Error
+    return prices, err
+}
+
+// bestListPrice picks the price list entry for quantity of a product or
+// variant. Lists for the customer's group win over lists for everyone, then
+// higher priority and then newer lists win. Within a list a price for the
+// variant wins over one for the whole product, and the highest quantity
+// break the quantity reaches applies.
+func bestListPrice(prices []listPrice, productID, variantID uint, quantity int) (listPrice, bool) {
+    var best listPrice
+    found := false
+    for _, price := range prices {
+        if price.ProductID != productID || (price.VariantID != 0 && price.VariantID != variantID) || price.MinQuantity > quantity {
+            continue
+        }
+        if !found || betterListPrice(price, best) {
+            best, found = price, true
+        }
+    }
+    return best, found
+}
+
+// betterListPrice reports whether a takes precedence over b
+func betterListPrice(a, b listPrice) bool {
+    if (a.CustomerGroup != "") != (b.CustomerGroup != "") {
+        return a.CustomerGroup != ""
+    }
+    if a.Priority != b.Priority {
+        return a.Priority > b.Priority
+    }
+    if a.PriceListID != b.PriceListID {
+        return a.PriceListID > b.PriceListID
+    }
+    if (a.VariantID != 0) != (b.VariantID != 0) {
+        return a.VariantID != 0
+    }
+    return a.MinQuantity > b.MinQuantity
+}
+
+// effectivePrice is what a query's customer pays per unit when buying
+// quantity of a product, or of one of its variants: the best price list
+// price, or else the product's own price if it is in the query's currency.
+// It also returns the price list the price comes from, 0 for the product's own.
+func effectivePrice(prices []listPrice, query priceQuery, product models.Product, variant *models.Variant, quantity int) (money.Money, uint, bool) {
+    var variantID uint
+    if variant != nil {
+        variantID = variant.ID
+    }
+    if price, ok := bestListPrice(prices, product.ID, variantID, quantity); ok {
+        return money.Money{Amount: price.Amount, Currency: query.Currency}, price.PriceListID, true
+    }
+    if product.Currency != query.Currency {
+        return money.Money{}, 0, false
+    }
+    if variant != nil {
+        return product.Money(variant.Price(product)), 0, true
+    }
+    return product.Money(product.Price), 0, true
+}
+
+// priceProducts replaces the prices of products converted to protobuf with
+// what the calling customer pays for one unit in currency. Products that have
+// no price in the currency keep their own price, in their own currency.
+func (s *server) priceProducts(ctx context.Context, currency string, products []models.Product, res []*pb.Product) error {
+    query, err := newPriceQuery(currency, customerGroupFromContext(ctx))
+    if err != nil {
+        return err
+    }
+    if len(products) == 0 {
+        return nil
+    }
+    ids := make([]uint, 0, len(products))
+    for _, product := range products {
+        ids = append(ids, product.ID)
+    }
+    prices, err := listPrices(s.db, query, ids)
+    if err != nil {
+        return status.Errorf(codes.Internal, "Error retrieving prices: %v", err)
+    }
+
+    for i, product := range products {
+        if price, _, ok := effectivePrice(prices, query, product, nil, 1); ok {
+            res[i].Price = toProtoMoney(price)
+        }
+        for j := range product.Variants {
+            if price, _, ok := effectivePrice(prices, query, product, &product.Variants[j], 1); ok {
+                res[i].Variants[j].Price = toProtoMoney(price)
+            }
+        }
+    }
+    return nil
+}
+
+func (s *server) ResolvePrices(ctx context.Context, req *pb.ResolvePricesRequest) (*pb.ResolvePricesResponse, error) {
+    query, err := newPriceQuery(req.Currency, req.CustomerGroup)
+    if err != nil {
+        return nil, err
+    }
+
+    ids := make([]uint, 0, len(req.Items))
+    for _, item := range req.Items {
+        ids = append(ids, uint(item.ProductId))
+    }
+    var found []models.Product
+    if err := s.db.Preload("Variants").Preload("Categories").Where("id IN ?", ids).Find(&found).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving products: %v", err)
+    }
+    parents, err := categoryParents(s.db)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving categories: %v", err)
+    }
+    products := make(map[uint]models.Product, len(found))
+    for _, product := range found {
+        products[product.ID] = product
+    }
+    prices, err := listPrices(s.db, query, ids)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving prices: %v", err)
+    }
+
+    res := &pb.ResolvePricesResponse{}
+    for _, item := range req.Items {
+        product, ok := products[uint(item.ProductId)]
+        if !ok {
+            return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", item.ProductId)
+        }
+        if item.Quantity < 1 {
+            return nil, status.Errorf(codes.InvalidArgument, "Quantity must be positive")
+        }
+
+        // Products with variants are sold per variant
+        var variant *models.Variant
+        if item.VariantId == 0 && len(product.Variants) > 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Product with ID '%d' has variants; a variant is required", product.ID)
+        }
+        for i := range product.Variants {
+            if int64(product.Variants[i].ID) == item.VariantId {
+                variant = &product.Variants[i]
+            }
+        }
+        if item.VariantId != 0 && variant == nil {
+            return nil, status.Errorf(codes.NotFound, "Variant with ID '%d' not found", item.VariantId)
+        }
+
+        price, priceListID, ok := effectivePrice(prices, query, product, variant, int(item.Quantity))
+        if !ok {
+            return nil, status.Errorf(codes.FailedPrecondition, "Product with ID '%d' has no price in %s", product.ID, query.Currency)
+        }
+        res.Prices = append(res.Prices, &pb.ResolvedPrice{
+            ProductId:   item.ProductId,
+            VariantId:   item.VariantId,
+            Price:       toProtoMoney(price),
+            PriceListId: int64(priceListID),
+            CategoryIds: categoryLineage(parents, product.Categories),
+            TaxClass:    product.TaxClass,
+            Weight:      int32(product.Weight),
+            Dimensions:  toProtoDimensions(product),
+        })
+    }
+    return res, nil
+}
+
+func (s *server) CreatePriceList(ctx context.Context, req *pb.CreatePriceListRequest) (*pb.PriceListResponse, error) {
+    list, err := newPriceList(req.Name, req.Currency, req.CustomerGroup, req.Priority, req.ValidFrom, req.ValidUntil)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.db.Create(&list).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error creating price list: %v", err)
+    }
+    return &pb.PriceListResponse{PriceList: toProtoPriceList(list)}, nil
+}
+
+func (s *server) GetPriceList(ctx context.Context, req *pb.GetPriceListRequest) (*pb.PriceListResponse, error) {
+    list, err := findPriceList(s.db, req.Id)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.PriceListResponse{PriceList: toProtoPriceList(*list)}, nil
+}
+
+func (s *server) UpdatePriceList(ctx context.Context, req *pb.UpdatePriceListRequest) (*pb.PriceListResponse, error) {
+    update, err := newPriceList(req.Name, req.Currency, req.CustomerGroup, req.Priority, req.ValidFrom, req.ValidUntil)
+    if err != nil {
+        return nil, err
+    }
+
+    var list *models.PriceList
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if list, err = findPriceList(tx.Clauses(clause.Locking{Strength: "UPDATE"}), req.Id); err != nil {
+            return err
+        }
+        // The prices on the list are amounts of its currency
+        if update.Currency != list.Currency && len(list.Prices) > 0 {
+            return status.Errorf(codes.FailedPrecondition, "Price list with ID '%d' has prices in %s", list.ID, list.Currency)
+        }
+
+        list.Name = update.Name
+        list.Currency = update.Currency
+        list.CustomerGroup = update.CustomerGroup
+        list.Priority = update.Priority
+        list.ValidFrom = update.ValidFrom
+        list.ValidUntil = update.ValidUntil
+        return tx.Select("name", "currency", "customer_group", "priority", "valid_from", "valid_until").Save(list).Error
+    })
+    if err != nil {
+        return nil, priceListError(err)
+    }
+    return &pb.PriceListResponse{PriceList: toProtoPriceList(*list)}, nil
+}
+
+func (s *server) DeletePriceList(ctx context.Context, req *pb.DeletePriceListRequest) (*pb.DeletePriceListResponse, error) {
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        list, err := findPriceList(tx, req.Id)
+        if err != nil {
+            return err
+        }
+        if err := tx.Where("price_list_id = ?", list.ID).Delete(&models.PriceListPrice{}).Error; err != nil {
+            return err
+        }
+        return tx.Delete(list).Error
+    })
+    if err != nil {
+        return nil, priceListError(err)
+    }
+    return &pb.DeletePriceListResponse{Success: true}, nil
+}
+
+func (s *server) ListPriceLists(ctx context.Context, req *pb.ListPriceListsRequest) (*pb.ListPriceListsResponse, error) {
+    var lists []models.PriceList
+    if err := s.db.Order("currency, customer_group, priority DESC, id").Find(&lists).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving price lists: %v", err)
+    }
+    res := &pb.ListPriceListsResponse{}
+    for _, list := range lists {
+        res.PriceLists = append(res.PriceLists, toProtoPriceList(list))
+    }
+    return res, nil
+}
+
+func (s *server) SetPriceListPrices(ctx context.Context, req *pb.SetPriceListPricesRequest) (*pb.PriceListResponse, error) {
+    var list *models.PriceList
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        if list, err = findPriceList(tx.Clauses(clause.Locking{Strength: "UPDATE"}), req.PriceListId); err != nil {
+            return err
+        }
+        prices, err := newPriceListPrices(tx, list.ID, req.Prices)
+        if err != nil {
+            return err
+        }
+
+        if err := tx.Where("price_list_id = ?", list.ID).Delete(&models.PriceListPrice{}).Error; err != nil {
+            return err
+        }
+        if len(prices) > 0 {
+            if err := tx.Create(&prices).Error; err != nil {
+                return err
+            }
+        }
+        list.Prices = prices
+        return nil
+    })
+    if err != nil {
+        return nil, priceListError(err)
+    }
+    return &pb.PriceListResponse{PriceList: toProtoPriceList(*list)}, nil
+}
+
+// newPriceList validates the fields of a price list being saved
+func newPriceList(name, currency, customerGroup string, priority int32, validFrom, validUntil string) (models.PriceList, error) {
+    list := models.PriceList{
+        Name:          strings.TrimSpace(name),
+        CustomerGroup: strings.ToLower(strings.TrimSpace(customerGroup)),
+        Priority:      int(priority),
+    }
+    if list.Name == "" {
+        return list, status.Errorf(codes.InvalidArgument, "Price list name is required")
+    }
+    var err error
+    if list.Currency, err = money.Normalize(currency); err != nil {
+        return list, status.Errorf(codes.InvalidArgument, "Unknown currency '%s'", currency)
+    }
+    if list.CustomerGroup != "" && !slugPattern.MatchString(list.CustomerGroup) {
+        return list, status.Errorf(codes.InvalidArgument, "Invalid customer group '%s'", customerGroup)
+    }
+    if list.ValidFrom, err = parseValidity(validFrom); err != nil {
+        return list, err
+    }
+    if list.ValidUntil, err = parseValidity(validUntil); err != nil {
+        return list, err
+    }
+    if list.ValidFrom != nil && list.ValidUntil != nil && !list.ValidUntil.After(*list.ValidFrom) {
+        return list, status.Errorf(codes.InvalidArgument, "Price list must end after it starts")
+    }
+    return list, nil
+}
+
+// parseValidity reads a bound of a validity window, where empty means none
+func parseValidity(value string) (*time.Time, error) {
+    if value == "" {
+        return nil, nil
+    }
+    parsed, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return nil, status.Errorf(codes.InvalidArgument, "Invalid time '%s', expected RFC 3339", value)
+    }
+    return &parsed, nil
+}
+
+// newPriceListPrices validates the prices of a price list. Each must be for
+// an existing product, and variant of it, and there can only be one price per
+// product, variant and quantity break.
+func newPriceListPrices(tx *gorm.DB, priceListID uint, entries []*pb.PriceListPrice) ([]models.PriceListPrice, error) {
+    ids := make([]int64, 0, len(entries))
+    for _, entry := range entries {
+        ids = append(ids, entry.ProductId)
+    }
+    var products []models.Product
+    if len(ids) > 0 {
+        if err := tx.Preload("Variants").Where("id IN ?", ids).Find(&products).Error; err != nil {
+            return nil, err
+        }
+    }
+    variants := make(map[uint]map[uint]bool, len(products))
+    for _, product := range products {
+        variants[product.ID] = map[uint]bool{0: true}
+        for _, variant := range product.Variants {
+            variants[product.ID][variant.ID] = true
+        }
+    }
+
+    type priceKey struct {
+        ProductID, VariantID uint
+        MinQuantity          int
+    }
+    seen := make(map[priceKey]bool, len(entries))
+    prices := make([]models.PriceListPrice, 0, len(entries))
+    for _, entry := range entries {
+        price := models.PriceListPrice{
+            PriceListID: priceListID,
+            ProductID:   uint(entry.ProductId),
+            VariantID:   uint(entry.VariantId),
+            MinQuantity: int(entry.MinQuantity),
+            Amount:      entry.Amount,
+        }
+        if price.MinQuantity == 0 {
+            price.MinQuantity = 1
+        }
+        if _, ok := variants[price.ProductID]; !ok {
+            return nil, status.Errorf(codes.NotFound, "Product with ID '%d' not found", entry.ProductId)
+        }
+        if !variants[price.ProductID][price.VariantID] {
+            return nil, status.Errorf(codes.NotFound, "Variant with ID '%d' not found", entry.VariantId)
+        }
+        if price.MinQuantity < 1 || price.Amount < 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Prices need a positive minimum quantity and can't be negative")
+        }
+        key := priceKey{price.ProductID, price.VariantID, price.MinQuantity}
+        if seen[key] {
+            return nil, status.Errorf(codes.InvalidArgument, "Product with ID '%d' has more than one price from quantity %d", entry.ProductId, price.MinQuantity)
+        }
+        seen[key] = true
+        prices = append(prices, price)
+    }
+    return prices, nil
+}
+
+// findPriceList loads a price list and its prices
+func findPriceList(db *gorm.DB, id int64) (*models.PriceList, error) {
+    var list models.PriceList
+    err := db.Preload("Prices", func(db *gorm.DB) *gorm.DB {
+        return db.Order("product_id, variant_id, min_quantity")
+    }).First(&list, id).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Price list with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving price list: %v", err)
+    }
+    return &list, nil
+}
+
+// priceListError passes status errors through and wraps anything else as internal
+func priceListError(err error) error {
+    if _, ok := status.FromError(err); ok {
+        return err
+    }
+    return status.Errorf(codes.Internal, "Error updating price list: %v", err)
+}
+
+// toProtoPriceList converts a price list and its loaded prices to protobuf
+func toProtoPriceList(list models.PriceList) *pb.PriceList {
+    res := &pb.PriceList{
+        Id:            int64(list.ID),
+        Name:          list.Name,
+        Currency:      list.Currency,
+        CustomerGroup: list.CustomerGroup,
+        Priority:      int32(list.Priority),
+    }
+    if list.ValidFrom != nil {
+        res.ValidFrom = list.ValidFrom.Format(time.RFC3339)
+    }
+    if list.ValidUntil != nil {
+        res.ValidUntil = list.ValidUntil.Format(time.RFC3339)
+    }
+    for _, price := range list.Prices {
+        res.Prices = append(res.Prices, &pb.PriceListPrice{
+            ProductId:   int64(price.ProductID),
+            VariantId:   int64(price.VariantID),
+            MinQuantity: int32(price.MinQuantity),
+            Amount:      price.Amount,
+        })
+    }
+    return res
+}
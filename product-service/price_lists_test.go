@@ -0,0 +1,235 @@
+package main
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/atullal/ecommerce-backend-protobuf/money"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "product-service/models"
+)
+
+func TestBestListPrice(t *testing.T) {
+    everyone := listPrice{PriceListID: 1, ProductID: 7, MinQuantity: 1, Amount: 900}
+    tests := []struct {
+        name      string
+        prices    []listPrice
+        variantID uint
+        quantity  int
+        want      int64
+        found     bool
+    }{
+        {name: "no prices", quantity: 1},
+        {name: "other product", prices: []listPrice{{PriceListID: 1, ProductID: 8, MinQuantity: 1, Amount: 500}}, quantity: 1},
+        {name: "list for everyone", prices: []listPrice{everyone}, quantity: 1, want: 900, found: true},
+        {
+            name:     "customer group over everyone",
+            prices:   []listPrice{everyone, {PriceListID: 2, CustomerGroup: "wholesale", Priority: -5, ProductID: 7, MinQuantity: 1, Amount: 950}},
+            quantity: 1, want: 950, found: true,
+        },
+        {
+            name:     "higher priority",
+            prices:   []listPrice{{PriceListID: 2, ProductID: 7, MinQuantity: 1, Amount: 800}, {PriceListID: 1, Priority: 10, ProductID: 7, MinQuantity: 1, Amount: 850}},
+            quantity: 1, want: 850, found: true,
+        },
+        {
+            name:     "newer list",
+            prices:   []listPrice{everyone, {PriceListID: 2, ProductID: 7, MinQuantity: 1, Amount: 990}},
+            quantity: 1, want: 990, found: true,
+        },
+        {
+            name:      "variant over product",
+            prices:    []listPrice{everyone, {PriceListID: 1, ProductID: 7, VariantID: 3, MinQuantity: 1, Amount: 700}},
+            variantID: 3, quantity: 1, want: 700, found: true,
+        },
+        {
+            name:      "other variant",
+            prices:    []listPrice{everyone, {PriceListID: 1, ProductID: 7, VariantID: 4, MinQuantity: 1, Amount: 700}},
+            variantID: 3, quantity: 1, want: 900, found: true,
+        },
+        {
+            name:     "quantity break reached",
+            prices:   []listPrice{everyone, {PriceListID: 1, ProductID: 7, MinQuantity: 10, Amount: 800}, {PriceListID: 1, ProductID: 7, MinQuantity: 50, Amount: 700}},
+            quantity: 12, want: 800, found: true,
+        },
+        {
+            name:     "highest quantity break",
+            prices:   []listPrice{everyone, {PriceListID: 1, ProductID: 7, MinQuantity: 10, Amount: 800}, {PriceListID: 1, ProductID: 7, MinQuantity: 50, Amount: 700}},
+            quantity: 50, want: 700, found: true,
+        },
+        {name: "quantity break not reached", prices: []listPrice{{PriceListID: 1, ProductID: 7, MinQuantity: 10, Amount: 800}}, quantity: 9},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, found := bestListPrice(tt.prices, 7, tt.variantID, tt.quantity)
+            if found != tt.found || got.Amount != tt.want {
+                t.Errorf("bestListPrice = %d, %v, want %d, %v", got.Amount, found, tt.want, tt.found)
+            }
+        })
+    }
+}
+
+func TestEffectivePrice(t *testing.T) {
+    override := int64(1200)
+    product := models.Product{Name: "Shirt", Price: 1000, Currency: "USD"}
+    product.ID = 7
+    variant := models.Variant{SKU: "SHIRT-L", PriceOverride: &override}
+    variant.ID = 3
+    // Price lists in other currencies are filtered out before pricing
+    prices := []listPrice{{PriceListID: 2, ProductID: 7, MinQuantity: 5, Amount: 900}}
+
+    tests := []struct {
+        name     string
+        currency string
+        variant  *models.Variant
+        quantity int
+        want     money.Money
+        listID   uint
+        ok       bool
+    }{
+        {name: "product price", currency: "USD", quantity: 1, want: money.Money{Amount: 1000, Currency: "USD"}, ok: true},
+        {name: "variant price", currency: "USD", variant: &variant, quantity: 1, want: money.Money{Amount: 1200, Currency: "USD"}, ok: true},
+        {name: "list price", currency: "USD", variant: &variant, quantity: 5, want: money.Money{Amount: 900, Currency: "USD"}, listID: 2, ok: true},
+        {name: "no price in currency", currency: "EUR", quantity: 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, listID, ok := effectivePrice(prices, priceQuery{Currency: tt.currency}, product, tt.variant, tt.quantity)
+            if got != tt.want || listID != tt.listID || ok != tt.ok {
+                t.Errorf("effectivePrice = %v, %d, %v, want %v, %d, %v", got, listID, ok, tt.want, tt.listID, tt.ok)
+            }
+        })
+    }
+}
+
+func TestNewPriceQuery(t *testing.T) {
+    t.Setenv("CURRENCY", "")
+    tests := []struct {
+        name     string
+        currency string
+        group    string
+        want     priceQuery
+        code     codes.Code
+    }{
+        {name: "store currency", want: priceQuery{Currency: money.DefaultCurrency}},
+        {name: "currency and group", currency: "eur", group: "Wholesale", want: priceQuery{Currency: "EUR", CustomerGroup: "wholesale"}},
+        {name: "unknown currency", currency: "XYZ", code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := newPriceQuery(tt.currency, tt.group)
+            got.At = time.Time{}
+            if got != tt.want || status.Code(err) != tt.code {
+                t.Errorf("newPriceQuery = %+v, %v, want %+v, %v", got, err, tt.want, tt.code)
+            }
+        })
+    }
+}
+
+func TestNewPriceList(t *testing.T) {
+    tests := []struct {
+        name       string
+        listName   string
+        currency   string
+        group      string
+        validFrom  string
+        validUntil string
+        code       codes.Code
+    }{
+        {name: "valid", listName: "Wholesale", currency: "usd", group: "Wholesale", validFrom: "2026-01-01T00:00:00Z", validUntil: "2026-02-01T00:00:00Z"},
+        {name: "open ended", listName: "Sale", currency: "EUR"},
+        {name: "missing name", listName: " ", currency: "USD", code: codes.InvalidArgument},
+        {name: "unknown currency", listName: "Sale", currency: "XYZ", code: codes.InvalidArgument},
+        {name: "invalid group", listName: "Sale", currency: "USD", group: "vip customers", code: codes.InvalidArgument},
+        {name: "invalid time", listName: "Sale", currency: "USD", validFrom: "2026-01-01", code: codes.InvalidArgument},
+        {name: "ends before it starts", listName: "Sale", currency: "USD", validFrom: "2026-02-01T00:00:00Z", validUntil: "2026-01-01T00:00:00Z", code: codes.InvalidArgument},
+        {name: "ends when it starts", listName: "Sale", currency: "USD", validFrom: "2026-01-01T00:00:00Z", validUntil: "2026-01-01T00:00:00Z", code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := newPriceList(tt.listName, tt.currency, tt.group, 0, tt.validFrom, tt.validUntil)
+            if status.Code(err) != tt.code {
+                t.Errorf("newPriceList error = %v, want %v", err, tt.code)
+            }
+        })
+    }
+
+    list, err := newPriceList(" Wholesale ", "usd", "Wholesale", 5, "", "")
+    if err != nil || list.Name != "Wholesale" || list.Currency != "USD" || list.CustomerGroup != "wholesale" || list.Priority != 5 {
+        t.Errorf("newPriceList = %+v, %v, want a normalized list", list, err)
+    }
+}
+
+func TestResolvePrices(t *testing.T) {
+    t.Setenv("CURRENCY", "USD")
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+    product := createTestProduct(t, db, nil)
+
+    now := time.Now()
+    priceList := func(name, currency, group string, validFrom, validUntil time.Time, prices ...*pb.PriceListPrice) int64 {
+        req := &pb.CreatePriceListRequest{Name: name, Currency: currency, CustomerGroup: group}
+        if !validFrom.IsZero() {
+            req.ValidFrom = validFrom.Format(time.RFC3339)
+        }
+        if !validUntil.IsZero() {
+            req.ValidUntil = validUntil.Format(time.RFC3339)
+        }
+        res, err := s.CreatePriceList(ctx, req)
+        if err != nil {
+            t.Fatalf("CreatePriceList error = %v", err)
+        }
+        if _, err := s.SetPriceListPrices(ctx, &pb.SetPriceListPricesRequest{PriceListId: res.PriceList.Id, Prices: prices}); err != nil {
+            t.Fatalf("SetPriceListPrices error = %v", err)
+        }
+        return res.PriceList.Id
+    }
+    productID := int64(product.ID)
+    sale := priceList("Sale", "USD", "", now.Add(-time.Hour), now.Add(time.Hour),
+        &pb.PriceListPrice{ProductId: productID, Amount: 900},
+        &pb.PriceListPrice{ProductId: productID, MinQuantity: 10, Amount: 800})
+    priceList("Expired", "USD", "", now.Add(-2*time.Hour), now.Add(-time.Hour), &pb.PriceListPrice{ProductId: productID, Amount: 100})
+    priceList("Upcoming", "USD", "", now.Add(time.Hour), time.Time{}, &pb.PriceListPrice{ProductId: productID, Amount: 200})
+    wholesale := priceList("Wholesale", "USD", "wholesale", time.Time{}, time.Time{}, &pb.PriceListPrice{ProductId: productID, Amount: 700})
+    euro := priceList("Euro", "EUR", "", time.Time{}, time.Time{}, &pb.PriceListPrice{ProductId: productID, Amount: 850})
+
+    tests := []struct {
+        name     string
+        currency string
+        group    string
+        quantity int32
+        want     *pb.Money
+        listID   int64
+        code     codes.Code
+    }{
+        {name: "current list", quantity: 1, want: &pb.Money{Amount: 900, Currency: "USD"}, listID: sale},
+        {name: "quantity break", quantity: 10, want: &pb.Money{Amount: 800, Currency: "USD"}, listID: sale},
+        {name: "customer group", group: "Wholesale", quantity: 10, want: &pb.Money{Amount: 700, Currency: "USD"}, listID: wholesale},
+        {name: "other currency", currency: "EUR", quantity: 1, want: &pb.Money{Amount: 850, Currency: "EUR"}, listID: euro},
+        {name: "no price in currency", currency: "GBP", quantity: 1, code: codes.FailedPrecondition},
+        {name: "no quantity", quantity: 0, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            res, err := s.ResolvePrices(ctx, &pb.ResolvePricesRequest{
+                Currency:      tt.currency,
+                CustomerGroup: tt.group,
+                Items:         []*pb.PriceItem{{ProductId: productID, Quantity: tt.quantity}},
+            })
+            if status.Code(err) != tt.code {
+                t.Fatalf("ResolvePrices error = %v, want %v", err, tt.code)
+            }
+            if err != nil {
+                return
+            }
+            got := res.Prices[0]
+            if got.Price.Amount != tt.want.Amount || got.Price.Currency != tt.want.Currency || got.PriceListId != tt.listID {
+                t.Errorf("ResolvePrices = %v from list %d, want %v from list %d", got.Price, got.PriceListId, tt.want, tt.listID)
+            }
+        })
+    }
+}
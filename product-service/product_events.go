@@ -0,0 +1,57 @@
+package main
+
+import (
+    "product-service/models"
+)
+
+// productEvent is the data published with product events
+type productEvent struct {
+    ProductID   uint    `json:"product_id"`
+    Name        string  `json:"name"`
+    Description string  `json:"description"`
+    Price       int64   `json:"price"`    // In minor units of Currency
+    Currency    string  `json:"currency"`
+    Quantity    int     `json:"quantity"`
+    Version     int     `json:"version"`
+}
+
+// inventoryEvent is the data published when a product's stock changes
+type inventoryEvent struct {
+    ProductID         uint   `json:"product_id"`
+    VariantID         uint   `json:"variant_id,omitempty"`
+    WarehouseID       uint   `json:"warehouse_id"`
+    QuantityChange    int    `json:"quantity_change"`
+    Quantity          int    `json:"quantity"`           // Quantity over all warehouses after the change
+    Reserved          int    `json:"reserved"`           // Quantity held by reservations over all warehouses after the change
+    WarehouseQuantity int    `json:"warehouse_quantity"` // Quantity at the warehouse after the change
+    Version           int    `json:"version"`
+    IdempotencyKey    string `json:"idempotency_key,omitempty"`
+}
+
+// newProductEvent builds the event data for a product
+func newProductEvent(product models.Product) productEvent {
+    return productEvent{
+        ProductID:   product.ID,
+        Name:        product.Name,
+        Description: product.Description,
+        Price:       product.Price,
+        Currency:    product.Currency,
+        Quantity:    product.Quantity,
+        Version:     product.Version,
+    }
+}
+
+// newInventoryEvent builds the event data for a stock change at a warehouse
+func newInventoryEvent(product models.Product, level models.StockLevel, change int, key string) inventoryEvent {
+    return inventoryEvent{
+        ProductID:         product.ID,
+        VariantID:         level.VariantID,
+        WarehouseID:       level.WarehouseID,
+        QuantityChange:    change,
+        Quantity:          product.Quantity,
+        Reserved:          product.Reserved,
+        WarehouseQuantity: level.Quantity,
+        Version:           product.Version,
+        IdempotencyKey:    key,
+    }
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "os"
+    "sort"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "product-service/models"
+)
+
+const (
+    // defaultReservationTTL is how long stock is held when neither the request
+    // nor RESERVATION_TTL say otherwise
+    defaultReservationTTL = 15 * time.Minute
+    // reservationSweepInterval is how often expired reservations are released
+    reservationSweepInterval = 30 * time.Second
+)
+
+// reservationTTL returns the hold time requested, or the configured default
+func reservationTTL(ttlSeconds int32) time.Duration {
+    if ttlSeconds > 0 {
+        return time.Duration(ttlSeconds) * time.Second
+    }
+    if value := os.Getenv("RESERVATION_TTL"); value != "" {
+        if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+            return ttl
+        }
+    }
+    return defaultReservationTTL
+}
+
+func (s *server) ReserveInventory(ctx context.Context, req *pb.ReserveInventoryRequest) (*pb.ReservationResponse, error) {
+    if req.ReservationKey == "" {
+        return nil, status.Errorf(codes.InvalidArgument, "Reservation key is required")
+    }
+    if len(req.Items) == 0 {
+        return nil, status.Errorf(codes.InvalidArgument, "Reservation has no items")
+    }
+
+    // Reserving again with the same key returns the existing reservation
+    if existing, err := s.findReservation(s.db, req.ReservationKey); err == nil {
+        return &pb.ReservationResponse{Reservation: toProtoReservation(*existing)}, nil
+    } else if status.Code(err) != codes.NotFound {
+        return nil, err
+    }
+
+    reservation := models.Reservation{
+        ReservationKey: req.ReservationKey,
+        Status:         models.ReservationHeld,
+        ExpiresAt:      time.Now().Add(reservationTTL(req.TtlSeconds)),
+    }
+    for _, item := range req.Items {
+        if item.ProductId == 0 || item.Quantity <= 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Invalid reservation item for product '%d'", item.ProductId)
+        }
+        reservation.Items = append(reservation.Items, models.ReservationItem{ProductID: uint(item.ProductId), VariantID: uint(item.VariantId), Quantity: int(item.Quantity)})
+    }
+
+    strategy, err := lookupAllocationStrategy(req.AllocationStrategy)
+    if err != nil {
+        return nil, err
+    }
+
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        for _, i := range lockOrder(reservation.Items) {
+            // Each item is held at the single warehouse the strategy picks
+            item := &reservation.Items[i]
+            warehouseID, err := allocateWarehouse(tx, item.ProductID, item.VariantID, item.Quantity, strategy, req.Destination)
+            if err != nil {
+                return err
+            }
+            item.WarehouseID = warehouseID
+            if _, err := adjustStock(tx, item.ProductID, item.VariantID, warehouseID, 0, item.Quantity, reservation.ReservationKey, movementSource{}); err != nil {
+                return err
+            }
+        }
+        return tx.Create(&reservation).Error
+    })
+    if err != nil {
+        // A concurrent request with the same key won the race; return its reservation
+        if existing, findErr := s.findReservation(s.db, req.ReservationKey); findErr == nil {
+            return &pb.ReservationResponse{Reservation: toProtoReservation(*existing)}, nil
+        }
+        if _, ok := status.FromError(err); ok {
+            return nil, err
+        }
+        return nil, status.Errorf(codes.Internal, "Error reserving inventory: %v", err)
+    }
+
+    return &pb.ReservationResponse{Reservation: toProtoReservation(reservation)}, nil
+}
+
+func (s *server) CommitReservation(ctx context.Context, req *pb.CommitReservationRequest) (*pb.ReservationResponse, error) {
+    reservation, err := s.settleReservation(req.ReservationKey, models.ReservationCommitted)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.ReservationResponse{Reservation: toProtoReservation(*reservation)}, nil
+}
+
+func (s *server) ReleaseReservation(ctx context.Context, req *pb.ReleaseReservationRequest) (*pb.ReservationResponse, error) {
+    reservation, err := s.settleReservation(req.ReservationKey, models.ReservationReleased)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.ReservationResponse{Reservation: toProtoReservation(*reservation)}, nil
+}
+
+// settleReservation moves a held reservation to COMMITTED, RELEASED or EXPIRED.
+// Committing takes the held stock off hand; the other outcomes give it back.
+// Settling a reservation again with the outcome it already has is a no-op, and
+// releasing one that already expired is too.
+func (s *server) settleReservation(key string, outcome models.ReservationStatus) (*models.Reservation, error) {
+    var reservation *models.Reservation
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        reservation, err = s.findReservation(tx.Clauses(clause.Locking{Strength: "UPDATE"}), key)
+        if err != nil {
+            return err
+        }
+
+        switch {
+        case reservation.Status == outcome:
+            return nil
+        case reservation.Status == models.ReservationExpired && outcome == models.ReservationReleased:
+            return nil
+        case reservation.Status != models.ReservationHeld:
+            return status.Errorf(codes.FailedPrecondition, "Reservation '%s' is already %s", key, reservation.Status)
+        }
+
+        // Only a commit changes the stock on hand, recorded in the ledger as a sale
+        quantitySign := 0
+        source := movementSource{Reason: models.MovementSale, ReferenceID: key}
+        if outcome == models.ReservationCommitted {
+            quantitySign = -1
+        }
+        for _, i := range lockOrder(reservation.Items) {
+            item := reservation.Items[i]
+            if _, err := adjustStock(tx, item.ProductID, item.VariantID, item.WarehouseID, quantitySign*item.Quantity, -item.Quantity, key, source); err != nil {
+                return err
+            }
+        }
+
+        reservation.Status = outcome
+        return tx.Model(reservation).Update("status", outcome).Error
+    })
+    if err != nil {
+        if _, ok := status.FromError(err); ok {
+            return nil, err
+        }
+        return nil, status.Errorf(codes.Internal, "Error updating reservation: %v", err)
+    }
+    return reservation, nil
+}
+
+// lockOrder returns the indexes of reservation items sorted by product and
+// variant, so concurrent reservations lock rows in the same order and cannot
+// deadlock
+func lockOrder(items []models.ReservationItem) []int {
+    order := make([]int, len(items))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool {
+        if items[order[a]].ProductID != items[order[b]].ProductID {
+            return items[order[a]].ProductID < items[order[b]].ProductID
+        }
+        return items[order[a]].VariantID < items[order[b]].VariantID
+    })
+    return order
+}
+
+// findReservation loads a reservation and its items, in the order they were
+// reserved, by key
+func (s *server) findReservation(db *gorm.DB, key string) (*models.Reservation, error) {
+    var reservation models.Reservation
+    if err := db.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Where("reservation_key = ?", key).First(&reservation).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Reservation '%s' not found", key)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving reservation: %v", err)
+    }
+    return &reservation, nil
+}
+
+// releaseExpiredReservations gives back the stock of held reservations past their expiry
+func (s *server) releaseExpiredReservations() {
+    var expired []models.Reservation
+    err := s.db.Select("reservation_key").
+        Where("status = ? AND expires_at < ?", models.ReservationHeld, time.Now()).
+        Find(&expired).Error
+    if err != nil {
+        log.Printf("reservation sweeper: failed to load reservations: %v", err)
+        return
+    }
+
+    for _, reservation := range expired {
+        if _, err := s.settleReservation(reservation.ReservationKey, models.ReservationExpired); err != nil {
+            log.Printf("reservation %s: failed to expire: %v", reservation.ReservationKey, err)
+        }
+    }
+}
+
+// runReservationSweeper periodically releases expired reservations until the process exits
+func (s *server) runReservationSweeper() {
+    ticker := time.NewTicker(reservationSweepInterval)
+    defer ticker.Stop()
+    for {
+        s.releaseExpiredReservations()
+        <-ticker.C
+    }
+}
+
+// toProtoReservation converts a reservation to its protobuf representation
+func toProtoReservation(reservation models.Reservation) *pb.Reservation {
+    res := &pb.Reservation{
+        Id:             int64(reservation.ID),
+        ReservationKey: reservation.ReservationKey,
+        Status:         string(reservation.Status),
+        ExpiresAt:      reservation.ExpiresAt.Format(time.RFC3339),
+    }
+    for _, item := range reservation.Items {
+        res.Items = append(res.Items, &pb.ReservationItem{ProductId: int64(item.ProductID), VariantId: int64(item.VariantID), Quantity: int32(item.Quantity), WarehouseId: int64(item.WarehouseID)})
+    }
+    return res
+}
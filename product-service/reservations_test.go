@@ -0,0 +1,175 @@
+package main
+
+import (
+    "context"
+    "reflect"
+    "testing"
+    "time"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "product-service/models"
+)
+
+func TestReservationTTL(t *testing.T) {
+    tests := []struct {
+        name       string
+        ttlSeconds int32
+        env        string
+        want       time.Duration
+    }{
+        {name: "default", want: defaultReservationTTL},
+        {name: "requested", ttlSeconds: 90, env: "5m", want: 90 * time.Second},
+        {name: "configured", env: "5m", want: 5 * time.Minute},
+        {name: "misconfigured", env: "soon", want: defaultReservationTTL},
+        {name: "negative", env: "-5m", want: defaultReservationTTL},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            t.Setenv("RESERVATION_TTL", tt.env)
+            if got := reservationTTL(tt.ttlSeconds); got != tt.want {
+                t.Errorf("reservationTTL(%d) = %v, want %v", tt.ttlSeconds, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLockOrder(t *testing.T) {
+    items := []models.ReservationItem{
+        {ProductID: 7, VariantID: 2},
+        {ProductID: 3},
+        {ProductID: 7, VariantID: 1},
+        {ProductID: 3},
+    }
+    if got, want := lockOrder(items), []int{1, 3, 2, 0}; !reflect.DeepEqual(got, want) {
+        t.Errorf("lockOrder = %v, want %v", got, want)
+    }
+}
+
+// stockOf reloads a product's on hand and reserved quantities
+func stockOf(t *testing.T, s *server, product models.Product) (quantity, reserved int) {
+    t.Helper()
+    if err := s.db.First(&product, product.ID).Error; err != nil {
+        t.Fatalf("failed to reload product: %v", err)
+    }
+    return product.Quantity, product.Reserved
+}
+
+func TestReservations(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+    product := createTestProduct(t, db, map[uint]int{0: 5})
+    reserve := func(key string, quantity int32) (*pb.ReservationResponse, error) {
+        return s.ReserveInventory(ctx, &pb.ReserveInventoryRequest{
+            ReservationKey: key,
+            Items:          []*pb.ReservationItem{{ProductId: int64(product.ID), Quantity: quantity}},
+        })
+    }
+
+    steps := []struct {
+        name     string
+        call     func() (*pb.ReservationResponse, error)
+        code     codes.Code
+        status   string
+        quantity int // On hand afterwards
+        reserved int
+    }{
+        {name: "reserve", call: func() (*pb.ReservationResponse, error) { return reserve("a", 3) }, status: "HELD", quantity: 5, reserved: 3},
+        {name: "reserve again", call: func() (*pb.ReservationResponse, error) { return reserve("a", 3) }, status: "HELD", quantity: 5, reserved: 3},
+        {name: "reserve more than is available", call: func() (*pb.ReservationResponse, error) { return reserve("b", 3) }, code: codes.InvalidArgument, quantity: 5, reserved: 3},
+        {name: "reserve the rest", call: func() (*pb.ReservationResponse, error) { return reserve("b", 2) }, status: "HELD", quantity: 5, reserved: 5},
+        {
+            name:     "commit",
+            call:     func() (*pb.ReservationResponse, error) { return s.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationKey: "a"}) },
+            status:   "COMMITTED",
+            quantity: 2,
+            reserved: 2,
+        },
+        {
+            name:     "commit again",
+            call:     func() (*pb.ReservationResponse, error) { return s.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationKey: "a"}) },
+            status:   "COMMITTED",
+            quantity: 2,
+            reserved: 2,
+        },
+        {
+            name:     "release a committed reservation",
+            call:     func() (*pb.ReservationResponse, error) { return s.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationKey: "a"}) },
+            code:     codes.FailedPrecondition,
+            quantity: 2,
+            reserved: 2,
+        },
+        {
+            name:     "release",
+            call:     func() (*pb.ReservationResponse, error) { return s.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationKey: "b"}) },
+            status:   "RELEASED",
+            quantity: 2,
+            reserved: 0,
+        },
+        {
+            name:     "release an unknown reservation",
+            call:     func() (*pb.ReservationResponse, error) { return s.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationKey: "c"}) },
+            code:     codes.NotFound,
+            quantity: 2,
+            reserved: 0,
+        },
+        {name: "reserve without a key", call: func() (*pb.ReservationResponse, error) { return reserve("", 1) }, code: codes.InvalidArgument, quantity: 2, reserved: 0},
+    }
+    for _, step := range steps {
+        res, err := step.call()
+        if status.Code(err) != step.code {
+            t.Fatalf("%s: error = %v, want %v", step.name, err, step.code)
+        }
+        if err == nil && res.Reservation.Status != step.status {
+            t.Errorf("%s: reservation status = %s, want %s", step.name, res.Reservation.Status, step.status)
+        }
+        if quantity, reserved := stockOf(t, s, product); quantity != step.quantity || reserved != step.reserved {
+            t.Errorf("%s: stock = %d on hand, %d reserved, want %d and %d", step.name, quantity, reserved, step.quantity, step.reserved)
+        }
+    }
+
+    // Only the commit took stock off hand, recorded in the ledger as a sale
+    var sales []models.StockMovement
+    db.Where("product_id = ? AND reason = ?", product.ID, models.MovementSale).Find(&sales)
+    if len(sales) != 1 || sales[0].Delta != -3 || sales[0].ReferenceID != "a" {
+        t.Errorf("sales = %+v, want one of -3 for reservation a", sales)
+    }
+}
+
+func TestReleaseExpiredReservations(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+    product := createTestProduct(t, db, map[uint]int{0: 5})
+    for _, key := range []string{"expired", "held"} {
+        req := &pb.ReserveInventoryRequest{ReservationKey: key, Items: []*pb.ReservationItem{{ProductId: int64(product.ID), Quantity: 2}}}
+        if _, err := s.ReserveInventory(ctx, req); err != nil {
+            t.Fatalf("ReserveInventory error = %v", err)
+        }
+    }
+    db.Model(&models.Reservation{}).Where("reservation_key = ?", "expired").Update("expires_at", time.Now().Add(-time.Second))
+
+    s.releaseExpiredReservations()
+
+    for key, want := range map[string]models.ReservationStatus{"expired": models.ReservationExpired, "held": models.ReservationHeld} {
+        if reservation, err := s.findReservation(db, key); err != nil || reservation.Status != want {
+            t.Errorf("reservation %s = %v, %v, want %s", key, reservation, err, want)
+        }
+    }
+    if quantity, reserved := stockOf(t, s, product); quantity != 5 || reserved != 2 {
+        t.Errorf("stock = %d on hand, %d reserved, want 5 and 2", quantity, reserved)
+    }
+
+    // Releasing an expired reservation is a no-op, committing it fails
+    if res, err := s.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationKey: "expired"}); err != nil || res.Reservation.Status != "EXPIRED" {
+        t.Errorf("ReleaseReservation of an expired reservation = %v, %v, want it EXPIRED", res, err)
+    }
+    if _, err := s.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationKey: "expired"}); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("CommitReservation of an expired reservation error = %v, want %v", err, codes.FailedPrecondition)
+    }
+    if _, reserved := stockOf(t, s, product); reserved != 2 {
+        t.Errorf("reserved = %d after settling the expired reservation again, want 2", reserved)
+    }
+}
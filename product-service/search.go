@@ -0,0 +1,111 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "regexp"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+)
+
+// defaultSearchLanguage is the text search configuration used when
+// SEARCH_LANGUAGE is unset
+const defaultSearchLanguage = "english"
+
+var searchLanguagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// searchLanguage returns the Postgres text search configuration that stems
+// product names and descriptions, e.g. "english" or "german"
+func searchLanguage() string {
+    if language := os.Getenv("SEARCH_LANGUAGE"); language != "" {
+        return language
+    }
+    return defaultSearchLanguage
+}
+
+// ensureSearchIndex maintains the products.search_vector column that product
+// search runs against. A trigger recomputes it whenever a product's name or
+// description is written, with name matches weighted above description
+// matches. Rows written before the trigger existed, or under a different
+// language, are brought up to date here.
+func ensureSearchIndex(db *gorm.DB) error {
+    language := searchLanguage()
+    if !searchLanguagePattern.MatchString(language) {
+        return fmt.Errorf("invalid SEARCH_LANGUAGE %q", language)
+    }
+    var known int64
+    if err := db.Raw("SELECT COUNT(*) FROM pg_ts_config WHERE cfgname = ?", language).Scan(&known).Error; err != nil {
+        return err
+    }
+    if known == 0 {
+        return fmt.Errorf("unknown SEARCH_LANGUAGE %q", language)
+    }
+
+    // The language was checked above, so it is safe to put in the statements
+    vector := func(row string) string {
+        return fmt.Sprintf(`setweight(to_tsvector('%[1]s', coalesce(%[2]s.name, '')), 'A') ||
+            setweight(to_tsvector('%[1]s', coalesce(%[2]s.description, '')), 'B')`, language, row)
+    }
+    return db.Transaction(func(tx *gorm.DB) error {
+        statements := []string{
+            `ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+            fmt.Sprintf(`CREATE OR REPLACE FUNCTION products_search_vector_update() RETURNS trigger AS $$
+                BEGIN
+                    NEW.search_vector := %s;
+                    RETURN NEW;
+                END
+                $$ LANGUAGE plpgsql`, vector("NEW")),
+            `DROP TRIGGER IF EXISTS products_search_vector_update ON products`,
+            `CREATE TRIGGER products_search_vector_update BEFORE INSERT OR UPDATE OF name, description ON products
+                FOR EACH ROW EXECUTE FUNCTION products_search_vector_update()`,
+            fmt.Sprintf(`UPDATE products SET search_vector = %[1]s WHERE search_vector IS DISTINCT FROM %[1]s`, vector("products")),
+            `CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+        }
+        for _, statement := range statements {
+            if err := tx.Exec(statement).Error; err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// searchMatch restricts a query to the products matching a search. The
+// keyword is parsed like a web search, so multi-word queries, "quoted
+// phrases", OR and -exclusions work, and words are stemmed in the configured
+// language.
+func searchMatch(db *gorm.DB, keyword string) *gorm.DB {
+    return db.Where("products.search_vector @@ websearch_to_tsquery(?::regconfig, ?)", searchLanguage(), keyword)
+}
+
+// withSearchRank selects columns plus the rank and highlighted snippets of
+// each product from a query over products matching keyword. The parsed query
+// is joined in as q. Snippets are HTML with matches in <b> tags.
+func withSearchRank(db *gorm.DB, keyword, columns string) *gorm.DB {
+    language := searchLanguage()
+    return db.
+        Select(columns+`,
+            ts_rank_cd(products.search_vector, q) AS rank,
+            ts_headline(?::regconfig, `+htmlEscaped("products.name")+`, q, 'HighlightAll=true') AS name_highlight,
+            ts_headline(?::regconfig, `+htmlEscaped("products.description")+`, q, 'MaxWords=35, MinWords=15, MaxFragments=2') AS description_highlight`,
+            language, language).
+        Joins("CROSS JOIN websearch_to_tsquery(?::regconfig, ?) AS q", language, keyword)
+}
+
+// htmlEscaped wraps a text column in SQL escaping it for HTML, so the <b> tags
+// ts_headline puts around matches are the only markup in a highlight. The
+// search parser reads the entities as single tokens, so matching is unchanged.
+func htmlEscaped(column string) string {
+    return `replace(replace(replace(replace(replace(` + column + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`
+}
+
+// searchHighlight converts the rank and snippets of a search hit to protobuf
+func searchHighlight(hit productHit) *pb.SearchHighlight {
+    return &pb.SearchHighlight{
+        ProductId:   int64(hit.ID),
+        Rank:        hit.Rank,
+        Name:        hit.NameHighlight,
+        Description: hit.DescriptionHighlight,
+    }
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "product-service/models"
+)
+
+func TestSearchHighlightsEscapeProductText(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    product := models.Product{
+        Name:        `<img src=x onerror=alert(1)> Trail Shoe`,
+        Description: `A "light" shoe for rock & root <script>alert('x')</script>`,
+        Price:       5000,
+        Currency:    "USD",
+    }
+    if err := db.Create(&product).Error; err != nil {
+        t.Fatalf("failed to create product: %v", err)
+    }
+
+    res, err := s.ListProducts(context.Background(), &pb.ListProductsRequest{SearchKeyword: "shoe"})
+    if err != nil {
+        t.Fatalf("ListProducts error = %v", err)
+    }
+    if len(res.Highlights) != 1 {
+        t.Fatalf("got %d highlights, want 1", len(res.Highlights))
+    }
+    highlight := res.Highlights[0]
+    if want := `&lt;img src=x onerror=alert(1)&gt; Trail <b>Shoe</b>`; highlight.Name != want {
+        t.Errorf("name highlight = %q, want %q", highlight.Name, want)
+    }
+    // The snippet may be cut short, but holds no markup from the product text
+    description := highlight.Description
+    if !strings.Contains(description, "<b>shoe</b>") || strings.Contains(description, "<script") || strings.Contains(description, `"`) {
+        t.Errorf("description highlight = %q, want escaped text with shoe in <b>", description)
+    }
+}
+
+func TestLookupProductSort(t *testing.T) {
+    tests := []struct {
+        name    string
+        keyword string
+        want    string
+        code    codes.Code
+    }{
+        {name: "", want: sortNewest},
+        {name: "", keyword: "shoe", want: sortRelevance},
+        {name: "relevance", want: sortNewest}, // Nothing to rank without a keyword
+        {name: "price_asc", keyword: "shoe", want: sortPriceAsc},
+        {name: "NAME", want: sortName},
+        {name: "POPULAR", code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        got, err := lookupProductSort(tt.name, tt.keyword)
+        if status.Code(err) != tt.code {
+            t.Errorf("lookupProductSort(%q, %q) error = %v, want %v", tt.name, tt.keyword, err, tt.code)
+            continue
+        }
+        if err == nil && got.order != productSorts[tt.want].order {
+            t.Errorf("lookupProductSort(%q, %q) orders by %q, want %s", tt.name, tt.keyword, got.order, tt.want)
+        }
+    }
+}
+
+func TestSearchProducts(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    names := map[string]uint{}
+    for _, product := range []models.Product{
+        {Name: "Trail Running Shoe", Description: "Grippy sole for muddy trails"},
+        {Name: "Rain Jacket", Description: "Keeps you dry when you run in the rain"},
+        {Name: "Road Shoe", Description: "Light and fast on tarmac"},
+        {Name: "Wool Socks", Description: "Warm socks for hiking boots"},
+    } {
+        product.Price, product.Currency = 5000, "USD"
+        if err := db.Create(&product).Error; err != nil {
+            t.Fatalf("failed to create product: %v", err)
+        }
+        names[product.Name] = product.ID
+    }
+
+    tests := []struct {
+        keyword string
+        want    []string // In order of relevance
+    }{
+        // Words are stemmed, and name matches rank above description matches
+        {keyword: "runs", want: []string{"Trail Running Shoe", "Rain Jacket"}},
+        {keyword: "shoe -trail", want: []string{"Road Shoe"}},
+        {keyword: `"hiking boots"`, want: []string{"Wool Socks"}},
+        {keyword: "jacket or tarmac", want: []string{"Rain Jacket", "Road Shoe"}},
+        {keyword: "sandals"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.keyword, func(t *testing.T) {
+            res, err := s.ListProducts(context.Background(), &pb.ListProductsRequest{SearchKeyword: tt.keyword})
+            if err != nil {
+                t.Fatalf("ListProducts error = %v", err)
+            }
+            var got []string
+            for _, product := range res.Products {
+                got = append(got, product.Name)
+            }
+            if len(got) != len(tt.want) || res.TotalCount != int64(len(tt.want)) || len(res.Highlights) != len(tt.want) {
+                t.Fatalf("found %v (%d in total, %d highlights), want %v", got, res.TotalCount, len(res.Highlights), tt.want)
+            }
+            for i, name := range tt.want {
+                if got[i] != name || res.Highlights[i].ProductId != int64(names[name]) {
+                    t.Errorf("result %d = %s, want %s", i, got[i], name)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "errors"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "github.com/atullal/ecommerce-backend-protobuf/events"
+    "product-service/models"
+)
+
+// movementSource says why, and on whose behalf, stock changes
+type movementSource struct {
+    Reason      models.MovementReason
+    ReferenceID string
+    ActorID     uint
+}
+
+// adjustStock changes a product's on hand and reserved quantities at one
+// warehouse (the default warehouse when warehouseID is zero) under row locks,
+// keeping the product's totals in step. Products with variants keep their
+// stock per variant, so variantID is required for them and must be zero for
+// the others. Changes that would leave less stock available than zero at the
+// warehouse are rejected. Every change to the on hand quantity is appended to
+// the stock movement ledger.
+func adjustStock(tx *gorm.DB, productID, variantID, warehouseID uint, quantityChange, reservedChange int, key string, source movementSource) (models.Product, error) {
+    var product models.Product
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, productID).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return product, status.Errorf(codes.NotFound, "Product with ID '%d' not found", productID)
+        }
+        return product, status.Errorf(codes.Internal, "Error retrieving product: %v", err)
+    }
+
+    variant, err := lockVariant(tx, product.ID, variantID)
+    if err != nil {
+        return product, err
+    }
+
+    warehouseID, err = resolveWarehouse(tx, warehouseID)
+    if err != nil {
+        return product, err
+    }
+
+    // Stock arriving at a warehouse for the first time gets a new stock level
+    level := models.StockLevel{ProductID: product.ID, VariantID: variantID, WarehouseID: warehouseID}
+    if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&level).Error; err != nil {
+        return product, status.Errorf(codes.Internal, "Error creating stock level: %v", err)
+    }
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("product_id = ? AND variant_id = ? AND warehouse_id = ?", product.ID, variantID, warehouseID).
+        First(&level).Error; err != nil {
+        return product, status.Errorf(codes.Internal, "Error retrieving stock level: %v", err)
+    }
+
+    level.Quantity += quantityChange
+    level.Reserved += reservedChange
+    if level.Reserved < 0 || level.Available() < 0 {
+        return product, status.Errorf(codes.InvalidArgument, "Insufficient inventory")
+    }
+    product.Quantity += quantityChange
+    product.Reserved += reservedChange
+    product.Version++
+
+    if err := tx.Save(&level).Error; err != nil {
+        return product, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
+    }
+    if variant != nil {
+        variant.Quantity += quantityChange
+        variant.Reserved += reservedChange
+        if err := tx.Select("quantity", "reserved").Save(variant).Error; err != nil {
+            return product, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
+        }
+    }
+    if err := tx.Save(&product).Error; err != nil {
+        return product, status.Errorf(codes.Internal, "Error updating inventory: %v", err)
+    }
+    if quantityChange != 0 {
+        movement := models.StockMovement{
+            ProductID:     product.ID,
+            VariantID:     variantID,
+            WarehouseID:   warehouseID,
+            Delta:         quantityChange,
+            QuantityAfter: level.Quantity,
+            Reason:        source.Reason,
+            ReferenceID:   source.ReferenceID,
+            ActorID:       source.ActorID,
+        }
+        if err := tx.Create(&movement).Error; err != nil {
+            return product, status.Errorf(codes.Internal, "Error recording stock movement: %v", err)
+        }
+    }
+    if err := events.Enqueue(tx, events.InventoryChanged, "product", product.ID, newInventoryEvent(product, level, quantityChange, key)); err != nil {
+        return product, status.Errorf(codes.Internal, "Error recording inventory event: %v", err)
+    }
+    return product, nil
+}
+
+// inventoryResponse reports a product's on hand, reserved and available stock
+func inventoryResponse(product models.Product) *pb.InventoryResponse {
+    return &pb.InventoryResponse{
+        ProductId: int64(product.ID),
+        Quantity:  int32(product.Quantity),
+        Version:   int64(product.Version),
+        Reserved:  int32(product.Reserved),
+        Available: int32(product.Available()),
+    }
+}
+
+// toProtoStockLevel converts a stock level to its protobuf representation
+func toProtoStockLevel(level models.StockLevel) *pb.StockLevel {
+    return &pb.StockLevel{
+        VariantId:     int64(level.VariantID),
+        WarehouseId:   int64(level.WarehouseID),
+        WarehouseCode: level.Warehouse.Code,
+        Quantity:      int32(level.Quantity),
+        Reserved:      int32(level.Reserved),
+        Available:     int32(level.Available()),
+    }
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "product-service/models"
+)
+
+// findProduct loads a product with its categories and full variant matrix
+func findProduct(db *gorm.DB, id uint) (models.Product, error) {
+    var product models.Product
+    err := db.Preload("Categories").
+        Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+        Preload("Options.Values", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+        Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+        Preload("Variants.Options").
+        First(&product, id).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return product, status.Errorf(codes.NotFound, "Product with ID '%d' not found", id)
+        }
+        return product, status.Errorf(codes.Internal, "Error retrieving product: %v", err)
+    }
+    return product, nil
+}
+
+// lockVariant locks the variant whose stock is about to change. Zero means the
+// product itself, which is only allowed for products without variants.
+func lockVariant(tx *gorm.DB, productID, variantID uint) (*models.Variant, error) {
+    if variantID == 0 {
+        var variants int64
+        if err := tx.Model(&models.Variant{}).Where("product_id = ?", productID).Count(&variants).Error; err != nil {
+            return nil, status.Errorf(codes.Internal, "Error retrieving variants: %v", err)
+        }
+        if variants > 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Product with ID '%d' has variants; a variant is required", productID)
+        }
+        return nil, nil
+    }
+
+    var variant models.Variant
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("product_id = ?", productID).
+        First(&variant, variantID).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Variant with ID '%d' not found", variantID)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving variant: %v", err)
+    }
+    return &variant, nil
+}
+
+func (s *server) SetProductOptions(ctx context.Context, req *pb.SetProductOptionsRequest) (*pb.ProductResponse, error) {
+    options, err := newProductOptions(uint(req.ProductId), req.Options)
+    if err != nil {
+        return nil, err
+    }
+
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        var product models.Product
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&product, req.ProductId).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.ProductId)
+            }
+            return err
+        }
+
+        // Variants are defined by their option values, so the axes are fixed once they exist
+        var variants int64
+        if err := tx.Model(&models.Variant{}).Where("product_id = ?", product.ID).Count(&variants).Error; err != nil {
+            return err
+        }
+        if variants > 0 {
+            return status.Errorf(codes.FailedPrecondition, "Product with ID '%d' already has variants", product.ID)
+        }
+
+        existing := tx.Model(&models.ProductOption{}).Select("id").Where("product_id = ?", product.ID)
+        if err := tx.Where("option_id IN (?)", existing).Delete(&models.ProductOptionValue{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("product_id = ?", product.ID).Delete(&models.ProductOption{}).Error; err != nil {
+            return err
+        }
+        if len(options) == 0 {
+            return nil
+        }
+        return tx.Create(&options).Error
+    })
+    if err != nil {
+        return nil, variantError(err)
+    }
+    return s.productResponse(uint(req.ProductId))
+}
+
+func (s *server) AddVariant(ctx context.Context, req *pb.AddVariantRequest) (*pb.ProductResponse, error) {
+    variant := models.Variant{
+        ProductID: uint(req.ProductId),
+        SKU:       strings.TrimSpace(req.Sku),
+        Barcode:   strings.TrimSpace(req.Barcode),
+    }
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Product{}, req.ProductId).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Product with ID '%d' not found", req.ProductId)
+            }
+            return err
+        }
+        product, err := findProduct(tx, variant.ProductID)
+        if err != nil {
+            return err
+        }
+
+        if variant.PriceOverride, err = priceOverride(req.PriceOverride, product); err != nil {
+            return err
+        }
+        if err := validateSKU(tx, &variant); err != nil {
+            return err
+        }
+        if variant.Options, err = matchVariantOptions(product, req.Options); err != nil {
+            return err
+        }
+        for _, other := range product.Variants {
+            if variantKey(other.Options) == variantKey(variant.Options) {
+                return status.Errorf(codes.AlreadyExists, "Variant with these options already exists as SKU '%s'", other.SKU)
+            }
+        }
+
+        // Once a product has variants its stock is kept per variant, so there
+        // must be none left on the product itself
+        var unassigned int64
+        err = tx.Model(&models.StockLevel{}).
+            Where("product_id = ? AND variant_id = 0 AND (quantity <> 0 OR reserved <> 0)", product.ID).
+            Count(&unassigned).Error
+        if err != nil {
+            return err
+        }
+        if unassigned > 0 {
+            return status.Errorf(codes.FailedPrecondition, "Product with ID '%d' has stock that is not assigned to a variant", product.ID)
+        }
+
+        return tx.Create(&variant).Error
+    })
+    if err != nil {
+        return nil, variantError(err)
+    }
+    return s.productResponse(variant.ProductID)
+}
+
+func (s *server) UpdateVariant(ctx context.Context, req *pb.UpdateVariantRequest) (*pb.ProductResponse, error) {
+    var variant models.Variant
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant, req.Id).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Variant with ID '%d' not found", req.Id)
+            }
+            return err
+        }
+
+        var product models.Product
+        if err := tx.Select("id", "currency").First(&product, variant.ProductID).Error; err != nil {
+            return err
+        }
+        override, err := priceOverride(req.PriceOverride, product)
+        if err != nil {
+            return err
+        }
+
+        variant.SKU = strings.TrimSpace(req.Sku)
+        variant.Barcode = strings.TrimSpace(req.Barcode)
+        variant.PriceOverride = override
+        if err := validateSKU(tx, &variant); err != nil {
+            return err
+        }
+        return tx.Select("sku", "barcode", "price_override").Save(&variant).Error
+    })
+    if err != nil {
+        return nil, variantError(err)
+    }
+    return s.productResponse(variant.ProductID)
+}
+
+func (s *server) DeleteVariant(ctx context.Context, req *pb.DeleteVariantRequest) (*pb.ProductResponse, error) {
+    var variant models.Variant
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant, req.Id).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "Variant with ID '%d' not found", req.Id)
+            }
+            return err
+        }
+
+        // Stock of a deleted variant could no longer be sold or moved
+        if variant.Quantity != 0 || variant.Reserved != 0 {
+            return status.Errorf(codes.FailedPrecondition, "Variant with ID '%d' still has stock", variant.ID)
+        }
+        if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.VariantOption{}).Error; err != nil {
+            return err
+        }
+        return tx.Delete(&variant).Error
+    })
+    if err != nil {
+        return nil, variantError(err)
+    }
+    return s.productResponse(variant.ProductID)
+}
+
+// productResponse loads a product with its variant matrix for a response
+func (s *server) productResponse(productID uint) (*pb.ProductResponse, error) {
+    product, err := findProduct(s.db, productID)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.ProductResponse{Product: toProtoProduct(product)}, nil
+}
+
+// newProductOptions validates the option axes of a product. Names and the
+// values of each option must be non-empty and unique, ignoring case.
+func newProductOptions(productID uint, options []*pb.ProductOption) ([]models.ProductOption, error) {
+    res := make([]models.ProductOption, 0, len(options))
+    names := make(map[string]bool, len(options))
+    for i, option := range options {
+        name := strings.TrimSpace(option.Name)
+        if name == "" || names[strings.ToLower(name)] {
+            return nil, status.Errorf(codes.InvalidArgument, "Option names must be unique and non-empty")
+        }
+        names[strings.ToLower(name)] = true
+        if len(option.Values) == 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "Option '%s' has no values", name)
+        }
+
+        productOption := models.ProductOption{ProductID: productID, Name: name, Position: i}
+        values := make(map[string]bool, len(option.Values))
+        for j, value := range option.Values {
+            value = strings.TrimSpace(value)
+            if value == "" || values[strings.ToLower(value)] {
+                return nil, status.Errorf(codes.InvalidArgument, "Values of option '%s' must be unique and non-empty", name)
+            }
+            values[strings.ToLower(value)] = true
+            productOption.Values = append(productOption.Values, models.ProductOptionValue{Value: value, Position: j})
+        }
+        res = append(res, productOption)
+    }
+    return res, nil
+}
+
+// matchVariantOptions resolves the option values of a new variant against
+// the product's axes; the variant needs exactly one allowed value per axis
+func matchVariantOptions(product models.Product, options []*pb.VariantOption) ([]models.VariantOption, error) {
+    if len(product.Options) == 0 {
+        return nil, status.Errorf(codes.FailedPrecondition, "Product with ID '%d' has no options", product.ID)
+    }
+    if len(options) != len(product.Options) {
+        return nil, status.Errorf(codes.InvalidArgument, "Variant must have one value for each option of the product")
+    }
+
+    res := make([]models.VariantOption, 0, len(options))
+    for _, productOption := range product.Options {
+        var requested *pb.VariantOption
+        for _, option := range options {
+            if strings.EqualFold(strings.TrimSpace(option.Name), productOption.Name) {
+                requested = option
+            }
+        }
+        if requested == nil {
+            return nil, status.Errorf(codes.InvalidArgument, "Variant has no value for option '%s'", productOption.Name)
+        }
+
+        matched := false
+        for _, value := range productOption.Values {
+            if strings.EqualFold(strings.TrimSpace(requested.Value), value.Value) {
+                res = append(res, models.VariantOption{OptionID: productOption.ID, Value: value.Value})
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            return nil, status.Errorf(codes.InvalidArgument, "Invalid value '%s' for option '%s'", requested.Value, productOption.Name)
+        }
+    }
+    return res, nil
+}
+
+// variantKey identifies the combination of option values of a variant
+func variantKey(options []models.VariantOption) string {
+    parts := make([]string, 0, len(options))
+    for _, option := range options {
+        parts = append(parts, fmt.Sprintf("%d=%s", option.OptionID, option.Value))
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ";")
+}
+
+// validateSKU checks that a variant has an SKU no other variant uses. Deleted
+// variants keep theirs, so their SKUs can't be reused.
+func validateSKU(tx *gorm.DB, variant *models.Variant) error {
+    if variant.SKU == "" {
+        return status.Errorf(codes.InvalidArgument, "Variant SKU is required")
+    }
+    var conflicts int64
+    if err := tx.Unscoped().Model(&models.Variant{}).Where("sku = ? AND id <> ?", variant.SKU, variant.ID).Count(&conflicts).Error; err != nil {
+        return status.Errorf(codes.Internal, "Error retrieving variant: %v", err)
+    }
+    if conflicts > 0 {
+        return status.Errorf(codes.AlreadyExists, "Variant with SKU '%s' already exists", variant.SKU)
+    }
+    return nil
+}
+
+// variantError passes status errors through and wraps anything else as internal
+func variantError(err error) error {
+    if _, ok := status.FromError(err); ok {
+        return err
+    }
+    return status.Errorf(codes.Internal, "Error updating variants: %v", err)
+}
+
+// toProtoVariant converts a variant to protobuf, naming its option values
+// after the product's options and listing them in the product's order
+func toProtoVariant(product models.Product, variant models.Variant) *pb.Variant {
+    res := &pb.Variant{
+        Id:        int64(variant.ID),
+        ProductId: int64(variant.ProductID),
+        Sku:       variant.SKU,
+        Barcode:   variant.Barcode,
+        Price:     toProtoMoney(product.Money(variant.Price(product))),
+        Quantity:  int32(variant.Quantity),
+        Reserved:  int32(variant.Reserved),
+        Available: int32(variant.Available()),
+    }
+    if variant.PriceOverride != nil {
+        res.PriceOverride = toProtoMoney(product.Money(*variant.PriceOverride))
+    }
+    for _, option := range product.Options {
+        for _, value := range variant.Options {
+            if value.OptionID == option.ID {
+                res.Options = append(res.Options, &pb.VariantOption{Name: option.Name, Value: value.Value})
+            }
+        }
+    }
+    return res
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "gorm.io/gorm"
+    "product-service/models"
+)
+
+func TestNewProductOptions(t *testing.T) {
+    tests := []struct {
+        name    string
+        options []*pb.ProductOption
+        code    codes.Code
+    }{
+        {name: "none"},
+        {name: "size and color", options: []*pb.ProductOption{{Name: " Size ", Values: []string{"S", "M"}}, {Name: "Color", Values: []string{"Red"}}}},
+        {name: "unnamed", options: []*pb.ProductOption{{Name: " ", Values: []string{"S"}}}, code: codes.InvalidArgument},
+        {name: "repeated name", options: []*pb.ProductOption{{Name: "Size", Values: []string{"S"}}, {Name: "size", Values: []string{"M"}}}, code: codes.InvalidArgument},
+        {name: "without values", options: []*pb.ProductOption{{Name: "Size"}}, code: codes.InvalidArgument},
+        {name: "repeated value", options: []*pb.ProductOption{{Name: "Size", Values: []string{"M", " m"}}}, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            options, err := newProductOptions(7, tt.options)
+            if status.Code(err) != tt.code {
+                t.Fatalf("newProductOptions error = %v, want %v", err, tt.code)
+            }
+            if err == nil && len(options) != len(tt.options) {
+                t.Errorf("newProductOptions = %+v, want %d options", options, len(tt.options))
+            }
+        })
+    }
+
+    options, _ := newProductOptions(7, []*pb.ProductOption{{Name: " Size ", Values: []string{"S", " M "}}, {Name: "Color", Values: []string{"Red"}}})
+    if size := options[0]; size.ProductID != 7 || size.Name != "Size" || size.Position != 0 || size.Values[1].Value != "M" || size.Values[1].Position != 1 || options[1].Position != 1 {
+        t.Errorf("options = %+v, want trimmed names and values in the given order", options)
+    }
+}
+
+// testVariantProduct is a product with the options Size (S, M) and Color (Red)
+func testVariantProduct() models.Product {
+    return models.Product{
+        Model: gorm.Model{ID: 7},
+        Options: []models.ProductOption{
+            {Model: gorm.Model{ID: 1}, Name: "Size", Values: []models.ProductOptionValue{{Value: "S"}, {Value: "M"}}},
+            {Model: gorm.Model{ID: 2}, Name: "Color", Values: []models.ProductOptionValue{{Value: "Red"}}},
+        },
+    }
+}
+
+func TestMatchVariantOptions(t *testing.T) {
+    tests := []struct {
+        name    string
+        product models.Product
+        options []*pb.VariantOption
+        want    string
+        code    codes.Code
+    }{
+        {
+            name:    "any order and case",
+            product: testVariantProduct(),
+            options: []*pb.VariantOption{{Name: "color", Value: "red"}, {Name: "Size", Value: " m"}},
+            want:    "1=M;2=Red",
+        },
+        {name: "product without options", product: models.Product{}, options: []*pb.VariantOption{{Name: "Size", Value: "S"}}, code: codes.FailedPrecondition},
+        {name: "option missing", product: testVariantProduct(), options: []*pb.VariantOption{{Name: "Size", Value: "S"}}, code: codes.InvalidArgument},
+        {name: "unknown option", product: testVariantProduct(), options: []*pb.VariantOption{{Name: "Size", Value: "S"}, {Name: "Fit", Value: "Slim"}}, code: codes.InvalidArgument},
+        {name: "unknown value", product: testVariantProduct(), options: []*pb.VariantOption{{Name: "Size", Value: "XL"}, {Name: "Color", Value: "Red"}}, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            options, err := matchVariantOptions(tt.product, tt.options)
+            if status.Code(err) != tt.code {
+                t.Fatalf("matchVariantOptions error = %v, want %v", err, tt.code)
+            }
+            if got := variantKey(options); err == nil && got != tt.want {
+                t.Errorf("matched options %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestToProtoVariant(t *testing.T) {
+    product := testVariantProduct()
+    product.Price, product.Currency = 1000, "EUR"
+    override := int64(1250)
+    variant := models.Variant{
+        Model:    gorm.Model{ID: 3},
+        SKU:      "TEE-M-RED",
+        Quantity: 5,
+        Reserved: 2,
+        Options:  []models.VariantOption{{OptionID: 2, Value: "Red"}, {OptionID: 1, Value: "M"}},
+    }
+
+    res := toProtoVariant(product, variant)
+    if res.Price.GetAmount() != 1000 || res.PriceOverride != nil || res.Available != 3 {
+        t.Errorf("variant priced %v, override %v, available %d, want the product's 1000, none and 3", res.Price, res.PriceOverride, res.Available)
+    }
+    // Option values are listed in the product's order
+    if len(res.Options) != 2 || res.Options[0].Name != "Size" || res.Options[1].Value != "Red" {
+        t.Errorf("options = %v, want Size then Color", res.Options)
+    }
+
+    variant.PriceOverride = &override
+    if res := toProtoVariant(product, variant); res.Price.GetAmount() != 1250 || res.PriceOverride.GetCurrency() != "EUR" {
+        t.Errorf("variant priced %v with override %v, want 1250 EUR", res.Price, res.PriceOverride)
+    }
+}
+
+func TestVariants(t *testing.T) {
+    db := testDB(t)
+    s := &server{db: db}
+    ctx := context.Background()
+    product := createTestProduct(t, db, nil)
+    productID := int64(product.ID)
+
+    options := []*pb.ProductOption{{Name: "Size", Values: []string{"S", "M"}}, {Name: "Color", Values: []string{"Red"}}}
+    if _, err := s.SetProductOptions(ctx, &pb.SetProductOptionsRequest{ProductId: productID, Options: options}); err != nil {
+        t.Fatalf("SetProductOptions error = %v", err)
+    }
+    add := func(sku, size string) (*pb.ProductResponse, error) {
+        return s.AddVariant(ctx, &pb.AddVariantRequest{ProductId: productID, Sku: sku, Options: []*pb.VariantOption{{Name: "Size", Value: size}, {Name: "Color", Value: "Red"}}})
+    }
+    if _, err := add("TEE-S", "S"); err != nil {
+        t.Fatalf("AddVariant error = %v", err)
+    }
+    res, err := add("TEE-M", "M")
+    if err != nil {
+        t.Fatalf("AddVariant error = %v", err)
+    }
+    if len(res.Product.Variants) != 2 || len(res.Product.Options) != 2 {
+        t.Fatalf("product = %v, want 2 options and 2 variants", res.Product)
+    }
+    medium := res.Product.Variants[1]
+
+    failures := []struct {
+        name string
+        call func() error
+        code codes.Code
+    }{
+        {name: "same options", call: func() error { _, err := add("TEE-M2", "m"); return err }, code: codes.AlreadyExists},
+        {name: "same SKU", call: func() error { _, err := add("TEE-S", "M"); return err }, code: codes.AlreadyExists},
+        {name: "options changed once variants exist", call: func() error {
+            _, err := s.SetProductOptions(ctx, &pb.SetProductOptionsRequest{ProductId: productID, Options: options})
+            return err
+        }, code: codes.FailedPrecondition},
+        {name: "stock without a variant", call: func() error {
+            _, err := adjustStock(db, product.ID, 0, 0, 1, 0, "", movementSource{Reason: models.MovementRestock})
+            return err
+        }, code: codes.InvalidArgument},
+        {name: "stock of another product's variant", call: func() error {
+            other := createTestProduct(t, db, nil)
+            _, err := adjustStock(db, other.ID, uint(medium.Id), 0, 1, 0, "", movementSource{Reason: models.MovementRestock})
+            return err
+        }, code: codes.NotFound},
+    }
+    for _, tt := range failures {
+        if err := tt.call(); status.Code(err) != tt.code {
+            t.Errorf("%s: error = %v, want %v", tt.name, err, tt.code)
+        }
+    }
+
+    // Stock is kept per variant, and summed on the product
+    if _, err := adjustStock(db, product.ID, uint(medium.Id), 0, 4, 0, "", movementSource{Reason: models.MovementRestock}); err != nil {
+        t.Fatalf("adjustStock error = %v", err)
+    }
+    res, err = s.productResponse(product.ID)
+    if err != nil {
+        t.Fatalf("productResponse error = %v", err)
+    }
+    if res.Product.Quantity != 4 || res.Product.Variants[0].Quantity != 0 || res.Product.Variants[1].Quantity != 4 {
+        t.Errorf("product stock %d, variant stock %d and %d, want 4, 0 and 4", res.Product.Quantity, res.Product.Variants[0].Quantity, res.Product.Variants[1].Quantity)
+    }
+
+    if _, err := s.DeleteVariant(ctx, &pb.DeleteVariantRequest{Id: medium.Id}); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("DeleteVariant with stock error = %v, want %v", err, codes.FailedPrecondition)
+    }
+    res, err = s.DeleteVariant(ctx, &pb.DeleteVariantRequest{Id: res.Product.Variants[0].Id})
+    if err != nil || len(res.Product.Variants) != 1 {
+        t.Fatalf("DeleteVariant = %v, %v, want the product with one variant left", res.GetProduct(), err)
+    }
+    // A deleted variant's SKU stays taken
+    if _, err := add("TEE-S", "S"); status.Code(err) != codes.AlreadyExists {
+        t.Errorf("AddVariant with a deleted variant's SKU error = %v, want %v", err, codes.AlreadyExists)
+    }
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+    "gorm.io/gorm"
+    "product-service/models"
+)
+
+// ensureDefaultWarehouse creates the default warehouse and moves the stock of
+// products that have no stock levels yet, i.e. those created before
+// warehouses existed, into it
+func ensureDefaultWarehouse(db *gorm.DB) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        warehouse := models.Warehouse{Code: models.DefaultWarehouseCode, Name: "Default warehouse", Active: true}
+        if err := tx.Where(models.Warehouse{Code: models.DefaultWarehouseCode}).FirstOrCreate(&warehouse).Error; err != nil {
+            return err
+        }
+        return tx.Exec(`INSERT INTO stock_levels (created_at, updated_at, product_id, warehouse_id, quantity, reserved)
+            SELECT NOW(), NOW(), p.id, ?, p.quantity, p.reserved FROM products p
+            WHERE p.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM stock_levels l WHERE l.product_id = p.id)`, warehouse.ID).Error
+    })
+}
+
+// resolveWarehouse returns the ID of the given warehouse, or of the default
+// warehouse when id is zero
+func resolveWarehouse(db *gorm.DB, id uint) (uint, error) {
+    var warehouse models.Warehouse
+    query := db.Select("id")
+    if id == 0 {
+        query = query.Where("code = ?", models.DefaultWarehouseCode)
+    } else {
+        query = query.Where("id = ?", id)
+    }
+    if err := query.First(&warehouse).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return 0, status.Errorf(codes.NotFound, "Warehouse with ID '%d' not found", id)
+        }
+        return 0, status.Errorf(codes.Internal, "Error retrieving warehouse: %v", err)
+    }
+    return warehouse.ID, nil
+}
+
+func (s *server) AddWarehouse(ctx context.Context, req *pb.AddWarehouseRequest) (*pb.WarehouseResponse, error) {
+    code := strings.ToUpper(strings.TrimSpace(req.Code))
+    if code == "" {
+        return nil, status.Errorf(codes.InvalidArgument, "Warehouse code is required")
+    }
+
+    warehouse := models.Warehouse{
+        Code:     code,
+        Name:     req.Name,
+        Priority: int(req.Priority),
+        Active:   true,
+    }
+    if req.Location != nil {
+        warehouse.Latitude = req.Location.Latitude
+        warehouse.Longitude = req.Location.Longitude
+    }
+
+    var existing int64
+    if err := s.db.Model(&models.Warehouse{}).Where("code = ?", code).Count(&existing).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving warehouse: %v", err)
+    }
+    if existing > 0 {
+        return nil, status.Errorf(codes.AlreadyExists, "Warehouse with code '%s' already exists", code)
+    }
+    if err := s.db.Create(&warehouse).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error creating warehouse: %v", err)
+    }
+
+    return &pb.WarehouseResponse{Warehouse: toProtoWarehouse(warehouse)}, nil
+}
+
+func (s *server) ListWarehouses(ctx context.Context, req *pb.ListWarehousesRequest) (*pb.ListWarehousesResponse, error) {
+    var warehouses []models.Warehouse
+    if err := s.db.Order("priority, id").Find(&warehouses).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving warehouses: %v", err)
+    }
+
+    res := &pb.ListWarehousesResponse{Warehouses: make([]*pb.Warehouse, 0, len(warehouses))}
+    for _, warehouse := range warehouses {
+        res.Warehouses = append(res.Warehouses, toProtoWarehouse(warehouse))
+    }
+    return res, nil
+}
+
+// toProtoWarehouse converts a warehouse to its protobuf representation
+func toProtoWarehouse(warehouse models.Warehouse) *pb.Warehouse {
+    return &pb.Warehouse{
+        Id:       int64(warehouse.ID),
+        Code:     warehouse.Code,
+        Name:     warehouse.Name,
+        Location: &pb.GeoPoint{Latitude: warehouse.Latitude, Longitude: warehouse.Longitude},
+        Priority: int32(warehouse.Priority),
+        Active:   warehouse.Active,
+    }
+}
@@ -0,0 +1,48 @@
+// Package events publishes the domain events of the store's services.
+//
+// A service writes each event to its outbox_events table with Enqueue, in the
+// same transaction as the change it describes, and runs a Relay that
+// publishes pending rows to a Broker. Delivery is at least once, so consumers
+// de-duplicate on the outbox row ID carried in every Envelope.
+package events
+
+import (
+    "context"
+    "fmt"
+    "os"
+)
+
+// Subjects of the events emitted by the services
+const (
+    OrderCreated     = "order.created"
+    OrderUpdated     = "order.updated"
+    ProductCreated   = "product.created"
+    ProductUpdated   = "product.updated"
+    ProductDeleted   = "product.deleted"
+    InventoryChanged = "inventory.changed"
+)
+
+// Broker publishes messages to subscribers. Implementations must be safe for concurrent use.
+type Broker interface {
+    Publish(ctx context.Context, subject string, data []byte) error
+    Close() error
+}
+
+// NewBrokerFromEnv creates the broker selected by the EVENT_BROKER environment
+// variable for the named service. "nats" (the default) publishes to the NATS
+// server at NATS_URL (default nats://localhost:4222). "none" returns a nil
+// broker: no relay runs and events stay in the outbox until one is configured.
+func NewBrokerFromEnv(name string) (Broker, error) {
+    switch kind := os.Getenv("EVENT_BROKER"); kind {
+    case "", "nats":
+        url := os.Getenv("NATS_URL")
+        if url == "" {
+            url = "nats://localhost:4222"
+        }
+        return NewNATSBroker(url, name)
+    case "none":
+        return nil, nil
+    default:
+        return nil, fmt.Errorf("unknown EVENT_BROKER %q", kind)
+    }
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+    "context"
+    "errors"
+    "log"
+    "strings"
+    "sync"
+)
+
+// ErrNoSubscribers is returned when no handler is subscribed to a message's subject
+var ErrNoSubscribers = errors.New("no subscribers for subject")
+
+// Handler receives a published message
+type Handler func(subject string, data []byte)
+
+// InProcessBroker delivers messages synchronously to handlers registered in the same process
+type InProcessBroker struct {
+    mu            sync.RWMutex
+    subscriptions []subscription
+}
+
+type subscription struct {
+    pattern string
+    handler Handler
+}
+
+// NewInProcessBroker creates a broker with no subscribers
+func NewInProcessBroker() *InProcessBroker {
+    return &InProcessBroker{}
+}
+
+// Subscribe registers a handler for a subject pattern. Patterns follow NATS
+// conventions: "*" matches one dot separated token and a trailing ">" matches the rest.
+func (b *InProcessBroker) Subscribe(pattern string, handler Handler) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.subscriptions = append(b.subscriptions, subscription{pattern: pattern, handler: handler})
+}
+
+// Publish calls every handler whose pattern matches the subject. A message no
+// handler matches is refused with ErrNoSubscribers rather than dropped.
+func (b *InProcessBroker) Publish(ctx context.Context, subject string, data []byte) error {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+    delivered := false
+    for _, sub := range b.subscriptions {
+        if subjectMatches(sub.pattern, subject) {
+            b.deliver(sub, subject, data)
+            delivered = true
+        }
+    }
+    if !delivered {
+        return ErrNoSubscribers
+    }
+    return nil
+}
+
+// deliver calls a handler, keeping a panicking subscriber from taking down the relay
+func (b *InProcessBroker) deliver(sub subscription, subject string, data []byte) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("event handler for %q panicked: %v", sub.pattern, r)
+        }
+    }()
+    sub.handler(subject, data)
+}
+
+// Close is a no-op for the in-process broker
+func (b *InProcessBroker) Close() error {
+    return nil
+}
+
+// subjectMatches reports whether subject matches a NATS style pattern
+func subjectMatches(pattern, subject string) bool {
+    patternTokens := strings.Split(pattern, ".")
+    subjectTokens := strings.Split(subject, ".")
+    for i, token := range patternTokens {
+        if token == ">" {
+            return len(subjectTokens) > i
+        }
+        if i >= len(subjectTokens) {
+            return false
+        }
+        if token != "*" && token != subjectTokens[i] {
+            return false
+        }
+    }
+    return len(patternTokens) == len(subjectTokens)
+}
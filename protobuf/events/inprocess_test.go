@@ -0,0 +1,58 @@
+package events
+
+import (
+    "context"
+    "errors"
+    "testing"
+)
+
+func TestSubjectMatches(t *testing.T) {
+    tests := []struct {
+        pattern string
+        subject string
+        want    bool
+    }{
+        {pattern: "order.created", subject: "order.created", want: true},
+        {pattern: "order.created", subject: "order.updated"},
+        {pattern: "order.*", subject: "order.updated", want: true},
+        {pattern: "order.*", subject: "order"},
+        {pattern: "order.*", subject: "order.item.added"},
+        {pattern: "order.>", subject: "order.item.added", want: true},
+        {pattern: "order.>", subject: "order"},
+        {pattern: ">", subject: "inventory.changed", want: true},
+    }
+    for _, tt := range tests {
+        if got := subjectMatches(tt.pattern, tt.subject); got != tt.want {
+            t.Errorf("subjectMatches(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+        }
+    }
+}
+
+func TestInProcessBroker(t *testing.T) {
+    broker := NewInProcessBroker()
+    if err := broker.Publish(context.Background(), OrderCreated, nil); !errors.Is(err, ErrNoSubscribers) {
+        t.Fatalf("Publish without subscribers error = %v, want ErrNoSubscribers", err)
+    }
+
+    var received []string
+    broker.Subscribe("order.*", func(subject string, data []byte) {
+        received = append(received, subject+" "+string(data))
+    })
+    broker.Subscribe(OrderUpdated, func(subject string, data []byte) {
+        panic("subscriber failed")
+    })
+
+    if err := broker.Publish(context.Background(), OrderCreated, []byte("1")); err != nil {
+        t.Fatalf("Publish error = %v", err)
+    }
+    // A panicking subscriber doesn't stop delivery to the others
+    if err := broker.Publish(context.Background(), OrderUpdated, []byte("2")); err != nil {
+        t.Fatalf("Publish error = %v", err)
+    }
+    if err := broker.Publish(context.Background(), InventoryChanged, []byte("3")); !errors.Is(err, ErrNoSubscribers) {
+        t.Errorf("Publish of an unsubscribed subject error = %v, want ErrNoSubscribers", err)
+    }
+    if len(received) != 2 || received[0] != "order.created 1" || received[1] != "order.updated 2" {
+        t.Errorf("received = %v, want order.created and order.updated", received)
+    }
+}
@@ -0,0 +1,163 @@
+package events
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// claimLease is how long a relay holds the events it claimed. Events of a
+// relay that stopped while publishing are picked up again once it runs out.
+const claimLease = time.Minute
+
+// OutboxEvent is a domain event written in the same transaction as the change
+// it describes, and published to the message broker afterwards by the relay
+type OutboxEvent struct {
+    gorm.Model
+    Subject       string     `gorm:"index"` // Broker subject, e.g. "order.created"
+    AggregateType string     // Kind of entity the event is about, e.g. "order"
+    AggregateID   uint       `gorm:"index"`
+    Payload       string     // JSON encoded event data
+    PublishedAt   *time.Time `gorm:"index"` // Nil until the relay has published the event
+    ClaimedUntil  *time.Time // Set while a relay is publishing the event
+    Attempts      int        // Number of failed publish attempts
+    LastError     string
+}
+
+// Envelope is the message published for every outbox event
+type Envelope struct {
+    ID            uint            `json:"id"` // Outbox row ID, usable by consumers for de-duplication
+    Subject       string          `json:"subject"`
+    AggregateType string          `json:"aggregate_type"`
+    AggregateID   uint            `json:"aggregate_id"`
+    OccurredAt    time.Time       `json:"occurred_at"`
+    Data          json.RawMessage `json:"data"`
+}
+
+// Enqueue writes an event to the outbox using tx, so it is only published if tx commits
+func Enqueue(tx *gorm.DB, subject, aggregateType string, aggregateID uint, data interface{}) error {
+    payload, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+    return tx.Create(&OutboxEvent{
+        Subject:       subject,
+        AggregateType: aggregateType,
+        AggregateID:   aggregateID,
+        Payload:       string(payload),
+    }).Error
+}
+
+// Relay publishes unpublished outbox events to a broker in insertion order
+type Relay struct {
+    DB        *gorm.DB
+    Broker    Broker
+    Interval  time.Duration // Delay between polls when the outbox is drained
+    BatchSize int
+}
+
+// NewRelay creates a relay with default polling settings
+func NewRelay(db *gorm.DB, broker Broker) *Relay {
+    return &Relay{DB: db, Broker: broker, Interval: time.Second, BatchSize: 100}
+}
+
+// Run publishes events until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+    for {
+        published, err := r.PublishPending(ctx)
+        if err != nil {
+            log.Printf("outbox relay: %v", err)
+        }
+        // Keep draining without waiting while there is a backlog
+        if published == r.BatchSize {
+            continue
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(r.Interval):
+        }
+    }
+}
+
+// PublishPending publishes one batch of events and returns how many were published.
+// The batch is claimed in a short transaction and published outside it, so no
+// transaction stays open while the broker is called. Several relays can run
+// side by side; events are delivered at least once and publishing stops at the
+// first failure to keep order.
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+    pending, err := r.claim()
+    if err != nil {
+        return 0, err
+    }
+
+    for i := range pending {
+        event := &pending[i]
+        message, err := json.Marshal(Envelope{
+            ID:            event.ID,
+            Subject:       event.Subject,
+            AggregateType: event.AggregateType,
+            AggregateID:   event.AggregateID,
+            OccurredAt:    event.CreatedAt,
+            Data:          json.RawMessage(event.Payload),
+        })
+        if err == nil {
+            err = r.Broker.Publish(ctx, event.Subject, message)
+        }
+        if err != nil {
+            log.Printf("outbox relay: failed to publish event %d: %v", event.ID, err)
+            return i, r.unclaim(pending[i:], err)
+        }
+
+        now := time.Now()
+        if err := r.DB.Model(event).Updates(map[string]interface{}{"published_at": &now, "claimed_until": nil}).Error; err != nil {
+            return i, err
+        }
+    }
+    return len(pending), nil
+}
+
+// claim locks the next batch of unclaimed events for this relay
+func (r *Relay) claim() ([]OutboxEvent, error) {
+    var pending []OutboxEvent
+    err := r.DB.Transaction(func(tx *gorm.DB) error {
+        now := time.Now()
+        err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+            Where("published_at IS NULL AND (claimed_until IS NULL OR claimed_until < ?)", now).
+            Order("id").
+            Limit(r.BatchSize).
+            Find(&pending).Error
+        if err != nil || len(pending) == 0 {
+            return err
+        }
+
+        ids := make([]uint, len(pending))
+        for i, event := range pending {
+            ids[i] = event.ID
+        }
+        return tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("claimed_until", now.Add(claimLease)).Error
+    })
+    return pending, err
+}
+
+// unclaim records the failure to publish the first of events and hands all of
+// them back, so the next poll retries them in order
+func (r *Relay) unclaim(events []OutboxEvent, cause error) error {
+    failed := events[0]
+    updates := map[string]interface{}{"attempts": failed.Attempts + 1, "last_error": cause.Error(), "claimed_until": nil}
+    if err := r.DB.Model(&failed).Updates(updates).Error; err != nil {
+        return err
+    }
+    ids := make([]uint, 0, len(events)-1)
+    for _, event := range events[1:] {
+        ids = append(ids, event.ID)
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+    return r.DB.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("claimed_until", nil).Error
+}
@@ -0,0 +1,156 @@
+package events
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "os"
+    "testing"
+    "time"
+
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// testDB returns a database with the outbox migrated into a schema of its
+// own, dropped when the test ends. Tests using it are skipped unless
+// TEST_DATABASE_URL names a Postgres database.
+func testDB(t *testing.T) *gorm.DB {
+    t.Helper()
+    dsn := os.Getenv("TEST_DATABASE_URL")
+    if dsn == "" {
+        t.Skip("TEST_DATABASE_URL not set")
+    }
+    config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+
+    admin, err := gorm.Open(postgres.Open(dsn), config)
+    if err != nil {
+        t.Fatalf("failed to connect database: %v", err)
+    }
+    schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+    if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+        t.Fatalf("failed to create schema: %v", err)
+    }
+
+    u, err := url.Parse(dsn)
+    if err != nil {
+        t.Fatalf("TEST_DATABASE_URL must be a URL: %v", err)
+    }
+    query := u.Query()
+    query.Set("search_path", schema)
+    u.RawQuery = query.Encode()
+    db, err := gorm.Open(postgres.Open(u.String()), config)
+    if err != nil {
+        t.Fatalf("failed to connect database: %v", err)
+    }
+
+    t.Cleanup(func() {
+        if sqlDB, err := db.DB(); err == nil {
+            sqlDB.Close()
+        }
+        admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+        if sqlDB, err := admin.DB(); err == nil {
+            sqlDB.Close()
+        }
+    })
+
+    if err := db.AutoMigrate(&OutboxEvent{}); err != nil {
+        t.Fatalf("failed to migrate database: %v", err)
+    }
+    return db
+}
+
+// flakyBroker records published envelopes and fails while err is set
+type flakyBroker struct {
+    published []Envelope
+    err       error
+}
+
+func (b *flakyBroker) Publish(ctx context.Context, subject string, data []byte) error {
+    if b.err != nil {
+        return b.err
+    }
+    var envelope Envelope
+    if err := json.Unmarshal(data, &envelope); err != nil {
+        return err
+    }
+    b.published = append(b.published, envelope)
+    return nil
+}
+
+func (b *flakyBroker) Close() error {
+    return nil
+}
+
+func TestRelay(t *testing.T) {
+    db := testDB(t)
+    for id := uint(1); id <= 3; id++ {
+        if err := Enqueue(db, OrderCreated, "order", id, map[string]uint{"order_id": id}); err != nil {
+            t.Fatalf("Enqueue error = %v", err)
+        }
+    }
+    broker := &flakyBroker{err: errors.New("broker down")}
+    relay := NewRelay(db, broker)
+
+    // A failed publish is recorded and the events are handed back
+    published, err := relay.PublishPending(context.Background())
+    if err != nil || published != 0 {
+        t.Fatalf("PublishPending = %d, %v; want 0, nil", published, err)
+    }
+    var first OutboxEvent
+    db.Order("id").First(&first)
+    if first.Attempts != 1 || first.LastError != "broker down" || first.PublishedAt != nil {
+        t.Errorf("failed event = %+v, want 1 attempt with its error", first)
+    }
+    var claimed int64
+    db.Model(&OutboxEvent{}).Where("claimed_until IS NOT NULL").Count(&claimed)
+    if claimed != 0 {
+        t.Errorf("%d events still claimed after a failed publish", claimed)
+    }
+
+    broker.err = nil
+    published, err = relay.PublishPending(context.Background())
+    if err != nil || published != 3 {
+        t.Fatalf("PublishPending = %d, %v; want 3, nil", published, err)
+    }
+    for i, envelope := range broker.published {
+        if envelope.AggregateID != uint(i+1) || envelope.Subject != OrderCreated {
+            t.Errorf("published[%d] = %+v, want order %d", i, envelope, i+1)
+        }
+    }
+    var pending int64
+    db.Model(&OutboxEvent{}).Where("published_at IS NULL").Count(&pending)
+    if pending != 0 {
+        t.Errorf("%d events left unpublished", pending)
+    }
+
+    if published, _ := relay.PublishPending(context.Background()); published != 0 {
+        t.Errorf("published %d events twice", published)
+    }
+}
+
+func TestRelaySkipsClaimedEvents(t *testing.T) {
+    db := testDB(t)
+    if err := Enqueue(db, ProductUpdated, "product", 1, map[string]uint{"product_id": 1}); err != nil {
+        t.Fatalf("Enqueue error = %v", err)
+    }
+    // Another relay is publishing the event
+    lease := time.Now().Add(time.Minute)
+    db.Model(&OutboxEvent{}).Where("1 = 1").Update("claimed_until", &lease)
+
+    broker := &flakyBroker{}
+    relay := NewRelay(db, broker)
+    if published, err := relay.PublishPending(context.Background()); err != nil || published != 0 {
+        t.Fatalf("PublishPending = %d, %v; want 0, nil", published, err)
+    }
+
+    // The other relay stopped and its lease ran out
+    expired := time.Now().Add(-time.Second)
+    db.Model(&OutboxEvent{}).Where("1 = 1").Update("claimed_until", &expired)
+    if published, err := relay.PublishPending(context.Background()); err != nil || published != 1 {
+        t.Fatalf("PublishPending = %d, %v; want 1, nil", published, err)
+    }
+}
@@ -0,0 +1,206 @@
+// Package money represents amounts as a whole number of minor units of an
+// ISO 4217 currency, e.g. 1999 cents for 19.99 USD, so that prices and totals
+// never pick up floating point error.
+//
+// Rounding: an amount with more decimal places than its currency's minor
+// unit is rounded to the nearest minor unit, and halves are rounded away from
+// zero ("1.005" USD is 1.01 USD and "-1.005" USD is -1.01 USD). Floats are
+// first written as their shortest decimal representation, so 19.99 stays
+// 19.99 even though the float is slightly below it. This is the same rule as
+// Postgres' round(numeric), which the services use to migrate stored amounts.
+package money
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "math/big"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// DefaultCurrency is the store currency when CURRENCY is unset
+const DefaultCurrency = "USD"
+
+var (
+    ErrUnknownCurrency  = errors.New("unknown currency")
+    ErrInvalidAmount    = errors.New("invalid amount")
+    ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// minorUnits is the number of decimal places of the minor unit of each
+// supported currency
+var minorUnits = map[string]int{
+    "AED": 2, "AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2,
+    "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2,
+    "INR": 2, "MXN": 2, "MYR": 2, "NOK": 2, "NZD": 2, "PHP": 2, "PLN": 2,
+    "RON": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TRY": 2, "USD": 2,
+    "ZAR": 2,
+    "CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "VND": 0,
+    "BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+// Money is an amount in the minor units of a currency
+type Money struct {
+    Amount   int64  // Minor units, e.g. cents
+    Currency string // ISO 4217 code, e.g. "USD"
+}
+
+// StoreCurrency returns the currency prices are kept in unless given
+// otherwise, from CURRENCY or DefaultCurrency
+func StoreCurrency() string {
+    if currency := os.Getenv("CURRENCY"); currency != "" {
+        return strings.ToUpper(currency)
+    }
+    return DefaultCurrency
+}
+
+// Normalize upper-cases a currency code and checks that it is supported
+func Normalize(currency string) (string, error) {
+    currency = strings.ToUpper(strings.TrimSpace(currency))
+    if _, ok := minorUnits[currency]; !ok {
+        return "", fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+    }
+    return currency, nil
+}
+
+// Scale returns the number of minor units in one major unit of a currency,
+// e.g. 100 for USD and 1 for JPY
+func Scale(currency string) (int64, error) {
+    currency, err := Normalize(currency)
+    if err != nil {
+        return 0, err
+    }
+    return int64(math.Pow10(minorUnits[currency])), nil
+}
+
+// New returns an amount of minor units of a currency
+func New(amount int64, currency string) (Money, error) {
+    currency, err := Normalize(currency)
+    if err != nil {
+        return Money{}, err
+    }
+    return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Parse reads a decimal amount such as "19.99" or "-5" in a currency. Extra
+// decimal places are rounded half away from zero.
+func Parse(amount, currency string) (Money, error) {
+    currency, err := Normalize(currency)
+    if err != nil {
+        return Money{}, err
+    }
+    places := minorUnits[currency]
+
+    digits := strings.TrimSpace(amount)
+    negative := false
+    if digits != "" && (digits[0] == '-' || digits[0] == '+') {
+        negative = digits[0] == '-'
+        digits = digits[1:]
+    }
+    whole, fraction, _ := strings.Cut(digits, ".")
+    if whole+fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+        return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, amount)
+    }
+
+    // Fit the fraction to the minor unit; the first digit cut off decides the rounding
+    roundUp := false
+    if len(fraction) > places {
+        roundUp = fraction[places] >= '5'
+        fraction = fraction[:places]
+    } else {
+        fraction += strings.Repeat("0", places-len(fraction))
+    }
+    units, err := strconv.ParseInt("0"+whole+fraction, 10, 64)
+    if err != nil || (roundUp && units == math.MaxInt64) {
+        return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, amount)
+    }
+    if roundUp {
+        units++
+    }
+    if negative {
+        units = -units
+    }
+    return Money{Amount: units, Currency: currency}, nil
+}
+
+// FromFloat converts a float amount, such as a legacy price, by way of its
+// shortest decimal representation
+func FromFloat(amount float64, currency string) (Money, error) {
+    if math.IsNaN(amount) || math.IsInf(amount, 0) {
+        return Money{}, fmt.Errorf("%w %v", ErrInvalidAmount, amount)
+    }
+    return Parse(strconv.FormatFloat(amount, 'f', -1, 64), currency)
+}
+
+func isDigits(s string) bool {
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// Decimal formats the amount in major units, e.g. "19.99", "-0.05" or "500" for JPY
+func (m Money) Decimal() string {
+    places := minorUnits[m.Currency]
+    digits := strconv.FormatInt(m.Amount, 10)
+    sign := ""
+    if m.Amount < 0 {
+        sign, digits = "-", digits[1:]
+    }
+    if places == 0 {
+        return sign + digits
+    }
+    if len(digits) <= places {
+        digits = strings.Repeat("0", places-len(digits)+1) + digits
+    }
+    return sign + digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+}
+
+// String formats the amount with its currency, e.g. "19.99 USD"
+func (m Money) String() string {
+    return m.Decimal() + " " + m.Currency
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+    return m.Amount == 0
+}
+
+// Add returns the sum of two amounts of the same currency
+func (m Money) Add(other Money) (Money, error) {
+    if m.Currency != other.Currency {
+        return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+    }
+    return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns the difference of two amounts of the same currency
+func (m Money) Sub(other Money) (Money, error) {
+    return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul returns the amount times a quantity, e.g. the total of an order line
+func (m Money) Mul(quantity int64) Money {
+    return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// MulRatio returns the amount times numerator/denominator, rounded half away
+// from zero, e.g. MulRatio(1250, 10000) for 12.5% of it
+func (m Money) MulRatio(numerator, denominator int64) Money {
+    product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(numerator))
+    divisor := big.NewInt(denominator)
+    quotient, remainder := new(big.Int).QuoRem(product, divisor, new(big.Int))
+    // The quotient is truncated; go one further from zero when at least half remains
+    if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).CmpAbs(divisor) >= 0 {
+        if product.Sign()*divisor.Sign() < 0 {
+            quotient.Sub(quotient, big.NewInt(1))
+        } else {
+            quotient.Add(quotient, big.NewInt(1))
+        }
+    }
+    return Money{Amount: quotient.Int64(), Currency: m.Currency}
+}
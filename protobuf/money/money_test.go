@@ -0,0 +1,191 @@
+package money
+
+import (
+    "errors"
+    "math"
+    "testing"
+)
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        amount   string
+        currency string
+        want     int64
+    }{
+        {"19.99", "USD", 1999},
+        {"19.9", "usd", 1990},
+        {"19", "USD", 1900},
+        {".5", "USD", 50},
+        {"+3.", "USD", 300},
+        {"0", "EUR", 0},
+        {" 7.25 ", "EUR", 725},
+        // Halves round away from zero, anything below half rounds toward it
+        {"1.005", "USD", 101},
+        {"1.0049999", "USD", 100},
+        {"-1.005", "USD", -101},
+        {"-1.004", "USD", -100},
+        {"0.995", "USD", 100},
+        // The minor unit depends on the currency
+        {"500", "JPY", 500},
+        {"499.5", "JPY", 500},
+        {"499.49", "JPY", 499},
+        {"1.2345", "KWD", 1235},
+        {"92233720368547758.07", "USD", math.MaxInt64},
+    }
+    for _, tt := range tests {
+        got, err := Parse(tt.amount, tt.currency)
+        if err != nil {
+            t.Errorf("Parse(%q, %s): %v", tt.amount, tt.currency, err)
+            continue
+        }
+        if got.Amount != tt.want {
+            t.Errorf("Parse(%q, %s) = %d, want %d", tt.amount, tt.currency, got.Amount, tt.want)
+        }
+    }
+}
+
+func TestParseErrors(t *testing.T) {
+    tests := []struct {
+        amount   string
+        currency string
+        want     error
+    }{
+        {"", "USD", ErrInvalidAmount},
+        {"-", "USD", ErrInvalidAmount},
+        {".", "USD", ErrInvalidAmount},
+        {"1.2.3", "USD", ErrInvalidAmount},
+        {"--1", "USD", ErrInvalidAmount},
+        {"1e3", "USD", ErrInvalidAmount},
+        {"12,50", "EUR", ErrInvalidAmount},
+        {"92233720368547758.08", "USD", ErrInvalidAmount},
+        {"92233720368547758.075", "USD", ErrInvalidAmount},
+        {"1", "XYZ", ErrUnknownCurrency},
+        {"1", "", ErrUnknownCurrency},
+    }
+    for _, tt := range tests {
+        if _, err := Parse(tt.amount, tt.currency); !errors.Is(err, tt.want) {
+            t.Errorf("Parse(%q, %q) error = %v, want %v", tt.amount, tt.currency, err, tt.want)
+        }
+    }
+}
+
+func TestFromFloat(t *testing.T) {
+    tests := []struct {
+        amount   float64
+        currency string
+        want     int64
+    }{
+        {19.99, "USD", 1999},
+        {0.1 + 0.2, "USD", 30},
+        {float64(float32(19.99)), "USD", 1999},
+        {1.005, "USD", 101},
+        {-2.675, "USD", -268},
+        {1234.5, "JPY", 1235},
+    }
+    for _, tt := range tests {
+        got, err := FromFloat(tt.amount, tt.currency)
+        if err != nil {
+            t.Errorf("FromFloat(%v, %s): %v", tt.amount, tt.currency, err)
+            continue
+        }
+        if got.Amount != tt.want {
+            t.Errorf("FromFloat(%v, %s) = %d, want %d", tt.amount, tt.currency, got.Amount, tt.want)
+        }
+    }
+    for _, amount := range []float64{math.NaN(), math.Inf(1), 1e300} {
+        if _, err := FromFloat(amount, "USD"); !errors.Is(err, ErrInvalidAmount) {
+            t.Errorf("FromFloat(%v) error = %v, want %v", amount, err, ErrInvalidAmount)
+        }
+    }
+}
+
+func TestDecimal(t *testing.T) {
+    tests := []struct {
+        money Money
+        want  string
+    }{
+        {Money{1999, "USD"}, "19.99"},
+        {Money{5, "USD"}, "0.05"},
+        {Money{-5, "USD"}, "-0.05"},
+        {Money{0, "USD"}, "0.00"},
+        {Money{-120000, "EUR"}, "-1200.00"},
+        {Money{500, "JPY"}, "500"},
+        {Money{1235, "KWD"}, "1.235"},
+    }
+    for _, tt := range tests {
+        if got := tt.money.Decimal(); got != tt.want {
+            t.Errorf("%#v.Decimal() = %q, want %q", tt.money, got, tt.want)
+        }
+        // Formatting and parsing round-trip
+        if parsed, err := Parse(tt.money.Decimal(), tt.money.Currency); err != nil || parsed != tt.money {
+            t.Errorf("Parse(%q) = %v, %v, want %v", tt.money.Decimal(), parsed, err, tt.money)
+        }
+    }
+    if got := (Money{1999, "USD"}).String(); got != "19.99 USD" {
+        t.Errorf("String() = %q, want %q", got, "19.99 USD")
+    }
+}
+
+func TestMulRatio(t *testing.T) {
+    tests := []struct {
+        amount                 int64
+        numerator, denominator int64
+        want                   int64
+    }{
+        {1000, 1250, 10000, 125}, // 12.5%
+        {999, 1, 2, 500},         // 4.995 -> 5.00
+        {997, 1, 2, 499},         // 4.985 -> 4.99
+        {-999, 1, 2, -500},
+        {999, -1, 2, -500},
+        {1999, 2000, 10000, 400}, // 20% of 19.99 is 3.998
+        {1001, 1, 3, 334},
+        {1000, 1, 3, 333},
+        {math.MaxInt64, 1, 1, math.MaxInt64},
+    }
+    for _, tt := range tests {
+        got := Money{tt.amount, "USD"}.MulRatio(tt.numerator, tt.denominator)
+        if got.Amount != tt.want || got.Currency != "USD" {
+            t.Errorf("MulRatio(%d, %d/%d) = %v, want %d", tt.amount, tt.numerator, tt.denominator, got, tt.want)
+        }
+    }
+}
+
+func TestAddSub(t *testing.T) {
+    sum, err := Money{1999, "USD"}.Add(Money{1, "USD"})
+    if err != nil || sum != (Money{2000, "USD"}) {
+        t.Errorf("Add = %v, %v, want 20.00 USD", sum, err)
+    }
+    difference, err := Money{1999, "USD"}.Sub(Money{2000, "USD"})
+    if err != nil || difference != (Money{-1, "USD"}) {
+        t.Errorf("Sub = %v, %v, want -0.01 USD", difference, err)
+    }
+    if _, err := (Money{1, "USD"}).Add(Money{1, "EUR"}); !errors.Is(err, ErrCurrencyMismatch) {
+        t.Errorf("Add across currencies error = %v, want %v", err, ErrCurrencyMismatch)
+    }
+    if got := (Money{1999, "USD"}).Mul(3); got != (Money{5997, "USD"}) {
+        t.Errorf("Mul = %v, want 59.97 USD", got)
+    }
+}
+
+func TestNormalize(t *testing.T) {
+    if got, err := Normalize(" eur "); err != nil || got != "EUR" {
+        t.Errorf("Normalize = %q, %v, want EUR", got, err)
+    }
+    if scale, err := Scale("JPY"); err != nil || scale != 1 {
+        t.Errorf("Scale(JPY) = %d, %v, want 1", scale, err)
+    }
+    if scale, err := Scale("KWD"); err != nil || scale != 1000 {
+        t.Errorf("Scale(KWD) = %d, %v, want 1000", scale, err)
+    }
+}
+
+func TestStoreCurrency(t *testing.T) {
+    t.Setenv("CURRENCY", "")
+    if got := StoreCurrency(); got != DefaultCurrency {
+        t.Errorf("StoreCurrency() = %q, want %q", got, DefaultCurrency)
+    }
+    t.Setenv("CURRENCY", "eur")
+    if got := StoreCurrency(); got != "EUR" {
+        t.Errorf("StoreCurrency() = %q, want EUR", got)
+    }
+}
@@ -0,0 +1,76 @@
+package serviceauth
+
+import (
+    "context"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+func TestVerified(t *testing.T) {
+    tests := []struct {
+        name  string
+        md    metadata.MD
+        token string
+        want  bool
+    }{
+        {name: "token", md: metadata.Pairs(TokenMetadataKey, "secret"), token: "secret", want: true},
+        {name: "wrong token", md: metadata.Pairs(TokenMetadataKey, "guess"), token: "secret"},
+        {name: "no token", md: metadata.Pairs("x-user-role", "3"), token: "secret"},
+        {name: "two tokens", md: metadata.Pairs(TokenMetadataKey, "guess", TokenMetadataKey, "secret"), token: "secret"},
+        {name: "unconfigured", md: metadata.Pairs(TokenMetadataKey, ""), token: ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+            if got := Verified(ctx, tt.token); got != tt.want {
+                t.Errorf("Verified = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIsSystem(t *testing.T) {
+    system := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TokenMetadataKey, "secret", SystemMetadataKey, "order-service"))
+    if !IsSystem(system, "secret") {
+        t.Error("IsSystem with the token and the marker = false, want true")
+    }
+    forged := metadata.NewIncomingContext(context.Background(), metadata.Pairs(SystemMetadataKey, "order-service"))
+    if IsSystem(forged, "secret") {
+        t.Error("IsSystem without the token = true, want false")
+    }
+    user := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TokenMetadataKey, "secret", "x-user-id", "10"))
+    if IsSystem(user, "secret") {
+        t.Error("IsSystem for a user = true, want false")
+    }
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+    intercept := UnaryServerInterceptor("secret")
+    handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+    ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TokenMetadataKey, "secret"))
+    if res, err := intercept(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil || res != "ok" {
+        t.Errorf("with the token = %v, %v, want ok", res, err)
+    }
+    if _, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{}, handler); status.Code(err) != codes.Unauthenticated {
+        t.Errorf("without the token error = %v, want %v", err, codes.Unauthenticated)
+    }
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+    var sent metadata.MD
+    invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+        sent, _ = metadata.FromOutgoingContext(ctx)
+        return nil
+    }
+    if err := UnaryClientInterceptor("secret")(context.Background(), "/m", nil, nil, nil, invoker); err != nil {
+        t.Fatal(err)
+    }
+    if got := sent.Get(TokenMetadataKey); len(got) != 1 || got[0] != "secret" {
+        t.Errorf("sent token = %v, want secret", got)
+    }
+}
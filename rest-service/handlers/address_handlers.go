@@ -0,0 +1,130 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/user"
+)
+
+// addressOwner is the authenticated user, whose address book the request works on
+func addressOwner(c *gin.Context) (int64, bool) {
+    userID, exists := c.Get("userID")
+    if !exists {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+        return 0, false
+    }
+    id, ok := userID.(uint)
+    if !ok {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+        return 0, false
+    }
+    return int64(id), true
+}
+
+// ListAddresses lists the user's address book, defaults first
+func (h *UserHandler) ListAddresses(c *gin.Context) {
+    userID, ok := addressOwner(c)
+    if !ok {
+        return
+    }
+
+    resp, err := h.UserService.ListAddresses(withCaller(c), &pb.ListAddressesRequest{UserId: userID})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+// CreateAddress adds an address to the user's address book. Which fields are
+// required depends on the country.
+func (h *UserHandler) CreateAddress(c *gin.Context) {
+    userID, ok := addressOwner(c)
+    if !ok {
+        return
+    }
+
+    var address pb.Address
+    if err := c.ShouldBindJSON(&address); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.UserService.CreateAddress(withCaller(c), &pb.CreateAddressRequest{UserId: userID, Address: &address})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *UserHandler) GetAddress(c *gin.Context) {
+    userID, ok := addressOwner(c)
+    if !ok {
+        return
+    }
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+        return
+    }
+
+    resp, err := h.UserService.GetAddress(withCaller(c), &pb.GetAddressRequest{UserId: userID, Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+// UpdateAddress replaces an address; orders already placed keep theirs
+func (h *UserHandler) UpdateAddress(c *gin.Context) {
+    userID, ok := addressOwner(c)
+    if !ok {
+        return
+    }
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+        return
+    }
+
+    var address pb.Address
+    if err := c.ShouldBindJSON(&address); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.UserService.UpdateAddress(withCaller(c), &pb.UpdateAddressRequest{UserId: userID, Id: id, Address: &address})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *UserHandler) DeleteAddress(c *gin.Context) {
+    userID, ok := addressOwner(c)
+    if !ok {
+        return
+    }
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+        return
+    }
+
+    resp, err := h.UserService.DeleteAddress(withCaller(c), &pb.DeleteAddressRequest{UserId: userID, Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
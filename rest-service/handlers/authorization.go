@@ -0,0 +1,27 @@
+package handlers
+
+import (
+    "github.com/gin-gonic/gin"
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+)
+
+// roleAdmin is the role allowed to see and manage every order
+const roleAdmin = 3
+
+// isAdmin reports whether the authenticated user has the admin role
+func isAdmin(c *gin.Context) bool {
+    role, _ := c.Get("role")
+    r, ok := role.(int)
+    return ok && r == roleAdmin
+}
+
+// canViewOrder reports whether the authenticated user may see the order:
+// customers only their own orders, admins every order
+func canViewOrder(c *gin.Context, order *orderpb.Order) bool {
+    if isAdmin(c) {
+        return true
+    }
+    userID, _ := c.Get("userID")
+    id, ok := userID.(uint)
+    return ok && order != nil && order.CustomerId == int64(id)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+    "context"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "google.golang.org/grpc/metadata"
+)
+
+// withCaller forwards the authenticated user, role and customer group to
+// backend services as gRPC metadata, so they can attribute and authorize the
+// request and price it for the user
+func withCaller(c *gin.Context) context.Context {
+    ctx := context.Context(c)
+    if userID, ok := c.Get("userID"); ok {
+        if id, ok := userID.(uint); ok {
+            ctx = metadata.AppendToOutgoingContext(ctx, "x-user-id", strconv.FormatUint(uint64(id), 10))
+        }
+    }
+    if role, ok := c.Get("role"); ok {
+        if r, ok := role.(int); ok {
+            ctx = metadata.AppendToOutgoingContext(ctx, "x-user-role", strconv.Itoa(r))
+        }
+    }
+    if group, ok := c.Get("customerGroup"); ok {
+        if g, ok := group.(string); ok && g != "" {
+            ctx = metadata.AppendToOutgoingContext(ctx, "x-customer-group", g)
+        }
+    }
+    return ctx
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+    "errors"
+    "io"
+    "net/http"
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/cart" // Replace with the correct import path
+    orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "rest-service/services"      // Adjust the import path based on your project structure
+    "strconv"
+)
+
+// CartTokenHeader carries the token of an anonymous cart in requests and responses
+const CartTokenHeader = "X-Cart-Token"
+
+type CartHandler struct {
+    CartService  *services.CartService
+    OrderService *services.OrderService
+}
+
+// cartKey identifies the caller's cart: by user when authenticated, otherwise by cart token
+func cartKey(c *gin.Context) *pb.CartKey {
+    if userID, exists := c.Get("userID"); exists {
+        if id, ok := userID.(uint); ok {
+            return &pb.CartKey{UserId: int64(id)}
+        }
+    }
+    return &pb.CartKey{CartToken: c.GetHeader(CartTokenHeader)}
+}
+
+// respondWithCart writes the cart and hands anonymous clients their cart token
+func respondWithCart(c *gin.Context, resp *pb.CartResponse) {
+    if token := resp.GetCart().GetCartToken(); token != "" {
+        c.Header(CartTokenHeader, token)
+    }
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *CartHandler) GetCart(c *gin.Context) {
+    req := pb.GetCartRequest{Key: cartKey(c)}
+
+    resp, err := h.CartService.GetCart(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithCart(c, resp)
+}
+
+func (h *CartHandler) AddItem(c *gin.Context) {
+    var req pb.AddItemRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.Key = cartKey(c)
+
+    resp, err := h.CartService.AddItem(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithCart(c, resp)
+}
+
+func (h *CartHandler) UpdateItem(c *gin.Context) {
+    // Extract the product ID from the URL parameter
+    id, err := strconv.ParseInt(c.Param("productId"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+        return
+    }
+
+    var req pb.UpdateItemRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.Key = cartKey(c)
+    req.ProductId = id
+
+    resp, err := h.CartService.UpdateItem(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithCart(c, resp)
+}
+
+func (h *CartHandler) RemoveItem(c *gin.Context) {
+    // Extract the product ID from the URL parameter
+    id, err := strconv.ParseInt(c.Param("productId"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+        return
+    }
+
+    // Items of products with variants are removed per variant, e.g. ?variantId=3
+    variantID, ok := queryInt(c, "variantId", 64)
+    if !ok {
+        return
+    }
+
+    req := pb.RemoveItemRequest{Key: cartKey(c), ProductId: id, VariantId: variantID}
+    resp, err := h.CartService.RemoveItem(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithCart(c, resp)
+}
+
+func (h *CartHandler) ClearCart(c *gin.Context) {
+    req := pb.ClearCartRequest{Key: cartKey(c)}
+
+    resp, err := h.CartService.ClearCart(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithCart(c, resp)
+}
+
+// ApplyCoupon previews a coupon on the cart and keeps it for checkout. The
+// response has the cart and the preview, priced in ?currency= like the order.
+func (h *CartHandler) ApplyCoupon(c *gin.Context) {
+    var req pb.ApplyCouponRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.Key = cartKey(c)
+
+    cart, err := h.CartService.GetCart(withCaller(c), &pb.GetCartRequest{Key: req.Key})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    // Only coupons that apply to the cart are kept
+    previewReq := orderpb.PreviewPromotionRequest{
+        CustomerId: req.Key.UserId,
+        Currency:   c.Query("currency"),
+        CouponCode: req.CouponCode,
+    }
+    for _, item := range cart.GetCart().GetItems() {
+        previewReq.Items = append(previewReq.Items, &orderpb.OrderItem{ProductId: item.ProductId, VariantId: item.VariantId, Quantity: item.Quantity})
+    }
+    preview, err := h.OrderService.PreviewPromotion(withCaller(c), &previewReq)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    req.Key = &pb.CartKey{UserId: cart.GetCart().GetUserId(), CartToken: cart.GetCart().GetCartToken()}
+    resp, err := h.CartService.ApplyCoupon(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    if token := resp.GetCart().GetCartToken(); token != "" {
+        c.Header(CartTokenHeader, token)
+    }
+    c.JSON(http.StatusOK, gin.H{"cart": resp.Cart, "preview": preview})
+}
+
+func (h *CartHandler) RemoveCoupon(c *gin.Context) {
+    req := pb.ApplyCouponRequest{Key: cartKey(c)}
+
+    resp, err := h.CartService.ApplyCoupon(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithCart(c, resp)
+}
+
+// QuoteShipping lists what each shipping method charges for the cart, cheapest
+// first, priced in ?currency= for the destination in ?country=, ?region= and
+// ?postalCode=, or to the address book entry in ?shippingAddressId=; signed in
+// customers without either get quotes for their default shipping address. The
+// cart's coupon counts, as it will at checkout.
+func (h *CartHandler) QuoteShipping(c *gin.Context) {
+    cart, err := h.CartService.GetCart(withCaller(c), &pb.GetCartRequest{Key: cartKey(c)})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+    if len(cart.GetCart().GetItems()) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+        return
+    }
+
+    req := orderpb.QuoteShippingRequest{
+        CustomerId: cart.GetCart().GetUserId(),
+        Currency:   c.Query("currency"),
+        CouponCode: cart.GetCart().GetCouponCode(),
+    }
+    if addressID := c.Query("shippingAddressId"); addressID != "" {
+        id, err := strconv.ParseInt(addressID, 10, 64)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shippingAddressId"})
+            return
+        }
+        req.ShippingAddressId = id
+    }
+    if country := c.Query("country"); country != "" {
+        req.Destination = &orderpb.Destination{Country: country, Region: c.Query("region"), PostalCode: c.Query("postalCode")}
+    }
+    for _, item := range cart.GetCart().GetItems() {
+        req.Items = append(req.Items, &orderpb.OrderItem{ProductId: item.ProductId, VariantId: item.VariantId, Quantity: item.Quantity})
+    }
+    resp, err := h.OrderService.QuoteShipping(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    if token := cart.GetCart().GetCartToken(); token != "" {
+        c.Header(CartTokenHeader, token)
+    }
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *CartHandler) Checkout(c *gin.Context) {
+    userID, exists := c.Get("userID")
+    if !exists {
+        // userID not found in the context, handle this scenario
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+        return
+    }
+    id, ok := userID.(uint)
+    if !ok {
+        // userID is not of the expected type, handle this scenario
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+        return
+    }
+
+    // The body optionally gives the destination the order is taxed and shipped
+    // for, or the shippingAddressId and billingAddressId from the address book,
+    // and the shippingMethodId of a quote
+    var req pb.CheckoutRequest
+    if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    // The order is priced in ?currency=, the store currency by default
+    req.UserId = int64(id)
+    req.Currency = c.Query("currency")
+    resp, err := h.CartService.Checkout(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
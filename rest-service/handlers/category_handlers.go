@@ -0,0 +1,109 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+)
+
+func (h *ProductHandler) ListCategories(c *gin.Context) {
+    resp, err := h.ProductService.ListCategories(withCaller(c), &pb.ListCategoriesRequest{})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) GetCategory(c *gin.Context) {
+    // Categories are looked up by slug, e.g. /category/shoes
+    req := pb.GetCategoryRequest{Slug: c.Param("slug")}
+    resp, err := h.ProductService.GetCategory(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) CreateCategory(c *gin.Context) {
+    var req pb.CreateCategoryRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.ProductService.CreateCategory(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) UpdateCategory(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+        return
+    }
+
+    var req pb.UpdateCategoryRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.Id = id
+
+    resp, err := h.ProductService.UpdateCategory(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) DeleteCategory(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+        return
+    }
+
+    resp, err := h.ProductService.DeleteCategory(withCaller(c), &pb.DeleteCategoryRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) SetProductCategories(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+        return
+    }
+
+    var req pb.SetProductCategoriesRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.ProductId = id
+
+    resp, err := h.ProductService.SetProductCategories(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
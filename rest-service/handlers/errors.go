@@ -0,0 +1,31 @@
+package handlers
+
+import (
+    "net/http"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// httpStatusFromError maps the gRPC status code of a backend error to an HTTP status
+func httpStatusFromError(err error) int {
+    switch status.Code(err) {
+    case codes.InvalidArgument, codes.OutOfRange:
+        return http.StatusBadRequest
+    case codes.Unauthenticated:
+        return http.StatusUnauthorized
+    case codes.PermissionDenied:
+        return http.StatusForbidden
+    case codes.NotFound:
+        return http.StatusNotFound
+    case codes.AlreadyExists, codes.Aborted, codes.FailedPrecondition:
+        return http.StatusConflict
+    case codes.ResourceExhausted:
+        return http.StatusTooManyRequests
+    case codes.Unavailable:
+        return http.StatusServiceUnavailable
+    case codes.DeadlineExceeded:
+        return http.StatusGatewayTimeout
+    default:
+        return http.StatusInternalServerError
+    }
+}
@@ -35,9 +35,10 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
     }
     req.CustomerId = int64(id)
 
-    resp, err := h.OrderService.CreateOrder(c, &req)
+    // The order is priced for the customer's group and may redeem a couponCode
+    resp, err := h.OrderService.CreateOrder(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
 
@@ -59,9 +60,15 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
     req := pb.GetOrderRequest{OrderId: id}
 
     // Call the ProductService with the context and request
-    resp, err := h.OrderService.GetOrder(c, &req)
+    resp, err := h.OrderService.GetOrder(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    // Someone else's order is reported as missing so order IDs can't be enumerated
+    if !canViewOrder(c, resp.Order) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
         return
     }
 
@@ -86,9 +93,9 @@ func (h *OrderHandler) UpdateOrder(c *gin.Context) {
         return
     }
     req.OrderId = id
-    resp, err := h.OrderService.UpdateOrder(c, &req)
+    resp, err := h.OrderService.UpdateOrder(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
 
@@ -112,16 +119,65 @@ func (h *OrderHandler) ListOrders(c *gin.Context) {
         return
     }
 
+    pageSize, ok := queryInt(c, "pageSize", 32)
+    if !ok {
+        return
+    }
+
     // Create a GetProductRequest with the product ID
-    req := pb.ListOrdersRequest{CustomerId: int64(id)}
+    req := pb.ListOrdersRequest{CustomerId: int64(id), PageSize: int32(pageSize), PageToken: c.Query("pageToken")}
 
     // Call the ProductService with the context and request
-    resp, err := h.OrderService.ListOrders(c, &req)
+    resp, err := h.OrderService.ListOrders(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
+    setNextLink(c, resp.NextPageToken)
 
     // Respond with the product details
     c.JSON(http.StatusOK, resp)
 }
+
+func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        return
+    }
+
+    req := pb.GetOrderHistoryRequest{OrderId: id}
+    resp, err := h.OrderService.GetOrderHistory(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        return
+    }
+
+    // The body is optional and only carries the reason
+    var req pb.CancelOrderRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    req.OrderId = id
+
+    resp, err := h.OrderService.CancelOrder(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
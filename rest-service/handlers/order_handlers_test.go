@@ -0,0 +1,173 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "rest-service/services"
+)
+
+// fakeOrderClient serves orders from memory and records the metadata of the last call
+type fakeOrderClient struct {
+    pb.OrderServiceClient
+    orders   map[int64]*pb.Order
+    metadata metadata.MD
+    listReq  *pb.ListOrdersRequest
+    nextPage string
+}
+
+func (f *fakeOrderClient) GetOrder(ctx context.Context, req *pb.GetOrderRequest, opts ...grpc.CallOption) (*pb.OrderResponse, error) {
+    f.metadata, _ = metadata.FromOutgoingContext(ctx)
+    order, ok := f.orders[req.OrderId]
+    if !ok {
+        return nil, status.Errorf(codes.NotFound, "Order with ID '%d' not found", req.OrderId)
+    }
+    return &pb.OrderResponse{Order: order}, nil
+}
+
+func (f *fakeOrderClient) ListOrders(ctx context.Context, req *pb.ListOrdersRequest, opts ...grpc.CallOption) (*pb.ListOrdersResponse, error) {
+    f.metadata, _ = metadata.FromOutgoingContext(ctx)
+    f.listReq = req
+    resp := &pb.ListOrdersResponse{NextPageToken: f.nextPage}
+    for _, order := range f.orders {
+        if order.CustomerId == req.CustomerId {
+            resp.Orders = append(resp.Orders, order)
+        }
+    }
+    return resp, nil
+}
+
+func newOrderTestRouter(client *fakeOrderClient, userID uint, role int) *gin.Engine {
+    gin.SetMode(gin.TestMode)
+    handler := OrderHandler{OrderService: services.NewOrderService(client)}
+
+    router := gin.New()
+    // Stands in for AuthMiddleware, which puts the JWT claims in the context
+    router.Use(func(c *gin.Context) {
+        c.Set("userID", userID)
+        c.Set("role", role)
+        c.Next()
+    })
+    router.GET("/order/:id", handler.GetOrder)
+    router.GET("/orders", handler.ListOrders)
+    return router
+}
+
+func newFakeOrderClient() *fakeOrderClient {
+    return &fakeOrderClient{orders: map[int64]*pb.Order{
+        1: {Id: 1, CustomerId: 10, Status: pb.OrderStatus_PENDING},
+        2: {Id: 2, CustomerId: 20, Status: pb.OrderStatus_SHIPPED},
+    }}
+}
+
+func TestGetOrderAuthorization(t *testing.T) {
+    tests := []struct {
+        name   string
+        userID uint
+        role   int
+        path   string
+        want   int
+    }{
+        {"owner sees own order", 10, 1, "/order/1", http.StatusOK},
+        {"customer cannot see another customer's order", 10, 1, "/order/2", http.StatusNotFound},
+        {"missing order looks the same as a denied one", 10, 1, "/order/3", http.StatusNotFound},
+        {"admin sees any order", 99, roleAdmin, "/order/2", http.StatusOK},
+        {"invalid id", 10, 1, "/order/abc", http.StatusBadRequest},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            router := newOrderTestRouter(newFakeOrderClient(), tt.userID, tt.role)
+            rec := httptest.NewRecorder()
+            router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+            if rec.Code != tt.want {
+                t.Fatalf("GET %s: got status %d, want %d (body %s)", tt.path, rec.Code, tt.want, rec.Body.String())
+            }
+        })
+    }
+}
+
+func TestGetOrderForwardsCaller(t *testing.T) {
+    client := newFakeOrderClient()
+    router := newOrderTestRouter(client, 10, 1)
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/order/1", nil))
+
+    if got := client.metadata.Get("x-user-id"); len(got) != 1 || got[0] != "10" {
+        t.Errorf("x-user-id metadata = %v, want [10]", got)
+    }
+    if got := client.metadata.Get("x-user-role"); len(got) != 1 || got[0] != "1" {
+        t.Errorf("x-user-role metadata = %v, want [1]", got)
+    }
+}
+
+func TestListOrdersOnlyReturnsOwnOrders(t *testing.T) {
+    client := newFakeOrderClient()
+    router := newOrderTestRouter(client, 20, 1)
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+    if rec.Code != http.StatusOK {
+        t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+    }
+    if client.listReq.CustomerId != 20 {
+        t.Errorf("listed orders of customer %d, want 20", client.listReq.CustomerId)
+    }
+
+    var resp pb.ListOrdersResponse
+    if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decoding response: %v", err)
+    }
+    for _, order := range resp.Orders {
+        if order.CustomerId != 20 {
+            t.Errorf("response contains order %d of customer %d", order.Id, order.CustomerId)
+        }
+    }
+}
+
+func TestListOrdersPagination(t *testing.T) {
+    client := newFakeOrderClient()
+    client.nextPage = "abc"
+    router := newOrderTestRouter(client, 10, 1)
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders?pageSize=5&pageToken=xyz", nil))
+
+    if rec.Code != http.StatusOK {
+        t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+    }
+    if client.listReq.PageSize != 5 || client.listReq.PageToken != "xyz" {
+        t.Errorf("listed page size %d token %q, want 5 and xyz", client.listReq.PageSize, client.listReq.PageToken)
+    }
+    want := `</orders?pageSize=5&pageToken=abc>; rel="next"`
+    if got := rec.Header().Get("Link"); got != want {
+        t.Errorf("Link = %s, want %s", got, want)
+    }
+
+    // The last page has no next link
+    client.nextPage = ""
+    rec = httptest.NewRecorder()
+    router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders?pageToken=abc", nil))
+    if got := rec.Header().Get("Link"); got != "" {
+        t.Errorf("Link on the last page = %s, want none", got)
+    }
+}
+
+func TestListOrdersInvalidPageSize(t *testing.T) {
+    router := newOrderTestRouter(newFakeOrderClient(), 10, 1)
+    rec := httptest.NewRecorder()
+    router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders?pageSize=many", nil))
+
+    if rec.Code != http.StatusBadRequest {
+        t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+    }
+}
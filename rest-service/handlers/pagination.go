@@ -0,0 +1,22 @@
+package handlers
+
+import (
+    "fmt"
+
+    "github.com/gin-gonic/gin"
+)
+
+// setNextLink points the Link header at the next page of a listing: the
+// request's own URL with pageToken swapped in. The deprecated page number is
+// dropped, as the token already says where the next page starts.
+func setNextLink(c *gin.Context, nextPageToken string) {
+    if nextPageToken == "" {
+        return
+    }
+    next := *c.Request.URL
+    query := next.Query()
+    query.Del("page")
+    query.Set("pageToken", nextPageToken)
+    next.RawQuery = query.Encode()
+    c.Header("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
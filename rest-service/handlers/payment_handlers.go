@@ -0,0 +1,165 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    "rest-service/services"
+)
+
+type PaymentHandler struct {
+    PaymentService *services.PaymentService
+}
+
+// AuthorizePayment pays for an order with a payment token. A declined
+// payment is answered with 402 and the payment, which has the reason.
+func (h *PaymentHandler) AuthorizePayment(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        return
+    }
+
+    var req pb.AuthorizePaymentRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.OrderId = id
+
+    resp, err := h.PaymentService.AuthorizePayment(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithPayment(c, resp)
+}
+
+func (h *PaymentHandler) ListPayments(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        return
+    }
+
+    resp, err := h.PaymentService.ListPayments(withCaller(c), &pb.ListPaymentsRequest{OrderId: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *PaymentHandler) GetPayment(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+        return
+    }
+
+    resp, err := h.PaymentService.GetPayment(withCaller(c), &pb.GetPaymentRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+// ConfirmPayment completes a payment after the customer's 3-D Secure challenge
+func (h *PaymentHandler) ConfirmPayment(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+        return
+    }
+
+    resp, err := h.PaymentService.ConfirmPayment(withCaller(c), &pb.ConfirmPaymentRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    respondWithPayment(c, resp)
+}
+
+func (h *PaymentHandler) CapturePayment(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+        return
+    }
+
+    // The body is optional and only carries a partial amount
+    var req pb.CapturePaymentRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    req.Id = id
+
+    resp, err := h.PaymentService.CapturePayment(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *PaymentHandler) VoidPayment(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+        return
+    }
+
+    resp, err := h.PaymentService.VoidPayment(withCaller(c), &pb.VoidPaymentRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *PaymentHandler) RefundPayment(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+        return
+    }
+
+    // The body is optional and carries a partial amount and the reason
+    var req pb.RefundPaymentRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    req.Id = id
+
+    resp, err := h.PaymentService.RefundPayment(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+// respondWithPayment answers with the payment, as 402 when it was declined
+func respondWithPayment(c *gin.Context, resp *pb.PaymentResponse) {
+    if resp.GetPayment().GetStatus() == pb.PaymentStatus_DECLINED {
+        c.JSON(http.StatusPaymentRequired, resp)
+        return
+    }
+    c.JSON(http.StatusOK, resp)
+}
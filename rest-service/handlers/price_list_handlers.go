@@ -0,0 +1,114 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+)
+
+func (h *ProductHandler) ListPriceLists(c *gin.Context) {
+    resp, err := h.ProductService.ListPriceLists(withCaller(c), &pb.ListPriceListsRequest{})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) GetPriceList(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price list ID"})
+        return
+    }
+
+    resp, err := h.ProductService.GetPriceList(withCaller(c), &pb.GetPriceListRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) CreatePriceList(c *gin.Context) {
+    var req pb.CreatePriceListRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.ProductService.CreatePriceList(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) UpdatePriceList(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price list ID"})
+        return
+    }
+
+    var req pb.UpdatePriceListRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.Id = id
+
+    resp, err := h.ProductService.UpdatePriceList(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) DeletePriceList(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price list ID"})
+        return
+    }
+
+    resp, err := h.ProductService.DeletePriceList(withCaller(c), &pb.DeletePriceListRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) SetPriceListPrices(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price list ID"})
+        return
+    }
+
+    // The prices replace every price on the list, amounts in minor units of its currency
+    var req pb.SetPriceListPricesRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.PriceListId = id
+
+    resp, err := h.ProductService.SetPriceListPrices(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
@@ -2,10 +2,14 @@ package handlers
 
 import (
     "net/http"
+    "github.com/atullal/ecommerce-backend-protobuf/money"
     "github.com/gin-gonic/gin"
     pb "github.com/atullal/ecommerce-backend-protobuf/product" // Replace with the correct import path
     "rest-service/services"      // Adjust the import path based on your project structure
+    "net/url"
+    "sort"
     "strconv"
+    "strings"
 )
 
 type ProductHandler struct {
@@ -19,9 +23,9 @@ func (h *ProductHandler) AddProduct(c *gin.Context) {
         return
     }
 
-    resp, err := h.ProductService.AddProduct(c, &req)
+    resp, err := h.ProductService.AddProduct(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
 
@@ -39,13 +43,14 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
         return
     }
 
-    // Create a GetProductRequest with the product ID
-    req := pb.GetProductRequest{Id: id}
+    // Create a GetProductRequest with the product ID, priced in ?currency=
+    req := pb.GetProductRequest{Id: id, Currency: c.Query("currency")}
 
-    // Call the ProductService with the context and request
-    resp, err := h.ProductService.GetProduct(c, &req)
+    // Call the ProductService with the context and request; signed in
+    // customers see the prices of their customer group
+    resp, err := h.ProductService.GetProduct(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
 
@@ -70,9 +75,9 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
         return
     }
     req.Id = id
-    resp, err := h.ProductService.UpdateProduct(c, &req)
+    resp, err := h.ProductService.UpdateProduct(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
 
@@ -94,7 +99,7 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
     req := pb.DeleteProductRequest{Id: id}
 
     // Call the ProductService with the context and request
-    resp, err := h.ProductService.DeleteProduct(c, &req)
+    resp, err := h.ProductService.DeleteProduct(withCaller(c), &req)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
@@ -117,19 +122,59 @@ func (h *ProductHandler) ListProducts(c *gin.Context) {
         PageSize:     int32(pageSize),
         SearchKeyword: searchKeyword,
         Categories:   categories,
-    }
-
-    // Call the ProductService with the context and request
-    resp, err := h.ProductService.ListProducts(c, &req)
+        Sort:         c.Query("sort"),
+        PageToken:    c.Query("pageToken"),
+        Currency:     c.Query("currency"),
+    }
+
+    // Optional filters: minPrice, maxPrice, inStock and attr.<option>=<value>.
+    // Prices are decimals such as 19.99 in currency, the store currency by default
+    currency := c.DefaultQuery("currency", money.StoreCurrency())
+    for name, target := range map[string]**pb.Money{"minPrice": &req.MinPrice, "maxPrice": &req.MaxPrice} {
+        if value := c.Query(name); value != "" {
+            parsed, err := money.Parse(value, currency)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ": " + err.Error()})
+                return
+            }
+            *target = &pb.Money{Amount: parsed.Amount, Currency: parsed.Currency}
+        }
+    }
+    if value := c.Query("inStock"); value != "" {
+        inStock, err := strconv.ParseBool(value)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid inStock"})
+            return
+        }
+        req.InStock = inStock
+    }
+    req.Attributes = attributeFilters(c.Request.URL.Query())
+
+    // Call the ProductService with the context and request, priced for the caller
+    resp, err := h.ProductService.ListProducts(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
+    setNextLink(c, resp.NextPageToken)
 
     // Respond with the product details
     c.JSON(http.StatusOK, resp)
 }
 
+// attributeFilters reads variant option filters such as ?attr.size=M&attr.size=L&attr.color=red,
+// sorted by option name
+func attributeFilters(query url.Values) []*pb.AttributeFilter {
+    var filters []*pb.AttributeFilter
+    for key, values := range query {
+        if name := strings.TrimPrefix(key, "attr."); name != key && name != "" {
+            filters = append(filters, &pb.AttributeFilter{Name: name, Values: values})
+        }
+    }
+    sort.Slice(filters, func(i, j int) bool { return filters[i].Name < filters[j].Name })
+    return filters
+}
+
 func (h *ProductHandler) UpdateInventory(c *gin.Context) {
 	// Extract the product ID from the URL parameter
     productID := c.Param("id") // Assuming the URL is something like /products/:id
@@ -147,9 +192,9 @@ func (h *ProductHandler) UpdateInventory(c *gin.Context) {
         return
     }
     req.ProductId = id
-    resp, err := h.ProductService.UpdateInventory(c, &req)
+    resp, err := h.ProductService.UpdateInventory(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
 
@@ -170,10 +215,27 @@ func (h *ProductHandler) GetInventory(c *gin.Context) {
     // Create a GetProductRequest with the product ID
     req := pb.GetInventoryRequest{ProductId: id}
 
+    // Optionally limit the stock levels to one warehouse
+    if warehouseID := c.Query("warehouseId"); warehouseID != "" {
+        req.WarehouseId, err = strconv.ParseInt(warehouseID, 10, 64)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse ID"})
+            return
+        }
+    }
+    // ... or to one variant
+    if variantID := c.Query("variantId"); variantID != "" {
+        req.VariantId, err = strconv.ParseInt(variantID, 10, 64)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
+            return
+        }
+    }
+
     // Call the ProductService with the context and request
-    resp, err := h.ProductService.GetInventory(c, &req)
+    resp, err := h.ProductService.GetInventory(withCaller(c), &req)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
         return
     }
 
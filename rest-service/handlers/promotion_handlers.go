@@ -0,0 +1,91 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+)
+
+func (h *OrderHandler) ListPromotions(c *gin.Context) {
+    resp, err := h.OrderService.ListPromotions(withCaller(c), &pb.ListPromotionsRequest{})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) GetPromotion(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+        return
+    }
+
+    resp, err := h.OrderService.GetPromotion(withCaller(c), &pb.GetPromotionRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) CreatePromotion(c *gin.Context) {
+    // The body is the promotion itself
+    var promotion pb.Promotion
+    if err := c.ShouldBindJSON(&promotion); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.OrderService.CreatePromotion(withCaller(c), &pb.CreatePromotionRequest{Promotion: &promotion})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) UpdatePromotion(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+        return
+    }
+
+    // The body replaces every setting of the promotion
+    var promotion pb.Promotion
+    if err := c.ShouldBindJSON(&promotion); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.OrderService.UpdatePromotion(withCaller(c), &pb.UpdatePromotionRequest{Id: id, Promotion: &promotion})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) DeletePromotion(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+        return
+    }
+
+    resp, err := h.OrderService.DeletePromotion(withCaller(c), &pb.DeletePromotionRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
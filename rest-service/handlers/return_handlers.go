@@ -0,0 +1,162 @@
+package handlers
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+)
+
+// returnIDs reads the order and return IDs of a return route
+func returnIDs(c *gin.Context) (orderID, returnID int64, ok bool) {
+    orderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        return 0, 0, false
+    }
+    returnID, err = strconv.ParseInt(c.Param("returnId"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+        return 0, 0, false
+    }
+    return orderID, returnID, true
+}
+
+func (h *OrderHandler) RequestReturn(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        return
+    }
+
+    var req pb.RequestReturnRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.OrderId = id
+
+    resp, err := h.OrderService.RequestReturn(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) ListReturns(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        return
+    }
+
+    resp, err := h.OrderService.ListReturns(withCaller(c), &pb.ListReturnsRequest{OrderId: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) GetReturn(c *gin.Context) {
+    orderID, returnID, ok := returnIDs(c)
+    if !ok {
+        return
+    }
+
+    resp, err := h.OrderService.GetReturn(withCaller(c), &pb.GetReturnRequest{OrderId: orderID, Id: returnID})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) ApproveReturn(c *gin.Context) {
+    h.decideReturn(c, h.OrderService.ApproveReturn)
+}
+
+func (h *OrderHandler) RejectReturn(c *gin.Context) {
+    h.decideReturn(c, h.OrderService.RejectReturn)
+}
+
+// decideReturn approves or rejects a return; the body is optional and only carries the reason
+func (h *OrderHandler) decideReturn(c *gin.Context, decide func(context.Context, *pb.ReturnDecisionRequest) (*pb.OrderReturnResponse, error)) {
+    orderID, returnID, ok := returnIDs(c)
+    if !ok {
+        return
+    }
+
+    var req pb.ReturnDecisionRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    req.OrderId, req.Id = orderID, returnID
+
+    resp, err := decide(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) ReceiveReturn(c *gin.Context) {
+    orderID, returnID, ok := returnIDs(c)
+    if !ok {
+        return
+    }
+
+    // The body is optional; without it everything requested was received
+    var req pb.ReceiveReturnRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    req.OrderId, req.Id = orderID, returnID
+
+    resp, err := h.OrderService.ReceiveReturn(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) InspectReturn(c *gin.Context) {
+    orderID, returnID, ok := returnIDs(c)
+    if !ok {
+        return
+    }
+
+    // The body is optional; without it everything received is refunded in full
+    var req pb.InspectReturnRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    req.OrderId, req.Id = orderID, returnID
+
+    resp, err := h.OrderService.InspectReturn(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
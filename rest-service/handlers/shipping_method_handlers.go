@@ -0,0 +1,75 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+)
+
+func (h *OrderHandler) ListShippingMethods(c *gin.Context) {
+    resp, err := h.OrderService.ListShippingMethods(withCaller(c), &pb.ListShippingMethodsRequest{})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) CreateShippingMethod(c *gin.Context) {
+    // The body is the shipping method itself, with its rates
+    var method pb.ShippingMethod
+    if err := c.ShouldBindJSON(&method); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.OrderService.CreateShippingMethod(withCaller(c), &pb.CreateShippingMethodRequest{ShippingMethod: &method})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) UpdateShippingMethod(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+        return
+    }
+
+    // The body replaces every setting of the method and all of its rates
+    var method pb.ShippingMethod
+    if err := c.ShouldBindJSON(&method); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.OrderService.UpdateShippingMethod(withCaller(c), &pb.UpdateShippingMethodRequest{Id: id, ShippingMethod: &method})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) DeleteShippingMethod(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+        return
+    }
+
+    resp, err := h.OrderService.DeleteShippingMethod(withCaller(c), &pb.DeleteShippingMethodRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/product"
+)
+
+func (h *ProductHandler) ListStockMovements(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+        return
+    }
+
+    // Optional query parameters: warehouseId, variantId, page and pageSize
+    req := pb.ListStockMovementsRequest{ProductId: id}
+    warehouseID, ok := queryInt(c, "warehouseId", 64)
+    if !ok {
+        return
+    }
+    variantID, ok := queryInt(c, "variantId", 64)
+    if !ok {
+        return
+    }
+    page, ok := queryInt(c, "page", 32)
+    if !ok {
+        return
+    }
+    pageSize, ok := queryInt(c, "pageSize", 32)
+    if !ok {
+        return
+    }
+    req.WarehouseId, req.VariantId, req.Page, req.PageSize = warehouseID, variantID, int32(page), int32(pageSize)
+
+    resp, err := h.ProductService.ListStockMovements(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) RebuildInventory(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+        return
+    }
+
+    // The body is optional; {"apply": true} overwrites the stock with the ledger
+    var req pb.RebuildInventoryRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    req.ProductId = id
+
+    resp, err := h.ProductService.RebuildInventory(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+// queryInt parses an optional integer query parameter, zero when absent.
+// On a malformed value it responds with 400 and returns false.
+func queryInt(c *gin.Context, name string, bitSize int) (int64, bool) {
+    value := c.Query(name)
+    if value == "" {
+        return 0, true
+    }
+    parsed, err := strconv.ParseInt(value, 10, bitSize)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+        return 0, false
+    }
+    return parsed, true
+}
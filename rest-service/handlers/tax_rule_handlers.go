@@ -0,0 +1,75 @@
+package handlers
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    pb "github.com/atullal/ecommerce-backend-protobuf/order"
+)
+
+func (h *OrderHandler) ListTaxRules(c *gin.Context) {
+    resp, err := h.OrderService.ListTaxRules(withCaller(c), &pb.ListTaxRulesRequest{Country: c.Query("country")})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) CreateTaxRule(c *gin.Context) {
+    // The body is the tax rule itself
+    var rule pb.TaxRule
+    if err := c.ShouldBindJSON(&rule); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.OrderService.CreateTaxRule(withCaller(c), &pb.CreateTaxRuleRequest{TaxRule: &rule})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) UpdateTaxRule(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rule ID"})
+        return
+    }
+
+    // The body replaces every setting of the rule
+    var rule pb.TaxRule
+    if err := c.ShouldBindJSON(&rule); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    resp, err := h.OrderService.UpdateTaxRule(withCaller(c), &pb.UpdateTaxRuleRequest{Id: id, TaxRule: &rule})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *OrderHandler) DeleteTaxRule(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rule ID"})
+        return
+    }
+
+    resp, err := h.OrderService.DeleteTaxRule(withCaller(c), &pb.DeleteTaxRuleRequest{Id: id})
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, resp)
+}
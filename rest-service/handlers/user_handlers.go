@@ -1,14 +1,35 @@
 package handlers
 
 import (
+    "log"
     "net/http"
     "github.com/gin-gonic/gin"
     pb "github.com/atullal/ecommerce-backend-protobuf/user" // Replace with the correct import path
+    cartpb "github.com/atullal/ecommerce-backend-protobuf/cart"
     "rest-service/services"      // Adjust the import path based on your project structure
+    "strconv"
 )
 
 type UserHandler struct {
     UserService *services.UserService
+    CartService *services.CartService
+}
+
+// mergeAnonymousCart moves the anonymous cart sent with the request into the
+// user's cart. Failing to merge must not fail the login, so errors are only logged.
+func (h *UserHandler) mergeAnonymousCart(c *gin.Context, resp *pb.UserResponse) {
+    token := c.GetHeader(CartTokenHeader)
+    if token == "" || h.CartService == nil {
+        return
+    }
+    userID, err := strconv.ParseInt(resp.Id, 10, 64)
+    if err != nil {
+        log.Printf("failed to merge cart: invalid user ID %q", resp.Id)
+        return
+    }
+    if _, err := h.CartService.MergeCarts(withCaller(c), &cartpb.MergeCartsRequest{CartToken: token, UserId: userID}); err != nil {
+        log.Printf("failed to merge cart for user %d: %v", userID, err)
+    }
 }
 
 func (h *UserHandler) CreateUser(c *gin.Context) {
@@ -18,11 +39,12 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
         return
     }
 
-    resp, err := h.UserService.CreateUser(c, &req)
+    resp, err := h.UserService.CreateUser(withCaller(c), &req)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    h.mergeAnonymousCart(c, resp)
 
     c.JSON(http.StatusOK, resp)
 }
@@ -34,11 +56,37 @@ func (h *UserHandler) AuthenticateUser(c *gin.Context) {
         return
     }
 
-    resp, err := h.UserService.AuthenticateUser(c, &req)
+    resp, err := h.UserService.AuthenticateUser(withCaller(c), &req)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    h.mergeAnonymousCart(c, resp)
+
+    c.JSON(http.StatusOK, resp)
+}
+
+func (h *UserHandler) SetCustomerGroup(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+
+    // The group decides which price lists apply to the user from their next login;
+    // an empty group puts them back on the prices for everyone
+    var req pb.SetCustomerGroupRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    req.UserId = id
+
+    resp, err := h.UserService.SetCustomerGroup(withCaller(c), &req)
+    if err != nil {
+        c.JSON(httpStatusFromError(err), gin.H{"error": err.Error()})
+        return
+    }
 
     c.JSON(http.StatusOK, resp)
 }
@@ -6,11 +6,17 @@ import (
     userpb "github.com/atullal/ecommerce-backend-protobuf/user"
     productpb "github.com/atullal/ecommerce-backend-protobuf/product"
     orderpb "github.com/atullal/ecommerce-backend-protobuf/order"
+    cartpb "github.com/atullal/ecommerce-backend-protobuf/cart"
+    paymentpb "github.com/atullal/ecommerce-backend-protobuf/payment"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
     "rest-service/handlers"
     "rest-service/services"
     "rest-service/middlewares"
     "google.golang.org/grpc"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
     "fmt"
+    "os"
 )
 
 type server struct {
@@ -18,27 +24,73 @@ type server struct {
 	UserServiceConnection *grpc.ClientConn
 	ProductServiceConnection *grpc.ClientConn
 	OrderServiceConnection *grpc.ClientConn
+	CartService *services.CartService
+	OrderService *services.OrderService
+	Idempotency gin.HandlerFunc
+	ServiceToken string // Sent with every backend call, which the services require
 }
 
 func (s *server) AddUserRoutes(userHandler handlers.UserHandler) {
 	// Set up routes
     s.RestServer.POST("/user", userHandler.CreateUser)
     s.RestServer.POST("/user/authenticate", userHandler.AuthenticateUser)
+
+    // Users manage their own address book
+    authenticated := s.RestServer.Group("/")
+    authenticated.Use(middleware.AuthMiddleware())
+    {
+        authenticated.GET("/user/addresses", userHandler.ListAddresses)
+        authenticated.POST("/user/addresses", s.Idempotency, userHandler.CreateAddress)
+        authenticated.GET("/user/addresses/:id", userHandler.GetAddress)
+        authenticated.PUT("/user/addresses/:id", userHandler.UpdateAddress)
+        authenticated.DELETE("/user/addresses/:id", userHandler.DeleteAddress)
+    }
+
+    admin := s.RestServer.Group("/")
+    admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+    {
+        admin.PUT("/user/:id/customer-group", userHandler.SetCustomerGroup)
+    }
 }
 
 func (s *server) AddProductRoutes(productHandler handlers.ProductHandler) {
     // Set up product-related routes
-    s.RestServer.GET("/products", productHandler.ListProducts)
-    s.RestServer.GET("/product/:id", productHandler.GetProduct)
+    // Signed in customers see the prices of their customer group
+    catalog := s.RestServer.Group("/")
+    catalog.Use(middleware.OptionalAuthMiddleware())
+    {
+        catalog.GET("/products", productHandler.ListProducts)
+        catalog.GET("/product/:id", productHandler.GetProduct)
+    }
+    s.RestServer.GET("/categories", productHandler.ListCategories)
+    s.RestServer.GET("/category/:slug", productHandler.GetCategory)
     // Add any other product routes here
     admin := s.RestServer.Group("/")
     admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
     {
         admin.DELETE("/product/:id", productHandler.DeleteProduct)
-        admin.POST("/product", productHandler.AddProduct)
-        admin.PUT("/product/:id", productHandler.UpdateProduct)
-        admin.PUT("/product/inventory/:id", productHandler.UpdateInventory)
+        admin.POST("/product", s.Idempotency, productHandler.AddProduct)
+        admin.PUT("/product/:id", s.Idempotency, productHandler.UpdateProduct)
+        admin.PUT("/product/inventory/:id", s.Idempotency, productHandler.UpdateInventory)
         admin.GET("/product/inventory/:id", productHandler.GetInventory)
+        admin.GET("/product/inventory/:id/movements", productHandler.ListStockMovements)
+        admin.POST("/product/inventory/:id/rebuild", productHandler.RebuildInventory)
+        admin.PUT("/product/:id/categories", productHandler.SetProductCategories)
+        admin.PUT("/product/:id/options", productHandler.SetProductOptions)
+        admin.POST("/product/:id/variant", s.Idempotency, productHandler.AddVariant)
+        admin.PUT("/variant/:id", productHandler.UpdateVariant)
+        admin.DELETE("/variant/:id", productHandler.DeleteVariant)
+        admin.POST("/category", s.Idempotency, productHandler.CreateCategory)
+        admin.PUT("/category/:id", productHandler.UpdateCategory)
+        admin.DELETE("/category/:id", productHandler.DeleteCategory)
+        admin.POST("/warehouse", s.Idempotency, productHandler.AddWarehouse)
+        admin.GET("/warehouses", productHandler.ListWarehouses)
+        admin.POST("/price-list", s.Idempotency, productHandler.CreatePriceList)
+        admin.GET("/price-lists", productHandler.ListPriceLists)
+        admin.GET("/price-list/:id", productHandler.GetPriceList)
+        admin.PUT("/price-list/:id", productHandler.UpdatePriceList)
+        admin.DELETE("/price-list/:id", productHandler.DeletePriceList)
+        admin.PUT("/price-list/:id/prices", productHandler.SetPriceListPrices)
     }
 }
 
@@ -47,9 +99,15 @@ func (s *server) AddOrderRoutes(orderHandler handlers.OrderHandler) {
     authenticated := s.RestServer.Group("/")
     authenticated.Use(middleware.AuthMiddleware())
     {
-        authenticated.POST("/order", orderHandler.CreateOrder)
+        authenticated.POST("/order", s.Idempotency, orderHandler.CreateOrder)
         authenticated.GET("/order/:id", orderHandler.GetOrder)
+        authenticated.GET("/order/:id/history", orderHandler.GetOrderHistory)
+        authenticated.POST("/order/:id/cancel", s.Idempotency, orderHandler.CancelOrder)
         authenticated.GET("/orders", orderHandler.ListOrders)
+        authenticated.POST("/order/:id/returns", s.Idempotency, orderHandler.RequestReturn)
+        authenticated.GET("/order/:id/returns", orderHandler.ListReturns)
+        authenticated.GET("/order/:id/returns/:returnId", orderHandler.GetReturn)
+        authenticated.GET("/order/:id/shipments", orderHandler.ListShipments)
     }
 
     // Add any other product routes here
@@ -57,13 +115,76 @@ func (s *server) AddOrderRoutes(orderHandler handlers.OrderHandler) {
     admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
     {
         admin.PUT("/order/:id", orderHandler.UpdateOrder)
+        admin.POST("/order/:id/returns/:returnId/approve", orderHandler.ApproveReturn)
+        admin.POST("/order/:id/returns/:returnId/reject", orderHandler.RejectReturn)
+        admin.POST("/order/:id/returns/:returnId/receive", s.Idempotency, orderHandler.ReceiveReturn)
+        admin.POST("/order/:id/returns/:returnId/inspect", s.Idempotency, orderHandler.InspectReturn)
+        admin.POST("/order/:id/shipments", s.Idempotency, orderHandler.CreateShipment)
+        admin.POST("/order/:id/shipments/:shipmentId/deliver", orderHandler.DeliverShipment)
+        admin.POST("/promotion", s.Idempotency, orderHandler.CreatePromotion)
+        admin.GET("/promotions", orderHandler.ListPromotions)
+        admin.GET("/promotion/:id", orderHandler.GetPromotion)
+        admin.PUT("/promotion/:id", orderHandler.UpdatePromotion)
+        admin.DELETE("/promotion/:id", orderHandler.DeletePromotion)
+        admin.POST("/tax-rule", s.Idempotency, orderHandler.CreateTaxRule)
+        admin.GET("/tax-rules", orderHandler.ListTaxRules)
+        admin.PUT("/tax-rule/:id", orderHandler.UpdateTaxRule)
+        admin.DELETE("/tax-rule/:id", orderHandler.DeleteTaxRule)
+        admin.POST("/shipping-method", s.Idempotency, orderHandler.CreateShippingMethod)
+        admin.GET("/shipping-methods", orderHandler.ListShippingMethods)
+        admin.PUT("/shipping-method/:id", orderHandler.UpdateShippingMethod)
+        admin.DELETE("/shipping-method/:id", orderHandler.DeleteShippingMethod)
+    }
+}
+
+func (s *server) AddPaymentRoutes(paymentHandler handlers.PaymentHandler) {
+    // Customers pay for their own orders and complete 3-D Secure
+    authenticated := s.RestServer.Group("/")
+    authenticated.Use(middleware.AuthMiddleware())
+    {
+        authenticated.POST("/order/:id/payment", s.Idempotency, paymentHandler.AuthorizePayment)
+        authenticated.GET("/order/:id/payments", paymentHandler.ListPayments)
+        authenticated.GET("/payment/:id", paymentHandler.GetPayment)
+        authenticated.POST("/payment/:id/confirm", s.Idempotency, paymentHandler.ConfirmPayment)
+    }
+
+    // Moving money is up to admins
+    admin := s.RestServer.Group("/")
+    admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+    {
+        admin.POST("/payment/:id/capture", s.Idempotency, paymentHandler.CapturePayment)
+        admin.POST("/payment/:id/void", s.Idempotency, paymentHandler.VoidPayment)
+        admin.POST("/payment/:id/refund", s.Idempotency, paymentHandler.RefundPayment)
+    }
+}
+
+func (s *server) AddCartRoutes(cartHandler handlers.CartHandler) {
+    // Carts work for anonymous users too, identified by the X-Cart-Token header
+    cart := s.RestServer.Group("/cart")
+    cart.Use(middleware.OptionalAuthMiddleware())
+    {
+        cart.GET("", cartHandler.GetCart)
+        cart.DELETE("", cartHandler.ClearCart)
+        cart.POST("/items", cartHandler.AddItem)
+        cart.PUT("/items/:productId", cartHandler.UpdateItem)
+        cart.DELETE("/items/:productId", cartHandler.RemoveItem)
+        cart.POST("/apply-coupon", cartHandler.ApplyCoupon)
+        cart.DELETE("/coupon", cartHandler.RemoveCoupon)
+        cart.GET("/shipping-rates", cartHandler.QuoteShipping)
+    }
+
+    // Checking out creates an order, so it needs an authenticated user
+    authenticated := s.RestServer.Group("/cart")
+    authenticated.Use(middleware.AuthMiddleware())
+    {
+        authenticated.POST("/checkout", s.Idempotency, cartHandler.Checkout)
     }
 }
 
 // initializeUserComponents sets up everything related to user handling
 func (s *server) InitializeUserComponents() {
     // Set up a connection to the gRPC server.
-    userServiceConnection, err := grpc.Dial("0.0.0.0:50051", grpc.WithInsecure())
+    userServiceConnection, err := grpc.Dial(serviceAddress("USER_SERVICE_ADDR", "0.0.0.0:50051"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(s.ServiceToken)))
     if err != nil {
         log.Fatalf("Failed to connect to gRPC server: %v", err)
     }
@@ -71,7 +192,7 @@ func (s *server) InitializeUserComponents() {
 
     // Initialize the UserService and UserHandler
     userService := services.NewUserService(userpb.NewUserServiceClient(userServiceConnection))
-    userHandler := handlers.UserHandler{UserService: userService}
+    userHandler := handlers.UserHandler{UserService: userService, CartService: s.CartService}
 
     s.AddUserRoutes(userHandler)
 }
@@ -79,7 +200,7 @@ func (s *server) InitializeUserComponents() {
 // initializeUserComponents sets up everything related to user handling
 func (s *server) InitializeProductComponents() {
     // Set up a connection to the gRPC server.
-    productServiceConnection, err := grpc.Dial("0.0.0.0:50052", grpc.WithInsecure())
+    productServiceConnection, err := grpc.Dial(serviceAddress("PRODUCT_SERVICE_ADDR", "0.0.0.0:50052"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(s.ServiceToken)))
     if err != nil {
         log.Fatalf("Failed to connect to gRPC server: %v", err)
     }
@@ -95,18 +216,48 @@ func (s *server) InitializeProductComponents() {
 // initializeUserComponents sets up everything related to order handling
 func (s *server) InitializeOrderComponents() {
     // Set up a connection to the gRPC server.
-    orderServiceConnection, err := grpc.Dial("0.0.0.0:50053", grpc.WithInsecure())
+    orderServiceConnection, err := grpc.Dial(serviceAddress("ORDER_SERVICE_ADDR", "0.0.0.0:50053"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(s.ServiceToken)))
     if err != nil {
         log.Fatalf("Failed to connect to gRPC server: %v", err)
     }
     fmt.Println("Connected to gRPC server")
 
-    orderService := services.NewOrderService(orderpb.NewOrderServiceClient(orderServiceConnection))
-    orderHandler := handlers.OrderHandler{OrderService: orderService}
+    s.OrderService = services.NewOrderService(orderpb.NewOrderServiceClient(orderServiceConnection))
+    orderHandler := handlers.OrderHandler{OrderService: s.OrderService}
 
     s.AddOrderRoutes(orderHandler)
 }
 
+// InitializeCartComponents sets up everything related to cart handling
+func (s *server) InitializeCartComponents() {
+    // Set up a connection to the gRPC server.
+    cartServiceConnection, err := grpc.Dial(serviceAddress("CART_SERVICE_ADDR", "0.0.0.0:50054"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(s.ServiceToken)))
+    if err != nil {
+        log.Fatalf("Failed to connect to gRPC server: %v", err)
+    }
+    fmt.Println("Connected to gRPC server")
+
+    s.CartService = services.NewCartService(cartpb.NewCartServiceClient(cartServiceConnection))
+    cartHandler := handlers.CartHandler{CartService: s.CartService, OrderService: s.OrderService}
+
+    s.AddCartRoutes(cartHandler)
+}
+
+// InitializePaymentComponents sets up everything related to payment handling
+func (s *server) InitializePaymentComponents() {
+    // Set up a connection to the gRPC server.
+    paymentServiceConnection, err := grpc.Dial(serviceAddress("PAYMENT_SERVICE_ADDR", "0.0.0.0:50055"), grpc.WithInsecure(), grpc.WithUnaryInterceptor(serviceauth.UnaryClientInterceptor(s.ServiceToken)))
+    if err != nil {
+        log.Fatalf("Failed to connect to gRPC server: %v", err)
+    }
+    fmt.Println("Connected to gRPC server")
+
+    paymentService := services.NewPaymentService(paymentpb.NewPaymentServiceClient(paymentServiceConnection))
+    paymentHandler := handlers.PaymentHandler{PaymentService: paymentService}
+
+    s.AddPaymentRoutes(paymentHandler)
+}
+
 func (s *server) InitializeRestService() {
 	// Set up routes
 	s.RestServer.GET("/health", func(c *gin.Context) {
@@ -122,6 +273,15 @@ func (s *server) Close() {
 	s.RestServer = nil
 }
 
+// serviceAddress returns the address of another service from the environment
+// variable env, e.g. PRODUCT_SERVICE_ADDR=product-service:50052, or fallback
+func serviceAddress(env, fallback string) string {
+    if address := os.Getenv(env); address != "" {
+        return address
+    }
+    return fallback
+}
+
 func main() {
 	s := server{}
 	defer s.Close()
@@ -130,14 +290,39 @@ func main() {
     // Initialize rest components
     s.InitializeRestService()
 
+    // Backend services only accept calls carrying the shared service token
+    serviceToken, err := serviceauth.TokenFromEnv()
+    if err != nil {
+        log.Fatalf("Failed to configure service authentication: %v", err)
+    }
+    s.ServiceToken = serviceToken
+
+    // Retried mutating requests carrying an Idempotency-Key replay the first response
+    // in the shared database, so every instance sees the same keys
+    db, err := gorm.Open(postgres.Open(os.Getenv("DSN")), &gorm.Config{})
+    if err != nil {
+        log.Fatalf("failed to connect database: %v", err)
+    }
+    idempotencyStore, err := middleware.NewIdempotencyStoreFromEnv(db)
+    if err != nil {
+        log.Fatalf("Failed to configure idempotency: %v", err)
+    }
+    s.Idempotency = middleware.IdempotencyMiddleware(idempotencyStore)
+
+    // Initialize order-related components before carts, which preview coupons
+    s.InitializeOrderComponents()
+
+    // Initialize cart-related components before users, which merge carts on login
+    s.InitializeCartComponents()
+
     // Initialize user-related components
     s.InitializeUserComponents()
 
     // Initialize product-related components
     s.InitializeProductComponents()
 
-    // Initialize order-related components
-    s.InitializeOrderComponents()
+    // Initialize payment-related components
+    s.InitializePaymentComponents()
 
     // Start the server
     if err := s.RestServer.Run(); err != nil {
@@ -19,15 +19,16 @@ func AuthMiddleware() gin.HandlerFunc {
             return
         }
 
-        userID, role, err := jwt.ValidateToken(tokenString)
+        claims, err := jwt.ParseToken(tokenString)
         if err != nil {
             c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
             return
         }
 
         // Store user ID in context for further use in handlers
-        c.Set("userID", userID)
-        c.Set("role", role)
+        c.Set("userID", claims.UserID)
+        c.Set("role", claims.Role)
+        c.Set("customerGroup", claims.CustomerGroup)
         c.Next()
     }
 }
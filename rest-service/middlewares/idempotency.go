@@ -0,0 +1,231 @@
+package middleware
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// IdempotencyKeyHeader is the header clients set to make a request safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// defaultIdempotencyTTL is how long responses are kept when IDEMPOTENCY_TTL is not set
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a key stays claimed by a request that has not
+// finished, so a key held by an instance that died can be used again
+const idempotencyLease = 5 * time.Minute
+
+// idempotencyEntry is the stored outcome of a request made with an idempotency key
+type idempotencyEntry struct {
+    requestHash string
+    done        bool // False while the first request is still being handled
+    status      int
+    contentType string
+    body        []byte
+}
+
+// IdempotencyStore keeps the responses of requests made with an idempotency key
+type IdempotencyStore interface {
+    // begin claims the key for a request. It returns the stored entry when the
+    // key was used before; a nil entry means the caller should handle the request.
+    begin(key, requestHash string) (*idempotencyEntry, bool, error)
+    // complete stores the response for a claimed key
+    complete(key string, status int, contentType string, body []byte) error
+    // release forgets a claimed key so the request can be retried
+    release(key string) error
+}
+
+// IdempotencyKey is a row of the idempotency_keys table
+type IdempotencyKey struct {
+    Key         string `gorm:"primaryKey"` // User, method, route and the client's key
+    RequestHash string `gorm:"not null"`
+    Done        bool   `gorm:"not null;default:false"`
+    Status      int
+    ContentType string
+    Body        []byte
+    ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+// dbIdempotencyStore keeps responses in Postgres, so every instance of the
+// service sees the same keys
+type dbIdempotencyStore struct {
+    db  *gorm.DB
+    ttl time.Duration
+}
+
+// NewIdempotencyStore creates a store that keeps responses in db for ttl
+func NewIdempotencyStore(db *gorm.DB, ttl time.Duration) (IdempotencyStore, error) {
+    if err := db.AutoMigrate(&IdempotencyKey{}); err != nil {
+        return nil, fmt.Errorf("failed to migrate idempotency keys: %v", err)
+    }
+    return &dbIdempotencyStore{db: db, ttl: ttl}, nil
+}
+
+// NewIdempotencyStoreFromEnv creates a store in db whose window is read from
+// IDEMPOTENCY_TTL as a Go duration, e.g. "24h"
+func NewIdempotencyStoreFromEnv(db *gorm.DB) (IdempotencyStore, error) {
+    ttl := defaultIdempotencyTTL
+    if value := os.Getenv("IDEMPOTENCY_TTL"); value != "" {
+        parsed, err := time.ParseDuration(value)
+        if err != nil || parsed <= 0 {
+            return nil, fmt.Errorf("invalid IDEMPOTENCY_TTL %q", value)
+        }
+        ttl = parsed
+    }
+    return NewIdempotencyStore(db, ttl)
+}
+
+func (s *dbIdempotencyStore) begin(key, requestHash string) (*idempotencyEntry, bool, error) {
+    now := time.Now()
+    if err := s.db.Where("expires_at < ?", now).Delete(&IdempotencyKey{}).Error; err != nil {
+        return nil, false, err
+    }
+
+    // Claim the key, taking over a claim whose window has passed
+    claim := IdempotencyKey{Key: key, RequestHash: requestHash, ExpiresAt: now.Add(idempotencyLease)}
+    result := s.db.Clauses(clause.OnConflict{
+        Columns: []clause.Column{{Name: "key"}},
+        DoUpdates: clause.Assignments(map[string]interface{}{
+            "request_hash": requestHash,
+            "done":         false,
+            "status":       0,
+            "content_type": "",
+            "body":         nil,
+            "expires_at":   claim.ExpiresAt,
+        }),
+        Where: clause.Where{Exprs: []clause.Expression{clause.Lt{Column: clause.Column{Table: "idempotency_keys", Name: "expires_at"}, Value: now}}},
+    }).Create(&claim)
+    if result.Error != nil {
+        return nil, false, result.Error
+    }
+    if result.RowsAffected == 1 {
+        return nil, false, nil
+    }
+
+    var existing IdempotencyKey
+    if err := s.db.First(&existing, "key = ?", key).Error; err != nil {
+        return nil, false, err
+    }
+    return &idempotencyEntry{
+        requestHash: existing.RequestHash,
+        done:        existing.Done,
+        status:      existing.Status,
+        contentType: existing.ContentType,
+        body:        existing.Body,
+    }, true, nil
+}
+
+func (s *dbIdempotencyStore) complete(key string, status int, contentType string, body []byte) error {
+    return s.db.Model(&IdempotencyKey{}).Where("key = ?", key).Updates(map[string]interface{}{
+        "done":         true,
+        "status":       status,
+        "content_type": contentType,
+        "body":         body,
+        "expires_at":   time.Now().Add(s.ttl),
+    }).Error
+}
+
+func (s *dbIdempotencyStore) release(key string) error {
+    return s.db.Where("key = ?", key).Delete(&IdempotencyKey{}).Error
+}
+
+// responseRecorder captures the response body while it is written to the client
+type responseRecorder struct {
+    gin.ResponseWriter
+    body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+    w.body.Write(data)
+    return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+    w.body.WriteString(data)
+    return w.ResponseWriter.WriteString(data)
+}
+
+// IdempotencyMiddleware replays the stored response when a request is retried
+// with the same Idempotency-Key. Keys are scoped to the authenticated user and
+// route; reusing a key with a different body is rejected with 422, and a retry
+// that arrives while the first request is still running gets 409. Server errors
+// are not stored, so such requests can be retried with the same key.
+func IdempotencyMiddleware(store IdempotencyStore) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        key := c.GetHeader(IdempotencyKeyHeader)
+        if key == "" {
+            c.Next()
+            return
+        }
+
+        body, err := io.ReadAll(c.Request.Body)
+        if err != nil {
+            c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Could not read request body"})
+            return
+        }
+        c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+        userID, _ := c.Get("userID")
+        scopedKey := fmt.Sprintf("%v|%s|%s|%s", userID, c.Request.Method, c.FullPath(), key)
+        hash := sha256.Sum256(append([]byte(c.Request.URL.Path+"\n"), body...))
+        requestHash := hex.EncodeToString(hash[:])
+
+        entry, found, err := store.begin(scopedKey, requestHash)
+        if err != nil {
+            log.Printf("Failed to claim idempotency key: %v", err)
+            c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Could not check Idempotency-Key, please retry"})
+            return
+        }
+        if found {
+            switch {
+            case entry.requestHash != requestHash:
+                c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+            case !entry.done:
+                c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+            default:
+                c.Header("Idempotent-Replayed", "true")
+                c.Data(entry.status, entry.contentType, entry.body)
+                c.Abort()
+            }
+            return
+        }
+
+        recorder := &responseRecorder{ResponseWriter: c.Writer}
+        c.Writer = recorder
+        defer func() {
+            // A handler that panics has no response to replay
+            if r := recover(); r != nil {
+                releaseIdempotencyKey(store, scopedKey)
+                panic(r)
+            }
+        }()
+        c.Next()
+
+        if status := recorder.Status(); status >= http.StatusInternalServerError {
+            releaseIdempotencyKey(store, scopedKey)
+        } else if err := store.complete(scopedKey, status, recorder.Header().Get("Content-Type"), recorder.body.Bytes()); err != nil {
+            // Without a stored response a retry would be told the request is still running
+            log.Printf("Failed to store response for idempotency key: %v", err)
+            releaseIdempotencyKey(store, scopedKey)
+        }
+    }
+}
+
+// releaseIdempotencyKey forgets a claimed key; a key that can't be released
+// stays claimed until its lease runs out
+func releaseIdempotencyKey(store IdempotencyStore, key string) {
+    if err := store.release(key); err != nil {
+        log.Printf("Failed to release idempotency key: %v", err)
+    }
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// memoryIdempotencyStore keeps entries in a map, standing in for the database
+type memoryIdempotencyStore struct {
+    mu      sync.Mutex
+    entries map[string]*idempotencyEntry
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+    return &memoryIdempotencyStore{entries: make(map[string]*idempotencyEntry)}
+}
+
+func (s *memoryIdempotencyStore) begin(key, requestHash string) (*idempotencyEntry, bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if entry, ok := s.entries[key]; ok {
+        copied := *entry
+        return &copied, true, nil
+    }
+    s.entries[key] = &idempotencyEntry{requestHash: requestHash}
+    return nil, false, nil
+}
+
+func (s *memoryIdempotencyStore) complete(key string, status int, contentType string, body []byte) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if entry, ok := s.entries[key]; ok {
+        entry.done = true
+        entry.status = status
+        entry.contentType = contentType
+        entry.body = body
+    }
+    return nil
+}
+
+func (s *memoryIdempotencyStore) release(key string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.entries, key)
+    return nil
+}
+
+// idempotentRouter serves POST /orders behind the middleware, answering with
+// the given status and counting how often the handler ran
+func idempotentRouter(store IdempotencyStore, status *int, calls *int) *gin.Engine {
+    gin.SetMode(gin.TestMode)
+    router := gin.New()
+    router.POST("/orders", IdempotencyMiddleware(store), func(c *gin.Context) {
+        *calls++
+        c.JSON(*status, gin.H{"call": *calls})
+    })
+    return router
+}
+
+func send(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+    if key != "" {
+        req.Header.Set(IdempotencyKeyHeader, key)
+    }
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder, req)
+    return recorder
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+    tests := []struct {
+        name       string
+        status     int
+        key        string
+        retryBody  string
+        wantStatus int
+        wantBody   string
+        wantCalls  int
+        replayed   bool
+    }{
+        {name: "replays the first response", status: http.StatusCreated, key: "k1", retryBody: `{"sku":"A"}`, wantStatus: http.StatusCreated, wantBody: `{"call":1}`, wantCalls: 1, replayed: true},
+        {name: "replays client errors", status: http.StatusBadRequest, key: "k1", retryBody: `{"sku":"A"}`, wantStatus: http.StatusBadRequest, wantBody: `{"call":1}`, wantCalls: 1, replayed: true},
+        {name: "rejects a different payload", status: http.StatusCreated, key: "k1", retryBody: `{"sku":"B"}`, wantStatus: http.StatusUnprocessableEntity, wantCalls: 1},
+        {name: "does not store server errors", status: http.StatusInternalServerError, key: "k1", retryBody: `{"sku":"A"}`, wantStatus: http.StatusInternalServerError, wantBody: `{"call":2}`, wantCalls: 2},
+        {name: "ignores requests without a key", status: http.StatusCreated, retryBody: `{"sku":"A"}`, wantStatus: http.StatusCreated, wantBody: `{"call":2}`, wantCalls: 2},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            status, calls := tt.status, 0
+            router := idempotentRouter(newMemoryIdempotencyStore(), &status, &calls)
+
+            send(router, tt.key, `{"sku":"A"}`)
+            got := send(router, tt.key, tt.retryBody)
+
+            if got.Code != tt.wantStatus {
+                t.Errorf("retry status = %d, want %d", got.Code, tt.wantStatus)
+            }
+            if tt.wantBody != "" && got.Body.String() != tt.wantBody {
+                t.Errorf("retry body = %s, want %s", got.Body.String(), tt.wantBody)
+            }
+            if calls != tt.wantCalls {
+                t.Errorf("handler ran %d times, want %d", calls, tt.wantCalls)
+            }
+            if replayed := got.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+                t.Errorf("Idempotent-Replayed = %v, want %v", replayed, tt.replayed)
+            }
+        })
+    }
+}
+
+func TestIdempotencyMiddlewareInFlight(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    store := newMemoryIdempotencyStore()
+    started, finish := make(chan struct{}), make(chan struct{})
+    router := gin.New()
+    router.POST("/orders", IdempotencyMiddleware(store), func(c *gin.Context) {
+        close(started)
+        <-finish
+        c.JSON(http.StatusCreated, gin.H{"id": 1})
+    })
+
+    done := make(chan *httptest.ResponseRecorder)
+    go func() { done <- send(router, "k1", `{}`) }()
+    <-started
+
+    if got := send(router, "k1", `{}`); got.Code != http.StatusConflict {
+        t.Errorf("retry while running: status = %d, want %d", got.Code, http.StatusConflict)
+    }
+    close(finish)
+    if got := <-done; got.Code != http.StatusCreated {
+        t.Fatalf("first request: status = %d, want %d", got.Code, http.StatusCreated)
+    }
+    if got := send(router, "k1", `{}`); got.Code != http.StatusCreated || got.Header().Get("Idempotent-Replayed") != "true" {
+        t.Errorf("retry after finishing: status = %d, replayed = %q", got.Code, got.Header().Get("Idempotent-Replayed"))
+    }
+}
+
+func TestIdempotencyMiddlewarePanic(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    store := newMemoryIdempotencyStore()
+    router := gin.New()
+    router.Use(gin.Recovery())
+    router.POST("/orders", IdempotencyMiddleware(store), func(c *gin.Context) {
+        panic("handler failed")
+    })
+
+    send(router, "k1", `{}`)
+    if len(store.entries) != 0 {
+        t.Errorf("key of a panicking request was kept: %v", store.entries)
+    }
+}
+
+// TestDBIdempotencyStore runs against the Postgres database named by
+// TEST_DATABASE_URL and is skipped without one
+func TestDBIdempotencyStore(t *testing.T) {
+    dsn := os.Getenv("TEST_DATABASE_URL")
+    if dsn == "" {
+        t.Skip("TEST_DATABASE_URL not set")
+    }
+    db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+    if err != nil {
+        t.Fatalf("failed to connect database: %v", err)
+    }
+    store, err := NewIdempotencyStore(db, time.Hour)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := "test|" + time.Now().Format(time.RFC3339Nano)
+    t.Cleanup(func() { store.release(key) })
+
+    if _, found, err := store.begin(key, "hash"); err != nil || found {
+        t.Fatalf("first claim: found = %v, err = %v", found, err)
+    }
+    entry, found, err := store.begin(key, "hash")
+    if err != nil || !found || entry.done {
+        t.Fatalf("claim while running: entry = %+v, found = %v, err = %v", entry, found, err)
+    }
+
+    if err := store.complete(key, http.StatusCreated, "application/json", []byte(`{"id":1}`)); err != nil {
+        t.Fatal(err)
+    }
+    entry, found, err = store.begin(key, "hash")
+    if err != nil || !found || !entry.done || entry.status != http.StatusCreated || string(entry.body) != `{"id":1}` {
+        t.Fatalf("claim after completing: entry = %+v, found = %v, err = %v", entry, found, err)
+    }
+
+    // A claim whose window has passed is taken over
+    if err := db.Model(&IdempotencyKey{}).Where("key = ?", key).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+        t.Fatal(err)
+    }
+    if _, found, err := store.begin(key, "other"); err != nil || found {
+        t.Fatalf("claim after expiry: found = %v, err = %v", found, err)
+    }
+
+    if err := store.release(key); err != nil {
+        t.Fatal(err)
+    }
+    if _, found, err := store.begin(key, "hash"); err != nil || found {
+        t.Fatalf("claim after release: found = %v, err = %v", found, err)
+    }
+}
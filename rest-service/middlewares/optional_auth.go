@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"strings"
+    "net/http"
+    "github.com/gin-gonic/gin"
+    "rest-service/utils" // Replace with your actual jwt package path
+)
+
+// OptionalAuthMiddleware identifies the user when a JWT token is sent, but
+// also lets anonymous requests through. An invalid token is still rejected.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        tokenString := c.GetHeader("Authorization")
+        // Assuming the token is in the format "Bearer <token>"
+        tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+        if tokenString == "" {
+            c.Next()
+            return
+        }
+
+        claims, err := jwt.ParseToken(tokenString)
+        if err != nil {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+            return
+        }
+
+        // Store user ID in context for further use in handlers
+        c.Set("userID", claims.UserID)
+        c.Set("role", claims.Role)
+        c.Set("customerGroup", claims.CustomerGroup)
+        c.Next()
+    }
+}
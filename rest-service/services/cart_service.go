@@ -0,0 +1,48 @@
+package services
+
+import (
+    "context"
+    pb "github.com/atullal/ecommerce-backend-protobuf/cart" // Replace with the correct import path
+)
+
+type CartService struct {
+    GrpcClient pb.CartServiceClient
+}
+
+func NewCartService(client pb.CartServiceClient) *CartService {
+    return &CartService{
+        GrpcClient: client,
+    }
+}
+
+func (s *CartService) GetCart(ctx context.Context, req *pb.GetCartRequest) (*pb.CartResponse, error) {
+    return s.GrpcClient.GetCart(ctx, req)
+}
+
+func (s *CartService) AddItem(ctx context.Context, req *pb.AddItemRequest) (*pb.CartResponse, error) {
+    return s.GrpcClient.AddItem(ctx, req)
+}
+
+func (s *CartService) UpdateItem(ctx context.Context, req *pb.UpdateItemRequest) (*pb.CartResponse, error) {
+    return s.GrpcClient.UpdateItem(ctx, req)
+}
+
+func (s *CartService) RemoveItem(ctx context.Context, req *pb.RemoveItemRequest) (*pb.CartResponse, error) {
+    return s.GrpcClient.RemoveItem(ctx, req)
+}
+
+func (s *CartService) ClearCart(ctx context.Context, req *pb.ClearCartRequest) (*pb.CartResponse, error) {
+    return s.GrpcClient.ClearCart(ctx, req)
+}
+
+func (s *CartService) MergeCarts(ctx context.Context, req *pb.MergeCartsRequest) (*pb.CartResponse, error) {
+    return s.GrpcClient.MergeCarts(ctx, req)
+}
+
+func (s *CartService) Checkout(ctx context.Context, req *pb.CheckoutRequest) (*pb.CheckoutResponse, error) {
+    return s.GrpcClient.Checkout(ctx, req)
+}
+
+func (s *CartService) ApplyCoupon(ctx context.Context, req *pb.ApplyCouponRequest) (*pb.CartResponse, error) {
+    return s.GrpcClient.ApplyCoupon(ctx, req)
+}
@@ -30,3 +30,111 @@ func (s *OrderService) UpdateOrder(ctx context.Context, req *pb.UpdateOrderReque
 func (s *OrderService) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
     return s.GrpcClient.ListOrders(ctx, req)
 }
+
+func (s *OrderService) GetOrderHistory(ctx context.Context, req *pb.GetOrderHistoryRequest) (*pb.OrderHistoryResponse, error) {
+    return s.GrpcClient.GetOrderHistory(ctx, req)
+}
+
+func (s *OrderService) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.OrderResponse, error) {
+    return s.GrpcClient.CancelOrder(ctx, req)
+}
+
+func (s *OrderService) CreatePromotion(ctx context.Context, req *pb.CreatePromotionRequest) (*pb.PromotionResponse, error) {
+    return s.GrpcClient.CreatePromotion(ctx, req)
+}
+
+func (s *OrderService) GetPromotion(ctx context.Context, req *pb.GetPromotionRequest) (*pb.PromotionResponse, error) {
+    return s.GrpcClient.GetPromotion(ctx, req)
+}
+
+func (s *OrderService) UpdatePromotion(ctx context.Context, req *pb.UpdatePromotionRequest) (*pb.PromotionResponse, error) {
+    return s.GrpcClient.UpdatePromotion(ctx, req)
+}
+
+func (s *OrderService) DeletePromotion(ctx context.Context, req *pb.DeletePromotionRequest) (*pb.DeletePromotionResponse, error) {
+    return s.GrpcClient.DeletePromotion(ctx, req)
+}
+
+func (s *OrderService) ListPromotions(ctx context.Context, req *pb.ListPromotionsRequest) (*pb.ListPromotionsResponse, error) {
+    return s.GrpcClient.ListPromotions(ctx, req)
+}
+
+func (s *OrderService) PreviewPromotion(ctx context.Context, req *pb.PreviewPromotionRequest) (*pb.PreviewPromotionResponse, error) {
+    return s.GrpcClient.PreviewPromotion(ctx, req)
+}
+
+func (s *OrderService) CreateTaxRule(ctx context.Context, req *pb.CreateTaxRuleRequest) (*pb.TaxRuleResponse, error) {
+    return s.GrpcClient.CreateTaxRule(ctx, req)
+}
+
+func (s *OrderService) UpdateTaxRule(ctx context.Context, req *pb.UpdateTaxRuleRequest) (*pb.TaxRuleResponse, error) {
+    return s.GrpcClient.UpdateTaxRule(ctx, req)
+}
+
+func (s *OrderService) DeleteTaxRule(ctx context.Context, req *pb.DeleteTaxRuleRequest) (*pb.DeleteTaxRuleResponse, error) {
+    return s.GrpcClient.DeleteTaxRule(ctx, req)
+}
+
+func (s *OrderService) ListTaxRules(ctx context.Context, req *pb.ListTaxRulesRequest) (*pb.ListTaxRulesResponse, error) {
+    return s.GrpcClient.ListTaxRules(ctx, req)
+}
+
+func (s *OrderService) RequestReturn(ctx context.Context, req *pb.RequestReturnRequest) (*pb.OrderReturnResponse, error) {
+    return s.GrpcClient.RequestReturn(ctx, req)
+}
+
+func (s *OrderService) GetReturn(ctx context.Context, req *pb.GetReturnRequest) (*pb.OrderReturnResponse, error) {
+    return s.GrpcClient.GetReturn(ctx, req)
+}
+
+func (s *OrderService) ListReturns(ctx context.Context, req *pb.ListReturnsRequest) (*pb.ListReturnsResponse, error) {
+    return s.GrpcClient.ListReturns(ctx, req)
+}
+
+func (s *OrderService) ApproveReturn(ctx context.Context, req *pb.ReturnDecisionRequest) (*pb.OrderReturnResponse, error) {
+    return s.GrpcClient.ApproveReturn(ctx, req)
+}
+
+func (s *OrderService) RejectReturn(ctx context.Context, req *pb.ReturnDecisionRequest) (*pb.OrderReturnResponse, error) {
+    return s.GrpcClient.RejectReturn(ctx, req)
+}
+
+func (s *OrderService) ReceiveReturn(ctx context.Context, req *pb.ReceiveReturnRequest) (*pb.OrderReturnResponse, error) {
+    return s.GrpcClient.ReceiveReturn(ctx, req)
+}
+
+func (s *OrderService) InspectReturn(ctx context.Context, req *pb.InspectReturnRequest) (*pb.OrderReturnResponse, error) {
+    return s.GrpcClient.InspectReturn(ctx, req)
+}
+
+func (s *OrderService) CreateShipment(ctx context.Context, req *pb.CreateShipmentRequest) (*pb.ShipmentResponse, error) {
+    return s.GrpcClient.CreateShipment(ctx, req)
+}
+
+func (s *OrderService) DeliverShipment(ctx context.Context, req *pb.DeliverShipmentRequest) (*pb.ShipmentResponse, error) {
+    return s.GrpcClient.DeliverShipment(ctx, req)
+}
+
+func (s *OrderService) ListShipments(ctx context.Context, req *pb.ListShipmentsRequest) (*pb.ListShipmentsResponse, error) {
+    return s.GrpcClient.ListShipments(ctx, req)
+}
+
+func (s *OrderService) CreateShippingMethod(ctx context.Context, req *pb.CreateShippingMethodRequest) (*pb.ShippingMethodResponse, error) {
+    return s.GrpcClient.CreateShippingMethod(ctx, req)
+}
+
+func (s *OrderService) UpdateShippingMethod(ctx context.Context, req *pb.UpdateShippingMethodRequest) (*pb.ShippingMethodResponse, error) {
+    return s.GrpcClient.UpdateShippingMethod(ctx, req)
+}
+
+func (s *OrderService) DeleteShippingMethod(ctx context.Context, req *pb.DeleteShippingMethodRequest) (*pb.DeleteShippingMethodResponse, error) {
+    return s.GrpcClient.DeleteShippingMethod(ctx, req)
+}
+
+func (s *OrderService) ListShippingMethods(ctx context.Context, req *pb.ListShippingMethodsRequest) (*pb.ListShippingMethodsResponse, error) {
+    return s.GrpcClient.ListShippingMethods(ctx, req)
+}
+
+func (s *OrderService) QuoteShipping(ctx context.Context, req *pb.QuoteShippingRequest) (*pb.QuoteShippingResponse, error) {
+    return s.GrpcClient.QuoteShipping(ctx, req)
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+    "context"
+    pb "github.com/atullal/ecommerce-backend-protobuf/payment"
+)
+
+type PaymentService struct {
+    GrpcClient pb.PaymentServiceClient
+}
+
+func NewPaymentService(client pb.PaymentServiceClient) *PaymentService {
+    return &PaymentService{
+        GrpcClient: client,
+    }
+}
+
+func (s *PaymentService) AuthorizePayment(ctx context.Context, req *pb.AuthorizePaymentRequest) (*pb.PaymentResponse, error) {
+    return s.GrpcClient.AuthorizePayment(ctx, req)
+}
+
+func (s *PaymentService) ConfirmPayment(ctx context.Context, req *pb.ConfirmPaymentRequest) (*pb.PaymentResponse, error) {
+    return s.GrpcClient.ConfirmPayment(ctx, req)
+}
+
+func (s *PaymentService) CapturePayment(ctx context.Context, req *pb.CapturePaymentRequest) (*pb.PaymentResponse, error) {
+    return s.GrpcClient.CapturePayment(ctx, req)
+}
+
+func (s *PaymentService) VoidPayment(ctx context.Context, req *pb.VoidPaymentRequest) (*pb.PaymentResponse, error) {
+    return s.GrpcClient.VoidPayment(ctx, req)
+}
+
+func (s *PaymentService) RefundPayment(ctx context.Context, req *pb.RefundPaymentRequest) (*pb.PaymentResponse, error) {
+    return s.GrpcClient.RefundPayment(ctx, req)
+}
+
+func (s *PaymentService) GetPayment(ctx context.Context, req *pb.GetPaymentRequest) (*pb.PaymentResponse, error) {
+    return s.GrpcClient.GetPayment(ctx, req)
+}
+
+func (s *PaymentService) ListPayments(ctx context.Context, req *pb.ListPaymentsRequest) (*pb.ListPaymentsResponse, error) {
+    return s.GrpcClient.ListPayments(ctx, req)
+}
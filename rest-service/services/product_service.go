@@ -42,3 +42,83 @@ func (s *ProductService) UpdateInventory(ctx context.Context, req *pb.UpdateInve
 func (s *ProductService) GetInventory(ctx context.Context, req *pb.GetInventoryRequest) (*pb.InventoryResponse, error) {
     return s.GrpcClient.GetInventory(ctx, req)
 }
+
+func (s *ProductService) AddWarehouse(ctx context.Context, req *pb.AddWarehouseRequest) (*pb.WarehouseResponse, error) {
+    return s.GrpcClient.AddWarehouse(ctx, req)
+}
+
+func (s *ProductService) ListWarehouses(ctx context.Context, req *pb.ListWarehousesRequest) (*pb.ListWarehousesResponse, error) {
+    return s.GrpcClient.ListWarehouses(ctx, req)
+}
+
+func (s *ProductService) ListStockMovements(ctx context.Context, req *pb.ListStockMovementsRequest) (*pb.ListStockMovementsResponse, error) {
+    return s.GrpcClient.ListStockMovements(ctx, req)
+}
+
+func (s *ProductService) RebuildInventory(ctx context.Context, req *pb.RebuildInventoryRequest) (*pb.RebuildInventoryResponse, error) {
+    return s.GrpcClient.RebuildInventory(ctx, req)
+}
+
+func (s *ProductService) CreateCategory(ctx context.Context, req *pb.CreateCategoryRequest) (*pb.CategoryResponse, error) {
+    return s.GrpcClient.CreateCategory(ctx, req)
+}
+
+func (s *ProductService) GetCategory(ctx context.Context, req *pb.GetCategoryRequest) (*pb.CategoryResponse, error) {
+    return s.GrpcClient.GetCategory(ctx, req)
+}
+
+func (s *ProductService) UpdateCategory(ctx context.Context, req *pb.UpdateCategoryRequest) (*pb.CategoryResponse, error) {
+    return s.GrpcClient.UpdateCategory(ctx, req)
+}
+
+func (s *ProductService) DeleteCategory(ctx context.Context, req *pb.DeleteCategoryRequest) (*pb.DeleteCategoryResponse, error) {
+    return s.GrpcClient.DeleteCategory(ctx, req)
+}
+
+func (s *ProductService) ListCategories(ctx context.Context, req *pb.ListCategoriesRequest) (*pb.ListCategoriesResponse, error) {
+    return s.GrpcClient.ListCategories(ctx, req)
+}
+
+func (s *ProductService) SetProductCategories(ctx context.Context, req *pb.SetProductCategoriesRequest) (*pb.ProductResponse, error) {
+    return s.GrpcClient.SetProductCategories(ctx, req)
+}
+
+func (s *ProductService) SetProductOptions(ctx context.Context, req *pb.SetProductOptionsRequest) (*pb.ProductResponse, error) {
+    return s.GrpcClient.SetProductOptions(ctx, req)
+}
+
+func (s *ProductService) AddVariant(ctx context.Context, req *pb.AddVariantRequest) (*pb.ProductResponse, error) {
+    return s.GrpcClient.AddVariant(ctx, req)
+}
+
+func (s *ProductService) UpdateVariant(ctx context.Context, req *pb.UpdateVariantRequest) (*pb.ProductResponse, error) {
+    return s.GrpcClient.UpdateVariant(ctx, req)
+}
+
+func (s *ProductService) DeleteVariant(ctx context.Context, req *pb.DeleteVariantRequest) (*pb.ProductResponse, error) {
+    return s.GrpcClient.DeleteVariant(ctx, req)
+}
+
+func (s *ProductService) CreatePriceList(ctx context.Context, req *pb.CreatePriceListRequest) (*pb.PriceListResponse, error) {
+    return s.GrpcClient.CreatePriceList(ctx, req)
+}
+
+func (s *ProductService) GetPriceList(ctx context.Context, req *pb.GetPriceListRequest) (*pb.PriceListResponse, error) {
+    return s.GrpcClient.GetPriceList(ctx, req)
+}
+
+func (s *ProductService) UpdatePriceList(ctx context.Context, req *pb.UpdatePriceListRequest) (*pb.PriceListResponse, error) {
+    return s.GrpcClient.UpdatePriceList(ctx, req)
+}
+
+func (s *ProductService) DeletePriceList(ctx context.Context, req *pb.DeletePriceListRequest) (*pb.DeletePriceListResponse, error) {
+    return s.GrpcClient.DeletePriceList(ctx, req)
+}
+
+func (s *ProductService) ListPriceLists(ctx context.Context, req *pb.ListPriceListsRequest) (*pb.ListPriceListsResponse, error) {
+    return s.GrpcClient.ListPriceLists(ctx, req)
+}
+
+func (s *ProductService) SetPriceListPrices(ctx context.Context, req *pb.SetPriceListPricesRequest) (*pb.PriceListResponse, error) {
+    return s.GrpcClient.SetPriceListPrices(ctx, req)
+}
@@ -23,4 +23,28 @@ func (s *UserService) AuthenticateUser(ctx context.Context, req *pb.Authenticate
     return s.GrpcClient.AuthenticateUser(ctx, req)
 }
 
+func (s *UserService) SetCustomerGroup(ctx context.Context, req *pb.SetCustomerGroupRequest) (*pb.UserResponse, error) {
+    return s.GrpcClient.SetCustomerGroup(ctx, req)
+}
+
+func (s *UserService) CreateAddress(ctx context.Context, req *pb.CreateAddressRequest) (*pb.AddressResponse, error) {
+    return s.GrpcClient.CreateAddress(ctx, req)
+}
+
+func (s *UserService) GetAddress(ctx context.Context, req *pb.GetAddressRequest) (*pb.AddressResponse, error) {
+    return s.GrpcClient.GetAddress(ctx, req)
+}
+
+func (s *UserService) UpdateAddress(ctx context.Context, req *pb.UpdateAddressRequest) (*pb.AddressResponse, error) {
+    return s.GrpcClient.UpdateAddress(ctx, req)
+}
+
+func (s *UserService) DeleteAddress(ctx context.Context, req *pb.DeleteAddressRequest) (*pb.DeleteAddressResponse, error) {
+    return s.GrpcClient.DeleteAddress(ctx, req)
+}
+
+func (s *UserService) ListAddresses(ctx context.Context, req *pb.ListAddressesRequest) (*pb.ListAddressesResponse, error) {
+    return s.GrpcClient.ListAddresses(ctx, req)
+}
+
 // Additional business logic functions can be added here...
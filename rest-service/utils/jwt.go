@@ -11,15 +11,17 @@ var jwtKey = []byte(os.Getenv("JWT_SECRET"))
 type Claims struct {
     UserID uint `json:"user_id"`
     Role int `json:"role"`
+    CustomerGroup string `json:"customer_group,omitempty"` // Decides the price lists the user gets
     jwt.RegisteredClaims
 }
 
 // GenerateToken generates a JWT token for a given user ID
-func GenerateToken(userID uint, role int) (string, error) {
+func GenerateToken(userID uint, role int, customerGroup string) (string, error) {
     expirationTime := time.Now().Add(30 * time.Minute)
     claims := &Claims{
         UserID: userID,
         Role: role,
+        CustomerGroup: customerGroup,
         RegisteredClaims: jwt.RegisteredClaims{
             ExpiresAt: jwt.NewNumericDate(expirationTime),
         },
@@ -31,6 +33,15 @@ func GenerateToken(userID uint, role int) (string, error) {
 
 // ValidateToken validates a given JWT token and returns user ID
 func ValidateToken(tokenString string) (uint, int, error) {
+    claims, err := ParseToken(tokenString)
+    if err != nil {
+        return 0, 0, err
+    }
+    return claims.UserID, claims.Role, nil
+}
+
+// ParseToken validates a given JWT token and returns all of its claims
+func ParseToken(tokenString string) (*Claims, error) {
     claims := &Claims{}
 
     token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -38,12 +49,12 @@ func ValidateToken(tokenString string) (uint, int, error) {
     })
 
     if err != nil {
-        return 0, 0, err
+        return nil, err
     }
 
     if !token.Valid {
-        return 0, 0, jwt.ErrTokenUnverifiable
+        return nil, jwt.ErrTokenUnverifiable
     }
 
-    return claims.UserID, claims.Role, nil
+    return claims, nil
 }
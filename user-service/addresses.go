@@ -0,0 +1,243 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "github.com/atullal/ecommerce-backend-protobuf/user"
+    "gorm.io/gorm"
+    "user-service/models"
+)
+
+// countryPattern is the form of ISO 3166-1 alpha-2 country codes
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// phonePattern is what phone numbers may look like, loosely: digits with an
+// optional leading + and the usual separators
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()./-]{4,24}$`)
+
+// addressFormat is what an address in a country needs beyond a name, a
+// street line and a city
+type addressFormat struct {
+    RegionRequired bool
+    PostalCode     *regexp.Regexp // Required and in this form when set
+}
+
+// addressFormats holds the rules of the countries orders are commonly shipped
+// to. Addresses elsewhere only need the common fields; their postal code is
+// optional.
+var addressFormats = map[string]addressFormat{
+    "US": {RegionRequired: true, PostalCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`)},
+    "CA": {RegionRequired: true, PostalCode: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`)},
+    "AU": {RegionRequired: true, PostalCode: regexp.MustCompile(`^\d{4}$`)},
+    "BR": {RegionRequired: true, PostalCode: regexp.MustCompile(`^\d{5}-?\d{3}$`)},
+    "IN": {RegionRequired: true, PostalCode: regexp.MustCompile(`^\d{6}$`)},
+    "MX": {RegionRequired: true, PostalCode: regexp.MustCompile(`^\d{5}$`)},
+    "GB": {PostalCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`)},
+    "DE": {PostalCode: regexp.MustCompile(`^\d{5}$`)},
+    "FR": {PostalCode: regexp.MustCompile(`^\d{5}$`)},
+    "ES": {PostalCode: regexp.MustCompile(`^\d{5}$`)},
+    "IT": {PostalCode: regexp.MustCompile(`^\d{5}$`)},
+    "NL": {PostalCode: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`)},
+    "JP": {PostalCode: regexp.MustCompile(`^\d{3}-?\d{4}$`)},
+}
+
+// CreateAddress adds an address to a user's address book. A user's first
+// address becomes their default for shipping and billing.
+func (s *server) CreateAddress(ctx context.Context, in *pb.CreateAddressRequest) (*pb.AddressResponse, error) {
+    address, err := newAddress(in.Address)
+    if err != nil {
+        return nil, err
+    }
+
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        var user models.User
+        if err := tx.Select("id").First(&user, in.UserId).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return status.Errorf(codes.NotFound, "User with ID '%d' not found", in.UserId)
+            }
+            return status.Errorf(codes.Internal, "Error retrieving user: %v", err)
+        }
+        var count int64
+        if err := tx.Model(&models.Address{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error retrieving addresses: %v", err)
+        }
+        if count == 0 {
+            address.DefaultShipping, address.DefaultBilling = true, true
+        }
+
+        address.UserID = user.ID
+        if err := tx.Create(&address).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error creating address: %v", err)
+        }
+        return clearOtherDefaults(tx, address)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.AddressResponse{Address: toProtoAddress(address)}, nil
+}
+
+func (s *server) GetAddress(ctx context.Context, in *pb.GetAddressRequest) (*pb.AddressResponse, error) {
+    address, err := findAddress(s.db, in.UserId, in.Id)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.AddressResponse{Address: toProtoAddress(*address)}, nil
+}
+
+// UpdateAddress replaces an address. Orders keep the snapshot they were
+// placed with, so the change only affects new orders.
+func (s *server) UpdateAddress(ctx context.Context, in *pb.UpdateAddressRequest) (*pb.AddressResponse, error) {
+    update, err := newAddress(in.Address)
+    if err != nil {
+        return nil, err
+    }
+
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        address, err := findAddress(tx, in.UserId, in.Id)
+        if err != nil {
+            return err
+        }
+        update.Model, update.UserID = address.Model, address.UserID
+        if err := tx.Save(&update).Error; err != nil {
+            return status.Errorf(codes.Internal, "Error updating address: %v", err)
+        }
+        return clearOtherDefaults(tx, update)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &pb.AddressResponse{Address: toProtoAddress(update)}, nil
+}
+
+func (s *server) DeleteAddress(ctx context.Context, in *pb.DeleteAddressRequest) (*pb.DeleteAddressResponse, error) {
+    address, err := findAddress(s.db, in.UserId, in.Id)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.db.Delete(address).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error deleting address: %v", err)
+    }
+    return &pb.DeleteAddressResponse{Success: true}, nil
+}
+
+func (s *server) ListAddresses(ctx context.Context, in *pb.ListAddressesRequest) (*pb.ListAddressesResponse, error) {
+    var addresses []models.Address
+    err := s.db.Where("user_id = ?", in.UserId).
+        Order("default_shipping DESC, default_billing DESC, id").Find(&addresses).Error
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "Error retrieving addresses: %v", err)
+    }
+    res := &pb.ListAddressesResponse{}
+    for _, address := range addresses {
+        res.Addresses = append(res.Addresses, toProtoAddress(address))
+    }
+    return res, nil
+}
+
+// newAddress validates an address being saved: every address needs a
+// recipient, a street line, a city and a country, and some countries a region
+// and a postal code in their format
+func newAddress(in *pb.Address) (models.Address, error) {
+    if in == nil {
+        return models.Address{}, status.Errorf(codes.InvalidArgument, "Address is required")
+    }
+    address := models.Address{
+        Label:           strings.TrimSpace(in.Label),
+        Name:            strings.TrimSpace(in.Name),
+        Company:         strings.TrimSpace(in.Company),
+        Line1:           strings.TrimSpace(in.Line1),
+        Line2:           strings.TrimSpace(in.Line2),
+        City:            strings.TrimSpace(in.City),
+        Region:          strings.TrimSpace(in.Region),
+        PostalCode:      strings.ToUpper(strings.TrimSpace(in.PostalCode)),
+        Country:         strings.ToUpper(strings.TrimSpace(in.Country)),
+        Phone:           strings.TrimSpace(in.Phone),
+        DefaultShipping: in.DefaultShipping,
+        DefaultBilling:  in.DefaultBilling,
+    }
+    if !countryPattern.MatchString(address.Country) {
+        return address, status.Errorf(codes.InvalidArgument, "Invalid country '%s'", in.Country)
+    }
+    for _, field := range []struct{ name, value string }{
+        {"name", address.Name}, {"line1", address.Line1}, {"city", address.City},
+    } {
+        if field.value == "" {
+            return address, status.Errorf(codes.InvalidArgument, "Address %s is required", field.name)
+        }
+    }
+
+    format := addressFormats[address.Country]
+    if format.RegionRequired && address.Region == "" {
+        return address, status.Errorf(codes.InvalidArgument, "Addresses in %s need a region", address.Country)
+    }
+    if format.PostalCode != nil {
+        if address.PostalCode == "" {
+            return address, status.Errorf(codes.InvalidArgument, "Addresses in %s need a postal code", address.Country)
+        }
+        if !format.PostalCode.MatchString(address.PostalCode) {
+            return address, status.Errorf(codes.InvalidArgument, "Invalid postal code '%s' for %s", in.PostalCode, address.Country)
+        }
+    }
+    if address.Phone != "" && !phonePattern.MatchString(address.Phone) {
+        return address, status.Errorf(codes.InvalidArgument, "Invalid phone number '%s'", in.Phone)
+    }
+    return address, nil
+}
+
+// clearOtherDefaults keeps an address the user's only default of each kind it is the default for
+func clearOtherDefaults(tx *gorm.DB, address models.Address) error {
+    for _, kind := range []struct {
+        column string
+        set    bool
+    }{
+        {"default_shipping", address.DefaultShipping},
+        {"default_billing", address.DefaultBilling},
+    } {
+        if !kind.set {
+            continue
+        }
+        err := tx.Model(&models.Address{}).Where("user_id = ? AND id <> ?", address.UserID, address.ID).
+            Update(kind.column, false).Error
+        if err != nil {
+            return status.Errorf(codes.Internal, "Error updating addresses: %v", err)
+        }
+    }
+    return nil
+}
+
+// findAddress loads an address of a user; other users' addresses are not found
+func findAddress(db *gorm.DB, userID, id int64) (*models.Address, error) {
+    var address models.Address
+    if err := db.Where("user_id = ?", userID).First(&address, id).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "Address with ID '%d' not found", id)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving address: %v", err)
+    }
+    return &address, nil
+}
+
+// toProtoAddress converts an address to protobuf
+func toProtoAddress(address models.Address) *pb.Address {
+    return &pb.Address{
+        Id:              int64(address.ID),
+        Label:           address.Label,
+        Name:            address.Name,
+        Company:         address.Company,
+        Line1:           address.Line1,
+        Line2:           address.Line2,
+        City:            address.City,
+        Region:          address.Region,
+        PostalCode:      address.PostalCode,
+        Country:         address.Country,
+        Phone:           address.Phone,
+        DefaultShipping: address.DefaultShipping,
+        DefaultBilling:  address.DefaultBilling,
+    }
+}
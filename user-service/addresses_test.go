@@ -0,0 +1,47 @@
+package main
+
+import (
+    "testing"
+
+    pb "github.com/atullal/ecommerce-backend-protobuf/user"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestNewAddress(t *testing.T) {
+    address, err := newAddress(&pb.Address{
+        Name: " Ada Lovelace ", Line1: "1 Main St", City: "Springfield",
+        Region: "IL", PostalCode: "62701-1234", Country: "us", Phone: "+1 (555) 010-0000",
+    })
+    if err != nil {
+        t.Fatalf("newAddress error = %v", err)
+    }
+    if address.Name != "Ada Lovelace" || address.Country != "US" {
+        t.Errorf("address = %+v", address)
+    }
+
+    tests := []struct {
+        name    string
+        address *pb.Address
+        code    codes.Code
+    }{
+        {name: "canadian postal code in lower case", address: &pb.Address{Name: "A", Line1: "1 Rue", City: "Montréal", Region: "QC", PostalCode: "h2x 1y4", Country: "CA"}, code: codes.OK},
+        {name: "no postal code where none is needed", address: &pb.Address{Name: "A", Line1: "1 Road", City: "Dublin", Country: "IE"}, code: codes.OK},
+        {name: "missing", address: nil, code: codes.InvalidArgument},
+        {name: "no name", address: &pb.Address{Line1: "1 Str", City: "Berlin", PostalCode: "10115", Country: "DE"}, code: codes.InvalidArgument},
+        {name: "no street", address: &pb.Address{Name: "A", City: "Berlin", PostalCode: "10115", Country: "DE"}, code: codes.InvalidArgument},
+        {name: "no city", address: &pb.Address{Name: "A", Line1: "1 Str", PostalCode: "10115", Country: "DE"}, code: codes.InvalidArgument},
+        {name: "invalid country", address: &pb.Address{Name: "A", Line1: "1 Str", City: "Berlin", Country: "DEU"}, code: codes.InvalidArgument},
+        {name: "no region in the US", address: &pb.Address{Name: "A", Line1: "1 Main St", City: "Springfield", PostalCode: "62701", Country: "US"}, code: codes.InvalidArgument},
+        {name: "no postal code in Germany", address: &pb.Address{Name: "A", Line1: "1 Str", City: "Berlin", Country: "DE"}, code: codes.InvalidArgument},
+        {name: "british postal code in Germany", address: &pb.Address{Name: "A", Line1: "1 Str", City: "Berlin", PostalCode: "SW1A 1AA", Country: "DE"}, code: codes.InvalidArgument},
+        {name: "invalid phone", address: &pb.Address{Name: "A", Line1: "1 Road", City: "London", PostalCode: "SW1A 1AA", Country: "GB", Phone: "call me"}, code: codes.InvalidArgument},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := newAddress(tt.address); status.Code(err) != tt.code {
+                t.Errorf("newAddress error = %v, want %v", err, tt.code)
+            }
+        })
+    }
+}
@@ -1,11 +1,11 @@
 module user-service
 
-go 1.22.0
+go 1.23.0
 
 require (
-	github.com/atullal/ecommerce-backend-protobuf v0.0.0-20240228104601-fa630e7a49d9
+	github.com/atullal/ecommerce-backend-protobuf v0.1.7
 	github.com/golang-jwt/jwt/v5 v5.2.0
-	golang.org/x/crypto v0.20.0
+	golang.org/x/crypto v0.37.0
 	google.golang.org/grpc v1.62.0
 	gorm.io/driver/postgres v1.5.6
 	gorm.io/gorm v1.25.7
@@ -23,9 +23,11 @@ require (
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
 	golang.org/x/net v0.21.0 // indirect
-	golang.org/x/sync v0.6.0 // indirect
-	golang.org/x/sys v0.17.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/sync v0.13.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/text v0.24.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240123012728-ef4313101c80 // indirect
 	google.golang.org/protobuf v1.32.0 // indirect
 )
+
+replace github.com/atullal/ecommerce-backend-protobuf => ../protobuf
@@ -5,9 +5,15 @@ import (
     "context"
     "log"
     "net"
+    "errors"
+    "regexp"
+    "strings"
 
     "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
     pb "github.com/atullal/ecommerce-backend-protobuf/user"
+    "github.com/atullal/ecommerce-backend-protobuf/serviceauth"
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
     "user-service/models"
@@ -47,6 +53,9 @@ func connectWithBackoff(dsn string) (*gorm.DB, error) {
     return nil, err // Return the last error
 }
 
+// serviceToken is the SERVICE_TOKEN calls between services are proven with
+var serviceToken string
+
 func initDB() *gorm.DB {
     dsn := os.Getenv("DSN")
     db, err := connectWithBackoff(dsn)
@@ -55,7 +64,7 @@ func initDB() *gorm.DB {
     }
 
     // Migrate the schema
-    if err := db.AutoMigrate(&models.User{}); err != nil {
+    if err := db.AutoMigrate(&models.User{}, &models.Address{}); err != nil {
         log.Fatalf("failed to migrate database: %v", err)
     }
     fmt.Println("Database connection successful")
@@ -93,12 +102,12 @@ func (s *server) CreateUser(ctx context.Context, in *pb.CreateUserRequest) (*pb.
     }
 
     // Generate JWT token
-    token, err := jwt.GenerateToken(user.ID, user.Role)
+    token, err := jwt.GenerateToken(user.ID, user.Role, user.CustomerGroup)
     if err != nil {
         return nil, err
     }
 
-    return &pb.UserResponse{Id: fmt.Sprintf("%d", user.ID), Username: user.Username, Email: user.Email, Token: token}, nil
+    return toUserResponse(user, token), nil
 }
 
 
@@ -113,12 +122,41 @@ func (s *server) AuthenticateUser(ctx context.Context, in *pb.AuthenticateUserRe
     }
 
     // Generate JWT token
-    token, err := jwt.GenerateToken(user.ID, user.Role)
+    token, err := jwt.GenerateToken(user.ID, user.Role, user.CustomerGroup)
     if err != nil {
         return nil, err
     }
 
-    return &pb.UserResponse{Id: fmt.Sprintf("%d", user.ID), Username: user.Username, Email: user.Email, Token: token}, nil
+    return toUserResponse(user, token), nil
+}
+
+// SetCustomerGroup changes the customer group of a user. Tokens carry the
+// group, so the change applies from the user's next login.
+func (s *server) SetCustomerGroup(ctx context.Context, in *pb.SetCustomerGroupRequest) (*pb.UserResponse, error) {
+    group := strings.ToLower(strings.TrimSpace(in.CustomerGroup))
+    if group != "" && !customerGroupPattern.MatchString(group) {
+        return nil, status.Errorf(codes.InvalidArgument, "Invalid customer group '%s'", in.CustomerGroup)
+    }
+
+    var user models.User
+    if err := s.db.First(&user, in.UserId).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, status.Errorf(codes.NotFound, "User with ID '%d' not found", in.UserId)
+        }
+        return nil, status.Errorf(codes.Internal, "Error retrieving user: %v", err)
+    }
+    if err := s.db.Model(&user).Update("customer_group", group).Error; err != nil {
+        return nil, status.Errorf(codes.Internal, "Error updating user: %v", err)
+    }
+    return toUserResponse(user, ""), nil
+}
+
+// customerGroupPattern is what customer group names look like, e.g. "wholesale" or "tier-2"
+var customerGroupPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// toUserResponse converts a user and their token to protobuf
+func toUserResponse(user models.User, token string) *pb.UserResponse {
+    return &pb.UserResponse{Id: fmt.Sprintf("%d", user.ID), Username: user.Username, Email: user.Email, Token: token, CustomerGroup: user.CustomerGroup}
 }
 
 
@@ -129,7 +167,11 @@ func main() {
     if err != nil {
         log.Fatalf("failed to listen: %v", err)
     }
-    s := grpc.NewServer()
+    // Only other services holding the shared token may call user-service
+    if serviceToken, err = serviceauth.TokenFromEnv(); err != nil {
+        log.Fatalf("failed to configure service authentication: %v", err)
+    }
+    s := grpc.NewServer(grpc.UnaryInterceptor(serviceauth.UnaryServerInterceptor(serviceToken)))
     serv := &server{db: db}
     pb.RegisterUserServiceServer(s, serv)
     log.Printf("server listening at %v", lis.Addr())
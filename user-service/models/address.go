@@ -0,0 +1,24 @@
+package models
+
+import (
+    "gorm.io/gorm"
+)
+
+// Address is an entry in a user's address book. Any address can be used to
+// ship or bill to; the defaults are used when an order doesn't pick one.
+type Address struct {
+    gorm.Model
+    UserID          uint   `gorm:"index"`
+    Label           string `gorm:"not null;default:''"` // e.g. "Home", optional
+    Name            string // Recipient
+    Company         string `gorm:"not null;default:''"`
+    Line1           string
+    Line2           string `gorm:"not null;default:''"`
+    City            string
+    Region          string `gorm:"not null;default:''"` // State, province or county
+    PostalCode      string `gorm:"not null;default:''"`
+    Country         string `gorm:"size:2"` // ISO 3166-1 alpha-2 code
+    Phone           string `gorm:"not null;default:''"`
+    DefaultShipping bool   `gorm:"not null;default:false"` // At most one per user
+    DefaultBilling  bool   `gorm:"not null;default:false"` // At most one per user
+}
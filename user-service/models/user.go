@@ -11,4 +11,5 @@ type User struct {
     Email     string `gorm:"unique"`
     Password  string
     Role	  int
+    CustomerGroup string `gorm:"not null;default:''"` // Decides the price lists the user gets, empty for none
 }
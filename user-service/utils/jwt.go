@@ -11,15 +11,17 @@ var jwtKey = []byte(os.Getenv("JWT_SECRET"))
 type Claims struct {
     UserID uint `json:"user_id"`
     Role int `json:"role"`
+    CustomerGroup string `json:"customer_group,omitempty"` // Decides the price lists the user gets
     jwt.RegisteredClaims
 }
 
 // GenerateToken generates a JWT token for a given user ID
-func GenerateToken(userID uint, role int) (string, error) {
+func GenerateToken(userID uint, role int, customerGroup string) (string, error) {
     expirationTime := time.Now().Add(30 * time.Minute)
     claims := &Claims{
         UserID: userID,
         Role: role,
+        CustomerGroup: customerGroup,
         RegisteredClaims: jwt.RegisteredClaims{
             ExpiresAt: jwt.NewNumericDate(expirationTime),
         },